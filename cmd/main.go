@@ -2,19 +2,276 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/jbdoumenjou/mychat/internal/api"
+	"github.com/jbdoumenjou/mychat/internal/auth"
+	"github.com/jbdoumenjou/mychat/internal/blob"
+	"github.com/jbdoumenjou/mychat/internal/event"
 	"github.com/jbdoumenjou/mychat/internal/log"
+	"github.com/jbdoumenjou/mychat/internal/otp"
+	"github.com/jbdoumenjou/mychat/internal/phone"
+	"github.com/jbdoumenjou/mychat/internal/ratelimit"
+	"github.com/jbdoumenjou/mychat/internal/realtime"
 	"github.com/jbdoumenjou/mychat/internal/repo"
+	"github.com/jbdoumenjou/mychat/internal/repo/sqlstore"
+	"github.com/jbdoumenjou/mychat/internal/sms"
 )
 
+// repositories gathers the repositories used by the API handlers.
+type repositories struct {
+	users       userRepository
+	chats       chatRepository
+	messages    messageRepository
+	idempotency api.IdempotencyRepo
+
+	close func() error
+}
+
+type userRepository interface {
+	api.UserRepo
+	api.MessageUserRepo
+}
+
+type chatRepository interface {
+	api.ChatRepo
+	api.MessageChatRepo
+	api.EventChatRepo
+	api.ReceiptChatRepo
+	api.BlockRepo
+}
+
+type messageRepository interface {
+	api.MessageRepo
+	api.ChatMessageRepo
+	api.EventMessageRepo
+	api.ReceiptMessageRepo
+	api.MessageEditRepo
+	api.ReactionMessageRepo
+	api.AttachmentMessageRepo
+	api.SearchMessageRepo
+}
+
+// newRepositories creates the repositories for the given storage.
+// The in-memory storage is used by default, data are lost when the server stops.
+// The database schema is migrated to the latest version.
+func newRepositories(storage, dsn string) (*repositories, error) {
+	if storage == "" || storage == "memory" {
+		messages := repo.NewMessageRepository()
+
+		return &repositories{
+			users:       repo.NewUserRepository(),
+			chats:       repo.NewChatRepository(messages),
+			messages:    messages,
+			idempotency: repo.NewIdempotencyRepository(),
+			close:       func() error { return nil },
+		}, nil
+	}
+
+	db, err := openDB(storage, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.MigrateUp(); err != nil {
+		_ = db.Close()
+
+		return nil, fmt.Errorf("failed to migrate %s storage: %w", storage, err)
+	}
+
+	return &repositories{
+		users:       sqlstore.NewUserRepository(db),
+		chats:       sqlstore.NewChatRepository(db),
+		messages:    sqlstore.NewMessageRepository(db),
+		idempotency: sqlstore.NewIdempotencyRepository(db),
+		close:       db.Close,
+	}, nil
+}
+
+// openDB opens the database of the storage.
+func openDB(storage, dsn string) (*sqlstore.DB, error) {
+	switch storage {
+	case sqlstore.DriverSQLite:
+		if dsn == "" {
+			dsn = "mychat.db"
+		}
+	case sqlstore.DriverPostgres:
+		if dsn == "" {
+			return nil, errors.New("DSN is required for postgres storage")
+		}
+	default:
+		return nil, fmt.Errorf("unknown storage %q, expected sqlite or postgres", storage)
+	}
+
+	db, err := sqlstore.Open(storage, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s storage: %w", storage, err)
+	}
+
+	return db, nil
+}
+
+// newTokens creates the access tokens issuer, signing the tokens with the secret.
+// Without secret, a random one is generated: the tokens are lost when the server stops,
+// and they can't be shared between several instances.
+func newTokens(secret, ttl string) (*auth.Tokens, error) {
+	tokenTTL := 24 * time.Hour
+
+	if ttl != "" {
+		var err error
+
+		if tokenTTL, err = time.ParseDuration(ttl); err != nil || tokenTTL <= 0 {
+			return nil, fmt.Errorf("invalid token TTL %q", ttl)
+		}
+	}
+
+	if secret == "" {
+		slog.Warn("no AUTH_SECRET provided, using a random secret")
+
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			return nil, fmt.Errorf("failed to generate secret: %w", err)
+		}
+
+		return auth.NewTokens(random, tokenTTL), nil
+	}
+
+	return auth.NewTokens([]byte(secret), tokenTTL), nil
+}
+
+// newEditWindow parses the delay during which the messages can be edited, 0 for no limit.
+func newEditWindow(window string) (time.Duration, error) {
+	if window == "" {
+		return api.DefaultEditWindow, nil
+	}
+
+	editWindow, err := time.ParseDuration(window)
+	if err != nil || editWindow < 0 {
+		return 0, fmt.Errorf("invalid message edit window %q", window)
+	}
+
+	return editWindow, nil
+}
+
+// newBlobStore creates the store of the uploaded files.
+// The files are kept in a local directory by default, or in an S3-compatible object storage.
+func newBlobStore(ctx context.Context, store, dir string, s3 blob.S3Config) (api.BlobStore, error) {
+	switch store {
+	case "", "local":
+		if dir == "" {
+			dir = "blobs"
+		}
+
+		return blob.NewLocalStore(dir)
+	case "s3":
+		if s3.Endpoint == "" || s3.Bucket == "" {
+			return nil, errors.New("S3_ENDPOINT and S3_BUCKET are required for s3 blob store")
+		}
+
+		return blob.NewS3Store(ctx, s3)
+	default:
+		return nil, fmt.Errorf("unknown blob store %q, expected local or s3", store)
+	}
+}
+
+// newMaxAttachmentSize parses the maximum size of an uploaded file, in bytes.
+func newMaxAttachmentSize(size string) (int64, error) {
+	if size == "" {
+		return api.DefaultMaxAttachmentSize, nil
+	}
+
+	maxSize, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || maxSize <= 0 {
+		return 0, fmt.Errorf("invalid attachment max size %q", size)
+	}
+
+	return maxSize, nil
+}
+
+// newUnattachedAttachmentTTL parses the duration after which an uploaded file not attached to a message is deleted.
+func newUnattachedAttachmentTTL(ttl string) (time.Duration, error) {
+	if ttl == "" {
+		return api.DefaultUnattachedAttachmentTTL, nil
+	}
+
+	unattachedTTL, err := time.ParseDuration(ttl)
+	if err != nil || unattachedTTL <= 0 {
+		return 0, fmt.Errorf("invalid unattached attachment TTL %q", ttl)
+	}
+
+	return unattachedTTL, nil
+}
+
+// newIdempotencyTTL parses the duration during which a request can be retried with its idempotency key.
+func newIdempotencyTTL(ttl string) (time.Duration, error) {
+	if ttl == "" {
+		return api.DefaultIdempotencyTTL, nil
+	}
+
+	idempotencyTTL, err := time.ParseDuration(ttl)
+	if err != nil || idempotencyTTL <= 0 {
+		return 0, fmt.Errorf("invalid idempotency TTL %q", ttl)
+	}
+
+	return idempotencyTTL, nil
+}
+
+// newRateLimits parses the limits of the requests per route, overriding the default ones.
+// The limits are separated by semicolons, each one written as pattern=requests/period,
+// like "POST /messages=30/1m", the default route limit has an empty pattern and off removes a limit.
+func newRateLimits(spec string) (map[string]ratelimit.Limit, error) {
+	limits := maps.Clone(api.DefaultRateLimits)
+
+	for _, rule := range strings.Split(spec, ";") {
+		if strings.TrimSpace(rule) == "" {
+			continue
+		}
+
+		pattern, value, ok := strings.Cut(rule, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q, expected pattern=requests/period", rule)
+		}
+
+		pattern = strings.TrimSpace(pattern)
+
+		if value = strings.TrimSpace(value); value == "off" {
+			delete(limits, pattern)
+
+			continue
+		}
+
+		limit, err := ratelimit.ParseLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit of %q: %w", pattern, err)
+		}
+
+		limits[pattern] = limit
+	}
+
+	return limits, nil
+}
+
+// newSMSSender creates the SMS sender of the verification codes.
+// The messages are written to the file if any, logged otherwise.
+func newSMSSender(file string) otp.SMSSender {
+	if file != "" {
+		return sms.NewFileSender(file)
+	}
+
+	return sms.NewLogSender()
+}
+
 func main() {
 	// Get the log level from the environment variable
 	logLevel := os.Getenv("LOG_LEVEL")
@@ -28,17 +285,136 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Repository
-	userRepo := repo.NewUserRepository()
-	chatRepo := repo.NewChatRepository()
-	messageRepo := repo.NewMessageRepository()
+	// mychat migrate [up|down [steps]|version]
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(os.Getenv("STORAGE"), os.Getenv("DSN"), os.Args[2:]); err != nil {
+			slog.Error("failed to migrate", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	// Repository, the storage is selected with the STORAGE environment variable (memory, sqlite or postgres)
+	// and the DSN environment variable gives the database location.
+	repos, err := newRepositories(os.Getenv("STORAGE"), os.Getenv("DSN"))
+	if err != nil {
+		slog.Error("failed to initialize storage", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	// Access tokens, signed with the AUTH_SECRET environment variable,
+	// they expire after AUTH_TOKEN_TTL (24h by default).
+	tokens, err := newTokens(os.Getenv("AUTH_SECRET"), os.Getenv("AUTH_TOKEN_TTL"))
+	if err != nil {
+		slog.Error("failed to initialize authentication", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	// Phone numbers normalization, the numbers written without international prefix
+	// are numbers of the PHONE_REGION environment variable region, if set.
+	phones, err := phone.NewNormalizer(os.Getenv("PHONE_REGION"))
+	if err != nil {
+		slog.Error("failed to initialize phone numbers", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	// Phone numbers verification with one-time codes sent by SMS,
+	// the messages are written to the SMS_FILE environment variable file if set, logged otherwise.
+	verifier := otp.NewVerifier(newSMSSender(os.Getenv("SMS_FILE")), otp.DefaultConfig)
+
+	// Messages edition, the messages can be edited during MESSAGE_EDIT_WINDOW after they are sent
+	// (15m by default, 0 for no limit).
+	editWindow, err := newEditWindow(os.Getenv("MESSAGE_EDIT_WINDOW"))
+	if err != nil {
+		slog.Error("failed to initialize message edition", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	// Uploaded files, stored in the BLOB_DIR directory (blobs by default) or, when BLOB_STORE is s3,
+	// in the S3_BUCKET bucket of the S3-compatible object storage at S3_ENDPOINT.
+	blobs, err := newBlobStore(context.Background(), os.Getenv("BLOB_STORE"), os.Getenv("BLOB_DIR"), blob.S3Config{
+		Endpoint:  os.Getenv("S3_ENDPOINT"),
+		AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_SECRET_KEY"),
+		Bucket:    os.Getenv("S3_BUCKET"),
+		UseSSL:    os.Getenv("S3_USE_SSL") == "true",
+		Region:    os.Getenv("S3_REGION"),
+	})
+	if err != nil {
+		slog.Error("failed to initialize blob store", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	// Uploaded files are limited to ATTACHMENT_MAX_SIZE bytes (10 MiB by default).
+	maxAttachmentSize, err := newMaxAttachmentSize(os.Getenv("ATTACHMENT_MAX_SIZE"))
+	if err != nil {
+		slog.Error("failed to initialize attachments", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	// Uploaded files not attached to a message are deleted after ATTACHMENT_UNATTACHED_TTL (24h by default).
+	unattachedTTL, err := newUnattachedAttachmentTTL(os.Getenv("ATTACHMENT_UNATTACHED_TTL"))
+	if err != nil {
+		slog.Error("failed to initialize attachments", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	// Requests rate limits per client and route, the RATE_LIMITS environment variable overrides the default ones.
+	// The clients are identified by their IP address behind a reverse proxy when RATE_LIMIT_TRUST_PROXY is true.
+	rateLimits, err := newRateLimits(os.Getenv("RATE_LIMITS"))
+	if err != nil {
+		slog.Error("failed to initialize rate limits", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	trustProxy := os.Getenv("RATE_LIMIT_TRUST_PROXY") == "true"
+	limiter := api.NewRateLimiter(ratelimit.NewMemoryStore(), rateLimits, trustProxy)
+
+	// Requests sent with an Idempotency-Key header, they can be retried during IDEMPOTENCY_TTL (24h by default).
+	// The keys of the anonymous clients are scoped to their IP address, identified as for the rate limits.
+	idempotencyTTL, err := newIdempotencyTTL(os.Getenv("IDEMPOTENCY_TTL"))
+	if err != nil {
+		slog.Error("failed to initialize idempotency keys", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	idempotency := api.NewIdempotency(repos.idempotency, idempotencyTTL, trustProxy)
+
+	// Domain events bus
+	bus := event.NewBus()
+	bus.SubscribeAll(func(ctx context.Context, e event.Event) {
+		slog.DebugContext(ctx, "domain event", slog.String("topic", string(e.Topic())))
+	})
+
+	// Real-time events hub, fed with the domain events
+	hub := realtime.NewHub()
+	api.ForwardEvents(bus, hub)
 
 	// API handlers
-	userHandler := api.NewUserHandler(userRepo)
-	messageHandler := api.NewMessageHandler(userRepo, messageRepo, chatRepo)
-	chatHandler := api.NewChatHandler(chatRepo, messageRepo)
+	userHandler := api.NewUserHandler(repos.users, phones, verifier, tokens, bus)
+	authHandler := api.NewAuthHandler(repos.users, phones, verifier, tokens)
+	messageHandler := api.NewMessageHandler(repos.users, repos.messages, repos.chats, phones, bus)
+	editHandler := api.NewMessageEditHandler(repos.chats, repos.messages, blobs, bus, editWindow)
+	reactionHandler := api.NewReactionHandler(repos.chats, repos.messages)
+	attachmentHandler := api.NewAttachmentHandler(repos.chats, repos.messages, blobs, maxAttachmentSize, unattachedTTL)
+	searchHandler := api.NewSearchHandler(repos.chats, repos.messages, phones)
+	blockHandler := api.NewBlockHandler(repos.chats, repos.users, phones)
+	receiptHandler := api.NewReceiptHandler(repos.chats, repos.messages, bus)
+	chatHandler := api.NewChatHandler(repos.chats, repos.messages, repos.users, phones, receiptHandler, bus)
+	wsHandler := api.NewWebSocketHandler(hub, messageHandler, receiptHandler, limiter)
+	eventHandler := api.NewEventHandler(hub, repos.chats, repos.messages, receiptHandler)
+
+	docsHandler, err := api.NewDocsHandler()
+	if err != nil {
+		slog.Error("failed to initialize docs", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
 
-	router := api.NewRouter(userHandler, messageHandler, chatHandler)
+	router := api.NewRouter(
+		userHandler, authHandler, messageHandler, editHandler, reactionHandler, attachmentHandler, searchHandler, blockHandler,
+		chatHandler, receiptHandler, wsHandler, eventHandler, docsHandler, limiter, idempotency,
+	)
 
 	// Create an HTTP server
 	server := &http.Server{
@@ -70,10 +446,20 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	// Close the real-time connections first, the WebSockets are not tracked by the server shutdown
+	// and the event streams would delay it until the timeout.
+	if err := hub.Shutdown(ctx); err != nil {
+		slog.Error("Real-time connections forced to close", slog.String("error", err.Error()))
+	}
+
 	// Shutdown the server gracefully
 	if err := server.Shutdown(ctx); err != nil {
 		slog.Error("Server forced to shutdown", slog.String("error", err.Error()))
 	} else {
 		slog.Info("Server shutdown gracefully")
 	}
+
+	if err := repos.close(); err != nil {
+		slog.Error("failed to close storage", slog.String("error", err.Error()))
+	}
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+)
+
+// errUsage is returned when the migrate command is misused.
+var errUsage = errors.New("usage: mychat migrate [up|down [steps]|version]")
+
+// migrate runs the migrate command on the database of the storage.
+// * up applies all the pending migrations, this is the default.
+// * down reverts the given number of migrations, 1 by default.
+// * version prints the current schema version.
+func migrate(storage, dsn string, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	steps := 1
+
+	switch {
+	case command == "down" && len(args) == 2:
+		var err error
+		if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+			return errUsage
+		}
+	case len(args) > 1:
+		return errUsage
+	}
+
+	db, err := openDB(storage, dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch command {
+	case "up":
+		err = db.MigrateUp()
+	case "down":
+		err = db.MigrateDown(steps)
+	case "version":
+	default:
+		return errUsage
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to migrate %s: %w", command, err)
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("failed to get schema version: %w", err)
+	}
+
+	slog.Info("schema version", slog.Int("version", version))
+
+	return nil
+}
@@ -0,0 +1,541 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/blob"
+	"github.com/jbdoumenjou/mychat/internal/repo"
+	"github.com/jbdoumenjou/mychat/internal/thumbnail"
+)
+
+// DefaultMaxAttachmentSize is the default maximum size of an uploaded file, in bytes.
+const DefaultMaxAttachmentSize = 10 << 20
+
+// DefaultUnattachedAttachmentTTL is the default duration after which a file not attached to a message is deleted.
+const DefaultUnattachedAttachmentTTL = 24 * time.Hour
+
+// attachmentPruneInterval is the minimum interval between two removals of the expired unattached files.
+const attachmentPruneInterval = time.Minute
+
+// multipartOverhead is the room left for the multipart headers and boundaries around the uploaded file.
+const multipartOverhead = 1 << 20
+
+// AttachmentHandler is the handler for the files uploaded by the users and attached to their messages.
+type AttachmentHandler struct {
+	chatRepo    AttachmentChatRepo
+	messageRepo AttachmentMessageRepo
+	blobs       BlobStore
+	// maxSize is the maximum size of an uploaded file, in bytes.
+	maxSize int64
+	// unattachedTTL is the duration after which a file not attached to a message is deleted.
+	unattachedTTL time.Duration
+	now           func() time.Time
+
+	mu       sync.Mutex
+	prunedAt time.Time
+
+	logger *slog.Logger
+}
+
+// AttachmentChatRepo defines the chat repository.
+type AttachmentChatRepo interface {
+	GetChat(chatID string) (repo.Chat, error)
+}
+
+// AttachmentMessageRepo defines the message repository.
+type AttachmentMessageRepo interface {
+	AddAttachment(uploader, filename, contentType string, size int64, image *repo.Image) (repo.Attachment, error)
+	GetAttachment(attachmentID string) (repo.Attachment, error)
+	DeleteAttachment(attachmentID string) error
+	GetUnattachedAttachments(before time.Time) ([]repo.Attachment, error)
+	GetChatMessage(chatID, messageID string) (repo.Message, error)
+}
+
+// BlobStore stores the content of the uploaded files under their attachment ID.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewAttachmentHandler creates a new AttachmentHandler,
+// the uploaded files are limited to maxSize bytes and deleted after unattachedTTL if not attached to a message.
+func NewAttachmentHandler(
+	chatRepo AttachmentChatRepo,
+	messageRepo AttachmentMessageRepo,
+	blobs BlobStore,
+	maxSize int64,
+	unattachedTTL time.Duration,
+) *AttachmentHandler {
+	logger := slog.With(slog.String("handler", "attachment"))
+	logger.Info("created handler", slog.Int64("maxSize", maxSize), slog.Duration("unattachedTTL", unattachedTTL))
+
+	return &AttachmentHandler{
+		chatRepo:      chatRepo,
+		messageRepo:   messageRepo,
+		blobs:         blobs,
+		maxSize:       maxSize,
+		unattachedTTL: unattachedTTL,
+		now:           time.Now,
+		logger:        logger,
+	}
+}
+
+// AttachmentResponse represents an uploaded file.
+// This is the response format for the API.
+type AttachmentResponse struct {
+	ID          string    `json:"id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// URL is the path to download the file.
+	URL string `json:"url"`
+	// Width and Height are the dimensions of an image.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// Thumbnails are the paths to download the thumbnails of an image, by size.
+	Thumbnails map[string]string `json:"thumbnails,omitempty"`
+}
+
+func newAttachmentResponse(attachment repo.Attachment) AttachmentResponse {
+	response := AttachmentResponse{
+		ID:          attachment.ID,
+		Filename:    attachment.Filename,
+		ContentType: attachment.ContentType,
+		Size:        attachment.Size,
+		CreatedAt:   attachment.CreatedAt,
+		URL:         "/attachments/" + attachment.ID,
+	}
+
+	if attachment.Image != nil {
+		response.Width = attachment.Image.Width
+		response.Height = attachment.Image.Height
+		response.Thumbnails = make(map[string]string, len(attachment.Image.Thumbnails))
+
+		for _, size := range attachment.Image.Thumbnails {
+			response.Thumbnails[size] = response.URL + "/thumbnails/" + size
+		}
+	}
+
+	return response
+}
+
+// defaultFilename names the uploaded files sent without a name.
+const defaultFilename = "attachment"
+
+// sniffLen is the number of bytes read to detect the content type of a file.
+const sniffLen = 512
+
+var errFileRequired = newFieldError("file", "a multipart form with a file field is required")
+
+// Upload stores a file uploaded by the authenticated user in the "file" field of a multipart form.
+// The file is not attached to a message yet, the user attaches it by sending a message with its ID.
+// The content type is detected from the content, the one declared by the client is ignored.
+// The metadata of the images are stripped, and their thumbnails are stored along with them.
+// The files left unattached for the unattached TTL are deleted.
+func (h *AttachmentHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler upload an attachment", slog.String("path", r.URL.Path))
+
+	h.pruneUnattached(r.Context())
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxSize+multipartOverhead)
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to read uploaded file", slog.String("error", err.Error()))
+
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeProblem(w, r, problemContentTooLarge, h.tooLarge())
+
+			return
+		}
+
+		writeError(w, r, errFileRequired, "failed to read uploaded file")
+
+		return
+	}
+	defer file.Close()
+
+	if header.Size > h.maxSize {
+		writeProblem(w, r, problemContentTooLarge, h.tooLarge())
+
+		return
+	}
+
+	upload, err := readUpload(file, header.Size)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to read uploaded file", slog.String("error", err.Error()))
+
+		switch {
+		case errors.Is(err, thumbnail.ErrInvalid):
+			writeError(w, r, &fieldError{field: "file", err: thumbnail.ErrInvalid}, "invalid image")
+		case errors.Is(err, thumbnail.ErrTooLarge):
+			writeError(w, r, &fieldError{field: "file", err: thumbnail.ErrTooLarge}, "image too large")
+		default:
+			writeProblem(w, r, problemInternal, "failed to read uploaded file")
+		}
+
+		return
+	}
+
+	filename := uploadFilename(header.Filename)
+
+	attachment, err := h.messageRepo.AddAttachment(currentUser(r), filename, upload.contentType, upload.size, upload.metadata())
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to add attachment", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to add attachment")
+
+		return
+	}
+
+	if err = h.store(r.Context(), attachment.ID, upload); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to store attachment", slog.String("error", err.Error()))
+
+		// the attachment without content can't be attached, the blobs already stored are deleted.
+		if err = deleteBlobs(r.Context(), h.blobs, attachment); err != nil {
+			h.logger.ErrorContext(r.Context(), "failed to delete attachment content", slog.String("error", err.Error()))
+		}
+
+		if err = h.messageRepo.DeleteAttachment(attachment.ID); err != nil {
+			h.logger.ErrorContext(r.Context(), "failed to delete attachment", slog.String("error", err.Error()))
+		}
+
+		writeProblem(w, r, problemInternal, "failed to store attachment")
+
+		return
+	}
+
+	response := newAttachmentResponse(attachment)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", response.URL)
+	w.WriteHeader(http.StatusCreated)
+
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to write response", slog.String("error", err.Error()))
+	}
+}
+
+// upload is an uploaded file ready to be stored.
+type upload struct {
+	content     io.Reader
+	size        int64
+	contentType string
+	// image is the image without its metadata and its thumbnails, nil if the file is not a supported image.
+	image *thumbnail.Image
+}
+
+// metadata describes the uploaded image, nil if the file is not a supported image.
+func (u upload) metadata() *repo.Image {
+	if u.image == nil {
+		return nil
+	}
+
+	image := &repo.Image{Width: u.image.Width, Height: u.image.Height}
+	for _, t := range u.image.Thumbnails {
+		image.Thumbnails = append(image.Thumbnails, t.Size.Name)
+	}
+
+	return image
+}
+
+// readUpload detects the content type of the uploaded file of the given size.
+// A supported image is read to strip its metadata and generate its thumbnails,
+// the other files are streamed to the blob store.
+func readUpload(file io.Reader, size int64) (upload, error) {
+	head := make([]byte, sniffLen)
+
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return upload{}, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	contentType := http.DetectContentType(head[:n])
+	content := io.MultiReader(bytes.NewReader(head[:n]), file)
+
+	if !thumbnail.Supported(contentType) {
+		return upload{content: content, size: size, contentType: contentType}, nil
+	}
+
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return upload{}, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	image, err := thumbnail.Process(data, contentType)
+	if err != nil {
+		return upload{}, err
+	}
+
+	return upload{
+		content:     bytes.NewReader(image.Content),
+		size:        int64(len(image.Content)),
+		contentType: contentType,
+		image:       &image,
+	}, nil
+}
+
+// store puts the uploaded file and its thumbnails in the blob store.
+func (h *AttachmentHandler) store(ctx context.Context, attachmentID string, upload upload) error {
+	if err := h.blobs.Put(ctx, attachmentID, upload.content, upload.size, upload.contentType); err != nil {
+		return err
+	}
+
+	if upload.image == nil {
+		return nil
+	}
+
+	for _, t := range upload.image.Thumbnails {
+		err := h.blobs.Put(ctx, thumbnailKey(attachmentID, t.Size.Name),
+			bytes.NewReader(t.Content), int64(len(t.Content)), thumbnail.ContentType(upload.contentType))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// thumbnailKey is the key of a thumbnail of an image attachment in the blob store.
+func thumbnailKey(attachmentID, size string) string {
+	return attachmentID + "_" + size
+}
+
+// deleteBlobs deletes the blobs of the attachment, its content and the thumbnails of an image.
+func deleteBlobs(ctx context.Context, blobs BlobStore, attachment repo.Attachment) error {
+	keys := []string{attachment.ID}
+
+	if attachment.Image != nil {
+		for _, size := range attachment.Image.Thumbnails {
+			keys = append(keys, thumbnailKey(attachment.ID, size))
+		}
+	}
+
+	var errs []error
+
+	for _, key := range keys {
+		if err := blobs.Delete(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// pruneUnattached deletes the attachments left unattached for the unattached TTL, and their blobs,
+// at most once per attachmentPruneInterval.
+func (h *AttachmentHandler) pruneUnattached(ctx context.Context) {
+	h.mu.Lock()
+
+	now := h.now()
+	if now.Sub(h.prunedAt) < attachmentPruneInterval {
+		h.mu.Unlock()
+
+		return
+	}
+
+	h.prunedAt = now
+	h.mu.Unlock()
+
+	expired, err := h.messageRepo.GetUnattachedAttachments(now.Add(-h.unattachedTTL))
+	if err != nil {
+		h.logger.ErrorContext(ctx, "failed to get unattached attachments", slog.String("error", err.Error()))
+
+		return
+	}
+
+	deleted := 0
+
+	for _, attachment := range expired {
+		// an attachment attached meanwhile is kept.
+		if err = h.messageRepo.DeleteAttachment(attachment.ID); err != nil {
+			if !errors.Is(err, repo.ErrAttachmentInUse) && !errors.Is(err, repo.ErrAttachmentNotFound) {
+				h.logger.ErrorContext(ctx, "failed to delete attachment", slog.String("error", err.Error()))
+			}
+
+			continue
+		}
+
+		deleted++
+
+		if err = deleteBlobs(ctx, h.blobs, attachment); err != nil {
+			h.logger.ErrorContext(ctx, "failed to delete attachment content", slog.String("error", err.Error()))
+		}
+	}
+
+	if deleted > 0 {
+		h.logger.InfoContext(ctx, "deleted unattached attachments", slog.Int("count", deleted))
+	}
+}
+
+// uploadFilename returns the name of the uploaded file without its directories, whatever the client system.
+func uploadFilename(filename string) string {
+	filename = filepath.Base(strings.ReplaceAll(filename, `\`, "/"))
+	if filename == "." || filename == "/" {
+		return defaultFilename
+	}
+
+	return filename
+}
+
+// tooLarge is the error message of a file larger than the maximum size.
+func (h *AttachmentHandler) tooLarge() string {
+	return fmt.Sprintf("file is larger than %d bytes", h.maxSize)
+}
+
+// Download writes the content of an attachment.
+// An attachment not attached to a message yet is only visible to its uploader,
+// once attached it is visible to the participants of the chat of the message.
+func (h *AttachmentHandler) Download(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler download an attachment", slog.String("path", r.URL.Path))
+
+	attachment, ok := h.getVisibleAttachment(w, r)
+	if !ok {
+		return
+	}
+
+	// the images are displayed by the browsers, the other files are downloaded
+	// so an uploaded page can't run in the context of the API.
+	disposition := "attachment"
+	if strings.HasPrefix(attachment.ContentType, "image/") {
+		disposition = "inline"
+	}
+
+	h.writeBlob(w, r, attachment.ID, attachment.ContentType, attachment.Size,
+		mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+}
+
+// DownloadThumbnail writes a thumbnail of an image attachment, visible like the attachment.
+func (h *AttachmentHandler) DownloadThumbnail(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler download a thumbnail", slog.String("path", r.URL.Path))
+
+	attachment, ok := h.getVisibleAttachment(w, r)
+	if !ok {
+		return
+	}
+
+	size := r.PathValue("size")
+	if attachment.Image == nil || !slices.Contains(attachment.Image.Thumbnails, size) {
+		writeProblem(w, r, problemThumbnailNotFound, "thumbnail not found")
+
+		return
+	}
+
+	h.writeBlob(w, r, thumbnailKey(attachment.ID, size), thumbnail.ContentType(attachment.ContentType), -1, "inline")
+}
+
+// writeBlob writes the blob of the key with its content type, its size if known (-1 otherwise) and its disposition.
+func (h *AttachmentHandler) writeBlob(w http.ResponseWriter, r *http.Request, key, contentType string, size int64, disposition string) {
+	content, err := h.blobs.Get(r.Context(), key)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get attachment content", slog.String("error", err.Error()))
+
+		if errors.Is(err, blob.ErrNotFound) {
+			writeProblem(w, r, problemAttachmentNotFound, "attachment not found")
+
+			return
+		}
+
+		writeProblem(w, r, problemInternal, "failed to get attachment content")
+
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if _, err = io.Copy(w, content); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to write attachment", slog.String("error", err.Error()))
+	}
+}
+
+// getVisibleAttachment gets the attachment if the authenticated user can see it,
+// otherwise it writes the error and returns false.
+// An unattached attachment of another user, or one of a message hidden from the user, is not found,
+// its existence is not disclosed.
+func (h *AttachmentHandler) getVisibleAttachment(w http.ResponseWriter, r *http.Request) (repo.Attachment, bool) {
+	user := currentUser(r)
+
+	attachment, err := h.messageRepo.GetAttachment(r.PathValue("id"))
+	if err == nil && !attachment.Attached() && attachment.Uploader != user {
+		err = repo.ErrAttachmentNotFound
+	}
+
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get attachment", slog.String("error", err.Error()))
+
+		if errors.Is(err, repo.ErrAttachmentNotFound) {
+			writeProblem(w, r, problemAttachmentNotFound, "attachment not found")
+
+			return repo.Attachment{}, false
+		}
+
+		writeProblem(w, r, problemInternal, "failed to get attachment")
+
+		return repo.Attachment{}, false
+	}
+
+	if !attachment.Attached() {
+		return attachment, true
+	}
+
+	chat, err := h.chatRepo.GetChat(attachment.ChatID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get chat", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to get chat")
+
+		return repo.Attachment{}, false
+	}
+
+	if !slices.Contains(chat.Participants, user) {
+		h.logger.ErrorContext(r.Context(), "user is not a participant of the chat", slog.String("phoneNumber", user))
+		writeProblem(w, r, problemNotParticipant, "user is not a participant of the chat")
+
+		return repo.Attachment{}, false
+	}
+
+	message, err := h.messageRepo.GetChatMessage(attachment.ChatID, attachment.MessageID)
+	if err == nil && !message.VisibleTo(user) {
+		err = repo.ErrMessageNotFound
+	}
+
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get attachment message", slog.String("error", err.Error()))
+
+		if errors.Is(err, repo.ErrMessageNotFound) {
+			writeProblem(w, r, problemAttachmentNotFound, "attachment not found")
+
+			return repo.Attachment{}, false
+		}
+
+		writeProblem(w, r, problemInternal, "failed to get message")
+
+		return repo.Attachment{}, false
+	}
+
+	return attachment, true
+}
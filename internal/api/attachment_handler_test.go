@@ -0,0 +1,389 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	stdimage "image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/auth"
+	"github.com/jbdoumenjou/mychat/internal/blob"
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// testMaxAttachmentSize is the maximum size of the files uploaded by the tests.
+const testMaxAttachmentSize = 16 << 10
+
+// fakePNG starts like a PNG image, enough to detect its content type but not to decode it.
+const fakePNG = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+// testPNG encodes a PNG image of the size.
+func testPNG(t *testing.T, width, height int) string {
+	t.Helper()
+
+	img := stdimage.NewRGBA(stdimage.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), stdimage.NewUniform(color.RGBA{R: 255, A: 255}), stdimage.Point{}, draw.Src)
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+
+	return buf.String()
+}
+
+// newUploadRequest creates a request uploading the content as a file, without authentication.
+func newUploadRequest(ctx context.Context, t *testing.T, field, filename, content string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile(field, filename)
+	require.NoError(t, err)
+	_, err = part.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/attachments", &body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	return req
+}
+
+// uploadRequest uploads the content as a file of the user with the API.
+func uploadRequest(ctx context.Context, t *testing.T, user, field, filename, content string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := newUploadRequest(ctx, t, field, filename, content)
+
+	if user != "" {
+		authenticate(t, req, user)
+	}
+
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+
+	return rr
+}
+
+// uploadTest uploads the content as a file of the user with the API.
+func uploadTest(ctx context.Context, t *testing.T, user, filename, content string) AttachmentResponse {
+	t.Helper()
+
+	rr := uploadRequest(ctx, t, user, "file", filename, content)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	var attachment AttachmentResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&attachment))
+	assert.Equal(t, attachment.URL, rr.Header().Get("Location"))
+
+	return attachment
+}
+
+// thumbnailFailingBlobs is a blob store failing to store the thumbnails.
+type thumbnailFailingBlobs struct {
+	*blob.LocalStore
+}
+
+func (b thumbnailFailingBlobs) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if strings.Contains(key, "_") {
+		return errors.New("storage is full")
+	}
+
+	return b.LocalStore.Put(ctx, key, r, size, contentType)
+}
+
+// newTestAttachmentHandler creates an AttachmentHandler with its own repository and blob store.
+func newTestAttachmentHandler(t *testing.T, blobs BlobStore) (*AttachmentHandler, *repo.MessageRepository) {
+	t.Helper()
+
+	messageRepo := repo.NewMessageRepository()
+
+	return NewAttachmentHandler(repo.NewChatRepository(messageRepo), messageRepo, blobs, testMaxAttachmentSize, time.Hour), messageRepo
+}
+
+// serveUpload uploads the content as a file of the user to the handler.
+func serveUpload(ctx context.Context, t *testing.T, handler *AttachmentHandler, user, filename, content string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := newUploadRequest(ctx, t, "file", filename, content)
+	req = req.WithContext(auth.WithUser(req.Context(), user))
+
+	rr := httptest.NewRecorder()
+	handler.Upload(rr, req)
+
+	return rr
+}
+
+// sendAttachmentsTest sends a message with the attachments to the receiver with the API.
+func sendAttachmentsTest(ctx context.Context, t *testing.T, sender, receiver string, attachments ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	payload, err := json.Marshal(Message{Receiver: receiver, Attachments: attachments})
+	require.NoError(t, err)
+
+	return requestTest(ctx, t, http.MethodPost, "/messages", string(payload), sender)
+}
+
+func TestAttachmentHandler_Attachments(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 3)
+	sender, receiver, outsider := users[0], users[1], users[2]
+
+	// the declared content type is ignored, the content tells it.
+	cat := testPNG(t, 320, 200)
+	image := uploadTest(ctx, t, sender, "cat.png", cat)
+	assert.Equal(t, "cat.png", image.Filename)
+	assert.Equal(t, "image/png", image.ContentType)
+	assert.Equal(t, int64(len(cat)), image.Size)
+	assert.Equal(t, "/attachments/"+image.ID, image.URL)
+	assert.Equal(t, 320, image.Width)
+	assert.Equal(t, 200, image.Height)
+	assert.Equal(t, map[string]string{
+		"small":  image.URL + "/thumbnails/small",
+		"medium": image.URL + "/thumbnails/medium",
+	}, image.Thumbnails)
+
+	page := uploadTest(ctx, t, sender, `C:\Users\me\page.html`, "<html><script>alert(1)</script></html>")
+	assert.Equal(t, "page.html", page.Filename)
+	assert.Equal(t, "text/html; charset=utf-8", page.ContentType)
+	assert.Empty(t, page.Thumbnails)
+
+	// the unattached attachments are only visible to their uploader.
+	rr := getTest(t, image.URL, sender)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, cat, rr.Body.String())
+
+	rr = getTest(t, image.URL, receiver)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = getTest(t, image.Thumbnails["small"], receiver)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// a message can be made of attachments only.
+	rr = sendAttachmentsTest(ctx, t, sender, receiver, image.ID, page.ID)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	var message MessageResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&message))
+	assert.Empty(t, message.Content)
+	assert.Equal(t, []AttachmentResponse{image, page}, message.Attachments)
+
+	messages := getTestPage[MessageResponse](t, "/chats/"+message.ChatID+"/messages", receiver).Items
+	require.Len(t, messages, 1)
+	assert.Equal(t, message.Attachments, messages[0].Attachments)
+
+	// the images are displayed, the other files are downloaded.
+	rr = getTest(t, image.URL, receiver)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, cat, rr.Body.String())
+	assert.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+	assert.Equal(t, `inline; filename=cat.png`, rr.Header().Get("Content-Disposition"))
+	assert.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+
+	rr = getTest(t, page.URL, receiver)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, `attachment; filename=page.html`, rr.Header().Get("Content-Disposition"))
+
+	// the thumbnails fit in their size, a smaller image is not scaled up.
+	for size, bounds := range map[string]stdimage.Rectangle{
+		"small":  stdimage.Rect(0, 0, 160, 100),
+		"medium": stdimage.Rect(0, 0, 320, 200),
+	} {
+		rr = getTest(t, image.Thumbnails[size], receiver)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+
+		thumbnail, err := png.Decode(rr.Body)
+		require.NoError(t, err)
+		assert.Equal(t, bounds, thumbnail.Bounds(), size)
+	}
+
+	rr = getTest(t, image.URL+"/thumbnails/large", receiver)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "thumbnail not found", decodeProblem(t, rr).Detail)
+
+	rr = getTest(t, page.URL+"/thumbnails/small", receiver)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	for _, target := range []string{image.URL, image.Thumbnails["small"]} {
+		rr = getTest(t, target, outsider)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Equal(t, "user is not a participant of the chat", decodeProblem(t, rr).Detail)
+	}
+
+	// the attachments of a deleted message are erased.
+	rr = requestTest(ctx, t, http.MethodDelete, "/chats/"+message.ChatID+"/messages/"+message.ID, "", sender)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+
+	rr = getTest(t, image.URL, receiver)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// their content and the thumbnails are deleted from the blob store.
+	for _, key := range []string{image.ID, thumbnailKey(image.ID, "small"), thumbnailKey(image.ID, "medium"), page.ID} {
+		_, err := testBlobs.Get(ctx, key)
+		require.ErrorIs(t, err, blob.ErrNotFound, key)
+	}
+}
+
+func TestAttachmentHandler_Errors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 2)
+	sender, receiver := users[0], users[1]
+
+	rr := uploadRequest(ctx, t, "", "file", "notes.txt", "hello")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = uploadRequest(ctx, t, sender, "document", "notes.txt", "hello")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "a multipart form with a file field is required", decodeProblem(t, rr).Detail)
+
+	rr = uploadRequest(ctx, t, sender, "file", "notes.txt", strings.Repeat("a", testMaxAttachmentSize+1))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Equal(t, "file is larger than 16384 bytes", decodeProblem(t, rr).Detail)
+
+	// an image is decoded to strip its metadata.
+	rr = uploadRequest(ctx, t, sender, "file", "cat.png", fakePNG)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "invalid image", decodeProblem(t, rr).Detail)
+
+	rr = getTest(t, "/attachments/unknown", sender)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "attachment not found", decodeProblem(t, rr).Detail)
+
+	notes := uploadTest(ctx, t, sender, "notes.txt", "hello")
+	other := uploadTest(ctx, t, receiver, "other.txt", "hello")
+
+	testCases := []struct {
+		desc        string
+		attachments []string
+		message     string
+	}{
+		{
+			desc:        "unknown attachment",
+			attachments: []string{"unknown"},
+			message:     "attachment not found",
+		},
+		{
+			desc:        "attachment of another user",
+			attachments: []string{notes.ID, other.ID},
+			message:     "attachment not found",
+		},
+		{
+			desc:        "attachment given twice",
+			attachments: []string{notes.ID, notes.ID},
+			message:     "attachment already attached to a message",
+		},
+		{
+			desc:        "too many attachments",
+			attachments: strings.Split(strings.Repeat(notes.ID+",", maxMessageAttachments+1), ",")[:maxMessageAttachments+1],
+			message:     "a message has at most 10 attachments",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			rr := sendAttachmentsTest(ctx, t, sender, receiver, test.attachments...)
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			assert.Equal(t, test.message, decodeProblem(t, rr).Detail)
+		})
+	}
+
+	// the failed messages left the attachment unattached.
+	rr = sendAttachmentsTest(ctx, t, sender, receiver, notes.ID)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	rr = sendAttachmentsTest(ctx, t, sender, receiver, notes.ID)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "attachment already attached to a message", decodeProblem(t, rr).Detail)
+}
+
+func TestAttachmentHandler_Upload_StoreFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+
+	blobs, err := blob.NewLocalStore(dir)
+	require.NoError(t, err)
+
+	handler, _ := newTestAttachmentHandler(t, thumbnailFailingBlobs{blobs})
+
+	rr := serveUpload(ctx, t, handler, "+33612345678", "cat.png", testPNG(t, 320, 200))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+
+	// the image stored before its thumbnails is deleted.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestAttachmentHandler_Upload_PruneUnattached(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	blobs, err := blob.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+
+	handler, messageRepo := newTestAttachmentHandler(t, blobs)
+	user := "+33612345678"
+
+	var uploaded []AttachmentResponse
+
+	for _, name := range []string{"cat.png", "dog.png"} {
+		rr := serveUpload(ctx, t, handler, user, name, testPNG(t, 320, 200))
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		var attachment AttachmentResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&attachment))
+
+		uploaded = append(uploaded, attachment)
+	}
+
+	cat, dog := uploaded[0], uploaded[1]
+
+	message, err := messageRepo.AddMessageWithOptions("chat", user, "", repo.MessageOptions{Attachments: []string{dog.ID}})
+	require.NoError(t, err)
+	require.Len(t, message.Attachments, 1)
+
+	// an upload after the TTL deletes the unattached attachments, and their blobs.
+	handler.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+	rr := serveUpload(ctx, t, handler, user, "notes.txt", "hello")
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	_, err = messageRepo.GetAttachment(cat.ID)
+	require.ErrorIs(t, err, repo.ErrAttachmentNotFound)
+
+	for _, key := range []string{cat.ID, thumbnailKey(cat.ID, "small"), thumbnailKey(cat.ID, "medium")} {
+		_, err = blobs.Get(ctx, key)
+		require.ErrorIs(t, err, blob.ErrNotFound, key)
+	}
+
+	// the attached ones are kept.
+	_, err = messageRepo.GetAttachment(dog.ID)
+	require.NoError(t, err)
+
+	content, err := blobs.Get(ctx, dog.ID)
+	require.NoError(t, err)
+	require.NoError(t, content.Close())
+}
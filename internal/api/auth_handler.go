@@ -0,0 +1,218 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/auth"
+)
+
+// AuthHandler is the handler for the user authentication.
+type AuthHandler struct {
+	userRepo MessageUserRepo
+	phones   PhoneNormalizer
+	verifier PhoneVerifier
+	tokens   TokenService
+
+	logger *slog.Logger
+}
+
+// TokenService issues and verifies the access tokens.
+type TokenService interface {
+	Issue(user string) (auth.Token, error)
+	Verify(token string) (string, error)
+}
+
+// NewAuthHandler creates a new AuthHandler.
+func NewAuthHandler(userRepo MessageUserRepo, phones PhoneNormalizer, verifier PhoneVerifier, tokens TokenService) *AuthHandler {
+	logger := slog.With(slog.String("handler", "auth"))
+	logger.Info("created handler")
+
+	return &AuthHandler{
+		userRepo: userRepo,
+		phones:   phones,
+		verifier: verifier,
+		tokens:   tokens,
+		logger:   logger,
+	}
+}
+
+// TokenResponse represents an access token issued to a user.
+type TokenResponse struct {
+	AccessToken string    `json:"accessToken"`
+	TokenType   string    `json:"tokenType"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// StartLogin sends a one-time code to a registered phone number, to log in.
+func (h *AuthHandler) StartLogin(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler start login", slog.String("path", r.URL.Path))
+
+	var user User
+
+	// Decode the JSON body
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		h.logger.ErrorContext(r.Context(), "Invalid input")
+		writeProblem(w, r, problemInvalidInput, "Invalid input")
+
+		return
+	}
+
+	phoneNumber, ok := normalizePhoneNumber(w, r, h.phones, "phoneNumber", user.PhoneNumber, h.logger)
+	if !ok {
+		return
+	}
+
+	if !h.userRepo.IsRegistered(phoneNumber) {
+		h.logger.ErrorContext(r.Context(),
+			"phone number not registered",
+			slog.String("phoneNumber", phoneNumber),
+		)
+		writeProblem(w, r, problemPhoneNotRegistered, "phone number not registered")
+
+		return
+	}
+
+	sendVerificationCode(w, r, h.verifier, phoneNumber, h.logger)
+}
+
+// Login issues an access token to a registered user, given the one-time code sent by StartLogin.
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler login", slog.String("path", r.URL.Path))
+
+	var verification Verification
+
+	// Decode the JSON body
+	if err := json.NewDecoder(r.Body).Decode(&verification); err != nil {
+		h.logger.ErrorContext(r.Context(), "Invalid input")
+		writeProblem(w, r, problemInvalidInput, "Invalid input")
+
+		return
+	}
+
+	phoneNumber, ok := normalizePhoneNumber(w, r, h.phones, "phoneNumber", verification.PhoneNumber, h.logger)
+	if !ok {
+		return
+	}
+
+	verification.PhoneNumber = phoneNumber
+
+	if !h.userRepo.IsRegistered(verification.PhoneNumber) {
+		h.logger.ErrorContext(r.Context(),
+			"phone number not registered",
+			slog.String("phoneNumber", verification.PhoneNumber),
+		)
+		writeProblem(w, r, problemInvalidCredentials, "invalid credentials")
+
+		return
+	}
+
+	if !verifyCode(w, r, h.verifier, verification, http.StatusUnauthorized, h.logger) {
+		return
+	}
+
+	writeToken(w, r, h.tokens, verification.PhoneNumber, http.StatusOK, h.logger)
+}
+
+// writeToken issues an access token to the user and writes it with the status.
+func writeToken(w http.ResponseWriter, r *http.Request, tokens TokenService, user string, status int, logger *slog.Logger) {
+	token, err := tokens.Issue(user)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "failed to issue token", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to issue token")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	err = json.NewEncoder(w).Encode(TokenResponse{
+		AccessToken: token.Value,
+		TokenType:   "Bearer",
+		ExpiresAt:   token.ExpiresAt,
+	})
+	if err != nil {
+		logger.ErrorContext(r.Context(), "failed to write response", slog.String("error", err.Error()))
+	}
+}
+
+// Authenticate authenticates the requests carrying an access token,
+// the authenticated user is put in the request context.
+// The token is read from the Authorization header with the Bearer scheme,
+// or from the access_token query parameter for the clients which can't set headers, like browser WebSockets.
+// Requests without token go through anonymously, the routes requiring a user reject them.
+func (h *AuthHandler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := accessToken(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		user, err := h.tokens.Verify(token)
+		if err != nil {
+			h.logger.DebugContext(r.Context(), "invalid token", slog.String("error", err.Error()))
+
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+
+			if errors.Is(err, auth.ErrExpiredToken) {
+				writeProblem(w, r, problemTokenExpired, "token expired")
+
+				return
+			}
+
+			writeProblem(w, r, problemInvalidToken, "invalid token")
+
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), user)))
+	})
+}
+
+// accessToken returns the access token of the request, if any.
+// A malformed Authorization header gives an empty token, to reject the request.
+func accessToken(r *http.Request) (string, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, _ := strings.Cut(header, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return "", true
+		}
+
+		return strings.TrimSpace(token), true
+	}
+
+	if token := r.URL.Query().Get("access_token"); token != "" {
+		return token, true
+	}
+
+	return "", false
+}
+
+// requireUser rejects the requests without authenticated user.
+func requireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.UserFromContext(r.Context()); !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeProblem(w, r, problemAuthenticationMissing, "authentication required")
+
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// currentUser returns the authenticated user of a request going through requireUser.
+func currentUser(r *http.Request) string {
+	user, _ := auth.UserFromContext(r.Context())
+
+	return user
+}
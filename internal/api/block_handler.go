@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// BlockHandler is the handler for the users blocked by a user.
+// The direct messages of a blocked user are only visible to their sender, without revealing the block,
+// the direct chat with a blocked user is hidden, and a blocked user can't add the blocker to a group chat.
+type BlockHandler struct {
+	blockRepo BlockRepo
+	userRepo  MessageUserRepo
+	phones    PhoneNormalizer
+
+	logger *slog.Logger
+}
+
+// BlockRepo defines the block repository.
+type BlockRepo interface {
+	AddBlock(blocker, blocked string) error
+	RemoveBlock(blocker, blocked string) error
+	GetBlocks(blocker string) ([]repo.Block, error)
+}
+
+// NewBlockHandler creates a new BlockHandler.
+func NewBlockHandler(blockRepo BlockRepo, userRepo MessageUserRepo, phones PhoneNormalizer) *BlockHandler {
+	logger := slog.With(slog.String("handler", "block"))
+	logger.Info("created handler")
+
+	return &BlockHandler{
+		blockRepo: blockRepo,
+		userRepo:  userRepo,
+		phones:    phones,
+		logger:    logger,
+	}
+}
+
+var (
+	errBlockSelf          = newFieldError("phoneNumber", "phoneNumber: a user can't block themselves")
+	errBlockNotRegistered = errors.New("phone number not registered")
+	errBlockNotFound      = errors.New("phone number not blocked")
+)
+
+// BlockResponse is a user blocked by the authenticated user.
+// This is the response format for the API.
+type BlockResponse struct {
+	PhoneNumber string    `json:"phoneNumber"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Block blocks a registered user for the authenticated user, blocking a user twice changes nothing.
+func (h *BlockHandler) Block(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler block a user", slog.String("path", r.URL.Path))
+
+	blocked, ok := normalizePhoneNumber(w, r, h.phones, "phoneNumber", r.PathValue("phoneNumber"), h.logger)
+	if !ok {
+		return
+	}
+
+	user := currentUser(r)
+
+	if blocked == user {
+		writeError(w, r, errBlockSelf, "failed to block user")
+
+		return
+	}
+
+	if !h.userRepo.IsRegistered(blocked) {
+		h.logger.ErrorContext(r.Context(), "phone number not registered", slog.String("phoneNumber", blocked))
+		writeError(w, r, errBlockNotRegistered, "failed to block user")
+
+		return
+	}
+
+	if err := h.blockRepo.AddBlock(user, blocked); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to block user", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to block user")
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Unblock unblocks a user blocked by the authenticated user.
+func (h *BlockHandler) Unblock(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler unblock a user", slog.String("path", r.URL.Path))
+
+	blocked, ok := normalizePhoneNumber(w, r, h.phones, "phoneNumber", r.PathValue("phoneNumber"), h.logger)
+	if !ok {
+		return
+	}
+
+	if err := h.blockRepo.RemoveBlock(currentUser(r), blocked); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to unblock user", slog.String("error", err.Error()))
+
+		if errors.Is(err, repo.ErrBlockNotFound) {
+			writeError(w, r, errBlockNotFound, "failed to unblock user")
+
+			return
+		}
+
+		writeProblem(w, r, problemInternal, "failed to unblock user")
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListBlocks lists the users blocked by the authenticated user, in the order they have been blocked.
+func (h *BlockHandler) ListBlocks(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler list blocked users", slog.String("path", r.URL.Path))
+
+	blocks, err := h.blockRepo.GetBlocks(currentUser(r))
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get blocked users", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to get blocked users")
+
+		return
+	}
+
+	response := make([]BlockResponse, len(blocks))
+	for i, block := range blocks {
+		response[i] = BlockResponse{PhoneNumber: block.Blocked, CreatedAt: block.CreatedAt}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to write response", slog.String("error", err.Error()))
+	}
+}
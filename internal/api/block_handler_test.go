@@ -0,0 +1,277 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/realtime"
+)
+
+// getTestBlocks lists the users blocked by the user with the API.
+func getTestBlocks(t *testing.T, user string) []string {
+	t.Helper()
+
+	rr := getTest(t, "/blocks", user)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var blocks []BlockResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&blocks))
+
+	phoneNumbers := make([]string, len(blocks))
+	for i, block := range blocks {
+		assert.False(t, block.CreatedAt.IsZero())
+
+		phoneNumbers[i] = block.PhoneNumber
+	}
+
+	return phoneNumbers
+}
+
+func TestBlockHandler_Blocks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 3)
+	alice, bob, carol := users[0], users[1], users[2]
+
+	assert.Empty(t, getTestBlocks(t, alice))
+
+	rr := requestTest(ctx, t, http.MethodPost, "/blocks/"+bob, "", alice)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+
+	time.Sleep(time.Millisecond)
+
+	rr = requestTest(ctx, t, http.MethodPost, "/blocks/"+carol, "", alice)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+
+	// blocking a user twice changes nothing.
+	rr = requestTest(ctx, t, http.MethodPost, "/blocks/"+bob, "", alice)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+
+	assert.Equal(t, []string{bob, carol}, getTestBlocks(t, alice))
+	assert.Empty(t, getTestBlocks(t, bob))
+
+	rr = requestTest(ctx, t, http.MethodDelete, "/blocks/"+bob, "", alice)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+
+	assert.Equal(t, []string{carol}, getTestBlocks(t, alice))
+
+	rr = requestTest(ctx, t, http.MethodDelete, "/blocks/"+bob, "", alice)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "phone number not blocked", decodeProblem(t, rr).Detail)
+}
+
+func TestBlockHandler_Block_Errors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 2)
+	alice, bob := users[0], users[1]
+
+	testCases := []struct {
+		desc    string
+		method  string
+		target  string
+		user    string
+		status  int
+		message string
+	}{
+		{
+			desc:    "anonymous",
+			method:  http.MethodPost,
+			target:  "/blocks/" + bob,
+			status:  http.StatusUnauthorized,
+			message: "authentication required",
+		},
+		{
+			desc:    "invalid phone number",
+			method:  http.MethodPost,
+			target:  "/blocks/123",
+			user:    alice,
+			status:  http.StatusBadRequest,
+			message: "phoneNumber: invalid phone number: too short",
+		},
+		{
+			desc:    "self",
+			method:  http.MethodPost,
+			target:  "/blocks/" + alice,
+			user:    alice,
+			status:  http.StatusBadRequest,
+			message: "phoneNumber: a user can't block themselves",
+		},
+		{
+			desc:    "not registered",
+			method:  http.MethodPost,
+			target:  "/blocks/" + generateRandomPhoneNumber(),
+			user:    alice,
+			status:  http.StatusNotFound,
+			message: "phone number not registered",
+		},
+		{
+			desc:    "unblock invalid phone number",
+			method:  http.MethodDelete,
+			target:  "/blocks/123",
+			user:    alice,
+			status:  http.StatusBadRequest,
+			message: "phoneNumber: invalid phone number: too short",
+		},
+		{
+			desc:    "unblock not blocked",
+			method:  http.MethodDelete,
+			target:  "/blocks/" + bob,
+			user:    alice,
+			status:  http.StatusNotFound,
+			message: "phone number not blocked",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			rr := requestTest(ctx, t, test.method, test.target, "", test.user)
+			assert.Equal(t, test.status, rr.Code)
+			assert.Equal(t, test.message, decodeProblem(t, rr).Detail)
+		})
+	}
+}
+
+func TestSendMessage_Blocked(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 3)
+	alice, bob, carol := users[0], users[1], users[2]
+
+	hello := sendTestMessage(ctx, t, alice, bob, "hello")
+
+	rr := requestTest(ctx, t, http.MethodPost, "/blocks/"+alice, "", bob)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+
+	// the message to the blocker is sent as usual, the block is not revealed to the sender.
+	blocked := sendTestMessage(ctx, t, alice, bob, "blocked"+alice[1:])
+	assert.Equal(t, hello.ChatID, blocked.ChatID)
+
+	page := getTestPage[MessageResponse](t, "/chats/"+hello.ChatID+"/messages", alice)
+	assert.Equal(t, []string{hello.ID, blocked.ID}, messageIDs(page.Items))
+	assert.Equal(t, map[string]string{bob: receiptSent}, page.Items[1].Receipts)
+
+	// the blocker does not see it, nor the direct chat.
+	page = getTestPage[MessageResponse](t, "/chats/"+hello.ChatID+"/messages", bob)
+	assert.Equal(t, []string{hello.ID}, messageIDs(page.Items))
+
+	rr = getTest(t, "/chats/"+hello.ChatID+"/messages/"+blocked.ID, bob)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = requestTest(ctx, t, http.MethodPut, "/chats/"+hello.ChatID+"/messages/"+blocked.ID+"/reactions/👍", "", bob)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// nor the files of the blocked messages.
+	image := uploadTest(ctx, t, alice, "cat.png", testPNG(t, 320, 200))
+
+	rr = sendAttachmentsTest(ctx, t, alice, bob, image.ID)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	for _, target := range []string{image.URL, image.Thumbnails["small"]} {
+		rr = getTest(t, target, bob)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, "attachment not found", decodeProblem(t, rr).Detail)
+
+		rr = getTest(t, target, alice)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	results := getTestPage[SearchResultResponse](t, searchTarget("q", "blocked"+alice[1:]), bob)
+	assert.Empty(t, results.Items)
+
+	chats := getTestPage[ChatSummaryResponse](t, "/chats", bob)
+	for _, chat := range chats.Items {
+		assert.NotEqual(t, hello.ChatID, chat.ID)
+	}
+
+	// the blocker can't message the blocked user.
+	payload, err := json.Marshal(Message{Receiver: alice, Content: "hi"})
+	require.NoError(t, err)
+
+	rr = requestTest(ctx, t, http.MethodPost, "/messages", string(payload), bob)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	problem := decodeProblem(t, rr)
+	assert.Equal(t, "receiver_blocked", problem.Code)
+	assert.Equal(t, "receiver is blocked", problem.Detail)
+
+	// the blocked user can't add the blocker to a group chat.
+	group := createTestGroup(ctx, t, alice, "Friends", bob, carol)
+	assert.Equal(t, []string{alice, carol}, group.Participants)
+
+	rr = requestTest(ctx, t, http.MethodPost, "/chats/"+group.ID+"/participants", `{"members": ["`+bob+`"]}`, alice)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var chat ChatResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&chat))
+	assert.Equal(t, []string{alice, carol}, chat.Participants)
+
+	// the direct chat is back once unblocked, without the blocked message.
+	rr = requestTest(ctx, t, http.MethodDelete, "/blocks/"+alice, "", bob)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+
+	chats = getTestPage[ChatSummaryResponse](t, "/chats", bob)
+
+	var found bool
+
+	for _, chat := range chats.Items {
+		if chat.ID == hello.ChatID {
+			found = true
+
+			require.NotNil(t, chat.LastMessage)
+			assert.Equal(t, "hello", chat.LastMessage.Content)
+		}
+	}
+
+	assert.True(t, found)
+
+	page = getTestPage[MessageResponse](t, "/chats/"+hello.ChatID+"/messages", bob)
+	assert.Equal(t, []string{hello.ID}, messageIDs(page.Items))
+}
+
+func TestSendMessage_Blocked_ChatCreated(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := httptest.NewServer(testRouter)
+	t.Cleanup(server.Close)
+
+	users := registerTestUsers(t, 3)
+	alice, bob, carol := users[0], users[1], users[2]
+
+	rr := requestTest(ctx, t, http.MethodPost, "/blocks/"+alice, "", bob)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+
+	aliceStream := openEventStream(ctx, t, server, "/events", alice, "")
+	readSSE(t, aliceStream)
+
+	bobStream := openEventStream(ctx, t, server, "/events", bob, "")
+	readSSE(t, bobStream)
+
+	// the direct chat created by the blocked user is only pushed to its sender.
+	blocked := sendTestMessage(ctx, t, alice, bob, "blocked")
+
+	event := readSSE(t, aliceStream)
+	require.Equal(t, realtime.EventChatCreated, event.Type)
+	assert.Equal(t, blocked.ChatID, chatIDOf(t, event))
+
+	requireMessageEvent(t, blocked, readSSE(t, aliceStream))
+
+	// the next event of the blocker is about another chat.
+	hello := sendTestMessage(ctx, t, carol, bob, "hello")
+
+	event = readSSE(t, bobStream)
+	require.Equal(t, realtime.EventChatCreated, event.Type)
+	assert.Equal(t, hello.ChatID, chatIDOf(t, event))
+
+	requireMessageEvent(t, hello, readSSE(t, bobStream))
+}
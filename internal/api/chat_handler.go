@@ -2,76 +2,304 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/jbdoumenjou/mychat/internal/event"
 	"github.com/jbdoumenjou/mychat/internal/repo"
 )
 
-// ChatHandler is the handler for user registration.
+// ChatHandler is the handler for the chats.
 type ChatHandler struct {
 	chatRepo    ChatRepo
 	messageRepo ChatMessageRepo
+	userRepo    MessageUserRepo
+	phones      PhoneNormalizer
+	receipts    *ReceiptHandler
+	publisher   Publisher
 
 	logger *slog.Logger
 }
 
 // ChatRepo defines the chat repository.
 type ChatRepo interface {
-	GetUserChats(user string) ([]repo.Chat, error)
+	CreateGroupChat(name string, members []string) (repo.Chat, error)
+	GetChat(chatID string) (repo.Chat, error)
+	GetUserChats(user string, query repo.ChatQuery) ([]repo.UserChat, error)
+	AddParticipants(chatID string, users []string) (repo.Chat, []string, error)
+	RemoveParticipant(chatID, user string) (repo.Chat, string, error)
+	SetAdmin(chatID, user string, admin bool) (repo.Chat, error)
+	RenameChat(chatID, name string) (repo.Chat, error)
+	GetBlockers(user string, users []string) ([]string, error)
 }
 
 // ChatMessageRepo defines the chat message repository.
 type ChatMessageRepo interface {
-	GetChatMessages(chatID string) ([]string, error)
+	GetChatMessages(chatID string, query repo.MessageQuery) ([]repo.Message, error)
+	GetChatMessage(chatID, messageID string) (repo.Message, error)
+	AddSystemMessage(chatID, sender, content string) (repo.Message, error)
+	GetReactions(chatID string, messageIDs []string) ([]repo.Reaction, error)
+	GetChatMessagesByID(chatID string, messageIDs []string) ([]repo.Message, error)
 }
 
 // NewChatHandler creates a new ChatHandler.
-func NewChatHandler(chatRepo ChatRepo, messageRepo ChatMessageRepo) *ChatHandler {
+// The messages listed are delivered to the user, their receipts are tracked by the ReceiptHandler.
+func NewChatHandler(
+	chatRepo ChatRepo,
+	messageRepo ChatMessageRepo,
+	userRepo MessageUserRepo,
+	phones PhoneNormalizer,
+	receipts *ReceiptHandler,
+	publisher Publisher,
+) *ChatHandler {
 	logger := slog.With(slog.String("handler", "chat"))
 	logger.Info("created handler")
 
 	return &ChatHandler{
 		chatRepo:    chatRepo,
 		messageRepo: messageRepo,
+		userRepo:    userRepo,
+		phones:      phones,
+		receipts:    receipts,
+		publisher:   publisher,
 		logger:      logger,
 	}
 }
 
-// ChatResponse represents a chat between 2 users.
+// Chat types.
+const (
+	chatTypeDirect = "direct"
+	chatTypeGroup  = "group"
+)
+
+const (
+	// maxChatNameLength is the maximum number of characters of a group chat name.
+	maxChatNameLength = 100
+	// maxGroupMembers is the maximum number of members of a group chat, including its creator.
+	maxGroupMembers = 256
+)
+
+// ChatResponse represents a chat, a direct chat between 2 users or a named group chat.
 // This is the response format for the API.
 // This avoids to expose the internal Chat struct.
 type ChatResponse struct {
 	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	Name         string    `json:"name,omitempty"`
 	Participants []string  `json:"participants"`
+	Admins       []string  `json:"admins,omitempty"`
 	CreatedAt    time.Time `json:"createdAt"`
 }
 
-// ListChats list all chats for a user.
+func newChatResponse(chat repo.Chat) ChatResponse {
+	chatType := chatTypeDirect
+	if chat.Group {
+		chatType = chatTypeGroup
+	}
+
+	return ChatResponse{
+		ID:           chat.ID,
+		Type:         chatType,
+		Name:         chat.Name,
+		Participants: chat.Participants,
+		Admins:       chat.Admins,
+		CreatedAt:    chat.CreatedAt,
+	}
+}
+
+// ChatSummaryResponse represents a chat listed for one of its participants,
+// with its last message and the number of messages the participant has not read.
+// This is the response format for the API.
+type ChatSummaryResponse struct {
+	ChatResponse
+	LastMessage    *MessagePreview `json:"lastMessage,omitempty"`
+	UnreadCount    int64           `json:"unreadCount"`
+	LastActivityAt time.Time       `json:"lastActivityAt"`
+}
+
+// MessagePreview represents the beginning of a message, shown in the chat list.
+// This is the response format for the API.
+type MessagePreview struct {
+	Type      string    `json:"type"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+	Seq       int64     `json:"seq"`
+}
+
+// maxPreviewLength is the maximum length of the content of a message preview, in characters.
+const maxPreviewLength = 100
+
+func newChatSummaryResponse(chat repo.UserChat) ChatSummaryResponse {
+	response := ChatSummaryResponse{
+		ChatResponse:   newChatResponse(chat.Chat),
+		UnreadCount:    chat.Unread,
+		LastActivityAt: chat.LastActivityAt(),
+	}
+
+	if chat.LastMessage != nil {
+		message := newMessageResponse(*chat.LastMessage)
+		response.LastMessage = &MessagePreview{
+			Type:      message.Type,
+			Sender:    message.Sender,
+			Content:   truncate(message.Content, maxPreviewLength),
+			CreatedAt: message.CreatedAt,
+			Seq:       message.Seq,
+		}
+	}
+
+	return response
+}
+
+// truncate shortens the text to at most maxLength characters, ending with an ellipsis when shortened.
+func truncate(text string, maxLength int) string {
+	runes := []rune(text)
+	if len(runes) <= maxLength {
+		return text
+	}
+
+	return string(runes[:maxLength-1]) + "…"
+}
+
+var errTooManyMembers = newFieldError("members", fmt.Sprintf("members: a group chat has at most %d members", maxGroupMembers))
+
+// NewChat represents a group chat to create, the authenticated user is its first member.
+type NewChat struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// CreateChat creates a named group chat with the authenticated user and the members.
+// The members who blocked the user are left out.
+func (h *ChatHandler) CreateChat(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler create chat", slog.String("path", r.URL.Path))
+
+	var newChat NewChat
+
+	// Decode the JSON body
+	if err := json.NewDecoder(r.Body).Decode(&newChat); err != nil {
+		h.logger.ErrorContext(r.Context(), "Invalid input")
+		writeProblem(w, r, problemInvalidInput, "Invalid input")
+
+		return
+	}
+
+	name, err := parseChatName(newChat.Name)
+	if err != nil {
+		writeError(w, r, err, "invalid name")
+
+		return
+	}
+
+	members, err := h.parseMembers(currentUser(r), newChat.Members)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "invalid members", slog.String("error", err.Error()))
+		writeError(w, r, err, "invalid members")
+
+		return
+	}
+
+	members, ok := h.withoutBlockers(w, r, members)
+	if !ok {
+		return
+	}
+
+	chat, err := h.chatRepo.CreateGroupChat(name, members)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to create chat", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to create chat")
+
+		return
+	}
+
+	h.publisher.Publish(r.Context(), event.ChatCreated{Chat: chat, Participants: chat.Participants})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/chats/"+chat.ID)
+	w.WriteHeader(http.StatusCreated)
+
+	if err = json.NewEncoder(w).Encode(newChatResponse(chat)); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to write response", slog.String("error", err.Error()))
+	}
+}
+
+// parseChatName validates the name of a group chat, trimming the surrounding spaces.
+func parseChatName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+
+	switch {
+	case name == "":
+		return "", newFieldError("name", "name is required")
+	case utf8.RuneCountInString(name) > maxChatNameLength:
+		return "", newFieldError("name", fmt.Sprintf("name must be at most %d characters", maxChatNameLength))
+	}
+
+	return name, nil
+}
+
+// parseMembers normalizes the members of a group chat created by the creator.
+// The creator is the first member, the duplicated members are ignored,
+// and the members must be registered.
+func (h *ChatHandler) parseMembers(creator string, phoneNumbers []string) ([]string, error) {
+	// the creator takes a place in the group.
+	if len(phoneNumbers) >= maxGroupMembers {
+		return nil, errTooManyMembers
+	}
+
+	members := []string{creator}
+
+	for i, phoneNumber := range phoneNumbers {
+		member, err := h.phones.Normalize(phoneNumber)
+		if err != nil {
+			return nil, wrapFieldError(fmt.Sprintf("members[%d]", i), err)
+		}
+
+		if slices.Contains(members, member) {
+			continue
+		}
+
+		if !h.userRepo.IsRegistered(member) {
+			return nil, wrapFieldError(fmt.Sprintf("members[%d]", i), errMemberNotRegistered)
+		}
+
+		members = append(members, member)
+	}
+
+	if len(members) < 2 {
+		return nil, newFieldError("members", "members: at least one other member is required")
+	}
+
+	return members, nil
+}
+
+// ListChats list a page of the chats of the authenticated user, from the most recently active to the least recently active.
+// Each chat comes with its last message and the number of messages the user has not read.
 func (h *ChatHandler) ListChats(w http.ResponseWriter, r *http.Request) {
 	h.logger.DebugContext(r.Context(), "handler list chats for a user", slog.String("path", r.URL.Path))
 
-	// TODO: the phone number could be a sensible data, this information should not be expose in the URL.
-	// By example, we can use a token to authenticate the user and get the phone number from the token.
-	phoneNumber := r.URL.Query().Get("phoneNumber")
+	page, query, err := parseChatQuery(r)
+	if err != nil {
+		writeError(w, r, err, "invalid page")
+
+		return
+	}
 
-	chats, err := h.chatRepo.GetUserChats(phoneNumber)
+	chats, err := h.chatRepo.GetUserChats(currentUser(r), query)
 	if err != nil {
 		h.logger.ErrorContext(r.Context(), "failed to get user chats", slog.String("error", err.Error()))
-		http.Error(w, "failed to get user chats", http.StatusInternalServerError)
+		writeProblem(w, r, problemInternal, "failed to get user chats")
 
 		return
 	}
 
-	result := make([]ChatResponse, 0, len(chats))
-	for _, chat := range chats {
-		result = append(result, ChatResponse{
-			ID:           chat.ID,
-			Participants: chat.Participants,
-			CreatedAt:    chat.CreatedAt,
-		})
-	}
+	// the chats are sorted from the most recently active, the first one is the farthest after the cursor.
+	result := newPage(chats, page.limit, page.forward(), chatPosition, newChatSummaryResponse)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -79,37 +307,247 @@ func (h *ChatHandler) ListChats(w http.ResponseWriter, r *http.Request) {
 	if err = json.NewEncoder(w).Encode(result); err != nil {
 		h.logger.ErrorContext(r.Context(), "failed to write response", slog.String("error", err.Error()))
 
-		http.Error(w, "failed to write response", http.StatusInternalServerError)
+		writeProblem(w, r, problemInternal, "failed to write response")
 	}
 }
 
-// ListChatMessages list all messages for a chat.
+// ListChatMessages list a page of the messages of a chat, sorted by sequence number.
+// By default, the page holds the latest messages.
 func (h *ChatHandler) ListChatMessages(w http.ResponseWriter, r *http.Request) {
 	h.logger.DebugContext(r.Context(), "handler list messages for a chat", slog.String("path", r.URL.Path))
-	chatID := r.PathValue("id")
 
-	messages, err := h.messageRepo.GetChatMessages(chatID)
+	chat, ok := getParticipantChat(w, r, h.chatRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	page, query, err := parseMessageQuery(r)
+	if err != nil {
+		writeError(w, r, err, "invalid page")
+
+		return
+	}
+
+	h.writeMessages(w, r, chat.ID, page, query)
+}
+
+// writeMessages writes the page of the messages of the chat matching the query, visible to the authenticated user.
+func (h *ChatHandler) writeMessages(w http.ResponseWriter, r *http.Request, chatID string, page pageRequest, query repo.MessageQuery) {
+	query.Viewer = currentUser(r)
+
+	messages, err := h.messageRepo.GetChatMessages(chatID, query)
 	if err != nil {
 		h.logger.ErrorContext(r.Context(),
 			"failed to get chat messages",
 			slog.String("error", err.Error()),
 		)
-		http.Error(w, "failed to get chat messages", http.StatusInternalServerError)
+		writeProblem(w, r, problemInternal, "failed to get chat messages")
+
+		return
+	}
 
+	toResponse, ok := h.deliver(w, r, chatID, messages)
+	if !ok {
 		return
 	}
 
+	// the messages are sorted by sequence number, the first one is the farthest before the cursor.
+	result := newPage(messages, page.limit, !page.forward(), messagePosition, toResponse)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
-	if err = json.NewEncoder(w).Encode(messages); err != nil {
+	if err = json.NewEncoder(w).Encode(result); err != nil {
 		h.logger.ErrorContext(r.Context(),
 			"failed to write response",
 			slog.String("error", err.Error()),
 		)
 
-		http.Error(w, "failed to write response", http.StatusInternalServerError)
+		writeProblem(w, r, problemInternal, "failed to write response")
 	}
 
 	h.logger.DebugContext(r.Context(), "successfully get chat messages")
 }
+
+// deliver marks the messages of the chat as delivered to the authenticated user,
+// and returns the conversion of the messages with their receipts, their reactions and their quotes,
+// writing the error response on failure.
+func (h *ChatHandler) deliver(
+	w http.ResponseWriter,
+	r *http.Request,
+	chatID string,
+	messages []repo.Message,
+) (func(repo.Message) MessageResponse, bool) {
+	if len(messages) > 0 {
+		h.receipts.markDelivered(r.Context(), currentUser(r), chatID, messages[len(messages)-1].Seq)
+	}
+
+	receipts, err := h.receipts.getReceipts(chatID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get receipts", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to get receipts")
+
+		return nil, false
+	}
+
+	messageIDs := make([]string, len(messages))
+	for i, message := range messages {
+		messageIDs[i] = message.ID
+	}
+
+	reactions, err := h.messageRepo.GetReactions(chatID, messageIDs)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get reactions", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to get reactions")
+
+		return nil, false
+	}
+
+	reactionsByMessage := newReactionResponses(reactions)
+
+	quotes, err := getQuotes(h.messageRepo, chatID, messages)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get quotes", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to get quotes")
+
+		return nil, false
+	}
+
+	return func(message repo.Message) MessageResponse {
+		response := newMessageResponseWithReceipts(message, receipts)
+		response.Reactions = reactionsByMessage[message.ID]
+		response.Quote = quotes[message.ReplyTo]
+
+		return response
+	}, true
+}
+
+// chatGetter gets a chat by its ID.
+type chatGetter interface {
+	GetChat(chatID string) (repo.Chat, error)
+}
+
+// getParticipantChat gets the chat of the request path if the authenticated user participates in it,
+// writing the error response otherwise.
+func getParticipantChat(w http.ResponseWriter, r *http.Request, chats chatGetter, logger *slog.Logger) (repo.Chat, bool) {
+	chat, err := chats.GetChat(r.PathValue("id"))
+	if err != nil {
+		logger.ErrorContext(r.Context(), "failed to get chat", slog.String("error", err.Error()))
+
+		if errors.Is(err, repo.ErrChatNotFound) {
+			writeProblem(w, r, problemChatNotFound, "chat not found")
+
+			return repo.Chat{}, false
+		}
+
+		writeProblem(w, r, problemInternal, "failed to get chat")
+
+		return repo.Chat{}, false
+	}
+
+	if user := currentUser(r); !slices.Contains(chat.Participants, user) {
+		logger.ErrorContext(r.Context(), "user is not a participant of the chat", slog.String("phoneNumber", user))
+		writeProblem(w, r, problemNotParticipant, "user is not a participant of the chat")
+
+		return repo.Chat{}, false
+	}
+
+	return chat, true
+}
+
+// parseChatQuery parses the requested page of chats.
+// One more chat than the page limit is queried to know if there is a next page.
+func parseChatQuery(r *http.Request) (pageRequest, repo.ChatQuery, error) {
+	page, err := parsePageRequest(r)
+	if err != nil {
+		return pageRequest{}, repo.ChatQuery{}, err
+	}
+
+	query := repo.ChatQuery{Limit: page.limit + 1}
+
+	if page.before != "" {
+		if query.Before, err = parseChatCursor(page.before); err != nil {
+			return pageRequest{}, repo.ChatQuery{}, &fieldError{field: "before", err: err}
+		}
+	}
+
+	if page.after != "" {
+		if query.After, err = parseChatCursor(page.after); err != nil {
+			return pageRequest{}, repo.ChatQuery{}, &fieldError{field: "after", err: err}
+		}
+	}
+
+	return page, query, nil
+}
+
+// parseMessageQuery parses the requested page of messages.
+// One more message than the page limit is queried to know if there is a next page.
+func parseMessageQuery(r *http.Request) (pageRequest, repo.MessageQuery, error) {
+	page, err := parsePageRequest(r)
+	if err != nil {
+		return pageRequest{}, repo.MessageQuery{}, err
+	}
+
+	query := repo.MessageQuery{Limit: page.limit + 1}
+
+	if page.before != "" {
+		if query.BeforeSeq, err = parseMessageCursor(page.before); err != nil {
+			return pageRequest{}, repo.MessageQuery{}, &fieldError{field: "before", err: err}
+		}
+	}
+
+	if page.after != "" {
+		if query.AfterSeq, err = parseMessageCursor(page.after); err != nil {
+			return pageRequest{}, repo.MessageQuery{}, &fieldError{field: "after", err: err}
+		}
+	}
+
+	return page, query, nil
+}
+
+// GetChatMessage get a message of a chat.
+func (h *ChatHandler) GetChatMessage(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler get a message of a chat", slog.String("path", r.URL.Path))
+
+	chat, ok := getParticipantChat(w, r, h.chatRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	message, err := h.messageRepo.GetChatMessage(chat.ID, r.PathValue("messageId"))
+	if err == nil && !message.VisibleTo(currentUser(r)) {
+		err = repo.ErrMessageNotFound
+	}
+
+	if err != nil {
+		h.logger.ErrorContext(r.Context(),
+			"failed to get chat message",
+			slog.String("error", err.Error()),
+		)
+
+		if errors.Is(err, repo.ErrMessageNotFound) {
+			writeProblem(w, r, problemMessageNotFound, "message not found")
+
+			return
+		}
+
+		writeProblem(w, r, problemInternal, "failed to get chat message")
+
+		return
+	}
+
+	toResponse, ok := h.deliver(w, r, chat.ID, []repo.Message{message})
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(toResponse(message)); err != nil {
+		h.logger.ErrorContext(r.Context(),
+			"failed to write response",
+			slog.String("error", err.Error()),
+		)
+	}
+}
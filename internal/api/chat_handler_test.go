@@ -6,12 +6,14 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/realtime"
 )
 
 func TestChatHandler_ListChats(t *testing.T) {
@@ -27,10 +29,10 @@ func TestChatHandler_ListChats(t *testing.T) {
 	var chats []string
 
 	for i := 1; i < len(users); i++ {
-		chat, err := testChatRepo.GetOrCreateChat(users[0], users[i])
+		chat, _, err := testChatRepo.GetOrCreateChat(users[0], users[i])
 		require.NoError(t, err)
 
-		chats = append(chats, chat)
+		chats = append(chats, chat.ID)
 	}
 
 	// Create a test request
@@ -40,11 +42,12 @@ func TestChatHandler_ListChats(t *testing.T) {
 	req, err := http.NewRequestWithContext(
 		ctx,
 		http.MethodGet,
-		"/chats?phoneNumber="+url.QueryEscape(users[0]),
+		"/chats",
 		http.NoBody,
 	)
 	require.NoError(t, err)
 	req.Header.Set("Content-Type", "application/json")
+	authenticate(t, req, users[0])
 
 	// Record the HTTP response
 	rr := httptest.NewRecorder()
@@ -54,16 +57,155 @@ func TestChatHandler_ListChats(t *testing.T) {
 	assert.Equal(t, "application/json", respContentType)
 	assert.Equal(t, http.StatusOK, rr.Code)
 
-	var result []ChatResponse
+	var result Page[ChatResponse]
 
 	err = json.NewDecoder(rr.Body).Decode(&result)
 	require.NoError(t, err)
-	require.NotEmpty(t, result)
-	// TODO: improve the test by checking the content of the result.
-	require.Len(t, chats, 9)
+	require.Len(t, result.Items, len(chats))
+	assert.Empty(t, result.NextCursor)
+
+	// the chats are sorted from the most recent to the oldest.
+	for i := 1; i < len(result.Items); i++ {
+		assert.False(t, result.Items[i].CreatedAt.After(result.Items[i-1].CreatedAt))
+	}
+
+	for _, chat := range result.Items {
+		assert.Contains(t, chats, chat.ID)
+		assert.Contains(t, chat.Participants, users[0])
+	}
+}
+
+func TestChatHandler_ListChats_Pagination(t *testing.T) {
+	user := generateRandomPhoneNumber()
+	require.NoError(t, testUserRepo.AddUser(user))
+
+	for range 5 {
+		_, _, err := testChatRepo.GetOrCreateChat(user, generateRandomPhoneNumber())
+		require.NoError(t, err)
+	}
+
+	all := getTestPage[ChatResponse](t, "/chats", user)
+	require.Len(t, all.Items, 5)
+
+	// browse the chats from the most recent to the oldest.
+	page := getTestPage[ChatResponse](t, "/chats?limit=2", user)
+	assert.Equal(t, all.Items[:2], page.Items)
+	require.NotEmpty(t, page.NextCursor)
+
+	page = getTestPage[ChatResponse](t, "/chats?limit=2&before="+page.NextCursor, user)
+	assert.Equal(t, all.Items[2:4], page.Items)
+	require.NotEmpty(t, page.NextCursor)
+
+	last := getTestPage[ChatResponse](t, "/chats?limit=2&before="+page.NextCursor, user)
+	assert.Equal(t, all.Items[4:], last.Items)
+	assert.Empty(t, last.NextCursor)
+
+	// browse back to the most recent chats.
+	page = getTestPage[ChatResponse](t, "/chats?limit=2&after="+page.NextCursor, user)
+	assert.Equal(t, all.Items[1:3], page.Items)
+	require.NotEmpty(t, page.NextCursor)
+
+	page = getTestPage[ChatResponse](t, "/chats?limit=2&after="+page.NextCursor, user)
+	assert.Equal(t, all.Items[:1], page.Items)
+	assert.Empty(t, page.NextCursor)
 }
 
-// TODO: add errors tests for ListChats
+func TestChatHandler_ListChats_Summary(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 3)
+	user, friend, other := users[0], users[1], users[2]
+
+	first := sendTestMessage(ctx, t, friend, user, "Hello")
+	second := sendTestMessage(ctx, t, other, user, "Hi")
+	sendTestMessage(ctx, t, user, other, "Hi!")
+
+	// the chat with the latest message is the most recently active one.
+	time.Sleep(time.Millisecond)
+
+	long := strings.Repeat("é", maxPreviewLength+10)
+	last := sendTestMessage(ctx, t, friend, user, long)
+
+	page := getTestPage[ChatSummaryResponse](t, "/chats", user)
+	require.Len(t, page.Items, 2)
+
+	chat := page.Items[0]
+	assert.Equal(t, first.ChatID, chat.ID)
+	assert.Equal(t, int64(2), chat.UnreadCount)
+	assert.Equal(t, last.CreatedAt, chat.LastActivityAt)
+	assert.Equal(t, &MessagePreview{
+		Type:      messageTypeText,
+		Sender:    friend,
+		Content:   strings.Repeat("é", maxPreviewLength-1) + "…",
+		CreatedAt: last.CreatedAt,
+		Seq:       2,
+	}, chat.LastMessage)
+
+	// the messages of the user are not unread.
+	assert.Equal(t, second.ChatID, page.Items[1].ID)
+	assert.Equal(t, int64(1), page.Items[1].UnreadCount)
+	assert.Equal(t, "Hi!", page.Items[1].LastMessage.Content)
+
+	rr := requestTest(ctx, t, http.MethodPost, "/chats/"+first.ChatID+"/read", `{"seq": 2}`, user)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	page = getTestPage[ChatSummaryResponse](t, "/chats?limit=1", user)
+	assert.Zero(t, page.Items[0].UnreadCount)
+
+	// a chat without message is active since its creation.
+	time.Sleep(time.Millisecond)
+
+	group := createTestGroup(ctx, t, user, "friends", friend)
+
+	page = getTestPage[ChatSummaryResponse](t, "/chats", user)
+	assert.Equal(t, group.ID, page.Items[0].ID)
+	assert.Nil(t, page.Items[0].LastMessage)
+	assert.Equal(t, group.CreatedAt, page.Items[0].LastActivityAt)
+}
+
+func TestChatHandler_ListChats_Errors(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		query  string
+		detail string
+	}{
+		{
+			desc:   "invalid limit",
+			query:  "limit=abc",
+			detail: "limit must be an integer between 1 and 100",
+		},
+		{
+			desc:   "limit too high",
+			query:  "limit=101",
+			detail: "limit must be an integer between 1 and 100",
+		},
+		{
+			desc:   "invalid cursor",
+			query:  "before=abc",
+			detail: "invalid cursor",
+		},
+		{
+			desc:   "message cursor",
+			query:  "after=" + encodeCursor(messageCursor{Seq: 1}),
+			detail: "invalid cursor",
+		},
+		{
+			desc:   "before and after",
+			query:  "before=abc&after=def",
+			detail: "before and after cannot be used together",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			rr := getTest(t, "/chats?"+test.query, generateRandomPhoneNumber())
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			assert.Equal(t, test.detail, decodeProblem(t, rr).Detail)
+		})
+	}
+}
 
 func TestChatHandler_ListChatMessages(t *testing.T) {
 	users := make([]string, 0, 10)
@@ -75,14 +217,17 @@ func TestChatHandler_ListChatMessages(t *testing.T) {
 		require.NoError(t, err)
 	}
 
-	chatID, err := testChatRepo.GetOrCreateChat(users[0], users[1])
+	chat, _, err := testChatRepo.GetOrCreateChat(users[0], users[1])
 	require.NoError(t, err)
 
+	chatID := chat.ID
+
 	msgs := make([]string, 0, 3)
 	for i := range 3 {
 		msgs = append(msgs, fmt.Sprintf("Content msg {%d}\n", i))
 
-		require.NoError(t, testMessageRepo.AddMessage(chatID, msgs[i]))
+		_, err = testMessageRepo.AddMessage(chatID, users[i%2], msgs[i])
+		require.NoError(t, err)
 	}
 
 	// Create a test request
@@ -98,6 +243,7 @@ func TestChatHandler_ListChatMessages(t *testing.T) {
 	require.NoError(t, err)
 
 	req.Header.Set("Content-Type", "application/json")
+	authenticate(t, req, users[0])
 
 	// Record the HTTP response
 	rr := httptest.NewRecorder()
@@ -107,12 +253,477 @@ func TestChatHandler_ListChatMessages(t *testing.T) {
 	assert.Equal(t, "application/json", respContentType)
 	assert.Equal(t, http.StatusOK, rr.Code)
 
-	var result []string
+	var result Page[MessageResponse]
 
 	err = json.NewDecoder(rr.Body).Decode(&result)
 	require.NoError(t, err)
-	require.NotEmpty(t, result)
-	require.Equal(t, msgs, result)
+	require.Len(t, result.Items, len(msgs))
+	assert.Empty(t, result.NextCursor)
+
+	for i, message := range result.Items {
+		assert.NotEmpty(t, message.ID)
+		assert.Equal(t, chatID, message.ChatID)
+		assert.Equal(t, users[i%2], message.Sender)
+		assert.Equal(t, msgs[i], message.Content)
+		assert.Equal(t, int64(i+1), message.Seq)
+		assert.False(t, message.CreatedAt.IsZero())
+	}
+}
+
+func TestChatHandler_ListChatMessages_Pagination(t *testing.T) {
+	user := generateRandomPhoneNumber()
+
+	chat, _, err := testChatRepo.GetOrCreateChat(user, generateRandomPhoneNumber())
+	require.NoError(t, err)
+
+	for i := range 5 {
+		_, err = testMessageRepo.AddMessage(chat.ID, user, fmt.Sprintf("Content msg {%d}", i))
+		require.NoError(t, err)
+	}
+
+	// browse the messages from the latest to the first one.
+	page := getTestPage[MessageResponse](t, "/chats/"+chat.ID+"/messages?limit=2", user)
+	assert.Equal(t, []int64{4, 5}, messageSeqs(page.Items))
+	require.NotEmpty(t, page.NextCursor)
+
+	page = getTestPage[MessageResponse](t, "/chats/"+chat.ID+"/messages?limit=2&before="+page.NextCursor, user)
+	assert.Equal(t, []int64{2, 3}, messageSeqs(page.Items))
+	require.NotEmpty(t, page.NextCursor)
+
+	last := getTestPage[MessageResponse](t, "/chats/"+chat.ID+"/messages?limit=2&before="+page.NextCursor, user)
+	assert.Equal(t, []int64{1}, messageSeqs(last.Items))
+	assert.Empty(t, last.NextCursor)
+
+	// browse forward to the latest message.
+	page = getTestPage[MessageResponse](t, "/chats/"+chat.ID+"/messages?limit=2&after="+page.NextCursor, user)
+	assert.Equal(t, []int64{3, 4}, messageSeqs(page.Items))
+	require.NotEmpty(t, page.NextCursor)
+
+	page = getTestPage[MessageResponse](t, "/chats/"+chat.ID+"/messages?limit=2&after="+page.NextCursor, user)
+	assert.Equal(t, []int64{5}, messageSeqs(page.Items))
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestChatHandler_ListChatMessages_Errors(t *testing.T) {
+	user := generateRandomPhoneNumber()
+
+	chat, _, err := testChatRepo.GetOrCreateChat(user, generateRandomPhoneNumber())
+	require.NoError(t, err)
+
+	testCases := []struct {
+		desc   string
+		path   string
+		user   string
+		code   int
+		detail string
+	}{
+		{
+			desc:   "unauthenticated",
+			path:   "/chats/" + chat.ID + "/messages",
+			code:   http.StatusUnauthorized,
+			detail: "authentication required",
+		},
+		{
+			desc:   "unknown chat",
+			path:   "/chats/unknown/messages",
+			user:   user,
+			code:   http.StatusNotFound,
+			detail: "chat not found",
+		},
+		{
+			desc:   "not a participant",
+			path:   "/chats/" + chat.ID + "/messages",
+			user:   generateRandomPhoneNumber(),
+			code:   http.StatusForbidden,
+			detail: "user is not a participant of the chat",
+		},
+		{
+			desc:   "invalid limit",
+			path:   "/chats/" + chat.ID + "/messages?limit=0",
+			user:   user,
+			code:   http.StatusBadRequest,
+			detail: "limit must be an integer between 1 and 100",
+		},
+		{
+			desc:   "invalid cursor",
+			path:   "/chats/" + chat.ID + "/messages?after=abc",
+			user:   user,
+			code:   http.StatusBadRequest,
+			detail: "invalid cursor",
+		},
+		{
+			desc:   "chat cursor",
+			path:   "/chats/" + chat.ID + "/messages?before=" + encodeCursor(chatCursor{ID: "chat"}),
+			user:   user,
+			code:   http.StatusBadRequest,
+			detail: "invalid cursor",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			rr := getTest(t, test.path, test.user)
+
+			assert.Equal(t, test.code, rr.Code)
+			assert.Equal(t, test.detail, decodeProblem(t, rr).Detail)
+		})
+	}
+}
+
+// getTest serves a GET request of the user on the test router, anonymous if the user is empty.
+func getTest(t *testing.T, target, user string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, http.NoBody)
+	require.NoError(t, err)
+
+	if user != "" {
+		authenticate(t, req, user)
+	}
+
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+
+	return rr
+}
+
+// getTestPage gets a page of items for the user from the test router.
+func getTestPage[T any](t *testing.T, target, user string) Page[T] {
+	t.Helper()
+
+	rr := getTest(t, target, user)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var page Page[T]
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&page))
+
+	return page
+}
+
+func messageSeqs(messages []MessageResponse) []int64 {
+	seqs := make([]int64, 0, len(messages))
+	for _, message := range messages {
+		seqs = append(seqs, message.Seq)
+	}
+
+	return seqs
+}
+
+func TestChatHandler_GetChatMessage(t *testing.T) {
+	sender := generateRandomPhoneNumber()
+	require.NoError(t, testUserRepo.AddUser(sender))
+
+	receiver := generateRandomPhoneNumber()
+	require.NoError(t, testUserRepo.AddUser(receiver))
+
+	chat, _, err := testChatRepo.GetOrCreateChat(sender, receiver)
+	require.NoError(t, err)
+
+	chatID := chat.ID
+
+	message, err := testMessageRepo.AddMessage(chatID, sender, "Hello World!")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+	}{
+		{
+			name:         "existing message",
+			path:         "/chats/" + chatID + "/messages/" + message.ID,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "unknown message",
+			path:         "/chats/" + chatID + "/messages/unknown",
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, test.path, http.NoBody)
+			require.NoError(t, err)
+			authenticate(t, req, receiver)
+
+			rr := httptest.NewRecorder()
+			testRouter.ServeHTTP(rr, req)
+
+			require.Equal(t, test.expectedCode, rr.Code)
+
+			if test.expectedCode != http.StatusOK {
+				return
+			}
+
+			var result MessageResponse
+
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&result))
+			// the message is delivered to the receiver getting it.
+			expected := newMessageResponse(message)
+			expected.Receipts = map[string]string{receiver: receiptDelivered}
+			assert.Equal(t, expected, result)
+		})
+	}
 }
 
-// TODO: add errors tests for ListChatMessages
+// requestTest serves a request of the user with the JSON body on the test router, anonymous if the user is empty.
+func requestTest(ctx context.Context, t *testing.T, method, target, body, user string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(ctx, method, target, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	if user != "" {
+		authenticate(t, req, user)
+	}
+
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+
+	return rr
+}
+
+// createTestGroup creates a group chat of the creator and the members with the API.
+func createTestGroup(ctx context.Context, t *testing.T, creator, name string, members ...string) ChatResponse {
+	t.Helper()
+
+	payload, err := json.Marshal(NewChat{Name: name, Members: members})
+	require.NoError(t, err)
+
+	rr := requestTest(ctx, t, http.MethodPost, "/chats", string(payload), creator)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	var chat ChatResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&chat))
+
+	return chat
+}
+
+// registerTestUsers registers n random users.
+func registerTestUsers(t *testing.T, n int) []string {
+	t.Helper()
+
+	users := make([]string, 0, n)
+
+	for range n {
+		user := generateRandomPhoneNumber()
+		require.NoError(t, testUserRepo.AddUser(user))
+
+		users = append(users, user)
+	}
+
+	return users
+}
+
+func TestChatHandler_CreateChat(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := httptest.NewServer(testRouter)
+	// closed after the streams, the cleanups being run in reverse order.
+	t.Cleanup(server.Close)
+
+	users := registerTestUsers(t, 4)
+	creator, member, otherMember, outsider := users[0], users[1], users[2], users[3]
+
+	stream := openEventStream(ctx, t, server, "/events", member, "")
+	readSSE(t, stream)
+
+	// the members may be written in any format, the creator and the duplicates are ignored.
+	national := "0" + strings.TrimPrefix(otherMember, "+33")
+	payload := `{"name": " Friends ", "members": ["` + member + `", "` + national + `", "` + otherMember + `", "` + creator + `"]}`
+
+	rr := requestTest(ctx, t, http.MethodPost, "/chats", payload, creator)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var chat ChatResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&chat))
+	assert.Equal(t, "/chats/"+chat.ID, rr.Header().Get("Location"))
+	assert.Equal(t, chatTypeGroup, chat.Type)
+	assert.Equal(t, "Friends", chat.Name)
+	assert.Equal(t, []string{creator, member, otherMember}, chat.Participants)
+
+	// the members are notified about the new group.
+	event := readSSE(t, stream)
+	require.Equal(t, realtime.EventChatCreated, event.Type)
+	assert.Equal(t, chat.ID, chatIDOf(t, event))
+
+	// every member sees the group, the others don't.
+	for _, user := range chat.Participants {
+		page := getTestPage[ChatResponse](t, "/chats", user)
+		assert.Contains(t, page.Items, chat)
+	}
+
+	assert.Empty(t, getTestPage[ChatResponse](t, "/chats", outsider).Items)
+
+	// a message sent to the group reaches every member.
+	rr = requestTest(ctx, t, http.MethodPost, "/messages", `{"chatId": "`+chat.ID+`", "content": "Hello everyone"}`, otherMember)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	var message MessageResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&message))
+	assert.Equal(t, chat.ID, message.ChatID)
+	assert.Equal(t, otherMember, message.Sender)
+
+	requireMessageEvent(t, message, readSSE(t, stream))
+
+	for _, user := range chat.Participants {
+		page := getTestPage[MessageResponse](t, "/chats/"+chat.ID+"/messages", user)
+		require.Len(t, page.Items, 1)
+		assert.Equal(t, message.ID, page.Items[0].ID)
+	}
+}
+
+func TestChatHandler_CreateChat_Errors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 2)
+	tooMany := make([]string, maxGroupMembers)
+
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("+3367%07d", i)
+	}
+
+	tooManyPayload, err := json.Marshal(NewChat{Name: "crowd", Members: tooMany})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		user    string
+		payload string
+		code    int
+		detail  string
+	}{
+		{
+			name:    "unauthenticated",
+			payload: `{"name": "friends", "members": ["` + users[1] + `"]}`,
+			code:    http.StatusUnauthorized,
+			detail:  "authentication required",
+		},
+		{
+			name:    "invalid input",
+			user:    users[0],
+			payload: `{"name": 123}`,
+			code:    http.StatusBadRequest,
+			detail:  "Invalid input",
+		},
+		{
+			name:    "missing name",
+			user:    users[0],
+			payload: `{"name": "  ", "members": ["` + users[1] + `"]}`,
+			code:    http.StatusBadRequest,
+			detail:  "name is required",
+		},
+		{
+			name:    "too long name",
+			user:    users[0],
+			payload: `{"name": "` + strings.Repeat("é", maxChatNameLength+1) + `", "members": ["` + users[1] + `"]}`,
+			code:    http.StatusBadRequest,
+			detail:  "name must be at most 100 characters",
+		},
+		{
+			name:    "no other member",
+			user:    users[0],
+			payload: `{"name": "alone", "members": ["` + users[0] + `"]}`,
+			code:    http.StatusBadRequest,
+			detail:  "members: at least one other member is required",
+		},
+		{
+			name:    "invalid member",
+			user:    users[0],
+			payload: `{"name": "friends", "members": ["` + users[1] + `", "+33 6"]}`,
+			code:    http.StatusBadRequest,
+			detail:  "members[1]: invalid phone number: too short",
+		},
+		{
+			name:    "unregistered member",
+			user:    users[0],
+			payload: `{"name": "friends", "members": ["` + generateRandomPhoneNumber() + `"]}`,
+			code:    http.StatusBadRequest,
+			detail:  "members[0]: phone number not registered",
+		},
+		{
+			name:    "too many members",
+			user:    users[0],
+			payload: string(tooManyPayload),
+			code:    http.StatusBadRequest,
+			detail:  "members: a group chat has at most 256 members",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := requestTest(ctx, t, http.MethodPost, "/chats", test.payload, test.user)
+
+			assert.Equal(t, test.code, rr.Code)
+
+			if test.detail != "" {
+				assert.Equal(t, test.detail, decodeProblem(t, rr).Detail)
+			}
+		})
+	}
+}
+
+func TestSendMessage_ToChat_Errors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 3)
+	chat := createTestGroup(ctx, t, users[0], "friends", users[1])
+
+	tests := []struct {
+		name    string
+		user    string
+		payload string
+		code    int
+		detail  string
+	}{
+		{
+			name:    "unknown chat",
+			user:    users[0],
+			payload: `{"chatId": "unknown", "content": "Hello"}`,
+			code:    http.StatusNotFound,
+			detail:  "chat not found",
+		},
+		{
+			name:    "not a member",
+			user:    users[2],
+			payload: `{"chatId": "` + chat.ID + `", "content": "Hello"}`,
+			code:    http.StatusForbidden,
+			detail:  "user is not a participant of the chat",
+		},
+		{
+			name:    "receiver and chat",
+			user:    users[0],
+			payload: `{"chatId": "` + chat.ID + `", "receiver": "` + users[1] + `", "content": "Hello"}`,
+			code:    http.StatusBadRequest,
+			detail:  "receiver and chatId cannot be used together",
+		},
+		{
+			name:    "no recipient",
+			user:    users[0],
+			payload: `{"content": "Hello"}`,
+			code:    http.StatusBadRequest,
+			detail:  "receiver or chatId is required",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := requestTest(ctx, t, http.MethodPost, "/messages", test.payload, test.user)
+
+			assert.Equal(t, test.code, rr.Code)
+			assert.Equal(t, test.detail, decodeProblem(t, rr).Detail)
+		})
+	}
+}
@@ -0,0 +1,375 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/jbdoumenjou/mychat/internal/event"
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+var (
+	errMemberNotRegistered = errors.New("phone number not registered")
+	errParticipantNotFound = errors.New("participant not found")
+)
+
+// ChatUpdate represents the changes of a group chat.
+type ChatUpdate struct {
+	Name string `json:"name"`
+}
+
+// NewParticipants represents the members to add to a group chat.
+type NewParticipants struct {
+	Members []string `json:"members"`
+}
+
+// UpdateChat renames a group chat, only an admin can rename it.
+func (h *ChatHandler) UpdateChat(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler update chat", slog.String("path", r.URL.Path))
+
+	chat, ok := h.getAdminChat(w, r)
+	if !ok {
+		return
+	}
+
+	var update ChatUpdate
+
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		h.logger.ErrorContext(r.Context(), "Invalid input")
+		writeProblem(w, r, problemInvalidInput, "Invalid input")
+
+		return
+	}
+
+	name, err := parseChatName(update.Name)
+	if err != nil {
+		writeError(w, r, err, "invalid name")
+
+		return
+	}
+
+	if name == chat.Name {
+		h.writeChat(w, r, chat)
+
+		return
+	}
+
+	updated, err := h.chatRepo.RenameChat(chat.ID, name)
+	if err != nil {
+		h.writeMembershipError(w, r, err, "failed to rename chat")
+
+		return
+	}
+
+	actor := currentUser(r)
+	h.notifyUpdate(r.Context(), chat, updated, actor, fmt.Sprintf("%s renamed the chat to %q", actor, name))
+	h.writeChat(w, r, updated)
+}
+
+// AddParticipants adds members to a group chat, only an admin can add them.
+// The members already in the chat, and the ones who blocked the admin, are ignored.
+func (h *ChatHandler) AddParticipants(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler add chat participants", slog.String("path", r.URL.Path))
+
+	chat, ok := h.getAdminChat(w, r)
+	if !ok {
+		return
+	}
+
+	var newParticipants NewParticipants
+
+	if err := json.NewDecoder(r.Body).Decode(&newParticipants); err != nil {
+		h.logger.ErrorContext(r.Context(), "Invalid input")
+		writeProblem(w, r, problemInvalidInput, "Invalid input")
+
+		return
+	}
+
+	members, err := h.parseNewParticipants(chat, newParticipants.Members)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "invalid members", slog.String("error", err.Error()))
+		writeError(w, r, err, "invalid members")
+
+		return
+	}
+
+	if members, ok = h.withoutBlockers(w, r, members); !ok {
+		return
+	}
+
+	if len(members) == 0 {
+		h.writeChat(w, r, chat)
+
+		return
+	}
+
+	updated, added, err := h.chatRepo.AddParticipants(chat.ID, members)
+	if err != nil {
+		h.writeMembershipError(w, r, err, "failed to add participants")
+
+		return
+	}
+
+	actor := currentUser(r)
+
+	notices := make([]string, 0, len(added))
+	for _, member := range added {
+		notices = append(notices, actor+" added "+member)
+	}
+
+	h.notifyUpdate(r.Context(), chat, updated, actor, notices...)
+	h.writeChat(w, r, updated)
+}
+
+// parseNewParticipants normalizes the members to add to the group chat,
+// ignoring the duplicated members and the participants of the chat.
+// The members must be registered.
+func (h *ChatHandler) parseNewParticipants(chat repo.Chat, phoneNumbers []string) ([]string, error) {
+	if len(phoneNumbers) == 0 {
+		return nil, newFieldError("members", "members: at least one member is required")
+	}
+
+	if len(chat.Participants)+len(phoneNumbers) > maxGroupMembers {
+		return nil, errTooManyMembers
+	}
+
+	var members []string
+
+	for i, phoneNumber := range phoneNumbers {
+		member, err := h.phones.Normalize(phoneNumber)
+		if err != nil {
+			return nil, wrapFieldError(fmt.Sprintf("members[%d]", i), err)
+		}
+
+		if slices.Contains(chat.Participants, member) || slices.Contains(members, member) {
+			continue
+		}
+
+		if !h.userRepo.IsRegistered(member) {
+			return nil, wrapFieldError(fmt.Sprintf("members[%d]", i), errMemberNotRegistered)
+		}
+
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+// withoutBlockers removes the members who blocked the authenticated user from the members to add to a group chat.
+// They are left out silently, to not reveal the block. It writes the error response on failure.
+func (h *ChatHandler) withoutBlockers(w http.ResponseWriter, r *http.Request, members []string) ([]string, bool) {
+	blockers, err := h.chatRepo.GetBlockers(currentUser(r), members)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get blockers", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to get blockers")
+
+		return nil, false
+	}
+
+	return slices.DeleteFunc(members, func(member string) bool { return slices.Contains(blockers, member) }), true
+}
+
+// RemoveParticipant removes a participant from a group chat.
+// An admin can remove any participant, the other participants can only remove themselves.
+func (h *ChatHandler) RemoveParticipant(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler remove chat participant", slog.String("path", r.URL.Path))
+
+	participant, ok := normalizePhoneNumber(w, r, h.phones, "phoneNumber", r.PathValue("phoneNumber"), h.logger)
+	if !ok {
+		return
+	}
+
+	h.removeParticipant(w, r, participant)
+}
+
+// LeaveChat removes the authenticated user from a group chat.
+func (h *ChatHandler) LeaveChat(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler leave chat", slog.String("path", r.URL.Path))
+
+	h.removeParticipant(w, r, currentUser(r))
+}
+
+// removeParticipant removes the participant from the group chat of the request path.
+// If the last admin leaves, the oldest participant becomes admin.
+func (h *ChatHandler) removeParticipant(w http.ResponseWriter, r *http.Request, participant string) {
+	chat, ok := h.getGroupChat(w, r)
+	if !ok {
+		return
+	}
+
+	actor := currentUser(r)
+	leaving := participant == actor
+
+	if !leaving && !chat.IsAdmin(actor) {
+		h.logger.ErrorContext(r.Context(), "user is not an admin of the chat", slog.String("phoneNumber", actor))
+		writeProblem(w, r, problemNotAdmin, "user is not an admin of the chat")
+
+		return
+	}
+
+	updated, promoted, err := h.chatRepo.RemoveParticipant(chat.ID, participant)
+	if err != nil {
+		h.writeMembershipError(w, r, err, "failed to remove participant")
+
+		return
+	}
+
+	notices := []string{actor + " removed " + participant}
+	if leaving {
+		notices = []string{participant + " left"}
+	}
+
+	if promoted != "" {
+		notices = append(notices, promoted+" is now an admin")
+	}
+
+	h.notifyUpdate(r.Context(), chat, updated, actor, notices...)
+
+	if leaving {
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	}
+
+	h.writeChat(w, r, updated)
+}
+
+// AddAdmin promotes a participant to admin of a group chat, only an admin can promote them.
+func (h *ChatHandler) AddAdmin(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler add chat admin", slog.String("path", r.URL.Path))
+
+	h.setAdmin(w, r, true)
+}
+
+// RemoveAdmin demotes an admin of a group chat to member, only an admin can demote them.
+// A group chat keeps at least one admin.
+func (h *ChatHandler) RemoveAdmin(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler remove chat admin", slog.String("path", r.URL.Path))
+
+	h.setAdmin(w, r, false)
+}
+
+func (h *ChatHandler) setAdmin(w http.ResponseWriter, r *http.Request, admin bool) {
+	participant, ok := normalizePhoneNumber(w, r, h.phones, "phoneNumber", r.PathValue("phoneNumber"), h.logger)
+	if !ok {
+		return
+	}
+
+	chat, ok := h.getAdminChat(w, r)
+	if !ok {
+		return
+	}
+
+	if slices.Contains(chat.Participants, participant) && chat.IsAdmin(participant) == admin {
+		h.writeChat(w, r, chat)
+
+		return
+	}
+
+	updated, err := h.chatRepo.SetAdmin(chat.ID, participant, admin)
+	if err != nil {
+		h.writeMembershipError(w, r, err, "failed to set admin")
+
+		return
+	}
+
+	actor := currentUser(r)
+
+	notice := actor + " made " + participant + " an admin"
+	if !admin {
+		notice = actor + " dismissed " + participant + " as admin"
+	}
+
+	h.notifyUpdate(r.Context(), chat, updated, actor, notice)
+	h.writeChat(w, r, updated)
+}
+
+// getGroupChat gets the group chat of the request path if the authenticated user participates in it,
+// writing the error response otherwise.
+func (h *ChatHandler) getGroupChat(w http.ResponseWriter, r *http.Request) (repo.Chat, bool) {
+	chat, ok := getParticipantChat(w, r, h.chatRepo, h.logger)
+	if !ok {
+		return repo.Chat{}, false
+	}
+
+	if !chat.Group {
+		h.logger.ErrorContext(r.Context(), "chat is not a group chat", slog.String("chatId", chat.ID))
+		writeProblem(w, r, problemNotGroupChat, "chat is not a group chat")
+
+		return repo.Chat{}, false
+	}
+
+	return chat, true
+}
+
+// getAdminChat gets the group chat of the request path if the authenticated user is one of its admins,
+// writing the error response otherwise.
+func (h *ChatHandler) getAdminChat(w http.ResponseWriter, r *http.Request) (repo.Chat, bool) {
+	chat, ok := h.getGroupChat(w, r)
+	if !ok {
+		return repo.Chat{}, false
+	}
+
+	if user := currentUser(r); !chat.IsAdmin(user) {
+		h.logger.ErrorContext(r.Context(), "user is not an admin of the chat", slog.String("phoneNumber", user))
+		writeProblem(w, r, problemNotAdmin, "user is not an admin of the chat")
+
+		return repo.Chat{}, false
+	}
+
+	return chat, true
+}
+
+// writeMembershipError writes the error response of a failed change of a group chat,
+// the changed participant is not found if they are not a participant of the chat.
+func (h *ChatHandler) writeMembershipError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	h.logger.ErrorContext(r.Context(), msg, slog.String("error", err.Error()))
+
+	if errors.Is(err, repo.ErrNotParticipant) {
+		err = errParticipantNotFound
+	}
+
+	writeError(w, r, err, msg)
+}
+
+// notifyUpdate adds the notices of the update to the chat history as system messages of the actor,
+// then notifies the participants of the chat, before and after the update.
+// The update is done, a failure to add a notice is only logged.
+func (h *ChatHandler) notifyUpdate(ctx context.Context, before, after repo.Chat, actor string, notices ...string) {
+	participants := slices.Clone(after.Participants)
+
+	for _, participant := range before.Participants {
+		if !slices.Contains(participants, participant) {
+			participants = append(participants, participant)
+		}
+	}
+
+	// the chat is sent first, the new participants receive its messages afterward.
+	h.publisher.Publish(ctx, event.ChatUpdated{Chat: after, Participants: participants})
+
+	for _, notice := range notices {
+		message, err := h.messageRepo.AddSystemMessage(after.ID, actor, notice)
+		if err != nil {
+			h.logger.ErrorContext(ctx, "failed to add system message", slog.String("error", err.Error()))
+
+			continue
+		}
+
+		h.publisher.Publish(ctx, event.MessageSent{Message: message, Participants: participants})
+	}
+}
+
+// writeChat writes the chat in the response.
+func (h *ChatHandler) writeChat(w http.ResponseWriter, r *http.Request, chat repo.Chat) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(newChatResponse(chat)); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to write response", slog.String("error", err.Error()))
+	}
+}
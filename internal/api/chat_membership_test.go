@@ -0,0 +1,309 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/realtime"
+)
+
+// decodeTestChat decodes the chat of a successful response.
+func decodeTestChat(t *testing.T, rr *httptest.ResponseRecorder) ChatResponse {
+	t.Helper()
+
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var chat ChatResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&chat))
+
+	return chat
+}
+
+// systemMessages returns the contents of the system messages of the chat.
+func systemMessages(t *testing.T, chatID, user string) []string {
+	t.Helper()
+
+	page := getTestPage[MessageResponse](t, "/chats/"+chatID+"/messages", user)
+
+	var notices []string
+
+	for _, message := range page.Items {
+		if message.Type == messageTypeSystem {
+			notices = append(notices, message.Content)
+		}
+	}
+
+	return notices
+}
+
+func TestChatHandler_Membership(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 4)
+	alice, bob, carol, dave := users[0], users[1], users[2], users[3]
+
+	chat := createTestGroup(ctx, t, alice, "friends", bob)
+	assert.Equal(t, []string{alice}, chat.Admins)
+
+	// the participants already in the chat are ignored.
+	rr := requestTest(ctx, t, http.MethodPost, "/chats/"+chat.ID+"/participants",
+		`{"members": ["`+bob+`", "`+carol+`", "`+dave+`"]}`, alice)
+	updated := decodeTestChat(t, rr)
+	assert.Equal(t, []string{alice, bob, carol, dave}, updated.Participants)
+
+	rr = requestTest(ctx, t, http.MethodPatch, "/chats/"+chat.ID, `{"name": " best friends "}`, alice)
+	updated = decodeTestChat(t, rr)
+	assert.Equal(t, "best friends", updated.Name)
+
+	rr = requestTest(ctx, t, http.MethodPut, "/chats/"+chat.ID+"/admins/"+bob, "", alice)
+	updated = decodeTestChat(t, rr)
+	assert.Equal(t, []string{alice, bob}, updated.Admins)
+
+	rr = requestTest(ctx, t, http.MethodDelete, "/chats/"+chat.ID+"/admins/"+alice, "", bob)
+	updated = decodeTestChat(t, rr)
+	assert.Equal(t, []string{bob}, updated.Admins)
+
+	rr = requestTest(ctx, t, http.MethodDelete, "/chats/"+chat.ID+"/participants/"+dave, "", bob)
+	updated = decodeTestChat(t, rr)
+	assert.Equal(t, []string{alice, bob, carol}, updated.Participants)
+
+	// the last admin leaves, the oldest participant becomes admin.
+	rr = requestTest(ctx, t, http.MethodPost, "/chats/"+chat.ID+"/leave", "", bob)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	// a participant can remove themselves.
+	rr = requestTest(ctx, t, http.MethodDelete, "/chats/"+chat.ID+"/participants/"+carol, "", carol)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	got, err := testChatRepo.GetChat(chat.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{alice}, got.Participants)
+	assert.Equal(t, []string{alice}, got.Admins)
+
+	assert.Equal(t, []string{
+		alice + " added " + carol,
+		alice + " added " + dave,
+		alice + ` renamed the chat to "best friends"`,
+		alice + " made " + bob + " an admin",
+		bob + " dismissed " + alice + " as admin",
+		bob + " removed " + dave,
+		bob + " left",
+		alice + " is now an admin",
+		carol + " left",
+	}, systemMessages(t, chat.ID, alice))
+}
+
+func TestChatHandler_Membership_Events(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 3)
+	alice, bob, carol := users[0], users[1], users[2]
+
+	chat := createTestGroup(ctx, t, alice, "friends", bob)
+
+	sub, err := testHub.Subscribe(carol)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	rr := requestTest(ctx, t, http.MethodPost, "/chats/"+chat.ID+"/participants", `{"members": ["`+carol+`"]}`, alice)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	// the new participant receives the chat before its messages.
+	event := <-sub.Events()
+	assert.Equal(t, realtime.EventChatUpdated, event.Type)
+	assert.Equal(t, chat.ID, event.Data.(ChatResponse).ID)
+
+	event = <-sub.Events()
+	assert.Equal(t, realtime.EventMessageCreated, event.Type)
+
+	message := event.Data.(MessageResponse)
+	assert.Equal(t, messageTypeSystem, message.Type)
+	assert.Equal(t, alice, message.Sender)
+	assert.Equal(t, alice+" added "+carol, message.Content)
+
+	// the removed participant is notified.
+	rr = requestTest(ctx, t, http.MethodDelete, "/chats/"+chat.ID+"/participants/"+carol, "", alice)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	event = <-sub.Events()
+	assert.Equal(t, realtime.EventChatUpdated, event.Type)
+	assert.NotContains(t, event.Data.(ChatResponse).Participants, carol)
+
+	event = <-sub.Events()
+	assert.Equal(t, alice+" removed "+carol, event.Data.(MessageResponse).Content)
+}
+
+func TestChatHandler_Membership_Errors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 4)
+	admin, member, other, outsider := users[0], users[1], users[2], users[3]
+
+	group := createTestGroup(ctx, t, admin, "friends", member, other)
+	direct := sendTestMessage(ctx, t, admin, member, "Hello")
+
+	testCases := []struct {
+		desc     string
+		method   string
+		target   string
+		body     string
+		user     string
+		expected int
+		message  string
+	}{
+		{
+			desc:     "unauthenticated",
+			method:   http.MethodPost,
+			target:   "/chats/" + group.ID + "/leave",
+			expected: http.StatusUnauthorized,
+			message:  "authentication required",
+		},
+		{
+			desc:     "unknown chat",
+			method:   http.MethodPatch,
+			target:   "/chats/unknown",
+			body:     `{"name": "new name"}`,
+			user:     admin,
+			expected: http.StatusNotFound,
+			message:  "chat not found",
+		},
+		{
+			desc:     "not a participant",
+			method:   http.MethodPost,
+			target:   "/chats/" + group.ID + "/participants",
+			body:     `{"members": ["` + outsider + `"]}`,
+			user:     outsider,
+			expected: http.StatusForbidden,
+			message:  "user is not a participant of the chat",
+		},
+		{
+			desc:     "rename by a member",
+			method:   http.MethodPatch,
+			target:   "/chats/" + group.ID,
+			body:     `{"name": "new name"}`,
+			user:     member,
+			expected: http.StatusForbidden,
+			message:  "user is not an admin of the chat",
+		},
+		{
+			desc:     "add by a member",
+			method:   http.MethodPost,
+			target:   "/chats/" + group.ID + "/participants",
+			body:     `{"members": ["` + outsider + `"]}`,
+			user:     member,
+			expected: http.StatusForbidden,
+			message:  "user is not an admin of the chat",
+		},
+		{
+			desc:     "remove by a member",
+			method:   http.MethodDelete,
+			target:   "/chats/" + group.ID + "/participants/" + other,
+			user:     member,
+			expected: http.StatusForbidden,
+			message:  "user is not an admin of the chat",
+		},
+		{
+			desc:     "promote by a member",
+			method:   http.MethodPut,
+			target:   "/chats/" + group.ID + "/admins/" + member,
+			user:     member,
+			expected: http.StatusForbidden,
+			message:  "user is not an admin of the chat",
+		},
+		{
+			desc:     "direct chat",
+			method:   http.MethodPost,
+			target:   "/chats/" + direct.ChatID + "/leave",
+			user:     admin,
+			expected: http.StatusConflict,
+			message:  "chat is not a group chat",
+		},
+		{
+			desc:     "empty name",
+			method:   http.MethodPatch,
+			target:   "/chats/" + group.ID,
+			body:     `{"name": " "}`,
+			user:     admin,
+			expected: http.StatusBadRequest,
+			message:  "name is required",
+		},
+		{
+			desc:     "no members",
+			method:   http.MethodPost,
+			target:   "/chats/" + group.ID + "/participants",
+			body:     `{"members": []}`,
+			user:     admin,
+			expected: http.StatusBadRequest,
+			message:  "members: at least one member is required",
+		},
+		{
+			desc:     "unregistered member",
+			method:   http.MethodPost,
+			target:   "/chats/" + group.ID + "/participants",
+			body:     `{"members": ["+33612345678"]}`,
+			user:     admin,
+			expected: http.StatusBadRequest,
+			message:  "members[0]: phone number not registered",
+		},
+		{
+			desc:     "invalid participant",
+			method:   http.MethodDelete,
+			target:   "/chats/" + group.ID + "/participants/123",
+			user:     admin,
+			expected: http.StatusBadRequest,
+		},
+		{
+			desc:     "unknown participant",
+			method:   http.MethodDelete,
+			target:   "/chats/" + group.ID + "/participants/" + outsider,
+			user:     admin,
+			expected: http.StatusNotFound,
+			message:  "participant not found",
+		},
+		{
+			desc:     "promote an outsider",
+			method:   http.MethodPut,
+			target:   "/chats/" + group.ID + "/admins/" + outsider,
+			user:     admin,
+			expected: http.StatusNotFound,
+			message:  "participant not found",
+		},
+		{
+			desc:     "demote the last admin",
+			method:   http.MethodDelete,
+			target:   "/chats/" + group.ID + "/admins/" + admin,
+			user:     admin,
+			expected: http.StatusConflict,
+			message:  "a group chat needs an admin",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			rr := requestTest(ctx, t, test.method, test.target, test.body, test.user)
+			assert.Equal(t, test.expected, rr.Code)
+
+			if test.message != "" {
+				assert.Equal(t, test.message, decodeProblem(t, rr).Detail)
+			}
+		})
+	}
+
+	// the failed requests left the chat unchanged.
+	got, err := testChatRepo.GetChat(group.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "friends", got.Name)
+	assert.Equal(t, []string{admin, member, other}, got.Participants)
+	assert.Equal(t, []string{admin}, got.Admins)
+	assert.Empty(t, systemMessages(t, group.ID, admin))
+}
@@ -0,0 +1,613 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/realtime"
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// maxReplayedMessages is the maximum number of missed messages replayed to a client resuming a stream,
+// a client missing more messages must resync with the API, then reconnect without Last-Event-ID.
+const maxReplayedMessages = 1000
+
+var (
+	errInvalidLastEventID = newFieldError("Last-Event-ID", "invalid Last-Event-ID")
+	errResyncRequired     = errors.New("too many missed messages, resync and reconnect without Last-Event-ID")
+)
+
+// EventHandler is the handler for the Server-Sent Events streams.
+// It is an alternative to the WebSocket for the clients behind proxies breaking WebSockets.
+type EventHandler struct {
+	subscriber  Subscriber
+	chatRepo    EventChatRepo
+	messageRepo EventMessageRepo
+	receipts    *ReceiptHandler
+
+	logger *slog.Logger
+}
+
+// EventChatRepo defines the chat repository.
+type EventChatRepo interface {
+	GetChat(chatID string) (repo.Chat, error)
+	GetUserChats(user string, query repo.ChatQuery) ([]repo.UserChat, error)
+}
+
+// EventMessageRepo defines the message repository.
+type EventMessageRepo interface {
+	GetChatMessages(chatID string, query repo.MessageQuery) ([]repo.Message, error)
+	GetChatMessagesByID(chatID string, messageIDs []string) ([]repo.Message, error)
+	GetLastSeq(chatID string) (int64, error)
+}
+
+// NewEventHandler creates a new EventHandler.
+// The messages streamed are delivered to the user, their receipts are tracked by the ReceiptHandler.
+func NewEventHandler(
+	subscriber Subscriber,
+	chatRepo EventChatRepo,
+	messageRepo EventMessageRepo,
+	receipts *ReceiptHandler,
+) *EventHandler {
+	logger := slog.With(slog.String("handler", "event"))
+	logger.Info("created handler")
+
+	return &EventHandler{
+		subscriber:  subscriber,
+		chatRepo:    chatRepo,
+		messageRepo: messageRepo,
+		receipts:    receipts,
+		logger:      logger,
+	}
+}
+
+// eventStream writes Server-Sent Events to the client.
+type eventStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// startEventStream sends the headers of the stream to the client.
+func startEventStream(w http.ResponseWriter) (*eventStream, error) {
+	rc := http.NewResponseController(w)
+
+	// The stream outlives the server write timeout.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return nil, fmt.Errorf("failed to clear write deadline: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if err := rc.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to flush stream: %w", err)
+	}
+
+	return &eventStream{w: w, rc: rc}, nil
+}
+
+// send sends an event, an event without type only updates the last event ID of the client.
+func (s *eventStream) send(id, eventType string, data any) error {
+	msg := "id: " + id + "\n"
+
+	if eventType != "" {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("failed to marshal event: %w", err)
+		}
+
+		msg += "event: " + eventType + "\ndata: " + string(payload) + "\n"
+	}
+
+	return s.write(msg + "\n")
+}
+
+// heartbeat sends a comment to keep the connection open through proxies.
+func (s *eventStream) heartbeat() error {
+	return s.write(": heartbeat\n\n")
+}
+
+func (s *eventStream) write(msg string) error {
+	if _, err := s.w.Write([]byte(msg)); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+
+	if err := s.rc.Flush(); err != nil {
+		return fmt.Errorf("failed to flush event: %w", err)
+	}
+
+	return nil
+}
+
+// subscribe subscribes to the events of the user, writing the error response on failure.
+func (h *EventHandler) subscribe(w http.ResponseWriter, r *http.Request, user string) (*realtime.Subscription, bool) {
+	sub, err := h.subscriber.Subscribe(user)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to subscribe", slog.String("error", err.Error()))
+
+		if errors.Is(err, realtime.ErrHubClosed) {
+			writeProblem(w, r, problemUnavailable, "server shutting down")
+
+			return nil, false
+		}
+
+		writeProblem(w, r, problemInternal, "failed to subscribe")
+
+		return nil, false
+	}
+
+	return sub, true
+}
+
+// ChatEvents streams the new messages of a chat, and the updates of their receipts, to one of its participants.
+// The ID of an event is the sequence number of the message,
+// a client reconnecting with the Last-Event-ID header receives the messages it missed, up to maxReplayedMessages.
+func (h *EventHandler) ChatEvents(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler stream chat events", slog.String("path", r.URL.Path))
+
+	user := currentUser(r)
+
+	chat, ok := getParticipantChat(w, r, h.chatRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	var (
+		lastSeq int64
+		err     error
+	)
+
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		lastSeq, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || lastSeq < 0 {
+			h.logger.ErrorContext(r.Context(), "invalid Last-Event-ID", slog.String("lastEventID", lastEventID))
+			writeError(w, r, errInvalidLastEventID, "invalid Last-Event-ID")
+
+			return
+		}
+	} else if lastSeq, err = h.messageRepo.GetLastSeq(chat.ID); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get last message", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to get last message")
+
+		return
+	}
+
+	// subscribe before reading the missed messages to not miss the ones sent meanwhile.
+	sub, ok := h.subscribe(w, r, user)
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	missed, err := h.messageRepo.GetChatMessages(chat.ID, repo.MessageQuery{AfterSeq: lastSeq, Viewer: user, Limit: maxReplayedMessages + 1})
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get chat messages", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to get chat messages")
+
+		return
+	}
+
+	if len(missed) > maxReplayedMessages {
+		h.logger.DebugContext(r.Context(), "too many missed messages", slog.Int64("lastSeq", lastSeq))
+		writeError(w, r, errResyncRequired, "too many missed messages")
+
+		return
+	}
+
+	quotes, err := getQuotes(h.messageRepo, chat.ID, missed)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get quotes", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to get quotes")
+
+		return
+	}
+
+	stream, err := startEventStream(w)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to start stream", slog.String("error", err.Error()))
+
+		return
+	}
+
+	sendMessage := func(message MessageResponse) error {
+		if message.Seq <= lastSeq {
+			return nil
+		}
+
+		lastSeq = message.Seq
+
+		return stream.send(strconv.FormatInt(message.Seq, 10), realtime.EventMessageCreated, message)
+	}
+
+	for _, message := range missed {
+		if err = sendMessage(newQuotedMessageResponse(message, quotes)); err != nil {
+			h.logger.ErrorContext(r.Context(), "failed to send event", slog.String("error", err.Error()))
+
+			return
+		}
+	}
+
+	if len(missed) > 0 {
+		h.receipts.markDelivered(r.Context(), user, chat.ID, lastSeq)
+	}
+
+	h.stream(r.Context(), stream, sub, func(event realtime.Event) error {
+		switch data := event.Data.(type) {
+		case MessageResponse:
+			if data.ChatID != chat.ID {
+				return nil
+			}
+
+			// an edited or deleted message does not move the stream forward.
+			if event.Type != realtime.EventMessageCreated {
+				return stream.send(strconv.FormatInt(lastSeq, 10), event.Type, data)
+			}
+
+			if err := sendMessage(data); err != nil {
+				return err
+			}
+
+			h.receipts.delivered(r.Context(), user, data)
+
+			return nil
+		case ReceiptResponse:
+			if data.ChatID != chat.ID {
+				return nil
+			}
+
+			return stream.send(strconv.FormatInt(lastSeq, 10), event.Type, data)
+		default:
+			return nil
+		}
+	})
+}
+
+// eventCursor is the position of a client in the events of all its chats: the global sequence number
+// of the last message received, and the creation time of the last chat received, the chats created after it are new.
+type eventCursor struct {
+	GlobalSeq int64     `json:"globalSeq"`
+	ChatsAt   time.Time `json:"chatsAt"`
+}
+
+func (c eventCursor) String() string {
+	return encodeCursor(c)
+}
+
+func parseEventCursor(s string) (eventCursor, error) {
+	var cursor eventCursor
+	if err := decodeCursor(s, &cursor); err != nil {
+		return eventCursor{}, err
+	}
+
+	if cursor.GlobalSeq < 0 {
+		return eventCursor{}, errors.New("negative global sequence number")
+	}
+
+	return cursor, nil
+}
+
+// Events streams the events of all the chats of the authenticated user:
+// the created and updated chats, their new messages and the updates of their receipts.
+// The ID of an event is an opaque cursor holding the position of the client in the messages of all the chats,
+// a client reconnecting with the Last-Event-ID header receives the events it missed, up to maxReplayedMessages messages.
+func (h *EventHandler) Events(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler stream events", slog.String("path", r.URL.Path))
+
+	user := currentUser(r)
+
+	var (
+		cursor   eventCursor
+		resuming bool
+		err      error
+	)
+
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		if cursor, err = parseEventCursor(lastEventID); err != nil {
+			h.logger.ErrorContext(r.Context(), "invalid Last-Event-ID", slog.String("error", err.Error()))
+			writeError(w, r, errInvalidLastEventID, "invalid Last-Event-ID")
+
+			return
+		}
+
+		resuming = true
+	}
+
+	// subscribe before reading the chats to not miss the events sent meanwhile.
+	sub, ok := h.subscribe(w, r, user)
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	// the chats created from now on are streamed, the chats are created with a millisecond precision:
+	// the ones created in the same millisecond are sent again when resuming rather than missed.
+	now := time.Now().UTC().Truncate(time.Millisecond).Add(-time.Millisecond)
+
+	chats, missed, ok := h.getMissed(w, r, user, resuming, cursor.GlobalSeq)
+	if !ok {
+		return
+	}
+
+	stream, err := startEventStream(w)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to start stream", slog.String("error", err.Error()))
+
+		return
+	}
+
+	if resuming {
+		err = h.replay(r.Context(), stream, &cursor, chats, missed, user)
+		cursor.ChatsAt = now
+	} else {
+		// the client starts after the last message of its chats, and gives the client its starting position.
+		cursor = eventCursor{GlobalSeq: lastGlobalSeq(chats), ChatsAt: now}
+		err = stream.send(cursor.String(), "", nil)
+	}
+
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to send events", slog.String("error", err.Error()))
+
+		return
+	}
+
+	h.streamEvents(r.Context(), stream, sub, cursor, chats, user)
+}
+
+// getMissed gets the chats of the user, and the messages missed since the global sequence number when resuming,
+// writing the error response on failure.
+func (h *EventHandler) getMissed(
+	w http.ResponseWriter,
+	r *http.Request,
+	user string,
+	resuming bool,
+	afterGlobalSeq int64,
+) ([]repo.UserChat, []missedMessage, bool) {
+	chats, err := h.chatRepo.GetUserChats(user, repo.ChatQuery{})
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get user chats", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to get user chats")
+
+		return nil, nil, false
+	}
+
+	if !resuming {
+		return chats, nil, true
+	}
+
+	missed, err := h.missedMessages(chats, afterGlobalSeq, user)
+	if errors.Is(err, errResyncRequired) {
+		h.logger.DebugContext(r.Context(), "too many missed messages", slog.Int64("globalSeq", afterGlobalSeq))
+		writeError(w, r, err, "too many missed messages")
+
+		return nil, nil, false
+	}
+
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get missed messages", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to get chat messages")
+
+		return nil, nil, false
+	}
+
+	return chats, missed, true
+}
+
+// streamEvents streams the events of the subscription from the cursor, the chats are known to the client.
+func (h *EventHandler) streamEvents(
+	ctx context.Context,
+	stream *eventStream,
+	sub *realtime.Subscription,
+	cursor eventCursor,
+	chats []repo.UserChat,
+	user string,
+) {
+	known := make(map[string]bool, len(chats))
+	for _, chat := range chats {
+		known[chat.ID] = true
+	}
+
+	// the messages up to the cursor have been replayed, or sent before the connection.
+	sentSeq := cursor.GlobalSeq
+
+	h.stream(ctx, stream, sub, func(event realtime.Event) error {
+		switch data := event.Data.(type) {
+		case ChatResponse:
+			// an updated chat may be a group the user has been added to.
+			if known[data.ID] && event.Type == realtime.EventChatCreated {
+				return nil
+			}
+
+			known[data.ID] = true
+
+			if event.Type == realtime.EventChatCreated && data.CreatedAt.After(cursor.ChatsAt) {
+				cursor.ChatsAt = data.CreatedAt
+			}
+
+			return stream.send(cursor.String(), event.Type, data)
+		case MessageResponse:
+			// an edited or deleted message does not move the stream forward.
+			if event.Type != realtime.EventMessageCreated {
+				return stream.send(cursor.String(), event.Type, data)
+			}
+
+			// the position of a created message is its global sequence number.
+			if event.Position <= sentSeq {
+				return nil
+			}
+
+			cursor.GlobalSeq = max(cursor.GlobalSeq, event.Position)
+
+			if err := stream.send(cursor.String(), event.Type, data); err != nil {
+				return err
+			}
+
+			h.receipts.delivered(ctx, user, data)
+
+			return nil
+		case ReceiptResponse:
+			return stream.send(cursor.String(), event.Type, data)
+		default:
+			return nil
+		}
+	})
+}
+
+// lastGlobalSeq returns the global sequence number of the last message of the chats, 0 without message.
+func lastGlobalSeq(chats []repo.UserChat) int64 {
+	var seq int64
+
+	for _, chat := range chats {
+		if chat.LastMessage != nil {
+			seq = max(seq, chat.LastMessage.GlobalSeq)
+		}
+	}
+
+	return seq
+}
+
+// missedMessage is a message created since the cursor of a client, and the chat it belongs to.
+type missedMessage struct {
+	chat     *repo.Chat
+	message  repo.Message
+	response MessageResponse
+}
+
+// replay sends the events missed since the cursor: the chats created since then, from the oldest one,
+// then the messages of all the chats in the order they were created.
+// A chat changed since then, like a group the user has been added to, is sent before its first missed notice.
+func (h *EventHandler) replay(
+	ctx context.Context,
+	stream *eventStream,
+	cursor *eventCursor,
+	chats []repo.UserChat,
+	missed []missedMessage,
+	user string,
+) error {
+	created := slices.SortedFunc(slices.Values(chats), func(a, b repo.UserChat) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	sent := make(map[string]bool)
+
+	for _, chat := range created {
+		if !chat.CreatedAt.After(cursor.ChatsAt) {
+			continue
+		}
+
+		cursor.ChatsAt = chat.CreatedAt
+		sent[chat.ID] = true
+
+		if err := stream.send(cursor.String(), realtime.EventChatCreated, newChatResponse(chat.Chat)); err != nil {
+			return err
+		}
+	}
+
+	delivered := make(map[string]int64)
+
+	for _, m := range missed {
+		if m.message.System && !sent[m.chat.ID] {
+			sent[m.chat.ID] = true
+
+			if err := stream.send(cursor.String(), realtime.EventChatUpdated, newChatResponse(*m.chat)); err != nil {
+				return err
+			}
+		}
+
+		cursor.GlobalSeq = m.message.GlobalSeq
+		delivered[m.chat.ID] = m.message.Seq
+
+		if err := stream.send(cursor.String(), realtime.EventMessageCreated, m.response); err != nil {
+			return err
+		}
+	}
+
+	for chatID, seq := range delivered {
+		h.receipts.markDelivered(ctx, user, chatID, seq)
+	}
+
+	return nil
+}
+
+// missedMessages gets the messages of the chats after the global sequence number, visible to the user,
+// in the order they were created. It returns errResyncRequired when there are more than maxReplayedMessages.
+func (h *EventHandler) missedMessages(chats []repo.UserChat, afterGlobalSeq int64, user string) ([]missedMessage, error) {
+	var missed []missedMessage
+
+	for i := range chats {
+		chat := &chats[i]
+
+		// the last message of the chat has been received.
+		if chat.LastMessage == nil || chat.LastMessage.GlobalSeq <= afterGlobalSeq {
+			continue
+		}
+
+		messages, err := h.messageRepo.GetChatMessages(chat.ID, repo.MessageQuery{
+			AfterGlobalSeq: afterGlobalSeq,
+			Viewer:         user,
+			Limit:          maxReplayedMessages - len(missed) + 1,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get chat messages: %w", err)
+		}
+
+		if len(missed)+len(messages) > maxReplayedMessages {
+			return nil, errResyncRequired
+		}
+
+		quotes, err := getQuotes(h.messageRepo, chat.ID, messages)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, message := range messages {
+			missed = append(missed, missedMessage{chat: &chat.Chat, message: message, response: newQuotedMessageResponse(message, quotes)})
+		}
+	}
+
+	slices.SortFunc(missed, func(a, b missedMessage) int { return cmp.Compare(a.message.GlobalSeq, b.message.GlobalSeq) })
+
+	return missed, nil
+}
+
+// stream sends the events of the subscription with send, and heartbeats to keep the connection open,
+// until the client leaves or the subscription is closed.
+func (h *EventHandler) stream(
+	ctx context.Context,
+	stream *eventStream,
+	sub *realtime.Subscription,
+	send func(event realtime.Event) error,
+) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				// the hub is shutting down or the client is too slow, the client will reconnect.
+				return
+			}
+
+			err = send(event)
+		case <-ticker.C:
+			err = stream.heartbeat()
+		}
+
+		if err != nil {
+			h.logger.ErrorContext(ctx, "failed to send event", slog.String("error", err.Error()))
+
+			return
+		}
+	}
+}
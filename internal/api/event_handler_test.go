@@ -0,0 +1,404 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/realtime"
+)
+
+// sseEvent is an event received from a Server-Sent Events stream.
+type sseEvent struct {
+	ID   string
+	Type string
+	Data string
+}
+
+// openEventStream opens an event stream of the user, resuming after lastEventID if not empty.
+// Without user, the access token must be given in the path.
+func openEventStream(
+	ctx context.Context,
+	t *testing.T,
+	server *httptest.Server,
+	path, user, lastEventID string,
+) *bufio.Reader {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+path, http.NoBody)
+	require.NoError(t, err)
+
+	if user != "" {
+		authenticate(t, req, user)
+	}
+
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	t.Cleanup(func() {
+		_ = resp.Body.Close()
+	})
+
+	return bufio.NewReader(resp.Body)
+}
+
+// readSSE reads the next event of the stream, skipping the comments.
+func readSSE(t *testing.T, reader *bufio.Reader) sseEvent {
+	t.Helper()
+
+	var event sseEvent
+
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "" && event != (sseEvent{}):
+			return event
+		case strings.HasPrefix(line, "id: "):
+			event.ID = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.Type = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.Data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+// chatIDOf returns the ID of the chat of a chat or message event.
+func chatIDOf(t *testing.T, event sseEvent) string {
+	t.Helper()
+
+	if event.Type == realtime.EventChatCreated || event.Type == realtime.EventChatUpdated {
+		var chat ChatResponse
+		require.NoError(t, json.Unmarshal([]byte(event.Data), &chat))
+
+		return chat.ID
+	}
+
+	var message MessageResponse
+	require.NoError(t, json.Unmarshal([]byte(event.Data), &message))
+
+	return message.ChatID
+}
+
+// requireMessageEvent checks the event holds the message.
+func requireMessageEvent(t *testing.T, expected MessageResponse, event sseEvent) {
+	t.Helper()
+
+	require.Equal(t, realtime.EventMessageCreated, event.Type)
+
+	var message MessageResponse
+	require.NoError(t, json.Unmarshal([]byte(event.Data), &message))
+	require.Equal(t, expected, message)
+}
+
+func TestEventHandler_ChatEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := httptest.NewServer(testRouter)
+	// closed after the streams, the cleanups being run in reverse order.
+	t.Cleanup(server.Close)
+
+	sender := generateRandomPhoneNumber()
+	require.NoError(t, testUserRepo.AddUser(sender))
+
+	receiver := generateRandomPhoneNumber()
+	require.NoError(t, testUserRepo.AddUser(receiver))
+
+	first := sendTestMessage(ctx, t, sender, receiver, "first")
+	time.Sleep(2 * time.Millisecond)
+	second := sendTestMessage(ctx, t, sender, receiver, "second")
+
+	path := "/chats/" + first.ChatID + "/events"
+
+	// resuming after the first message replays the second one.
+	stream := openEventStream(ctx, t, server, path, receiver, "1")
+
+	event := readSSE(t, stream)
+	assert.Equal(t, "2", event.ID)
+	requireMessageEvent(t, second, event)
+
+	// the new messages are streamed.
+	third := sendTestMessage(ctx, t, receiver, sender, "third")
+
+	event = readSSE(t, stream)
+	assert.Equal(t, "3", event.ID)
+	requireMessageEvent(t, third, event)
+
+	// without Last-Event-ID, only the new messages are streamed.
+	stream = openEventStream(ctx, t, server, path, receiver, "")
+	fourth := sendTestMessage(ctx, t, sender, receiver, "fourth")
+
+	event = readSSE(t, stream)
+	assert.Equal(t, "4", event.ID)
+	requireMessageEvent(t, fourth, event)
+}
+
+func TestEventHandler_ChatEvents_Errors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sender := generateRandomPhoneNumber()
+	require.NoError(t, testUserRepo.AddUser(sender))
+
+	receiver := generateRandomPhoneNumber()
+	require.NoError(t, testUserRepo.AddUser(receiver))
+
+	message := sendTestMessage(ctx, t, sender, receiver, "Hello World!")
+
+	tests := []struct {
+		name         string
+		chatID       string
+		user         string
+		lastEventID  string
+		expectedCode int
+	}{
+		{
+			name:         "unauthenticated",
+			chatID:       message.ChatID,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "unknown chat",
+			chatID:       "unknown",
+			user:         sender,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "not a participant",
+			chatID:       message.ChatID,
+			user:         generateRandomPhoneNumber(),
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "invalid Last-Event-ID",
+			chatID:       message.ChatID,
+			user:         sender,
+			lastEventID:  "invalid",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(
+				ctx,
+				http.MethodGet,
+				"/chats/"+test.chatID+"/events",
+				http.NoBody,
+			)
+			require.NoError(t, err)
+
+			if test.user != "" {
+				authenticate(t, req, test.user)
+			}
+
+			if test.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", test.lastEventID)
+			}
+
+			rr := httptest.NewRecorder()
+			testRouter.ServeHTTP(rr, req)
+
+			assert.Equal(t, test.expectedCode, rr.Code)
+		})
+	}
+}
+
+func TestEventHandler_Events(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := httptest.NewServer(testRouter)
+	// closed after the streams, the cleanups being run in reverse order.
+	t.Cleanup(server.Close)
+
+	user := generateRandomPhoneNumber()
+	require.NoError(t, testUserRepo.AddUser(user))
+
+	friend := generateRandomPhoneNumber()
+	require.NoError(t, testUserRepo.AddUser(friend))
+
+	other := generateRandomPhoneNumber()
+	require.NoError(t, testUserRepo.AddUser(other))
+
+	// a message sent before the connection is not streamed.
+	sendTestMessage(ctx, t, user, friend, "before")
+
+	// the chats created in the same millisecond as the connection are sent again when resuming.
+	time.Sleep(2 * time.Millisecond)
+
+	// the browsers EventSource can't set headers, the token is passed as a query parameter.
+	path := "/events?access_token=" + url.QueryEscape(testAccessToken(t, user))
+	stream := openEventStream(ctx, t, server, path, "", "")
+
+	// the stream starts with the position of the client.
+	start := readSSE(t, stream)
+	require.NotEmpty(t, start.ID)
+	require.Empty(t, start.Type)
+
+	existing := sendTestMessage(ctx, t, friend, user, "existing chat")
+	created := sendTestMessage(ctx, t, other, user, "new chat")
+
+	requireMessageEvent(t, existing, readSSE(t, stream))
+
+	event := readSSE(t, stream)
+	require.Equal(t, realtime.EventChatCreated, event.Type)
+
+	var chat ChatResponse
+	require.NoError(t, json.Unmarshal([]byte(event.Data), &chat))
+	assert.Equal(t, created.ChatID, chat.ID)
+	assert.Equal(t, []string{other, user}, chat.Participants)
+
+	requireMessageEvent(t, created, readSSE(t, stream))
+
+	// reconnecting from the start position replays the missed events, the new chats first.
+	stream = openEventStream(ctx, t, server, path, "", start.ID)
+
+	event = readSSE(t, stream)
+	require.Equal(t, realtime.EventChatCreated, event.Type)
+	assert.Equal(t, created.ChatID, chatIDOf(t, event))
+
+	requireMessageEvent(t, existing, readSSE(t, stream))
+
+	event = readSSE(t, stream)
+	requireMessageEvent(t, created, event)
+
+	// the position of the client is the last message and chat, it does not grow with its chats.
+	cursor, err := parseEventCursor(event.ID)
+	require.NoError(t, err)
+
+	last, err := testMessageRepo.GetChatMessage(created.ChatID, created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, last.GlobalSeq, cursor.GlobalSeq)
+
+	// reconnecting from the last event does not replay anything.
+	stream = openEventStream(ctx, t, server, path, "", event.ID)
+	live := sendTestMessage(ctx, t, user, other, "live")
+
+	requireMessageEvent(t, live, readSSE(t, stream))
+}
+
+func TestEventHandler_Events_AddedToGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := httptest.NewServer(testRouter)
+	t.Cleanup(server.Close)
+
+	users := registerTestUsers(t, 3)
+	admin, member, user := users[0], users[1], users[2]
+
+	group := createTestGroup(ctx, t, admin, "friends", member)
+
+	// the chats created in the same millisecond as the connection are sent again when resuming.
+	time.Sleep(2 * time.Millisecond)
+
+	stream := openEventStream(ctx, t, server, "/events", user, "")
+	start := readSSE(t, stream)
+	require.Empty(t, start.Type)
+
+	rr := requestTest(ctx, t, http.MethodPost, "/chats/"+group.ID+"/participants", `{"members": ["`+user+`"]}`, admin)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	// the group is new to the user, its following messages are streamed.
+	event := readSSE(t, stream)
+	require.Equal(t, realtime.EventChatUpdated, event.Type)
+	assert.Equal(t, group.ID, chatIDOf(t, event))
+
+	event = readSSE(t, stream)
+	require.Equal(t, realtime.EventMessageCreated, event.Type)
+	assert.Equal(t, group.ID, chatIDOf(t, event))
+
+	// the renaming of a known chat is streamed too.
+	rr = requestTest(ctx, t, http.MethodPatch, "/chats/"+group.ID, `{"name": "best friends"}`, admin)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	event = readSSE(t, stream)
+	require.Equal(t, realtime.EventChatUpdated, event.Type)
+
+	var chat ChatResponse
+	require.NoError(t, json.Unmarshal([]byte(event.Data), &chat))
+	assert.Equal(t, "best friends", chat.Name)
+
+	// resuming from the start, the group is sent before its missed notices.
+	stream = openEventStream(ctx, t, server, "/events", user, start.ID)
+
+	event = readSSE(t, stream)
+	require.Equal(t, realtime.EventChatUpdated, event.Type)
+	assert.Equal(t, group.ID, chatIDOf(t, event))
+
+	for range 2 {
+		event = readSSE(t, stream)
+		require.Equal(t, realtime.EventMessageCreated, event.Type)
+		assert.Equal(t, group.ID, chatIDOf(t, event))
+	}
+}
+
+func TestEventHandler_ResyncRequired(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 2)
+	sender, receiver := users[0], users[1]
+
+	server := httptest.NewServer(testRouter)
+	t.Cleanup(server.Close)
+
+	first := sendTestMessage(ctx, t, sender, receiver, "first")
+
+	stream := openEventStream(ctx, t, server, "/events", receiver, "")
+	start := readSSE(t, stream)
+
+	// the messages missed are replayed up to a limit, the client must resync past it.
+	for range maxReplayedMessages + 1 {
+		_, err := testMessageRepo.AddMessage(first.ChatID, sender, "missed")
+		require.NoError(t, err)
+	}
+
+	for _, test := range []struct {
+		path        string
+		lastEventID string
+	}{
+		{path: "/chats/" + first.ChatID + "/events", lastEventID: "0"},
+		{path: "/events", lastEventID: start.ID},
+	} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, test.path, http.NoBody)
+		require.NoError(t, err)
+		authenticate(t, req, receiver)
+		req.Header.Set("Last-Event-ID", test.lastEventID)
+
+		rr := httptest.NewRecorder()
+		testRouter.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusGone, rr.Code, test.path)
+		problem := decodeProblem(t, rr)
+		assert.Equal(t, "resync_required", problem.Code)
+		assert.Equal(t, errResyncRequired.Error(), problem.Detail)
+	}
+
+	// the last messages can still be replayed.
+	stream = openEventStream(ctx, t, server, "/chats/"+first.ChatID+"/events", receiver, "2")
+
+	event := readSSE(t, stream)
+	assert.Equal(t, "3", event.ID)
+}
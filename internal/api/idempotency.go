@@ -0,0 +1,251 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+const (
+	// IdempotencyKeyHeader is the header of the key identifying a request, sent again with its retries.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// DefaultIdempotencyTTL is the default duration during which a request can be retried with its key.
+	DefaultIdempotencyTTL = 24 * time.Hour
+
+	// maxIdempotencyKeyLength is the maximum length of an idempotency key.
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBodySize is the maximum size of the body of a request sent with an idempotency key.
+	maxIdempotentBodySize = 1 << 20
+)
+
+// IdempotencyRepo defines the idempotency keys repository.
+type IdempotencyRepo interface {
+	AddIdempotencyKey(record repo.IdempotencyRecord) (repo.IdempotencyRecord, error)
+	CompleteIdempotencyKey(key string, response repo.IdempotencyResponse) error
+	RemoveIdempotencyKey(key string) error
+}
+
+// Idempotency makes the requests sent with an idempotency key safe to retry.
+// A retry with the same key gets the response of the first request, without executing it again.
+type Idempotency struct {
+	idempotencyRepo IdempotencyRepo
+	// ttl is the duration during which a request can be retried with its key.
+	ttl time.Duration
+	// trustProxy takes the IP address of an anonymous client from the X-Forwarded-For header, set by a reverse proxy.
+	trustProxy bool
+
+	logger *slog.Logger
+}
+
+// NewIdempotency creates a new Idempotency keeping the keys in the repository during the ttl.
+// The keys of the anonymous requests are scoped to the IP address of the client, as the rate limits.
+func NewIdempotency(idempotencyRepo IdempotencyRepo, ttl time.Duration, trustProxy bool) *Idempotency {
+	logger := slog.With(slog.String("middleware", "idempotency"))
+	logger.Info("created middleware")
+
+	return &Idempotency{
+		idempotencyRepo: idempotencyRepo,
+		ttl:             ttl,
+		trustProxy:      trustProxy,
+		logger:          logger,
+	}
+}
+
+var (
+	errIdempotencyKeyInvalid       = newFieldError(IdempotencyKeyHeader, "invalid idempotency key, expected 1 to 255 printable ASCII characters")
+	errIdempotencyKeyReused        = errors.New("idempotency key already used by another request")
+	errIdempotencyKeyInProgress    = errors.New("a request with the idempotency key is in progress")
+	errIdempotencyResponseWithheld = errors.New("the response of the request with the idempotency key can't be replayed")
+)
+
+// Handle honors the Idempotency-Key header of the requests to the handler, the requests without it are served as usual.
+// The keys are scoped to the route and the authenticated user, or the IP address of an anonymous client.
+// A retry with the same key and body gets the response of the first request, with an Idempotent-Replayed header,
+// unless the response must not be stored, like an access token, then the retry is rejected with 409 Conflict;
+// a request with the same key and another body is rejected with 422 Unprocessable Entity,
+// and a retry while the first request is in progress is rejected with 409 Conflict.
+// A request failing with a server error can be retried.
+func (i *Idempotency) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+
+			return
+		}
+
+		if !validIdempotencyKey(key) {
+			writeError(w, r, errIdempotencyKeyInvalid, "invalid idempotency key")
+
+			return
+		}
+
+		body, ok := i.readBody(w, r)
+		if !ok {
+			return
+		}
+
+		sum := sha256.Sum256(body)
+		fingerprint := hex.EncodeToString(sum[:])
+
+		record, err := i.idempotencyRepo.AddIdempotencyKey(repo.IdempotencyRecord{
+			Key:         r.Pattern + " " + client(r, i.trustProxy) + " " + key,
+			Fingerprint: fingerprint,
+			ExpiresAt:   time.Now().UTC().Add(i.ttl).Truncate(time.Millisecond),
+		})
+		if err != nil {
+			i.reject(w, r, record, fingerprint, err)
+
+			return
+		}
+
+		recorder := &idempotencyRecorder{ResponseWriter: w}
+		defer i.complete(r, record.Key, recorder)
+
+		next(recorder, r)
+	}
+}
+
+// readBody reads the body of the request, which can be read again by the handler, writing the error if any.
+func (i *Idempotency) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+	if err != nil {
+		i.logger.ErrorContext(r.Context(), "failed to read body", slog.String("error", err.Error()))
+
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeProblem(w, r, problemContentTooLarge, "request body too large")
+
+			return nil, false
+		}
+
+		writeProblem(w, r, problemInvalidInput, "Invalid input")
+
+		return nil, false
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, true
+}
+
+// reject writes the response of a request whose key has already been used, replaying it for a retry.
+func (i *Idempotency) reject(w http.ResponseWriter, r *http.Request, record repo.IdempotencyRecord, fingerprint string, err error) {
+	if !errors.Is(err, repo.ErrIdempotencyKeyExists) {
+		i.logger.ErrorContext(r.Context(), "failed to add idempotency key", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to check idempotency key")
+
+		return
+	}
+
+	switch {
+	case record.Fingerprint != fingerprint:
+		writeError(w, r, errIdempotencyKeyReused, "idempotency key reused")
+	case record.Response.Status == 0:
+		writeError(w, r, errIdempotencyKeyInProgress, "request in progress")
+	case record.Response.Withheld:
+		writeError(w, r, errIdempotencyResponseWithheld, "response withheld")
+	default:
+		i.logger.DebugContext(r.Context(), "replay response", slog.String("key", record.Key))
+
+		if record.Response.ContentType != "" {
+			w.Header().Set("Content-Type", record.Response.ContentType)
+		}
+
+		if record.Response.Location != "" {
+			w.Header().Set("Location", record.Response.Location)
+		}
+
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(record.Response.Status)
+
+		if _, err = w.Write(record.Response.Body); err != nil {
+			i.logger.ErrorContext(r.Context(), "failed to write response", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// complete saves the response of the request of the key to replay it.
+// The key of a request without response or failing with a server error is removed, so it can be retried.
+// The body of a response not to be stored, with a Cache-Control: no-store header like the access tokens, is withheld.
+func (i *Idempotency) complete(r *http.Request, key string, recorder *idempotencyRecorder) {
+	if recorder.status == 0 || recorder.status >= http.StatusInternalServerError {
+		if err := i.idempotencyRepo.RemoveIdempotencyKey(key); err != nil {
+			i.logger.ErrorContext(r.Context(), "failed to remove idempotency key", slog.String("error", err.Error()))
+		}
+
+		return
+	}
+
+	response := repo.IdempotencyResponse{
+		Status:      recorder.status,
+		ContentType: recorder.Header().Get("Content-Type"),
+		Location:    recorder.Header().Get("Location"),
+		Body:        recorder.body.Bytes(),
+	}
+
+	if strings.Contains(recorder.Header().Get("Cache-Control"), "no-store") {
+		response.Body = nil
+		response.Withheld = true
+	}
+
+	if err := i.idempotencyRepo.CompleteIdempotencyKey(key, response); err != nil {
+		i.logger.ErrorContext(r.Context(), "failed to complete idempotency key", slog.String("error", err.Error()))
+	}
+}
+
+// validIdempotencyKey reports whether the key is made of at most 255 printable ASCII characters.
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+
+	for _, c := range []byte(key) {
+		if c < ' ' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// idempotencyRecorder records the response written by a handler to the response writer.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the status of the response and writes it.
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the body of the response and writes it.
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	rec.body.Write(b)
+
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the response writer, for the http.ResponseController.
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// idempotentTest posts the JSON payload to the router with the idempotency key, as the user if any.
+func idempotentTest(ctx context.Context, t *testing.T, target, payload, user, key string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(payload))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IdempotencyKeyHeader, key)
+
+	if user != "" {
+		authenticate(t, req, user)
+	}
+
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestIdempotency_SendMessage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 3)
+	alice, bob, carol := users[0], users[1], users[2]
+
+	payload := `{"receiver": "` + bob + `", "content": "hello"}`
+
+	rr := idempotentTest(ctx, t, "/messages", payload, alice, "key-1")
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	assert.Empty(t, rr.Header().Get("Idempotent-Replayed"))
+
+	var sent MessageResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &sent))
+
+	// the retry gets the same response, the message is sent once.
+	replayed := idempotentTest(ctx, t, "/messages", payload, alice, "key-1")
+	assert.Equal(t, http.StatusCreated, replayed.Code)
+	assert.Equal(t, "true", replayed.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, rr.Header().Get("Location"), replayed.Header().Get("Location"))
+	assert.Equal(t, "application/json", replayed.Header().Get("Content-Type"))
+	assert.Equal(t, rr.Body.String(), replayed.Body.String())
+
+	page := getTestPage[MessageResponse](t, "/chats/"+sent.ChatID+"/messages", alice)
+	assert.Equal(t, []string{sent.ID}, messageIDs(page.Items))
+
+	// the key can't be used by another request.
+	rr = idempotentTest(ctx, t, "/messages", `{"receiver": "`+bob+`", "content": "bye"}`, alice, "key-1")
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	problem := decodeProblem(t, rr)
+	assert.Equal(t, "idempotency_key_reused", problem.Code)
+	assert.Equal(t, "idempotency key already used by another request", problem.Detail)
+
+	// the keys are scoped to the user.
+	rr = idempotentTest(ctx, t, "/messages", `{"receiver": "`+bob+`", "content": "hello"}`, carol, "key-1")
+	assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	assert.Empty(t, rr.Header().Get("Idempotent-Replayed"))
+
+	// the client errors are replayed too.
+	rr = idempotentTest(ctx, t, "/messages", `{"content": "hello"}`, alice, "key-2")
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	replayed = idempotentTest(ctx, t, "/messages", `{"content": "hello"}`, alice, "key-2")
+	assert.Equal(t, http.StatusBadRequest, replayed.Code)
+	assert.Equal(t, "true", replayed.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, rr.Body.String(), replayed.Body.String())
+
+	rr = idempotentTest(ctx, t, "/messages", payload, alice, strings.Repeat("k", maxIdempotencyKeyLength+1))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	problem = decodeProblem(t, rr)
+	assert.Equal(t, errIdempotencyKeyInvalid.Error(), problem.Detail)
+	assert.Equal(t, []FieldError{{Field: IdempotencyKeyHeader, Detail: errIdempotencyKeyInvalid.Error()}}, problem.Errors)
+}
+
+func TestIdempotency_Register(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	phoneNumber := generateRandomPhoneNumber()
+	payload := `{"phoneNumber": "` + phoneNumber + `"}`
+
+	rr := idempotentTest(ctx, t, "/register/start", payload, "", "start-"+phoneNumber)
+	require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+
+	code := testSMS.code(phoneNumber)
+
+	// the retry gets the same challenge, without waiting to request a new code.
+	replayed := idempotentTest(ctx, t, "/register/start", payload, "", "start-"+phoneNumber)
+	assert.Equal(t, http.StatusAccepted, replayed.Code)
+	assert.Equal(t, rr.Body.String(), replayed.Body.String())
+	assert.Equal(t, code, testSMS.code(phoneNumber))
+
+	rr = idempotentTest(ctx, t, "/register/verify", verificationPayload(phoneNumber, code), "", "verify-"+phoneNumber)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	// the access token is not stored to be replayed, the retry is rejected.
+	replayed = idempotentTest(ctx, t, "/register/verify", verificationPayload(phoneNumber, code), "", "verify-"+phoneNumber)
+	assert.Equal(t, http.StatusConflict, replayed.Code)
+	assert.Empty(t, replayed.Header().Get("Idempotent-Replayed"))
+	problem := decodeProblem(t, replayed)
+	assert.Equal(t, "response_withheld", problem.Code)
+	assert.Equal(t, "the response of the request with the idempotency key can't be replayed", problem.Detail)
+}
+
+func TestIdempotency_Handle(t *testing.T) {
+	idempotency := NewIdempotency(repo.NewIdempotencyRepository(), time.Hour, false)
+
+	var (
+		handler    http.HandlerFunc
+		calls      int
+		inProgress int
+	)
+
+	remoteAddr := "192.0.2.1:1234"
+
+	serve := func(key string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(IdempotencyKeyHeader, key)
+
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		return rr.Code
+	}
+
+	status := http.StatusInternalServerError
+	handler = idempotency.Handle(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		// the request is retried while it is in progress.
+		if r.Header.Get(IdempotencyKeyHeader) == "slow" && inProgress == 0 {
+			inProgress = serve("slow")
+		}
+
+		w.WriteHeader(status)
+	})
+
+	// a request failing with a server error can be retried.
+	assert.Equal(t, http.StatusInternalServerError, serve("key"))
+
+	status = http.StatusNoContent
+
+	assert.Equal(t, http.StatusNoContent, serve("key"))
+	assert.Equal(t, http.StatusNoContent, serve("key"))
+	assert.Equal(t, 2, calls)
+
+	// a retry is rejected while the request is in progress.
+	assert.Equal(t, http.StatusNoContent, serve("slow"))
+	assert.Equal(t, http.StatusConflict, inProgress)
+	assert.Equal(t, 3, calls)
+
+	// the requests without key are served as usual.
+	assert.Equal(t, http.StatusNoContent, serve(""))
+	assert.Equal(t, http.StatusNoContent, serve(""))
+	assert.Equal(t, 5, calls)
+
+	// the keys of the anonymous clients are scoped to their IP address.
+	remoteAddr = "192.0.2.2:1234"
+
+	assert.Equal(t, http.StatusNoContent, serve("key"))
+	assert.Equal(t, http.StatusNoContent, serve("key"))
+	assert.Equal(t, 6, calls)
+}
+
+func TestIdempotency_Handle_NoStore(t *testing.T) {
+	idempotencyRepo := repo.NewIdempotencyRepository()
+	idempotency := NewIdempotency(idempotencyRepo, time.Hour, false)
+
+	handler := idempotency.Handle(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"accessToken": "secret"}`))
+	})
+
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/register/verify", strings.NewReader("{}"))
+		req.Pattern = "POST /register/verify"
+		req.Header.Set(IdempotencyKeyHeader, "key")
+
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		return rr
+	}
+
+	rr := serve()
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.JSONEq(t, `{"accessToken": "secret"}`, rr.Body.String())
+
+	// the body is not stored.
+	record, err := idempotencyRepo.AddIdempotencyKey(repo.IdempotencyRecord{
+		Key:       "POST /register/verify ip:192.0.2.1 key",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.ErrorIs(t, err, repo.ErrIdempotencyKeyExists)
+	assert.Equal(t, repo.IdempotencyResponse{Status: http.StatusCreated, ContentType: "application/json", Withheld: true}, record.Response)
+
+	rr = serve()
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "secret")
+}
@@ -1,9 +1,21 @@
 package api
 
 import (
+	"context"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/jbdoumenjou/mychat/internal/auth"
+	"github.com/jbdoumenjou/mychat/internal/blob"
+	"github.com/jbdoumenjou/mychat/internal/event"
+	"github.com/jbdoumenjou/mychat/internal/otp"
+	"github.com/jbdoumenjou/mychat/internal/phone"
+	"github.com/jbdoumenjou/mychat/internal/ratelimit"
+	"github.com/jbdoumenjou/mychat/internal/realtime"
 	"github.com/jbdoumenjou/mychat/internal/repo"
 )
 
@@ -12,19 +24,95 @@ var (
 	testUserRepo    *repo.UserRepository
 	testChatRepo    *repo.ChatRepository
 	testMessageRepo *repo.MessageRepository
+	testHub         *realtime.Hub
+	testBus         *event.Bus
+	testTokens      *auth.Tokens
+	testSMS         *testSMSSender
+	testEdits       *MessageEditHandler
+	testBlobs       *blob.LocalStore
 )
 
+// testSMSSender records the last verification code sent to each phone number.
+type testSMSSender struct {
+	mu    sync.Mutex
+	codes map[string]string
+}
+
+func (s *testSMSSender) SendSMS(_ context.Context, phoneNumber, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the code ends the text.
+	s.codes[phoneNumber] = text[strings.LastIndex(text, " ")+1:]
+
+	return nil
+}
+
+// code returns the last code sent to the phone number.
+func (s *testSMSSender) code(phoneNumber string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.codes[phoneNumber]
+}
+
 func TestMain(m *testing.M) {
 	testUserRepo = repo.NewUserRepository()
 	testMessageRepo = repo.NewMessageRepository()
-	testChatRepo = repo.NewChatRepository()
+	testChatRepo = repo.NewChatRepository(testMessageRepo)
+	testHub = realtime.NewHub()
+	testBus = event.NewBus()
+	testTokens = auth.NewTokens([]byte("test-secret"), time.Hour)
+
+	blobDir, err := os.MkdirTemp("", "mychat-blobs")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(blobDir)
+
+	if testBlobs, err = blob.NewLocalStore(blobDir); err != nil {
+		panic(err)
+	}
+
+	phones, err := phone.NewNormalizer("FR")
+	if err != nil {
+		panic(err)
+	}
+
+	testSMS = &testSMSSender{codes: make(map[string]string)}
+	verifier := otp.NewVerifier(testSMS, otp.DefaultConfig)
+
+	ForwardEvents(testBus, testHub)
+
+	userHandler := NewUserHandler(testUserRepo, phones, verifier, testTokens, testBus)
+	authHandler := NewAuthHandler(testUserRepo, phones, verifier, testTokens)
+	messageHandler := NewMessageHandler(testUserRepo, testMessageRepo, testChatRepo, phones, testBus)
+	testEdits = NewMessageEditHandler(testChatRepo, testMessageRepo, testBlobs, testBus, DefaultEditWindow)
+	reactionHandler := NewReactionHandler(testChatRepo, testMessageRepo)
+	attachmentHandler := NewAttachmentHandler(testChatRepo, testMessageRepo, testBlobs, testMaxAttachmentSize, DefaultUnattachedAttachmentTTL)
+	searchHandler := NewSearchHandler(testChatRepo, testMessageRepo, phones)
+	blockHandler := NewBlockHandler(testChatRepo, testUserRepo, phones)
+	receiptHandler := NewReceiptHandler(testChatRepo, testMessageRepo, testBus)
+	chatHandler := NewChatHandler(testChatRepo, testMessageRepo, testUserRepo, phones, receiptHandler, testBus)
+	// the tests share the clients, the requests are not limited.
+	limiter := NewRateLimiter(ratelimit.NewMemoryStore(), nil, false)
+	wsHandler := NewWebSocketHandler(testHub, messageHandler, receiptHandler, limiter)
+	eventHandler := NewEventHandler(testHub, testChatRepo, testMessageRepo, receiptHandler)
+	idempotency := NewIdempotency(repo.NewIdempotencyRepository(), DefaultIdempotencyTTL, false)
 
-	userHandler := NewUserHandler(testUserRepo)
-	messageHandler := NewMessageHandler(testUserRepo, testMessageRepo, testChatRepo)
-	chatHandler := NewChatHandler(testChatRepo, testMessageRepo)
+	docsHandler, err := NewDocsHandler()
+	if err != nil {
+		panic(err)
+	}
 
-	// Create the testRouter
-	testRouter = NewRouter(userHandler, messageHandler, chatHandler)
+	// Create the testRouter, its responses must match the OpenAPI specification.
+	testRouter, err = newSpecValidator(NewRouter(
+		userHandler, authHandler, messageHandler, testEdits, reactionHandler, attachmentHandler, searchHandler, blockHandler, chatHandler,
+		receiptHandler, wsHandler, eventHandler, docsHandler, limiter, idempotency,
+	))
+	if err != nil {
+		panic(err)
+	}
 
 	m.Run()
 }
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/event"
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// DefaultEditWindow is the default delay after which a message can no longer be edited.
+const DefaultEditWindow = 15 * time.Minute
+
+// MessageEditHandler is the handler for the edition and the deletion of the messages by their sender.
+type MessageEditHandler struct {
+	chatRepo    MessageEditChatRepo
+	messageRepo MessageEditRepo
+	blobs       BlobStore
+	publisher   Publisher
+	// editWindow is the delay after the creation of a message during which it can be edited, 0 for no limit.
+	editWindow time.Duration
+	now        func() time.Time
+
+	logger *slog.Logger
+}
+
+// MessageEditChatRepo defines the chat repository.
+type MessageEditChatRepo interface {
+	GetChat(chatID string) (repo.Chat, error)
+}
+
+// MessageEditRepo defines the message repository.
+type MessageEditRepo interface {
+	GetChatMessage(chatID, messageID string) (repo.Message, error)
+	EditMessage(chatID, messageID, content string) (repo.Message, error)
+	DeleteMessage(chatID, messageID string) (repo.Message, error)
+	GetMessageHistory(chatID, messageID string) ([]repo.MessageVersion, error)
+}
+
+// NewMessageEditHandler creates a new MessageEditHandler,
+// the messages can be edited during the edit window after their creation, 0 for no limit.
+// The files of a deleted message are deleted from the blob store.
+func NewMessageEditHandler(
+	chatRepo MessageEditChatRepo,
+	messageRepo MessageEditRepo,
+	blobs BlobStore,
+	publisher Publisher,
+	editWindow time.Duration,
+) *MessageEditHandler {
+	logger := slog.With(slog.String("handler", "message_edit"))
+	logger.Info("created handler", slog.Duration("editWindow", editWindow))
+
+	return &MessageEditHandler{
+		chatRepo:    chatRepo,
+		messageRepo: messageRepo,
+		blobs:       blobs,
+		publisher:   publisher,
+		editWindow:  editWindow,
+		now:         time.Now,
+		logger:      logger,
+	}
+}
+
+// MessageEdit represents the new content of a message.
+type MessageEdit struct {
+	Content string `json:"content"`
+}
+
+// MessageVersionResponse represents a content of a message, the original one or an edited one.
+// This is the response format for the API.
+type MessageVersionResponse struct {
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+var (
+	errNotSender         = errors.New("user is not the sender of the message")
+	errSystemMessage     = errors.New("system messages cannot be changed")
+	errEditWindowExpired = errors.New("the message can no longer be edited")
+)
+
+// EditMessage replaces the content of a message of the authenticated user, during the edit window.
+// The participants of the chat are notified with the edited message.
+func (h *MessageEditHandler) EditMessage(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler edit a message", slog.String("path", r.URL.Path))
+
+	chat, message, ok := h.getOwnMessage(w, r)
+	if !ok {
+		return
+	}
+
+	var edit MessageEdit
+
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		h.logger.ErrorContext(r.Context(), "Invalid input")
+		writeProblem(w, r, problemInvalidInput, "Invalid input")
+
+		return
+	}
+
+	if edit.Content == "" {
+		writeError(w, r, errContentRequired, "failed to edit message")
+
+		return
+	}
+
+	if h.editWindow > 0 && h.now().After(message.CreatedAt.Add(h.editWindow)) {
+		writeError(w, r, errEditWindowExpired, "failed to edit message")
+
+		return
+	}
+
+	edited, err := h.messageRepo.EditMessage(chat.ID, message.ID, edit.Content)
+	if err != nil {
+		writeMessageError(w, r, err, "failed to edit message", h.logger)
+
+		return
+	}
+
+	h.publisher.Publish(r.Context(), event.MessageEdited{Message: edited, Participants: recipients(chat, edited)})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(newMessageResponse(edited)); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to write response", slog.String("error", err.Error()))
+	}
+}
+
+// DeleteMessage deletes a message of the authenticated user, it stays in the chat as a tombstone.
+// The content of its files and their thumbnails are deleted, the participants of the chat are notified with the tombstone.
+func (h *MessageEditHandler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler delete a message", slog.String("path", r.URL.Path))
+
+	chat, message, ok := h.getOwnMessage(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := h.messageRepo.DeleteMessage(chat.ID, message.ID)
+	if err != nil {
+		writeMessageError(w, r, err, "failed to delete message", h.logger)
+
+		return
+	}
+
+	// the files are no longer reachable, a blob left behind is only logged.
+	for _, attachment := range message.Attachments {
+		if err = deleteBlobs(r.Context(), h.blobs, attachment); err != nil {
+			h.logger.ErrorContext(r.Context(), "failed to delete attachment content", slog.String("error", err.Error()))
+		}
+	}
+
+	h.publisher.Publish(r.Context(), event.MessageDeleted{Message: deleted, Participants: recipients(chat, deleted)})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetMessageHistory gets the versions of a message of the authenticated user, from the original one to the current one.
+func (h *MessageEditHandler) GetMessageHistory(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler get the history of a message", slog.String("path", r.URL.Path))
+
+	chat, message, ok := h.getOwnMessage(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := h.messageRepo.GetMessageHistory(chat.ID, message.ID)
+	if err != nil {
+		writeMessageError(w, r, err, "failed to get message history", h.logger)
+
+		return
+	}
+
+	versions := make([]MessageVersionResponse, 0, len(history)+1)
+	for _, version := range append(history, message.Version()) {
+		versions = append(versions, MessageVersionResponse{Content: version.Content, CreatedAt: version.CreatedAt})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(versions); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to write response", slog.String("error", err.Error()))
+	}
+}
+
+// getOwnMessage gets the message of the request, it must be a message of the authenticated user not deleted yet.
+// It reports whether the message is found, otherwise the error is written.
+func (h *MessageEditHandler) getOwnMessage(w http.ResponseWriter, r *http.Request) (repo.Chat, repo.Message, bool) {
+	chat, ok := getParticipantChat(w, r, h.chatRepo, h.logger)
+	if !ok {
+		return repo.Chat{}, repo.Message{}, false
+	}
+
+	message, err := h.messageRepo.GetChatMessage(chat.ID, r.PathValue("messageId"))
+	if err == nil && !message.VisibleTo(currentUser(r)) {
+		err = repo.ErrMessageNotFound
+	}
+
+	if err != nil {
+		writeMessageError(w, r, err, "failed to get chat message", h.logger)
+
+		return repo.Chat{}, repo.Message{}, false
+	}
+
+	switch {
+	case message.System:
+		writeError(w, r, errSystemMessage, "failed to get chat message")
+	case message.Sender != currentUser(r):
+		writeError(w, r, errNotSender, "failed to get chat message")
+	case message.Deleted():
+		writeError(w, r, repo.ErrMessageDeleted, "failed to get chat message")
+	default:
+		return chat, message, true
+	}
+
+	return repo.Chat{}, repo.Message{}, false
+}
+
+// writeMessageError writes the error of the message repository.
+func writeMessageError(w http.ResponseWriter, r *http.Request, err error, message string, logger *slog.Logger) {
+	logger.ErrorContext(r.Context(), message, slog.String("error", err.Error()))
+
+	writeError(w, r, err, message)
+}
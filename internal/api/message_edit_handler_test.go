@@ -0,0 +1,264 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/realtime"
+)
+
+func TestMessageEditHandler_EditMessage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := httptest.NewServer(testRouter)
+	t.Cleanup(server.Close)
+
+	users := registerTestUsers(t, 2)
+	sender, receiver := users[0], users[1]
+
+	message := sendTestMessage(ctx, t, sender, receiver, "Hello")
+	target := "/chats/" + message.ChatID + "/messages/" + message.ID
+
+	stream := openEventStream(ctx, t, server, "/chats/"+message.ChatID+"/events", receiver, "")
+
+	rr := requestTest(ctx, t, http.MethodPatch, target, `{"content": "Hello, World!"}`, sender)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var edited MessageResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&edited))
+	assert.Equal(t, "Hello, World!", edited.Content)
+	require.NotNil(t, edited.EditedAt)
+	assert.Equal(t, message.Seq, edited.Seq)
+
+	// the participants are notified, the stream stays at the last message.
+	event := readSSE(t, stream)
+	assert.Equal(t, realtime.EventMessageUpdated, event.Type)
+	assert.Equal(t, "1", event.ID)
+
+	var notified MessageResponse
+	require.NoError(t, json.Unmarshal([]byte(event.Data), &notified))
+	assert.Equal(t, edited, notified)
+
+	rr = requestTest(ctx, t, http.MethodPatch, target, `{"content": "Hello, World!!"}`, sender)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var last MessageResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&last))
+
+	got := getTestPage[MessageResponse](t, "/chats/"+message.ChatID+"/messages", receiver).Items[0]
+	assert.Equal(t, "Hello, World!!", got.Content)
+	assert.Equal(t, last.EditedAt, got.EditedAt)
+
+	// the author gets the versions of the message, from the original one.
+	rr = requestTest(ctx, t, http.MethodGet, target+"/history", "", sender)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var history []MessageVersionResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&history))
+	assert.Equal(t, []MessageVersionResponse{
+		{Content: "Hello", CreatedAt: message.CreatedAt},
+		{Content: "Hello, World!", CreatedAt: *edited.EditedAt},
+		{Content: "Hello, World!!", CreatedAt: *last.EditedAt},
+	}, history)
+}
+
+func TestMessageEditHandler_DeleteMessage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 2)
+	sender, receiver := users[0], users[1]
+
+	message := sendTestMessage(ctx, t, sender, receiver, "Hello")
+	target := "/chats/" + message.ChatID + "/messages/" + message.ID
+
+	rr := requestTest(ctx, t, http.MethodPatch, target, `{"content": "Hello, World!"}`, sender)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	sub, err := testHub.Subscribe(receiver)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	rr = requestTest(ctx, t, http.MethodDelete, target, "", sender)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+
+	event := <-sub.Events()
+	assert.Equal(t, realtime.EventMessageDeleted, event.Type)
+
+	tombstone := event.Data.(MessageResponse)
+	assert.Equal(t, message.ID, tombstone.ID)
+	assert.Equal(t, deletedMessageContent, tombstone.Content)
+	assert.NotNil(t, tombstone.DeletedAt)
+
+	// the tombstone stays in the chat.
+	page := getTestPage[MessageResponse](t, "/chats/"+message.ChatID+"/messages", receiver)
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, deletedMessageContent, page.Items[0].Content)
+	assert.Equal(t, tombstone.DeletedAt, page.Items[0].DeletedAt)
+
+	for _, method := range []string{http.MethodPatch, http.MethodDelete} {
+		rr = requestTest(ctx, t, method, target, `{"content": "Hi"}`, sender)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Equal(t, "message is deleted", decodeProblem(t, rr).Detail)
+	}
+
+	rr = requestTest(ctx, t, http.MethodGet, target+"/history", "", sender)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+func TestMessageEditHandler_EditWindow(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 2)
+	sender, receiver := users[0], users[1]
+
+	message := sendTestMessage(ctx, t, sender, receiver, "Hello")
+	target := "/chats/" + message.ChatID + "/messages/" + message.ID
+
+	testEdits.now = func() time.Time { return time.Now().Add(DefaultEditWindow + time.Second) }
+	t.Cleanup(func() { testEdits.now = time.Now })
+
+	rr := requestTest(ctx, t, http.MethodPatch, target, `{"content": "Hello, World!"}`, sender)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Equal(t, "the message can no longer be edited", decodeProblem(t, rr).Detail)
+
+	// the message can still be deleted.
+	rr = requestTest(ctx, t, http.MethodDelete, target, "", sender)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+}
+
+func TestMessageEditHandler_Errors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 3)
+	sender, receiver, outsider := users[0], users[1], users[2]
+
+	message := sendTestMessage(ctx, t, sender, receiver, "Hello")
+	target := "/chats/" + message.ChatID + "/messages/" + message.ID
+
+	group := createTestGroup(ctx, t, sender, "friends", receiver)
+	rr := requestTest(ctx, t, http.MethodPost, "/chats/"+group.ID+"/participants", `{"members": ["`+outsider+`"]}`, sender)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	notice := getTestPage[MessageResponse](t, "/chats/"+group.ID+"/messages", sender).Items[0]
+
+	testCases := []struct {
+		desc     string
+		method   string
+		target   string
+		body     string
+		user     string
+		expected int
+		message  string
+	}{
+		{
+			desc:     "unauthenticated",
+			method:   http.MethodPatch,
+			target:   target,
+			body:     `{"content": "Hi"}`,
+			expected: http.StatusUnauthorized,
+		},
+		{
+			desc:     "unknown chat",
+			method:   http.MethodPatch,
+			target:   "/chats/unknown/messages/" + message.ID,
+			body:     `{"content": "Hi"}`,
+			user:     sender,
+			expected: http.StatusNotFound,
+			message:  "chat not found",
+		},
+		{
+			desc:     "not a participant",
+			method:   http.MethodDelete,
+			target:   target,
+			user:     outsider,
+			expected: http.StatusForbidden,
+			message:  "user is not a participant of the chat",
+		},
+		{
+			desc:     "unknown message",
+			method:   http.MethodDelete,
+			target:   "/chats/" + message.ChatID + "/messages/unknown",
+			user:     sender,
+			expected: http.StatusNotFound,
+			message:  "message not found",
+		},
+		{
+			desc:     "edit by another participant",
+			method:   http.MethodPatch,
+			target:   target,
+			body:     `{"content": "Hi"}`,
+			user:     receiver,
+			expected: http.StatusForbidden,
+			message:  "user is not the sender of the message",
+		},
+		{
+			desc:     "delete by another participant",
+			method:   http.MethodDelete,
+			target:   target,
+			user:     receiver,
+			expected: http.StatusForbidden,
+			message:  "user is not the sender of the message",
+		},
+		{
+			desc:     "history of another participant",
+			method:   http.MethodGet,
+			target:   target + "/history",
+			user:     receiver,
+			expected: http.StatusForbidden,
+			message:  "user is not the sender of the message",
+		},
+		{
+			desc:     "system message",
+			method:   http.MethodPatch,
+			target:   "/chats/" + group.ID + "/messages/" + notice.ID,
+			body:     `{"content": "Hi"}`,
+			user:     sender,
+			expected: http.StatusForbidden,
+			message:  "system messages cannot be changed",
+		},
+		{
+			desc:     "invalid input",
+			method:   http.MethodPatch,
+			target:   target,
+			body:     `{"content": 1}`,
+			user:     sender,
+			expected: http.StatusBadRequest,
+		},
+		{
+			desc:     "no content",
+			method:   http.MethodPatch,
+			target:   target,
+			body:     `{"content": ""}`,
+			user:     sender,
+			expected: http.StatusBadRequest,
+			message:  "message content is required",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			rr := requestTest(ctx, t, test.method, test.target, test.body, test.user)
+			assert.Equal(t, test.expected, rr.Code)
+
+			if test.message != "" {
+				assert.Equal(t, test.message, decodeProblem(t, rr).Detail)
+			}
+		})
+	}
+
+	// the failed requests left the message unchanged.
+	got := getTestPage[MessageResponse](t, "/chats/"+message.ChatID+"/messages", sender).Items[0]
+	assert.Equal(t, "Hello", got.Content)
+	assert.Nil(t, got.EditedAt)
+}
@@ -1,9 +1,17 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/event"
+	"github.com/jbdoumenjou/mychat/internal/repo"
 )
 
 // MessageHandler is the handler for user registration.
@@ -11,6 +19,8 @@ type MessageHandler struct {
 	chatRepo    MessageChatRepo
 	messageRepo MessageRepo
 	userRepo    MessageUserRepo
+	phones      PhoneNormalizer
+	publisher   Publisher
 
 	logger *slog.Logger
 }
@@ -22,16 +32,25 @@ type MessageUserRepo interface {
 
 // MessageRepo defines the user repository.
 type MessageRepo interface {
-	AddMessage(chatID, content string) error
+	AddMessageWithOptions(chatID, sender, content string, options repo.MessageOptions) (repo.Message, error)
+	GetChatMessage(chatID, messageID string) (repo.Message, error)
 }
 
 // MessageChatRepo defines the chat repository.
 type MessageChatRepo interface {
-	GetOrCreateChat(sender, receiver string) (string, error)
+	GetChat(chatID string) (repo.Chat, error)
+	GetOrCreateChat(sender, receiver string) (repo.Chat, bool, error)
+	GetBlockers(user string, users []string) ([]string, error)
 }
 
 // NewMessageHandler creates a new MessageHandler.
-func NewMessageHandler(userRepo MessageUserRepo, messageRepo MessageRepo, chatRepo MessageChatRepo) *MessageHandler {
+func NewMessageHandler(
+	userRepo MessageUserRepo,
+	messageRepo MessageRepo,
+	chatRepo MessageChatRepo,
+	phones PhoneNormalizer,
+	publisher Publisher,
+) *MessageHandler {
 	logger := slog.With(slog.String("handler", "message"))
 	logger.Info("created handler")
 
@@ -39,17 +58,114 @@ func NewMessageHandler(userRepo MessageUserRepo, messageRepo MessageRepo, chatRe
 		chatRepo:    chatRepo,
 		messageRepo: messageRepo,
 		userRepo:    userRepo,
+		phones:      phones,
+		publisher:   publisher,
 		logger:      logger,
 	}
 }
 
-// Message represents a message to send from a user to another user.
+// Message represents a message to send from the authenticated user,
+// either to another user in their direct chat or to a chat the user participates in.
+// The message can reply to a message of the same chat, and attach files uploaded by the user.
 type Message struct {
-	Sender   string `json:"sender"`
-	Receiver string `json:"receiver"`
-	Content  string `json:"content"`
+	Receiver    string   `json:"receiver,omitempty"`
+	ChatID      string   `json:"chatId,omitempty"`
+	Content     string   `json:"content"`
+	ReplyTo     string   `json:"replyTo,omitempty"`
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+// maxMessageAttachments is the maximum number of attachments of a message.
+const maxMessageAttachments = 10
+
+// Message types.
+const (
+	// messageTypeText is a message sent by a user.
+	messageTypeText = "text"
+	// messageTypeSystem is a notice about the changes of a group chat, written on behalf of the user making them.
+	messageTypeSystem = "system"
+)
+
+// deletedMessageContent is the content of the tombstone of a deleted message.
+const deletedMessageContent = "message deleted"
+
+// MessageResponse represents a message stored in a chat.
+// This is the response format for the API.
+// This avoids to expose the internal Message struct.
+type MessageResponse struct {
+	ID        string    `json:"id"`
+	ChatID    string    `json:"chatId"`
+	Type      string    `json:"type"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+	Seq       int64     `json:"seq"`
+	// EditedAt is set when the message has been edited, DeletedAt when it has been deleted.
+	EditedAt  *time.Time `json:"editedAt,omitempty"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// Receipts are the statuses of the message for each of its recipients, sent, delivered or read.
+	Receipts map[string]string `json:"receipts,omitempty"`
+	// Reactions are the reactions to the message aggregated per emoji.
+	Reactions []ReactionResponse `json:"reactions,omitempty"`
+	// ReplyTo is the ID of the message this message replies to, Quote renders it when it is loaded.
+	ReplyTo string         `json:"replyTo,omitempty"`
+	Quote   *QuoteResponse `json:"quote,omitempty"`
+	// ReplyCount is the number of replies to the message, listed in its thread.
+	ReplyCount int64 `json:"replyCount,omitempty"`
+	// Attachments are the files attached to the message, downloaded by the participants from their URL.
+	Attachments []AttachmentResponse `json:"attachments,omitempty"`
 }
 
+func newMessageResponse(message repo.Message) MessageResponse {
+	messageType := messageTypeText
+	if message.System {
+		messageType = messageTypeSystem
+	}
+
+	response := MessageResponse{
+		ID:         message.ID,
+		ChatID:     message.ChatID,
+		Type:       messageType,
+		Sender:     message.Sender,
+		Content:    message.Content,
+		CreatedAt:  message.CreatedAt,
+		Seq:        message.Seq,
+		ReplyTo:    message.ReplyTo,
+		ReplyCount: message.ReplyCount,
+	}
+
+	for _, attachment := range message.Attachments {
+		response.Attachments = append(response.Attachments, newAttachmentResponse(attachment))
+	}
+
+	if !message.EditedAt.IsZero() {
+		response.EditedAt = &message.EditedAt
+	}
+
+	// the deleted message is a tombstone.
+	if message.Deleted() {
+		response.Content = deletedMessageContent
+		response.DeletedAt = &message.DeletedAt
+	}
+
+	return response
+}
+
+var (
+	errContentRequired       = newFieldError("content", "message content is required")
+	errRecipientRequired     = newFieldError("receiver", "receiver or chatId is required")
+	errReceiverAndChat       = newFieldError("chatId", "receiver and chatId cannot be used together")
+	errSenderNotRegistered   = errors.New("sender phone number not registered")
+	errReceiverNotRegistered = newFieldError("receiver", "receiver phone number not registered")
+	errChatNotFound          = errors.New("chat not found")
+	errNotParticipant        = errors.New("user is not a participant of the chat")
+	errReplyToNotFound       = newFieldError("replyTo", "replyTo message not found in the chat")
+	errTooManyAttachments    = newFieldError("attachments", fmt.Sprintf("a message has at most %d attachments", maxMessageAttachments))
+	errAttachmentNotFound    = newFieldError("attachments", "attachment not found")
+	errAttachmentInUse       = newFieldError("attachments", "attachment already attached to a message")
+	errReceiverBlocked       = errors.New("receiver is blocked")
+)
+
 // SendMessage create a new message in a chat with two users.
 func (h *MessageHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 	h.logger.DebugContext(r.Context(), "handler register message", slog.String("path", r.URL.Path))
@@ -59,69 +175,259 @@ func (h *MessageHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 	// Decode the JSON body
 	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
 		h.logger.ErrorContext(r.Context(), "Invalid input")
-		// TODO: improve error management.
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+		writeProblem(w, r, problemInvalidInput, "Invalid input")
 
 		return
 	}
 
-	if message.Content == "" {
-		h.logger.ErrorContext(r.Context(), "message content is required")
-		http.Error(w, "message content is required", http.StatusBadRequest)
+	created, err := h.send(r.Context(), currentUser(r), message)
+	if err != nil {
+		writeError(w, r, err, "Failed to send message")
 
 		return
 	}
 
-	// Check if the phone number is already registered.
-	if !h.userRepo.IsRegistered(message.Sender) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/chats/"+created.ChatID+"/messages/"+created.ID)
+	w.WriteHeader(http.StatusCreated)
+
+	if err = json.NewEncoder(w).Encode(created); err != nil {
 		h.logger.ErrorContext(r.Context(),
-			"Sender phone number not registered",
-			slog.String("phoneNumber", message.Sender),
+			"failed to write response",
+			slog.String("error", err.Error()),
 		)
-		http.Error(w, "Sender phone number not registered", http.StatusBadRequest)
 
 		return
 	}
 
+	h.logger.DebugContext(r.Context(),
+		"Content send successfully",
+		slog.Any("message", created),
+	)
+}
+
+// send stores the message in its chat, the direct chat of the sender and the receiver
+// or the chat given by its ID, then notifies the participants about the new message.
+// The message is returned with the quote of the message it replies to, if any.
+// The content is required, unless files are attached.
+// A direct message to a receiver who blocked the sender is stored as sent, but only the sender sees it.
+func (h *MessageHandler) send(ctx context.Context, sender string, message Message) (MessageResponse, error) {
+	if message.Content == "" && len(message.Attachments) == 0 {
+		h.logger.ErrorContext(ctx, "message content is required")
+
+		return MessageResponse{}, errContentRequired
+	}
+
+	if len(message.Attachments) > maxMessageAttachments {
+		return MessageResponse{}, errTooManyAttachments
+	}
+
 	// Check if the phone number is already registered.
-	if !h.userRepo.IsRegistered(message.Receiver) {
-		h.logger.ErrorContext(r.Context(),
-			"Receiver phone number not registered",
-			slog.String("phoneNumber", message.Receiver),
+	if !h.userRepo.IsRegistered(sender) {
+		h.logger.ErrorContext(ctx,
+			"Sender phone number not registered",
+			slog.String("phoneNumber", sender),
 		)
-		http.Error(w, "Receiver phone number not registered", http.StatusBadRequest)
 
-		return
+		return MessageResponse{}, errSenderNotRegistered
+	}
+
+	var (
+		chat repo.Chat
+		err  error
+	)
+
+	switch {
+	case message.Receiver != "" && message.ChatID != "":
+		return MessageResponse{}, errReceiverAndChat
+	case message.ChatID != "":
+		chat, err = h.getSenderChat(ctx, sender, message.ChatID)
+	case message.Receiver != "":
+		chat, err = h.getDirectChat(ctx, sender, message.Receiver)
+	default:
+		return MessageResponse{}, errRecipientRequired
 	}
 
-	// Get or create the chat with the two users.
-	chatID, err := h.chatRepo.GetOrCreateChat(message.Sender, message.Receiver)
 	if err != nil {
-		h.logger.ErrorContext(r.Context(),
-			"Failed to create chatID",
+		return MessageResponse{}, err
+	}
+
+	blocked, err := h.isBlocked(ctx, sender, chat)
+	if err != nil {
+		return MessageResponse{}, err
+	}
+
+	added, parent, err := h.add(ctx, chat.ID, sender, message, blocked)
+	if err != nil {
+		return MessageResponse{}, err
+	}
+
+	h.publisher.Publish(ctx, event.MessageSent{Message: added, ReplyTo: parent, Participants: recipients(chat, added)})
+
+	response := newMessageResponse(added)
+	if parent != nil {
+		response.Quote = newQuoteResponse(*parent)
+	}
+
+	return response, nil
+}
+
+// recipients returns the participants of the chat notified about the message,
+// a blocked message is only visible to its sender.
+func recipients(chat repo.Chat, message repo.Message) []string {
+	if message.Blocked {
+		return []string{message.Sender}
+	}
+
+	return chat.Participants
+}
+
+// isBlocked reports whether the receiver of the direct chat has blocked the sender.
+// It returns errReceiverBlocked if the sender has blocked the receiver.
+func (h *MessageHandler) isBlocked(ctx context.Context, sender string, chat repo.Chat) (bool, error) {
+	if chat.Group {
+		return false, nil
+	}
+
+	receivers := slices.DeleteFunc(slices.Clone(chat.Participants), func(user string) bool { return user == sender })
+
+	for _, receiver := range receivers {
+		blockers, err := h.chatRepo.GetBlockers(receiver, []string{sender})
+		if err != nil {
+			h.logger.ErrorContext(ctx, "failed to get blockers", slog.String("error", err.Error()))
+
+			return false, fmt.Errorf("failed to get blockers: %w", err)
+		}
+
+		if len(blockers) > 0 {
+			return false, errReceiverBlocked
+		}
+	}
+
+	blockers, err := h.chatRepo.GetBlockers(sender, receivers)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "failed to get blockers", slog.String("error", err.Error()))
+
+		return false, fmt.Errorf("failed to get blockers: %w", err)
+	}
+
+	return len(blockers) > 0, nil
+}
+
+// add adds the message to the chat with its attachments, it returns the message it replies to if any.
+// The message is hidden from the receiver if blocked, and it can only reply to a message visible to the sender.
+func (h *MessageHandler) add(
+	ctx context.Context, chatID, sender string, message Message, blocked bool,
+) (repo.Message, *repo.Message, error) {
+	if message.ReplyTo != "" {
+		parent, err := h.messageRepo.GetChatMessage(chatID, message.ReplyTo)
+		if err == nil && !parent.VisibleTo(sender) {
+			return repo.Message{}, nil, errReplyToNotFound
+		}
+	}
+
+	added, err := h.messageRepo.AddMessageWithOptions(chatID, sender, message.Content, repo.MessageOptions{
+		ReplyTo:     message.ReplyTo,
+		Attachments: message.Attachments,
+		Blocked:     blocked,
+	})
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to register message", slog.String("error", err.Error()))
+
+		switch {
+		case errors.Is(err, repo.ErrReplyToNotFound):
+			return repo.Message{}, nil, errReplyToNotFound
+		case errors.Is(err, repo.ErrAttachmentNotFound):
+			return repo.Message{}, nil, errAttachmentNotFound
+		case errors.Is(err, repo.ErrAttachmentInUse):
+			return repo.Message{}, nil, errAttachmentInUse
+		default:
+			return repo.Message{}, nil, fmt.Errorf("failed to add message: %w", err)
+		}
+	}
+
+	if message.ReplyTo == "" {
+		return added, nil, nil
+	}
+
+	parent, err := h.messageRepo.GetChatMessage(chatID, message.ReplyTo)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "failed to get replied message", slog.String("error", err.Error()))
+
+		return repo.Message{}, nil, fmt.Errorf("failed to get replied message: %w", err)
+	}
+
+	return added, &parent, nil
+}
+
+// getSenderChat gets the chat if the sender participates in it.
+func (h *MessageHandler) getSenderChat(ctx context.Context, sender, chatID string) (repo.Chat, error) {
+	chat, err := h.chatRepo.GetChat(chatID)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "failed to get chat", slog.String("error", err.Error()))
+
+		if errors.Is(err, repo.ErrChatNotFound) {
+			return repo.Chat{}, errChatNotFound
+		}
+
+		return repo.Chat{}, fmt.Errorf("failed to get chat: %w", err)
+	}
+
+	if !slices.Contains(chat.Participants, sender) {
+		h.logger.ErrorContext(ctx, "user is not a participant of the chat", slog.String("phoneNumber", sender))
+
+		return repo.Chat{}, errNotParticipant
+	}
+
+	return chat, nil
+}
+
+// getDirectChat gets the direct chat of the sender and the receiver, creating it if needed.
+func (h *MessageHandler) getDirectChat(ctx context.Context, sender, receiver string) (repo.Chat, error) {
+	receiver, err := h.phones.Normalize(receiver)
+	if err != nil {
+		h.logger.ErrorContext(ctx,
+			"invalid receiver phone number",
+			slog.String("phoneNumber", receiver),
 			slog.String("error", err.Error()),
 		)
-		http.Error(w, "Failed to create chatID", http.StatusInternalServerError)
 
-		return
+		return repo.Chat{}, wrapFieldError("receiver", err)
 	}
 
-	// Add the message to the chat.
-	if err = h.messageRepo.AddMessage(chatID, message.Content); err != nil {
-		h.logger.ErrorContext(r.Context(),
-			"Failed to register message",
+	// Check if the phone number is already registered.
+	if !h.userRepo.IsRegistered(receiver) {
+		h.logger.ErrorContext(ctx,
+			"Receiver phone number not registered",
+			slog.String("phoneNumber", receiver),
+		)
+
+		return repo.Chat{}, errReceiverNotRegistered
+	}
+
+	// Get or create the chat with the two users.
+	chat, created, err := h.chatRepo.GetOrCreateChat(sender, receiver)
+	if err != nil {
+		h.logger.ErrorContext(ctx,
+			"Failed to create chatID",
 			slog.String("error", err.Error()),
 		)
-		http.Error(w, "Failed to register message", http.StatusInternalServerError)
 
-		return
+		return repo.Chat{}, fmt.Errorf("failed to create chat: %w", err)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	if created {
+		// the chat is hidden from a receiver who blocked the sender.
+		blockers, err := h.chatRepo.GetBlockers(sender, []string{receiver})
+		if err != nil {
+			h.logger.ErrorContext(ctx, "failed to get blockers", slog.String("error", err.Error()))
 
-	h.logger.DebugContext(r.Context(),
-		"Content send successfully",
-		slog.Any("message", message),
-	)
+			return repo.Chat{}, fmt.Errorf("failed to get blockers: %w", err)
+		}
+
+		participants := slices.DeleteFunc(slices.Clone(chat.Participants), func(user string) bool { return slices.Contains(blockers, user) })
+		h.publisher.Publish(ctx, event.ChatCreated{Chat: chat, Participants: participants})
+	}
+
+	return chat, nil
 }
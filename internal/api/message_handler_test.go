@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net/http"
@@ -33,10 +34,11 @@ func TestSendMessage(t *testing.T) {
 		ctx,
 		http.MethodPost,
 		"/messages",
-		strings.NewReader(`{"sender": "`+sender+`", "receiver": "`+receiver+`", "content": "Hello World!"}`),
+		strings.NewReader(`{"receiver": "`+receiver+`", "content": "Hello World!"}`),
 	)
 	require.NoError(t, err)
 	req.Header.Set("Content-Type", "application/json")
+	authenticate(t, req, sender)
 
 	// Record the HTTP response
 	rr := httptest.NewRecorder()
@@ -45,6 +47,43 @@ func TestSendMessage(t *testing.T) {
 	respContentType := rr.Header().Get("Content-Type")
 	assert.Equal(t, "application/json", respContentType)
 	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	var result MessageResponse
+
+	err = json.NewDecoder(rr.Body).Decode(&result)
+	require.NoError(t, err)
+	assert.NotEmpty(t, result.ID)
+	assert.NotEmpty(t, result.ChatID)
+	assert.Equal(t, sender, result.Sender)
+	assert.Equal(t, "Hello World!", result.Content)
+	assert.Equal(t, int64(1), result.Seq)
+	assert.False(t, result.CreatedAt.IsZero())
+	assert.Equal(t, "/chats/"+result.ChatID+"/messages/"+result.ID, rr.Header().Get("Location"))
+}
+
+func TestSendMessage_NormalizesReceiver(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sender := generateRandomPhoneNumber()
+	require.NoError(t, testUserRepo.AddUser(sender))
+
+	receiver := generateRandomPhoneNumber()
+	require.NoError(t, testUserRepo.AddUser(receiver))
+
+	// the same receiver written in the international, national and E.164 formats.
+	national := "0" + strings.TrimPrefix(receiver, "+33")
+
+	first := sendTestMessage(ctx, t, sender, "00"+strings.TrimPrefix(receiver, "+"), "first")
+	second := sendTestMessage(ctx, t, sender, national[:2]+" "+national[2:], "second")
+	third := sendTestMessage(ctx, t, sender, receiver, "third")
+
+	assert.Equal(t, first.ChatID, second.ChatID)
+	assert.Equal(t, first.ChatID, third.ChatID)
+
+	chat, err := testChatRepo.GetChat(first.ChatID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{sender, receiver}, chat.Participants)
 }
 
 func TestSendMessage_Errors(t *testing.T) {
@@ -69,10 +108,17 @@ func TestSendMessage_Errors(t *testing.T) {
 		expectedCodeErr int
 	}{
 		{
-			name:            "Empty user1",
+			name:            "Unauthenticated",
 			sender:          "",
 			receiver:        user2,
 			content:         "Hello World!",
+			expectedCodeErr: http.StatusUnauthorized,
+		},
+		{
+			name:            "Unregistered sender",
+			sender:          generateRandomPhoneNumber(),
+			receiver:        user2,
+			content:         "Hello World!",
 			expectedCodeErr: http.StatusBadRequest,
 		},
 		{
@@ -82,6 +128,13 @@ func TestSendMessage_Errors(t *testing.T) {
 			content:         "Hello World!",
 			expectedCodeErr: http.StatusBadRequest,
 		},
+		{
+			name:            "Invalid receiver phone number",
+			sender:          user1,
+			receiver:        "+33 6 66",
+			content:         "Hello World!",
+			expectedCodeErr: http.StatusBadRequest,
+		},
 		{
 			name:            "Empty content",
 			sender:          user1,
@@ -98,30 +151,51 @@ func TestSendMessage_Errors(t *testing.T) {
 				ctx,
 				http.MethodPost,
 				"/messages",
-				strings.NewReader(`{"sender": "`+test.sender+`", "receiver": "`+test.receiver+`", "content": "`+test.content+`"}`),
+				strings.NewReader(`{"receiver": "`+test.receiver+`", "content": "`+test.content+`"}`),
 			)
 			require.NoError(t, err)
 			req.Header.Set("Content-Type", "application/json")
 
+			if test.sender != "" {
+				authenticate(t, req, test.sender)
+			}
+
 			// Record the HTTP response
 			rr := httptest.NewRecorder()
 			testRouter.ServeHTTP(rr, req)
 
 			respContentType := rr.Header().Get("Content-Type")
-			assert.Equal(t, "text/plain; charset=utf-8", respContentType)
+			assert.Equal(t, ProblemContentType, respContentType)
 			assert.Equal(t, test.expectedCodeErr, rr.Code)
 		})
 	}
 }
 
-// generateRandomPhoneNumber generates a random phone number in E.164 format.
+// generateRandomPhoneNumber generates a random valid phone number in E.164 format,
+// a French mobile phone number.
 func generateRandomPhoneNumber() string {
-	// Generate a random country code (1 to 999)
-	countryCode := rand.Intn(999) + 1
+	return fmt.Sprintf("+3367%07d", rand.Intn(10_000_000))
+}
+
+// sendTestMessage sends a message with the API and returns the created message.
+func sendTestMessage(ctx context.Context, t *testing.T, sender, receiver, content string) MessageResponse {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		"/messages",
+		strings.NewReader(`{"receiver": "`+receiver+`", "content": "`+content+`"}`),
+	)
+	require.NoError(t, err)
+	authenticate(t, req, sender)
+
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
 
-	// Generate a random local number (10 digits)
-	localNumber := rand.Intn(1000000000) + 1000000000
+	var message MessageResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&message))
 
-	// Return the phone number in E.164 format
-	return fmt.Sprintf("+%d%d", countryCode, localNumber)
+	return message
 }
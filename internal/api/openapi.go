@@ -0,0 +1,311 @@
+package api
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// openAPISpec is the OpenAPI specification of the API, in JSON.
+// It describes every route of the router, the tests check the responses against it.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// DocsHandler is the handler for the documentation of the API, its OpenAPI specification and a page rendering it.
+type DocsHandler struct {
+	page []byte
+
+	logger *slog.Logger
+}
+
+// NewDocsHandler creates a new DocsHandler, the documentation page is rendered once from the specification.
+func NewDocsHandler() (*DocsHandler, error) {
+	logger := slog.With(slog.String("handler", "docs"))
+
+	page, err := renderDocs(openAPISpec)
+	if err != nil {
+		return nil, fmt.Errorf("render docs: %w", err)
+	}
+
+	logger.Info("created handler")
+
+	return &DocsHandler{
+		page:   page,
+		logger: logger,
+	}, nil
+}
+
+// Spec writes the OpenAPI specification of the API.
+func (h *DocsHandler) Spec(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler get openapi spec", slog.String("path", r.URL.Path))
+
+	h.write(w, r, "application/json", openAPISpec)
+}
+
+// Page writes the documentation page of the API.
+func (h *DocsHandler) Page(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler get docs", slog.String("path", r.URL.Path))
+
+	h.write(w, r, "text/html; charset=utf-8", h.page)
+}
+
+func (h *DocsHandler) write(w http.ResponseWriter, r *http.Request, contentType string, content []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(content); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to write response", slog.String("error", err.Error()))
+	}
+}
+
+// specDocument is the part of an OpenAPI document rendered in the documentation page.
+type specDocument struct {
+	Info struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		Description string `json:"description"`
+	} `json:"info"`
+	Tags []struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	} `json:"tags"`
+	Paths      map[string]map[string]specOperation `json:"paths"`
+	Components struct {
+		Parameters map[string]specParameter `json:"parameters"`
+		Responses  map[string]specResponse  `json:"responses"`
+	} `json:"components"`
+}
+
+// specOperation is an operation of an OpenAPI document.
+type specOperation struct {
+	Tags        []string          `json:"tags"`
+	Summary     string            `json:"summary"`
+	Description string            `json:"description"`
+	Security    *[]map[string]any `json:"security"`
+	Parameters  []specParameter   `json:"parameters"`
+	RequestBody *struct {
+		Description string                 `json:"description"`
+		Content     map[string]specContent `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]specResponse `json:"responses"`
+}
+
+// specParameter is a parameter of an operation, or a reference to a shared parameter.
+type specParameter struct {
+	Ref         string `json:"$ref"`
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Required    bool   `json:"required"`
+	Description string `json:"description"`
+}
+
+// specResponse is a response of an operation, or a reference to a shared response.
+type specResponse struct {
+	Ref         string                 `json:"$ref"`
+	Description string                 `json:"description"`
+	Content     map[string]specContent `json:"content"`
+}
+
+// specContent is a media type of a body, its schema is only named.
+type specContent struct {
+	Schema struct {
+		Ref   string `json:"$ref"`
+		Type  string `json:"type"`
+		Items struct {
+			Ref string `json:"$ref"`
+		} `json:"items"`
+	} `json:"schema"`
+}
+
+// docsOperation is an operation as shown in the documentation page.
+type docsOperation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Public      bool
+	Parameters  []specParameter
+	Body        []docsContent
+	Responses   []docsResponse
+}
+
+type docsContent struct {
+	MediaType string
+	Schema    string
+}
+
+type docsResponse struct {
+	Status      string
+	Description string
+	Content     []docsContent
+}
+
+type docsSection struct {
+	Name        string
+	Description string
+	Operations  []docsOperation
+}
+
+// renderDocs renders the documentation page of the OpenAPI document, its operations grouped by tag.
+func renderDocs(spec []byte) ([]byte, error) {
+	var doc specDocument
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("decode spec: %w", err)
+	}
+
+	sections := make([]docsSection, 0, len(doc.Tags))
+	for _, tag := range doc.Tags {
+		sections = append(sections, docsSection{Name: tag.Name, Description: tag.Description})
+	}
+
+	for _, path := range sortedKeys(doc.Paths) {
+		for _, method := range sortedKeys(doc.Paths[path]) {
+			operation := doc.Paths[path][method]
+
+			i := slices.IndexFunc(sections, func(s docsSection) bool { return slices.Contains(operation.Tags, s.Name) })
+			if i < 0 {
+				return nil, fmt.Errorf("operation %s %s: no documented tag", method, path)
+			}
+
+			sections[i].Operations = append(sections[i].Operations, newDocsOperation(&doc, strings.ToUpper(method), path, operation))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := docsTemplate.Execute(&buf, map[string]any{"Info": doc.Info, "Sections": sections}); err != nil {
+		return nil, fmt.Errorf("execute template: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// newDocsOperation resolves the references to the shared parameters and responses of the operation.
+func newDocsOperation(doc *specDocument, method, path string, operation specOperation) docsOperation {
+	docs := docsOperation{
+		Method:      method,
+		Path:        path,
+		Summary:     operation.Summary,
+		Description: operation.Description,
+		Public:      operation.Security != nil && len(*operation.Security) == 0,
+	}
+
+	for _, parameter := range operation.Parameters {
+		if parameter.Ref != "" {
+			parameter = doc.Components.Parameters[refName(parameter.Ref)]
+		}
+
+		docs.Parameters = append(docs.Parameters, parameter)
+	}
+
+	if operation.RequestBody != nil {
+		docs.Body = newDocsContents(operation.RequestBody.Content)
+	}
+
+	for _, status := range sortedKeys(operation.Responses) {
+		response := operation.Responses[status]
+		if response.Ref != "" {
+			response = doc.Components.Responses[refName(response.Ref)]
+		}
+
+		docs.Responses = append(docs.Responses, docsResponse{
+			Status:      status,
+			Description: response.Description,
+			Content:     newDocsContents(response.Content),
+		})
+	}
+
+	return docs
+}
+
+func newDocsContents(contents map[string]specContent) []docsContent {
+	docs := make([]docsContent, 0, len(contents))
+
+	for _, mediaType := range sortedKeys(contents) {
+		schema := contents[mediaType].Schema
+
+		name := refName(schema.Ref)
+		switch {
+		case schema.Items.Ref != "":
+			name = refName(schema.Items.Ref) + "[]"
+		case name == "":
+			name = schema.Type
+		}
+
+		docs = append(docs, docsContent{MediaType: mediaType, Schema: name})
+	}
+
+	return docs
+}
+
+// refName returns the name of the component of the reference.
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
+
+var docsTemplate = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Info.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 960px; margin: 0 auto; padding: 1em; color: #222; }
+h3 { font-size: 1em; margin-bottom: 0.3em; }
+section { border-top: 1px solid #ddd; }
+.operation { margin: 1em 0 1.5em; }
+.method { display: inline-block; min-width: 4em; font-family: monospace; }
+.public { color: #070; font-weight: normal; }
+table { border-collapse: collapse; margin: 0.5em 0; }
+th, td { text-align: left; padding: 0.2em 0.8em 0.2em 0; vertical-align: top; }
+code { background: #f4f4f4; }
+</style>
+</head>
+<body>
+<h1>{{.Info.Title}} <small>{{.Info.Version}}</small></h1>
+<p>{{.Info.Description}}</p>
+<p>The schemas are described in the <a href="/openapi.json">OpenAPI specification</a>.
+The routes require an access token, given as a bearer token, except the public ones.</p>
+<nav><ul>{{range .Sections}}<li><a href="#{{.Name}}">{{.Name}}</a></li>{{end}}</ul></nav>
+{{range .Sections}}
+<section id="{{.Name}}">
+<h2>{{.Name}}</h2>
+<p>{{.Description}}</p>
+{{range .Operations}}
+<div class="operation">
+<h3><span class="method">{{.Method}}</span> <code>{{.Path}}</code> {{.Summary}}{{if .Public}} <span class="public">public</span>{{end}}</h3>
+{{with .Description}}<p>{{.}}</p>{{end}}
+{{with .Parameters}}
+<table>
+<tr><th>Parameter</th><th>In</th><th>Description</th></tr>
+{{range .}}<tr><td><code>{{.Name}}</code>{{if .Required}} required{{end}}</td><td>{{.In}}</td><td>{{.Description}}</td></tr>
+{{end}}</table>
+{{end}}
+{{with .Body}}<p>Body: {{range .}}<code>{{.MediaType}}</code> {{.Schema}} {{end}}</p>{{end}}
+<table>
+<tr><th>Status</th><th>Description</th><th>Content</th></tr>
+{{range .Responses}}<tr><td>{{.Status}}</td><td>{{.Description}}</td><td>{{range .Content}}<code>{{.MediaType}}</code> {{.Schema}} {{end}}</td></tr>
+{{end}}</table>
+</div>
+{{end}}
+</section>
+{{end}}
+</body>
+</html>
+`))
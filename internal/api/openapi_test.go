@@ -0,0 +1,500 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loadSpec loads the OpenAPI specification of the API and validates it.
+func loadSpec() (*openapi3.T, error) {
+	spec, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		return nil, fmt.Errorf("load spec: %w", err)
+	}
+
+	if err = spec.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("validate spec: %w", err)
+	}
+
+	return spec, nil
+}
+
+// findRoute finds the operation of the method and the path in the specification, with the values of its path parameters.
+// A literal segment of a path is preferred to a parameter.
+func findRoute(spec *openapi3.T, method, path string) (*routers.Route, map[string]string) {
+	segments := strings.Split(path, "/")
+
+	var (
+		found    *routers.Route
+		params   map[string]string
+		literals = -1
+	)
+
+	for specPath, item := range spec.Paths.Map() {
+		operation := item.GetOperation(method)
+		if operation == nil {
+			continue
+		}
+
+		specSegments := strings.Split(specPath, "/")
+		if len(specSegments) != len(segments) {
+			continue
+		}
+
+		matched, values := 0, make(map[string]string)
+
+		for i, segment := range specSegments {
+			switch {
+			case strings.HasPrefix(segment, "{"):
+				values[strings.Trim(segment, "{}")] = segments[i]
+			case segment == segments[i]:
+				matched++
+			default:
+				matched = -1
+			}
+
+			if matched < 0 {
+				break
+			}
+		}
+
+		if matched > literals {
+			found = &routers.Route{Spec: spec, Path: specPath, PathItem: item, Method: method, Operation: operation}
+			params, literals = values, matched
+		}
+	}
+
+	return found, params
+}
+
+// specValidator checks the responses of the handler against the OpenAPI specification.
+// A response not matching it is replaced by an internal error explaining the mismatch, failing the test getting it.
+// The streams are not checked.
+type specValidator struct {
+	handler http.Handler
+	spec    *openapi3.T
+}
+
+func newSpecValidator(handler http.Handler) (*specValidator, error) {
+	spec, err := loadSpec()
+	if err != nil {
+		return nil, err
+	}
+
+	return &specValidator{handler: handler, spec: spec}, nil
+}
+
+func (v *specValidator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, params := findRoute(v.spec, r.Method, r.URL.Path)
+	if route == nil {
+		writeProblem(w, r, problemInternal, fmt.Sprintf("%s %s is not in the OpenAPI specification", r.Method, r.URL.Path))
+
+		return
+	}
+
+	if isStream(route.Operation) {
+		v.handler.ServeHTTP(w, r)
+
+		return
+	}
+
+	rr := httptest.NewRecorder()
+	v.handler.ServeHTTP(rr, r)
+
+	if err := validateResponse(r, route, params, rr); err != nil {
+		writeProblem(w, r, problemInternal, fmt.Sprintf("%s %s does not match the OpenAPI specification: %s", r.Method, route.Path, err))
+
+		return
+	}
+
+	maps.Copy(w.Header(), rr.Header())
+	w.WriteHeader(rr.Code)
+	_, _ = w.Write(rr.Body.Bytes())
+}
+
+// isStream reports whether the operation streams its response, a WebSocket or server-sent events.
+func isStream(operation *openapi3.Operation) bool {
+	if operation.Responses.Status(http.StatusSwitchingProtocols) != nil {
+		return true
+	}
+
+	ok := operation.Responses.Status(http.StatusOK)
+
+	return ok != nil && ok.Value.Content.Get("text/event-stream") != nil
+}
+
+// validateResponse validates the recorded response of the request, the bodies other than JSON are not described by a schema.
+func validateResponse(r *http.Request, route *routers.Route, params map[string]string, rr *httptest.ResponseRecorder) error {
+	options := &openapi3filter.Options{IncludeResponseStatus: true}
+
+	mediaType, _, _ := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+	if mediaType != "" && !strings.HasSuffix(mediaType, "json") {
+		options.ExcludeResponseBody = true
+
+		if response := route.Operation.Responses.Status(rr.Code); response != nil && response.Value.Content.Get(mediaType) == nil {
+			return fmt.Errorf("response %d: media type %q not documented", rr.Code, mediaType)
+		}
+	}
+
+	return openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{Request: r, PathParams: params, Route: route},
+		Status:                 rr.Code,
+		Header:                 rr.Header(),
+		Body:                   io.NopCloser(bytes.NewReader(rr.Body.Bytes())),
+		Options:                options,
+	})
+}
+
+func TestOpenAPI_Spec(t *testing.T) {
+	spec, err := loadSpec()
+	require.NoError(t, err)
+
+	for path, item := range spec.Paths.Map() {
+		for method, operation := range item.Operations() {
+			// every operation can be rejected by the authentication, the rate limiter, or fail.
+			for _, status := range []int{http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusInternalServerError} {
+				assert.NotNil(t, operation.Responses.Status(status), "%s %s: response %d", method, path, status)
+			}
+		}
+	}
+}
+
+// routerPatterns returns the patterns of the routes registered by NewRouter, parsed from its source.
+func routerPatterns(t *testing.T) []string {
+	t.Helper()
+
+	file, err := parser.ParseFile(token.NewFileSet(), "router.go", nil, 0)
+	require.NoError(t, err)
+
+	var patterns []string
+
+	ast.Inspect(file, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+
+		if selector, ok := call.Fun.(*ast.SelectorExpr); !ok || selector.Sel.Name != "HandleFunc" {
+			return true
+		}
+
+		literal, ok := call.Args[0].(*ast.BasicLit)
+		require.True(t, ok, "the pattern of a route must be a literal")
+
+		pattern, err := strconv.Unquote(literal.Value)
+		require.NoError(t, err)
+
+		patterns = append(patterns, pattern)
+
+		return true
+	})
+
+	return patterns
+}
+
+func TestOpenAPI_Routes(t *testing.T) {
+	spec, err := loadSpec()
+	require.NoError(t, err)
+
+	var operations []string
+
+	for path, item := range spec.Paths.Map() {
+		for method := range item.Operations() {
+			operations = append(operations, method+" "+path)
+		}
+	}
+
+	patterns := routerPatterns(t)
+	require.NotEmpty(t, patterns)
+
+	// every route is described, and every operation is routed.
+	assert.ElementsMatch(t, patterns, operations)
+}
+
+// jsonField is a field of the JSON encoding of a type.
+type jsonField struct {
+	name     string
+	typ      string
+	required bool
+}
+
+// jsonFields returns the fields of the JSON encoding of the struct, the fields of the embedded structs are promoted.
+// A field without omitempty is always encoded.
+func jsonFields(typ reflect.Type) []jsonField {
+	var fields []jsonField
+
+	for _, field := range reflect.VisibleFields(typ) {
+		tag, ok := field.Tag.Lookup("json")
+		if !ok || tag == "-" || !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+		fields = append(fields, jsonField{name: name, typ: jsonType(field.Type), required: !strings.Contains(options, "omitempty")})
+	}
+
+	return fields
+}
+
+// jsonType returns the JSON schema type of the encoding of the Go type.
+func jsonType(typ reflect.Type) string {
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	switch {
+	case typ == reflect.TypeFor[time.Time]():
+		return openapi3.TypeString
+	case typ.Kind() == reflect.String:
+		return openapi3.TypeString
+	case typ.Kind() == reflect.Bool:
+		return openapi3.TypeBoolean
+	case typ.Kind() >= reflect.Int && typ.Kind() <= reflect.Uint64:
+		return openapi3.TypeInteger
+	case typ.Kind() == reflect.Slice:
+		return openapi3.TypeArray
+	default:
+		return openapi3.TypeObject
+	}
+}
+
+func TestOpenAPI_Schemas(t *testing.T) {
+	spec, err := loadSpec()
+	require.NoError(t, err)
+
+	// the schemas of the requests and of the responses, the required properties of a response are always encoded.
+	schemas := map[string]struct {
+		value    any
+		response bool
+	}{
+		"Problem":                {value: Problem{}, response: true},
+		"FieldError":             {value: FieldError{}, response: true},
+		"User":                   {value: User{}},
+		"Verification":           {value: Verification{}},
+		"VerificationResponse":   {value: VerificationResponse{}, response: true},
+		"TokenResponse":          {value: TokenResponse{}, response: true},
+		"Message":                {value: Message{}},
+		"MessageResponse":        {value: MessageResponse{}, response: true},
+		"ReactionResponse":       {value: ReactionResponse{}, response: true},
+		"QuoteResponse":          {value: QuoteResponse{}, response: true},
+		"AttachmentResponse":     {value: AttachmentResponse{}, response: true},
+		"NewChat":                {value: NewChat{}},
+		"ChatUpdate":             {value: ChatUpdate{}},
+		"NewParticipants":        {value: NewParticipants{}},
+		"ChatResponse":           {value: ChatResponse{}, response: true},
+		"ChatSummaryResponse":    {value: ChatSummaryResponse{}, response: true},
+		"MessagePreview":         {value: MessagePreview{}, response: true},
+		"ReadReceipt":            {value: ReadReceipt{}},
+		"ReceiptResponse":        {value: ReceiptResponse{}, response: true},
+		"MessageEdit":            {value: MessageEdit{}},
+		"MessageVersionResponse": {value: MessageVersionResponse{}, response: true},
+		"SearchResultResponse":   {value: SearchResultResponse{}, response: true},
+		"SnippetFragment":        {value: SnippetFragment{}, response: true},
+		"BlockResponse":          {value: BlockResponse{}, response: true},
+		"ChatSummaryPage":        {value: Page[ChatSummaryResponse]{}, response: true},
+		"MessagePage":            {value: Page[MessageResponse]{}, response: true},
+		"SearchResultPage":       {value: Page[SearchResultResponse]{}, response: true},
+	}
+
+	assert.ElementsMatch(t, slices.Collect(maps.Keys(schemas)), slices.Collect(maps.Keys(spec.Components.Schemas)))
+
+	for name, test := range schemas {
+		t.Run(name, func(t *testing.T) {
+			schema := spec.Components.Schemas[name]
+			require.NotNil(t, schema)
+
+			var properties, required []string
+
+			for _, field := range jsonFields(reflect.TypeOf(test.value)) {
+				properties = append(properties, field.name)
+
+				if field.required {
+					required = append(required, field.name)
+				}
+
+				if property := schema.Value.Properties[field.name]; assert.NotNil(t, property, field.name) {
+					assert.True(t, property.Value.Type.Is(field.typ), "%s is a %s", field.name, field.typ)
+				}
+			}
+
+			assert.ElementsMatch(t, properties, slices.Collect(maps.Keys(schema.Value.Properties)))
+
+			if test.response {
+				assert.ElementsMatch(t, required, schema.Value.Required)
+			}
+		})
+	}
+}
+
+func TestSpecValidator(t *testing.T) {
+	var handler http.HandlerFunc
+
+	validator, err := newSpecValidator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { handler(w, r) }))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		path    string
+		handler http.HandlerFunc
+		code    int
+	}{
+		{
+			name: "documented response",
+			path: "/blocks",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`[{"phoneNumber": "+33612345678", "createdAt": "2024-01-01T00:00:00Z"}]`))
+			},
+			code: http.StatusOK,
+		},
+		{
+			name: "documented error",
+			path: "/blocks",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				writeProblem(w, r, problemRateLimited, "too many requests")
+			},
+			code: http.StatusTooManyRequests,
+		},
+		{
+			name: "undocumented property",
+			path: "/blocks",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`[{"phoneNumber": "+33612345678", "createdAt": "2024-01-01T00:00:00Z", "name": "bob"}]`))
+			},
+			code: http.StatusInternalServerError,
+		},
+		{
+			name: "undocumented status",
+			path: "/blocks",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			code: http.StatusInternalServerError,
+		},
+		{
+			name: "undocumented media type",
+			path: "/blocks",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				http.Error(w, "not found", http.StatusNotFound)
+			},
+			code: http.StatusInternalServerError,
+		},
+		{
+			name: "undocumented route",
+			path: "/unknown",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+			code: http.StatusInternalServerError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler = test.handler
+
+			rr := httptest.NewRecorder()
+			validator.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			assert.Equal(t, test.code, rr.Code, rr.Body.String())
+		})
+	}
+}
+
+func TestDocsHandler(t *testing.T) {
+	rr := getTest(t, "/openapi.json", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.Equal(t, openAPISpec, rr.Body.Bytes())
+
+	rr = getTest(t, "/docs", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+
+	page := rr.Body.String()
+	for _, pattern := range routerPatterns(t) {
+		method, path, _ := strings.Cut(pattern, " ")
+		assert.Contains(t, page, `<span class="method">`+method+`</span> <code>`+path+`</code>`)
+	}
+}
+
+var (
+	// curlCommand matches a curl command of the README, its method and the path of its URL.
+	curlCommand = regexp.MustCompile(`curl .*?(?:-X (\w+) .*?)?"?http://localhost:8080(/[^\s"?]*)`)
+	// quotedRoute matches a route quoted in the README, like ` + "`GET /chats`" + `.
+	quotedRoute = regexp.MustCompile("`(GET|POST|PUT|PATCH|DELETE) (/[^`\\s?]*)`")
+	// brunoRequest matches the method and the path of the URL of a Bruno request.
+	brunoRequest = regexp.MustCompile(`(?m)^(get|post|put|patch|delete) \{\s+url: \{\{base_url\}\}(/[^\s?]*)`)
+)
+
+// TestOpenAPI_Documentation checks the routes documented in the README and in the Bruno collection are in the specification.
+func TestOpenAPI_Documentation(t *testing.T) {
+	spec, err := loadSpec()
+	require.NoError(t, err)
+
+	readme, err := os.ReadFile("../../README.md")
+	require.NoError(t, err)
+
+	var routes []string
+
+	// the options of a curl command can be split across lines.
+	scanner := bufio.NewScanner(strings.NewReader(strings.ReplaceAll(string(readme), "\\\n", " ")))
+	for scanner.Scan() {
+		for _, match := range curlCommand.FindAllStringSubmatch(scanner.Text(), -1) {
+			routes = append(routes, cmp.Or(match[1], http.MethodGet)+" "+match[2])
+		}
+
+		for _, match := range quotedRoute.FindAllStringSubmatch(scanner.Text(), -1) {
+			routes = append(routes, match[1]+" "+match[2])
+		}
+	}
+
+	requests, err := filepath.Glob("../../docs/bruno/mychat/*.bru")
+	require.NoError(t, err)
+
+	for _, request := range requests {
+		content, err := os.ReadFile(request)
+		require.NoError(t, err)
+
+		match := brunoRequest.FindStringSubmatch(string(content))
+		require.NotNil(t, match, request)
+
+		routes = append(routes, strings.ToUpper(match[1])+" "+match[2])
+	}
+
+	require.NotEmpty(t, routes)
+
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route, " ")
+
+		found, _ := findRoute(spec, method, path)
+		assert.NotNil(t, found, "%s is not in the OpenAPI specification", route)
+	}
+}
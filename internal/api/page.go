@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 100
+)
+
+var (
+	errInvalidLimit   = newFieldError("limit", fmt.Sprintf("limit must be an integer between 1 and %d", maxPageLimit))
+	errInvalidCursor  = errors.New("invalid cursor")
+	errBeforeAndAfter = newFieldError("after", "before and after cannot be used together")
+)
+
+// Page is a page of a list.
+// NextCursor is set when there are more items,
+// it must be given to the same before or after parameter to get the next page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// pageRequest is the requested page of a list:
+// the items before or after a cursor, the latest items otherwise.
+type pageRequest struct {
+	limit  int
+	before string
+	after  string
+}
+
+// parsePageRequest parses the limit, before and after query parameters.
+func parsePageRequest(r *http.Request) (pageRequest, error) {
+	query := r.URL.Query()
+	page := pageRequest{
+		limit:  defaultPageLimit,
+		before: query.Get("before"),
+		after:  query.Get("after"),
+	}
+
+	if page.before != "" && page.after != "" {
+		return pageRequest{}, errBeforeAndAfter
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+
+		page.limit, err = strconv.Atoi(limit)
+		if err != nil || page.limit < 1 || page.limit > maxPageLimit {
+			return pageRequest{}, errInvalidLimit
+		}
+	}
+
+	return page, nil
+}
+
+// forward reports whether the page holds the items right after the cursor.
+func (p pageRequest) forward() bool {
+	return p.after != ""
+}
+
+// newPage builds a page from the items fetched with one more item than the page limit.
+// This extra item is the farthest from the cursor, it tells there is a next page.
+// When farthestFirst is true, the extra item is the first one, otherwise the last one.
+// The next cursor is the position of the last item of the page, the nearest to the extra item.
+func newPage[T, R any](items []T, limit int, farthestFirst bool, position func(T) any, convert func(T) R) Page[R] {
+	var next string
+
+	if len(items) > limit {
+		if farthestFirst {
+			items = items[len(items)-limit:]
+			next = encodeCursor(position(items[0]))
+		} else {
+			items = items[:limit]
+			next = encodeCursor(position(items[limit-1]))
+		}
+	}
+
+	result := make([]R, 0, len(items))
+	for _, item := range items {
+		result = append(result, convert(item))
+	}
+
+	return Page[R]{Items: result, NextCursor: next}
+}
+
+// encodeCursor encodes a position as an opaque cursor.
+func encodeCursor(position any) string {
+	data, _ := json.Marshal(position)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor decodes an opaque cursor in the position.
+func decodeCursor(s string, position any) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("failed to decode cursor: %w", err)
+	}
+
+	if err = json.Unmarshal(data, position); err != nil {
+		return fmt.Errorf("failed to decode cursor: %w", err)
+	}
+
+	return nil
+}
+
+// chatCursor is the position of a chat in the chats of a user.
+type chatCursor struct {
+	LastActivityAt time.Time `json:"lastActivityAt"`
+	ID             string    `json:"id"`
+}
+
+func chatPosition(chat repo.UserChat) any {
+	return chatCursor{LastActivityAt: chat.LastActivityAt(), ID: chat.ID}
+}
+
+func parseChatCursor(s string) (*repo.ChatPosition, error) {
+	var cursor chatCursor
+	if err := decodeCursor(s, &cursor); err != nil || cursor.ID == "" {
+		return nil, errInvalidCursor
+	}
+
+	return &repo.ChatPosition{LastActivityAt: cursor.LastActivityAt, ID: cursor.ID}, nil
+}
+
+// messageCursor is the position of a message in its chat.
+type messageCursor struct {
+	Seq int64 `json:"seq"`
+}
+
+func messagePosition(message repo.Message) any {
+	return messageCursor{Seq: message.Seq}
+}
+
+func parseMessageCursor(s string) (int64, error) {
+	var cursor messageCursor
+	if err := decodeCursor(s, &cursor); err != nil || cursor.Seq < 1 {
+		return 0, errInvalidCursor
+	}
+
+	return cursor.Seq, nil
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// PhoneNormalizer validates the phone numbers and normalizes them to the E.164 format.
+type PhoneNormalizer interface {
+	Normalize(phoneNumber string) (string, error)
+}
+
+// normalizePhoneNumber normalizes the phone number given in the field,
+// writing a bad request error naming the field if it is invalid.
+func normalizePhoneNumber(
+	w http.ResponseWriter,
+	r *http.Request,
+	phones PhoneNormalizer,
+	field, phoneNumber string,
+	logger *slog.Logger,
+) (string, bool) {
+	normalized, err := phones.Normalize(phoneNumber)
+	if err != nil {
+		logger.ErrorContext(r.Context(),
+			"invalid phone number",
+			slog.String(field, phoneNumber),
+			slog.String("error", err.Error()),
+		)
+		writeError(w, r, wrapFieldError(field, err), "invalid phone number")
+
+		return "", false
+	}
+
+	return normalized, true
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+const (
+	// ProblemContentType is the content type of the error responses.
+	ProblemContentType = "application/problem+json"
+	// ProblemTypePrefix prefixes the code of a problem in its type URI.
+	ProblemTypePrefix = "urn:mychat:problem:"
+)
+
+// Problem is an error response, a problem details object as defined by RFC 9457.
+// Its code identifies the kind of problem for the clients, it does not change between versions.
+// This is the response format for the API.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+	// Errors are the invalid fields of the request.
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is an invalid field of a request, the field is a JSON field or a parameter of the request.
+type FieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// problemType is a kind of problem, with its stable code and its default status.
+type problemType struct {
+	code   string
+	status int
+	title  string
+}
+
+// The problem types of the API.
+var (
+	problemInvalidInput          = problemType{"invalid_input", http.StatusBadRequest, "Invalid input"}
+	problemValidationFailed      = problemType{"validation_failed", http.StatusBadRequest, "Validation failed"}
+	problemSenderNotRegistered   = problemType{"sender_not_registered", http.StatusBadRequest, "Sender not registered"}
+	problemInvalidCode           = problemType{"invalid_verification_code", http.StatusBadRequest, "Invalid verification code"}
+	problemCodeExpired           = problemType{"verification_code_expired", http.StatusBadRequest, "Verification code expired"}
+	problemAuthenticationMissing = problemType{"authentication_required", http.StatusUnauthorized, "Authentication required"}
+	problemInvalidToken          = problemType{"invalid_token", http.StatusUnauthorized, "Invalid access token"}
+	problemTokenExpired          = problemType{"token_expired", http.StatusUnauthorized, "Access token expired"}
+	problemInvalidCredentials    = problemType{"invalid_credentials", http.StatusUnauthorized, "Invalid credentials"}
+	problemNotParticipant        = problemType{"not_participant", http.StatusForbidden, "Not a participant of the chat"}
+	problemNotAdmin              = problemType{"not_admin", http.StatusForbidden, "Not an admin of the chat"}
+	problemNotSender             = problemType{"not_sender", http.StatusForbidden, "Not the sender of the message"}
+	problemSystemMessage         = problemType{"system_message", http.StatusForbidden, "System message"}
+	problemEditWindowExpired     = problemType{"edit_window_expired", http.StatusForbidden, "Edit window expired"}
+	problemReceiverBlocked       = problemType{"receiver_blocked", http.StatusForbidden, "Receiver blocked"}
+	problemChatNotFound          = problemType{"chat_not_found", http.StatusNotFound, "Chat not found"}
+	problemMessageNotFound       = problemType{"message_not_found", http.StatusNotFound, "Message not found"}
+	problemParticipantNotFound   = problemType{"participant_not_found", http.StatusNotFound, "Participant not found"}
+	problemAttachmentNotFound    = problemType{"attachment_not_found", http.StatusNotFound, "Attachment not found"}
+	problemThumbnailNotFound     = problemType{"thumbnail_not_found", http.StatusNotFound, "Thumbnail not found"}
+	problemPhoneNotRegistered    = problemType{"phone_number_not_registered", http.StatusNotFound, "Phone number not registered"}
+	problemBlockNotFound         = problemType{"block_not_found", http.StatusNotFound, "User not blocked"}
+	problemPhoneRegistered       = problemType{"phone_number_already_registered", http.StatusConflict, "Phone number already registered"}
+	problemNotGroupChat          = problemType{"not_group_chat", http.StatusConflict, "Not a group chat"}
+	problemLastAdmin             = problemType{"last_admin", http.StatusConflict, "Last admin of the chat"}
+	problemMessageDeleted        = problemType{"message_deleted", http.StatusConflict, "Message deleted"}
+	problemAttachmentInUse       = problemType{"attachment_in_use", http.StatusConflict, "Attachment in use"}
+	problemRequestInProgress     = problemType{"request_in_progress", http.StatusConflict, "Request in progress"}
+	problemResponseWithheld      = problemType{"response_withheld", http.StatusConflict, "Response withheld"}
+	problemResyncRequired        = problemType{"resync_required", http.StatusGone, "Resync required"}
+	problemContentTooLarge       = problemType{"content_too_large", http.StatusRequestEntityTooLarge, "Content too large"}
+	problemIdempotencyKeyReused  = problemType{"idempotency_key_reused", http.StatusUnprocessableEntity, "Idempotency key reused"}
+	problemRateLimited           = problemType{"rate_limited", http.StatusTooManyRequests, "Too many requests"}
+	problemCodeSentTooRecently   = problemType{"verification_code_sent_too_recently", http.StatusTooManyRequests, "Verification code sent too recently"}
+	problemTooManyAttempts       = problemType{"too_many_verification_attempts", http.StatusTooManyRequests, "Too many verification attempts"}
+	problemInternal              = problemType{"internal_error", http.StatusInternalServerError, "Internal server error"}
+	problemUnavailable           = problemType{"unavailable", http.StatusServiceUnavailable, "Service unavailable"}
+)
+
+// errorProblems maps the errors of the handlers and of the repositories to their problem type,
+// the first matching error gives the problem.
+var errorProblems = []struct {
+	err     error
+	problem problemType
+}{
+	{repo.ErrPhoneNumberAlreadyRegistered, problemPhoneRegistered},
+	{repo.ErrChatNotFound, problemChatNotFound},
+	{repo.ErrNotGroupChat, problemNotGroupChat},
+	{repo.ErrNotParticipant, problemNotParticipant},
+	{repo.ErrLastAdmin, problemLastAdmin},
+	{repo.ErrMessageNotFound, problemMessageNotFound},
+	{repo.ErrMessageDeleted, problemMessageDeleted},
+	{repo.ErrAttachmentNotFound, problemAttachmentNotFound},
+	{repo.ErrAttachmentInUse, problemAttachmentInUse},
+	{repo.ErrBlockNotFound, problemBlockNotFound},
+	{errSenderNotRegistered, problemSenderNotRegistered},
+	{errChatNotFound, problemChatNotFound},
+	{errNotParticipant, problemNotParticipant},
+	{errParticipantNotFound, problemParticipantNotFound},
+	{errNotSender, problemNotSender},
+	{errSystemMessage, problemSystemMessage},
+	{errEditWindowExpired, problemEditWindowExpired},
+	{errReceiverBlocked, problemReceiverBlocked},
+	{errBlockNotRegistered, problemPhoneNotRegistered},
+	{errBlockNotFound, problemBlockNotFound},
+	{errIdempotencyKeyReused, problemIdempotencyKeyReused},
+	{errIdempotencyKeyInProgress, problemRequestInProgress},
+	{errIdempotencyResponseWithheld, problemResponseWithheld},
+	{errResyncRequired, problemResyncRequired},
+}
+
+// problemOf returns the problem type of the error,
+// a field error is a validation failure and an unexpected error an internal error.
+func problemOf(err error) problemType {
+	for _, mapping := range errorProblems {
+		if errors.Is(err, mapping.err) {
+			return mapping.problem
+		}
+	}
+
+	var invalid *fieldError
+	if errors.As(err, &invalid) {
+		return problemValidationFailed
+	}
+
+	return problemInternal
+}
+
+// fieldError is an invalid field of a request, its message names the field.
+type fieldError struct {
+	field string
+	err   error
+}
+
+// newFieldError returns the error of the field with the message, which names the field.
+func newFieldError(field, message string) error {
+	return &fieldError{field: field, err: errors.New(message)}
+}
+
+// wrapFieldError wraps the error of the field, its message is prefixed by the field.
+func wrapFieldError(field string, err error) error {
+	return &fieldError{field: field, err: fmt.Errorf("%s: %w", field, err)}
+}
+
+func (e *fieldError) Error() string {
+	return e.err.Error()
+}
+
+func (e *fieldError) Unwrap() error {
+	return e.err
+}
+
+// writeError writes the problem of the error, with its message as detail and its invalid field if any.
+// The message of an unexpected error is not revealed, the fallback detail is written instead.
+func writeError(w http.ResponseWriter, r *http.Request, err error, fallback string) {
+	problem := problemOf(err)
+	if problem == problemInternal {
+		writeProblem(w, r, problem, fallback)
+
+		return
+	}
+
+	var invalid *fieldError
+	if !errors.As(err, &invalid) {
+		writeProblem(w, r, problem, err.Error())
+
+		return
+	}
+
+	writeProblem(w, r, problem, err.Error(), FieldError{Field: invalid.field, Detail: invalid.err.Error()})
+}
+
+// writeProblem writes a problem of the type with its default status, see writeProblemStatus.
+func writeProblem(w http.ResponseWriter, r *http.Request, problem problemType, detail string, fields ...FieldError) {
+	writeProblemStatus(w, r, problem, problem.status, detail, fields...)
+}
+
+// writeProblemStatus writes a problem of the type with the status, the detail explains this occurrence of the problem.
+// Like http.Error, the headers of the content set for a successful response are removed.
+func writeProblemStatus(
+	w http.ResponseWriter,
+	r *http.Request,
+	problem problemType,
+	status int,
+	detail string,
+	fields ...FieldError,
+) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	h.Set("Content-Type", ProblemContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(Problem{
+		Type:     ProblemTypePrefix + problem.code,
+		Title:    problem.title,
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     problem.code,
+		Errors:   fields,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to write problem", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// decodeProblem decodes the problem of the error response.
+func decodeProblem(t *testing.T, rr *httptest.ResponseRecorder) Problem {
+	t.Helper()
+
+	require.Equal(t, ProblemContentType, rr.Header().Get("Content-Type"), rr.Body.String())
+
+	var problem Problem
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+	assert.Equal(t, rr.Code, problem.Status)
+
+	return problem
+}
+
+func TestProblemOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want problemType
+	}{
+		{name: "repository error", err: repo.ErrPhoneNumberAlreadyRegistered, want: problemPhoneRegistered},
+		{name: "wrapped repository error", err: fmt.Errorf("failed: %w", repo.ErrLastAdmin), want: problemLastAdmin},
+		{name: "handler error", err: errReceiverBlocked, want: problemReceiverBlocked},
+		{name: "field error", err: errContentRequired, want: problemValidationFailed},
+		{name: "wrapped field error", err: wrapFieldError("receiver", errors.New("too short")), want: problemValidationFailed},
+		{name: "unexpected error", err: errors.New("boom"), want: problemInternal},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, problemOf(test.err))
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Problem
+	}{
+		{
+			name: "sentinel error",
+			err:  repo.ErrChatNotFound,
+			want: Problem{
+				Type:     "urn:mychat:problem:chat_not_found",
+				Title:    "Chat not found",
+				Status:   http.StatusNotFound,
+				Detail:   "chat not found",
+				Instance: "/chats/1",
+				Code:     "chat_not_found",
+			},
+		},
+		{
+			name: "field error",
+			err:  wrapFieldError("members[1]", errors.New("invalid phone number")),
+			want: Problem{
+				Type:     "urn:mychat:problem:validation_failed",
+				Title:    "Validation failed",
+				Status:   http.StatusBadRequest,
+				Detail:   "members[1]: invalid phone number",
+				Instance: "/chats/1",
+				Code:     "validation_failed",
+				Errors:   []FieldError{{Field: "members[1]", Detail: "members[1]: invalid phone number"}},
+			},
+		},
+		{
+			name: "unexpected error",
+			err:  errors.New("connection refused"),
+			want: Problem{
+				Type:     "urn:mychat:problem:internal_error",
+				Title:    "Internal server error",
+				Status:   http.StatusInternalServerError,
+				Detail:   "failed to get chat",
+				Instance: "/chats/1",
+				Code:     "internal_error",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			rr.Header().Set("Content-Length", "42")
+
+			writeError(rr, httptest.NewRequest(http.MethodGet, "/chats/1", nil), test.err, "failed to get chat")
+
+			assert.Equal(t, test.want.Status, rr.Code)
+			assert.Empty(t, rr.Header().Get("Content-Length"))
+			assert.Equal(t, test.want, decodeProblem(t, rr))
+		})
+	}
+}
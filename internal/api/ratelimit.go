@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/auth"
+	"github.com/jbdoumenjou/mychat/internal/ratelimit"
+)
+
+// RateLimitStore keeps the token buckets of the clients,
+// a shared store applies the limits across several instances.
+type RateLimitStore interface {
+	// Take takes a token from the bucket of the key, filled according to the limit.
+	Take(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error)
+}
+
+// DefaultRouteLimit is the key of the limit of the routes without their own limit in the rate limits.
+const DefaultRouteLimit = ""
+
+// DefaultRateLimits are the default limits of the requests of a client, per route pattern.
+// The registration and login routes, sending codes by SMS and checking them, are the most limited.
+var DefaultRateLimits = map[string]ratelimit.Limit{
+	DefaultRouteLimit:       {Requests: 600, Period: time.Minute},
+	"POST /register/start":  {Requests: 5, Period: time.Hour},
+	"POST /register/verify": {Requests: 20, Period: time.Hour},
+	"POST /login/start":     {Requests: 5, Period: time.Hour},
+	"POST /login":           {Requests: 20, Period: time.Hour},
+	"POST /messages":        {Requests: 60, Period: time.Minute},
+	"POST /attachments":     {Requests: 30, Period: time.Minute},
+}
+
+// RateLimiter limits the rate of the requests of each client per route, with token buckets.
+// A client is the authenticated user, or the IP address of an anonymous request.
+type RateLimiter struct {
+	store RateLimitStore
+	// limits are the limits per route pattern, the DefaultRouteLimit one applies to the other routes.
+	// The routes without limit are not limited.
+	limits map[string]ratelimit.Limit
+	// trustProxy takes the IP address of the client from the X-Forwarded-For header, set by a reverse proxy.
+	trustProxy bool
+
+	logger *slog.Logger
+}
+
+// NewRateLimiter creates a new RateLimiter keeping the buckets in the store.
+func NewRateLimiter(store RateLimitStore, limits map[string]ratelimit.Limit, trustProxy bool) *RateLimiter {
+	logger := slog.With(slog.String("middleware", "ratelimit"))
+	logger.Info("created middleware")
+
+	return &RateLimiter{
+		store:      store,
+		limits:     limits,
+		trustProxy: trustProxy,
+		logger:     logger,
+	}
+}
+
+// Limit limits the requests of the clients to the routes of the mux, it must run after the authentication.
+// Every limited response has RateLimit-* headers, and a request over the limit is rejected
+// with 429 Too Many Requests and a Retry-After header.
+// When the store fails, the request is served.
+func (l *RateLimiter) Limit(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+
+		pattern, limit, ok := l.routeLimit(pattern)
+		if !ok {
+			mux.ServeHTTP(w, r)
+
+			return
+		}
+
+		result, err := l.store.Take(r.Context(), pattern+" "+client(r, l.trustProxy), limit)
+		if err != nil {
+			l.logger.ErrorContext(r.Context(), "failed to take a token", slog.String("error", err.Error()))
+			mux.ServeHTTP(w, r)
+
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		w.Header().Set("RateLimit-Policy", strconv.Itoa(limit.Requests)+";w="+strconv.Itoa(ceilSeconds(limit.Period)))
+
+		if !result.Allowed {
+			l.logger.DebugContext(r.Context(), "rate limit exceeded", slog.String("route", pattern))
+			w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(result.RetryAfter), 1)))
+			writeProblem(w, r, problemRateLimited, "too many requests")
+
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// allow takes a token from the bucket of the user for the route, for a request not sent over HTTP,
+// like a message sent over a WebSocket, it shares the limit of the route with the HTTP requests.
+// It reports whether the request is allowed, a request to a route without limit or when the store fails is allowed.
+func (l *RateLimiter) allow(ctx context.Context, pattern, user string) bool {
+	pattern, limit, ok := l.routeLimit(pattern)
+	if !ok {
+		return true
+	}
+
+	result, err := l.store.Take(ctx, pattern+" user:"+user, limit)
+	if err != nil {
+		l.logger.ErrorContext(ctx, "failed to take a token", slog.String("error", err.Error()))
+
+		return true
+	}
+
+	if !result.Allowed {
+		l.logger.DebugContext(ctx, "rate limit exceeded", slog.String("route", pattern))
+	}
+
+	return result.Allowed
+}
+
+// routeLimit returns the limit of the route pattern, or the DefaultRouteLimit one with its key.
+// It reports whether the route is limited.
+func (l *RateLimiter) routeLimit(pattern string) (string, ratelimit.Limit, bool) {
+	if limit, ok := l.limits[pattern]; ok {
+		return pattern, limit, true
+	}
+
+	limit, ok := l.limits[DefaultRouteLimit]
+
+	return DefaultRouteLimit, limit, ok
+}
+
+// client returns the key of the client of the request, its authenticated user or its IP address.
+func client(r *http.Request, trustProxy bool) string {
+	if user, ok := auth.UserFromContext(r.Context()); ok {
+		return "user:" + user
+	}
+
+	return "ip:" + clientIP(r, trustProxy)
+}
+
+// clientIP returns the IP address of the client of the request.
+// Behind a trusted reverse proxy, it is the last address of the X-Forwarded-For header, added by the proxy.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			last := forwarded[len(forwarded)-1]
+			if ip := strings.TrimSpace(last[strings.LastIndex(last, ",")+1:]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// ceilSeconds returns the number of seconds of the duration, rounded up.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/auth"
+	"github.com/jbdoumenjou/mychat/internal/ratelimit"
+)
+
+// failingStore is a rate limit store always failing.
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("store unavailable")
+}
+
+// newTestLimited returns a handler limiting the requests to a mux with a limited route and a default one.
+func newTestLimited(store RateLimitStore, trustProxy bool) http.Handler {
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	mux.HandleFunc("POST /limited", ok)
+	mux.HandleFunc("GET /default", ok)
+
+	limiter := NewRateLimiter(store, map[string]ratelimit.Limit{
+		DefaultRouteLimit: {Requests: 5, Period: time.Hour},
+		"POST /limited":   {Requests: 2, Period: time.Hour},
+	}, trustProxy)
+
+	return limiter.Limit(mux)
+}
+
+// limitedTest serves a request of the user, or of the remote address if the user is empty.
+func limitedTest(t *testing.T, handler http.Handler, method, target, user, remoteAddr string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), method, target, nil)
+	require.NoError(t, err)
+
+	if user != "" {
+		req = req.WithContext(auth.WithUser(req.Context(), user))
+	}
+
+	req.RemoteAddr = remoteAddr
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestRateLimiter_Limit(t *testing.T) {
+	handler := newTestLimited(ratelimit.NewMemoryStore(), false)
+
+	for _, remaining := range []string{"1", "0"} {
+		rr := limitedTest(t, handler, http.MethodPost, "/limited", "", "192.0.2.1:1234")
+		require.Equal(t, http.StatusNoContent, rr.Code)
+		assert.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, remaining, rr.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "2;w=3600", rr.Header().Get("RateLimit-Policy"))
+		assert.NotEmpty(t, rr.Header().Get("RateLimit-Reset"))
+		assert.Empty(t, rr.Header().Get("Retry-After"))
+	}
+
+	// a token is refilled every 30 minutes.
+	rr := limitedTest(t, handler, http.MethodPost, "/limited", "", "192.0.2.1:5678")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	problem := decodeProblem(t, rr)
+	assert.Equal(t, "rate_limited", problem.Code)
+	assert.Equal(t, "too many requests", problem.Detail)
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "3600", rr.Header().Get("RateLimit-Reset"))
+
+	retryAfter, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.InDelta(t, 1800, retryAfter, 1)
+
+	// the other clients and the other routes have their own limits.
+	rr = limitedTest(t, handler, http.MethodPost, "/limited", "", "192.0.2.2:1234")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	rr = limitedTest(t, handler, http.MethodPost, "/limited", "+33612345678", "192.0.2.1:1234")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	rr = limitedTest(t, handler, http.MethodGet, "/default", "", "192.0.2.1:1234")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "5", rr.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "4", rr.Header().Get("RateLimit-Remaining"))
+
+	// the unknown routes share the default limit.
+	rr = limitedTest(t, handler, http.MethodGet, "/unknown", "", "192.0.2.1:1234")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "5", rr.Header().Get("RateLimit-Limit"))
+}
+
+func TestRateLimiter_Limit_Proxy(t *testing.T) {
+	handler := newTestLimited(ratelimit.NewMemoryStore(), true)
+
+	forwardedTest := func(forwardedFor string) int {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "/limited", nil)
+		require.NoError(t, err)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	// the client is the last address, added by the proxy, the other ones can be forged.
+	assert.Equal(t, http.StatusNoContent, forwardedTest("203.0.113.1"))
+	assert.Equal(t, http.StatusNoContent, forwardedTest("198.51.100.1, 203.0.113.1"))
+	assert.Equal(t, http.StatusTooManyRequests, forwardedTest("198.51.100.2, 203.0.113.1"))
+	assert.Equal(t, http.StatusNoContent, forwardedTest("203.0.113.2"))
+}
+
+func TestRateLimiter_Limit_StoreFailure(t *testing.T) {
+	handler := newTestLimited(failingStore{}, false)
+
+	// the requests are served without limit.
+	for range 3 {
+		rr := limitedTest(t, handler, http.MethodPost, "/limited", "", "192.0.2.1:1234")
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		assert.Empty(t, rr.Header().Get("RateLimit-Limit"))
+	}
+}
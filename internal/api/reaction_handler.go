@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// ReactionHandler is the handler for the emoji reactions of the participants to the messages of a chat.
+type ReactionHandler struct {
+	chatRepo    ReactionChatRepo
+	messageRepo ReactionMessageRepo
+
+	logger *slog.Logger
+}
+
+// ReactionChatRepo defines the chat repository.
+type ReactionChatRepo interface {
+	GetChat(chatID string) (repo.Chat, error)
+}
+
+// ReactionMessageRepo defines the message repository.
+type ReactionMessageRepo interface {
+	GetChatMessage(chatID, messageID string) (repo.Message, error)
+	AddReaction(chatID, messageID, user, emoji string) error
+	RemoveReaction(chatID, messageID, user, emoji string) error
+	GetReactions(chatID string, messageIDs []string) ([]repo.Reaction, error)
+}
+
+// NewReactionHandler creates a new ReactionHandler.
+func NewReactionHandler(chatRepo ReactionChatRepo, messageRepo ReactionMessageRepo) *ReactionHandler {
+	logger := slog.With(slog.String("handler", "reaction"))
+	logger.Info("created handler")
+
+	return &ReactionHandler{
+		chatRepo:    chatRepo,
+		messageRepo: messageRepo,
+		logger:      logger,
+	}
+}
+
+// ReactionResponse represents the reactions to a message with an emoji.
+// This is the response format for the API.
+type ReactionResponse struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+	// Users are the participants who reacted with the emoji, in the order they reacted.
+	Users []string `json:"users"`
+}
+
+// newReactionResponses aggregates the reactions per message, then per emoji,
+// the emojis are sorted by their first reaction.
+func newReactionResponses(reactions []repo.Reaction) map[string][]ReactionResponse {
+	responses := make(map[string][]ReactionResponse)
+
+	for _, reaction := range reactions {
+		messageReactions := responses[reaction.MessageID]
+
+		i := 0
+		for i < len(messageReactions) && messageReactions[i].Emoji != reaction.Emoji {
+			i++
+		}
+
+		if i == len(messageReactions) {
+			messageReactions = append(messageReactions, ReactionResponse{Emoji: reaction.Emoji})
+		}
+
+		messageReactions[i].Count++
+		messageReactions[i].Users = append(messageReactions[i].Users, reaction.User)
+
+		responses[reaction.MessageID] = messageReactions
+	}
+
+	return responses
+}
+
+var errInvalidEmoji = newFieldError("emoji", "emoji must be a single emoji")
+
+// AddReaction adds the reaction of the authenticated user with the emoji to a message of the chat.
+// A user reacts once with an emoji, adding the reaction again changes nothing.
+func (h *ReactionHandler) AddReaction(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler add a reaction", slog.String("path", r.URL.Path))
+
+	h.react(w, r, h.messageRepo.AddReaction)
+}
+
+// RemoveReaction removes the reaction of the authenticated user with the emoji from a message of the chat.
+// Removing a missing reaction changes nothing.
+func (h *ReactionHandler) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler remove a reaction", slog.String("path", r.URL.Path))
+
+	h.react(w, r, h.messageRepo.RemoveReaction)
+}
+
+// react applies the change of the reaction of the authenticated user to the message,
+// and writes the message with its reactions.
+func (h *ReactionHandler) react(w http.ResponseWriter, r *http.Request, change func(chatID, messageID, user, emoji string) error) {
+	chat, ok := getParticipantChat(w, r, h.chatRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	emoji := r.PathValue("emoji")
+	if !validEmoji(emoji) {
+		writeError(w, r, errInvalidEmoji, "invalid emoji")
+
+		return
+	}
+
+	message, err := h.messageRepo.GetChatMessage(chat.ID, r.PathValue("messageId"))
+	if err == nil && !message.VisibleTo(currentUser(r)) {
+		err = repo.ErrMessageNotFound
+	}
+
+	if err != nil {
+		writeMessageError(w, r, err, "failed to get chat message", h.logger)
+
+		return
+	}
+
+	if err = change(chat.ID, message.ID, currentUser(r), emoji); err != nil {
+		writeMessageError(w, r, err, "failed to change reaction", h.logger)
+
+		return
+	}
+
+	reactions, err := h.messageRepo.GetReactions(chat.ID, []string{message.ID})
+	if err != nil {
+		writeMessageError(w, r, err, "failed to get reactions", h.logger)
+
+		return
+	}
+
+	response := newMessageResponse(message)
+	response.Reactions = newReactionResponses(reactions)[message.ID]
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to write response", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReactionHandler_Reactions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 2)
+	sender, receiver := users[0], users[1]
+
+	message := sendTestMessage(ctx, t, sender, receiver, "Hello")
+	reactions := "/chats/" + message.ChatID + "/messages/" + message.ID + "/reactions/"
+
+	// a flag and a skin-toned emoji are made of several code points, but displayed as a single character.
+	for _, reaction := range []struct{ user, emoji string }{
+		{receiver, "👍"},
+		{sender, "🇫🇷"},
+		{sender, "👍"},
+		{receiver, "👍🏽"},
+		// a user reacts once with an emoji.
+		{receiver, "👍"},
+	} {
+		rr := requestTest(ctx, t, http.MethodPut, reactions+url.PathEscape(reaction.emoji), "", reaction.user)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	rr := requestTest(ctx, t, http.MethodPut, reactions+url.PathEscape("🎉"), "", sender)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var reacted MessageResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&reacted))
+	assert.Equal(t, message.ID, reacted.ID)
+
+	expected := []ReactionResponse{
+		{Emoji: "👍", Count: 2, Users: []string{receiver, sender}},
+		{Emoji: "🇫🇷", Count: 1, Users: []string{sender}},
+		{Emoji: "👍🏽", Count: 1, Users: []string{receiver}},
+		{Emoji: "🎉", Count: 1, Users: []string{sender}},
+	}
+	assert.Equal(t, expected, reacted.Reactions)
+
+	// the reactions are listed with the messages.
+	page := getTestPage[MessageResponse](t, "/chats/"+message.ChatID+"/messages", receiver)
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, expected, page.Items[0].Reactions)
+
+	rr = requestTest(ctx, t, http.MethodDelete, reactions+url.PathEscape("🎉"), "", sender)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	// removing a missing reaction changes nothing.
+	rr = requestTest(ctx, t, http.MethodDelete, reactions+url.PathEscape("👍"), "", receiver)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	rr = requestTest(ctx, t, http.MethodDelete, reactions+url.PathEscape("👍"), "", receiver)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var removed MessageResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&removed))
+	// the emojis are sorted by their first remaining reaction.
+	assert.Equal(t, []ReactionResponse{
+		{Emoji: "🇫🇷", Count: 1, Users: []string{sender}},
+		{Emoji: "👍", Count: 1, Users: []string{sender}},
+		{Emoji: "👍🏽", Count: 1, Users: []string{receiver}},
+	}, removed.Reactions)
+
+	got := getTest(t, "/chats/"+message.ChatID+"/messages/"+message.ID, receiver)
+	require.Equal(t, http.StatusOK, got.Code)
+
+	var single MessageResponse
+	require.NoError(t, json.NewDecoder(got.Body).Decode(&single))
+	assert.Equal(t, removed.Reactions, single.Reactions)
+
+	// the reactions of a deleted message are erased.
+	rr = requestTest(ctx, t, http.MethodDelete, "/chats/"+message.ChatID+"/messages/"+message.ID, "", sender)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+
+	page = getTestPage[MessageResponse](t, "/chats/"+message.ChatID+"/messages", receiver)
+	require.Len(t, page.Items, 1)
+	assert.Empty(t, page.Items[0].Reactions)
+
+	rr = requestTest(ctx, t, http.MethodPut, reactions+url.PathEscape("👍"), "", receiver)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Equal(t, "message is deleted", decodeProblem(t, rr).Detail)
+}
+
+func TestReactionHandler_Errors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 3)
+	sender, receiver, outsider := users[0], users[1], users[2]
+
+	message := sendTestMessage(ctx, t, sender, receiver, "Hello")
+	reactions := "/chats/" + message.ChatID + "/messages/" + message.ID + "/reactions/"
+
+	testCases := []struct {
+		desc     string
+		method   string
+		target   string
+		user     string
+		expected int
+		message  string
+	}{
+		{
+			desc:     "unauthenticated",
+			method:   http.MethodPut,
+			target:   reactions + url.PathEscape("👍"),
+			expected: http.StatusUnauthorized,
+		},
+		{
+			desc:     "unknown chat",
+			method:   http.MethodPut,
+			target:   "/chats/unknown/messages/" + message.ID + "/reactions/" + url.PathEscape("👍"),
+			user:     sender,
+			expected: http.StatusNotFound,
+			message:  "chat not found",
+		},
+		{
+			desc:     "not a participant",
+			method:   http.MethodPut,
+			target:   reactions + url.PathEscape("👍"),
+			user:     outsider,
+			expected: http.StatusForbidden,
+			message:  "user is not a participant of the chat",
+		},
+		{
+			desc:     "unknown message",
+			method:   http.MethodDelete,
+			target:   "/chats/" + message.ChatID + "/messages/unknown/reactions/" + url.PathEscape("👍"),
+			user:     sender,
+			expected: http.StatusNotFound,
+			message:  "message not found",
+		},
+		{
+			desc:     "several emojis",
+			method:   http.MethodPut,
+			target:   reactions + url.PathEscape("👍👍"),
+			user:     sender,
+			expected: http.StatusBadRequest,
+			message:  "emoji must be a single emoji",
+		},
+		{
+			desc:     "several characters",
+			method:   http.MethodDelete,
+			target:   reactions + "ok",
+			user:     sender,
+			expected: http.StatusBadRequest,
+			message:  "emoji must be a single emoji",
+		},
+		{
+			desc:     "letter",
+			method:   http.MethodPut,
+			target:   reactions + "a",
+			user:     sender,
+			expected: http.StatusBadRequest,
+			message:  "emoji must be a single emoji",
+		},
+		{
+			desc:     "digit",
+			method:   http.MethodPut,
+			target:   reactions + "1",
+			user:     sender,
+			expected: http.StatusBadRequest,
+			message:  "emoji must be a single emoji",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			rr := requestTest(ctx, t, test.method, test.target, "", test.user)
+			assert.Equal(t, test.expected, rr.Code)
+
+			if test.message != "" {
+				assert.Equal(t, test.message, decodeProblem(t, rr).Detail)
+			}
+		})
+	}
+
+	// the failed requests left the message without reactions.
+	got := getTestPage[MessageResponse](t, "/chats/"+message.ChatID+"/messages", sender).Items[0]
+	assert.Empty(t, got.Reactions)
+}
+
+func TestValidEmoji(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		emoji    string
+		expected bool
+	}{
+		{desc: "emoji", emoji: "👍", expected: true},
+		{desc: "skin-toned emoji", emoji: "👍🏽", expected: true},
+		{desc: "text emoji with a variation selector", emoji: "❤️", expected: true},
+		{desc: "kiss with skin tones", emoji: "👩🏻\u200d❤️\u200d💋\u200d👨🏼", expected: true},
+		{desc: "flag", emoji: "🇫🇷", expected: true},
+		{desc: "subdivision flag", emoji: "🏴\U000E0067\U000E0062\U000E0073\U000E0063\U000E0074\U000E007F", expected: true},
+		{desc: "keycap", emoji: "1️⃣", expected: true},
+		{desc: "keycap without a variation selector", emoji: "#\u20e3", expected: true},
+		{desc: "empty", emoji: ""},
+		{desc: "letter", emoji: "a"},
+		{desc: "digit", emoji: "1"},
+		{desc: "letter with combining marks", emoji: "e\u0301\u0302"},
+		{desc: "symbol", emoji: "→"},
+		{desc: "single regional indicator", emoji: "🇫"},
+		{desc: "several emojis", emoji: "👍👍"},
+		{desc: "overlong sequence", emoji: "👍" + strings.Repeat("\u200d👍", 12)},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			assert.Equal(t, test.expected, validEmoji(test.emoji))
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+
+	"github.com/jbdoumenjou/mychat/internal/event"
+	"github.com/jbdoumenjou/mychat/internal/realtime"
+)
+
+// Publisher publishes the domain events.
+type Publisher interface {
+	Publish(ctx context.Context, event event.Event)
+}
+
+// Notifier pushes events to the connected users.
+type Notifier interface {
+	Publish(users []string, event realtime.Event)
+}
+
+// ForwardEvents pushes the domain events of the bus to the connected participants of the chats,
+// in the API format. The returned function stops the forwarding.
+func ForwardEvents(bus *event.Bus, notifier Notifier) func() {
+	unsubscribeChats := event.Subscribe(bus, func(_ context.Context, e event.ChatCreated) {
+		notifier.Publish(e.Participants, realtime.Event{
+			Type: realtime.EventChatCreated,
+			Data: newChatResponse(e.Chat),
+		})
+	})
+
+	unsubscribeUpdates := event.Subscribe(bus, func(_ context.Context, e event.ChatUpdated) {
+		notifier.Publish(e.Participants, realtime.Event{
+			Type: realtime.EventChatUpdated,
+			Data: newChatResponse(e.Chat),
+		})
+	})
+
+	unsubscribeMessages := event.Subscribe(bus, func(_ context.Context, e event.MessageSent) {
+		message := newMessageResponse(e.Message)
+		if e.ReplyTo != nil {
+			message.Quote = newQuoteResponse(*e.ReplyTo)
+		}
+
+		notifier.Publish(e.Participants, realtime.Event{
+			Type:     realtime.EventMessageCreated,
+			Data:     message,
+			Position: e.Message.GlobalSeq,
+		})
+	})
+
+	unsubscribeEdits := event.Subscribe(bus, func(_ context.Context, e event.MessageEdited) {
+		notifier.Publish(e.Participants, realtime.Event{
+			Type: realtime.EventMessageUpdated,
+			Data: newMessageResponse(e.Message),
+		})
+	})
+
+	unsubscribeDeletions := event.Subscribe(bus, func(_ context.Context, e event.MessageDeleted) {
+		notifier.Publish(e.Participants, realtime.Event{
+			Type: realtime.EventMessageDeleted,
+			Data: newMessageResponse(e.Message),
+		})
+	})
+
+	unsubscribeReceipts := event.Subscribe(bus, func(_ context.Context, e event.ReceiptUpdated) {
+		notifier.Publish(e.Senders, realtime.Event{
+			Type: realtime.EventReceiptUpdated,
+			Data: newReceiptResponse(e.Receipt),
+		})
+	})
+
+	return func() {
+		unsubscribeChats()
+		unsubscribeUpdates()
+		unsubscribeMessages()
+		unsubscribeEdits()
+		unsubscribeDeletions()
+		unsubscribeReceipts()
+	}
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/jbdoumenjou/mychat/internal/event"
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// ReceiptHandler is the handler for the delivery and read receipts of the messages.
+// The messages are delivered to a participant when they are listed or pushed to them,
+// and read when the participant marks them as read.
+type ReceiptHandler struct {
+	chatRepo    ReceiptChatRepo
+	messageRepo ReceiptMessageRepo
+	publisher   Publisher
+
+	logger *slog.Logger
+}
+
+// ReceiptChatRepo defines the chat repository.
+type ReceiptChatRepo interface {
+	GetChat(chatID string) (repo.Chat, error)
+	MarkDelivered(chatID, user string, seq int64) (repo.Receipt, int64, error)
+	MarkRead(chatID, user string, seq int64) (repo.Receipt, int64, error)
+	GetReceipts(chatID string) ([]repo.Receipt, error)
+}
+
+// ReceiptMessageRepo defines the message repository.
+type ReceiptMessageRepo interface {
+	GetChatMessages(chatID string, query repo.MessageQuery) ([]repo.Message, error)
+	GetLastSeq(chatID string) (int64, error)
+}
+
+// NewReceiptHandler creates a new ReceiptHandler.
+func NewReceiptHandler(chatRepo ReceiptChatRepo, messageRepo ReceiptMessageRepo, publisher Publisher) *ReceiptHandler {
+	logger := slog.With(slog.String("handler", "receipt"))
+	logger.Info("created handler")
+
+	return &ReceiptHandler{
+		chatRepo:    chatRepo,
+		messageRepo: messageRepo,
+		publisher:   publisher,
+		logger:      logger,
+	}
+}
+
+// Statuses of a message for one of its recipients.
+const (
+	receiptSent      = "sent"
+	receiptDelivered = "delivered"
+	receiptRead      = "read"
+)
+
+// ReadReceipt marks the messages of a chat as read, up to a sequence number.
+type ReadReceipt struct {
+	Seq int64 `json:"seq"`
+}
+
+// ReceiptResponse represents the position of a participant in the messages of a chat.
+// This is the response format for the API.
+// This avoids to expose the internal Receipt struct.
+type ReceiptResponse struct {
+	ChatID       string `json:"chatId"`
+	User         string `json:"user"`
+	DeliveredSeq int64  `json:"deliveredSeq"`
+	ReadSeq      int64  `json:"readSeq"`
+}
+
+func newReceiptResponse(receipt repo.Receipt) ReceiptResponse {
+	return ReceiptResponse{
+		ChatID:       receipt.ChatID,
+		User:         receipt.User,
+		DeliveredSeq: receipt.DeliveredSeq,
+		ReadSeq:      receipt.ReadSeq,
+	}
+}
+
+// receiptStatus returns the status of the message of the sequence number for the participant of the receipt.
+func receiptStatus(receipt repo.Receipt, seq int64) string {
+	switch {
+	case receipt.ReadSeq >= seq:
+		return receiptRead
+	case receipt.DeliveredSeq >= seq:
+		return receiptDelivered
+	default:
+		return receiptSent
+	}
+}
+
+// newMessageResponseWithReceipts converts the message with its status for each of its recipients,
+// the participants of the chat other than its sender.
+// A blocked message stays sent, it is never delivered.
+func newMessageResponseWithReceipts(message repo.Message, receipts []repo.Receipt) MessageResponse {
+	response := newMessageResponse(message)
+	if message.System {
+		return response
+	}
+
+	response.Receipts = make(map[string]string, len(receipts))
+
+	for _, receipt := range receipts {
+		switch {
+		case receipt.User == message.Sender:
+		case message.Blocked:
+			response.Receipts[receipt.User] = receiptSent
+		default:
+			response.Receipts[receipt.User] = receiptStatus(receipt, message.Seq)
+		}
+	}
+
+	return response
+}
+
+// MarkRead marks the messages of a chat as read by the authenticated user, up to a sequence number.
+func (h *ReceiptHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler mark messages as read", slog.String("path", r.URL.Path))
+
+	chat, ok := getParticipantChat(w, r, h.chatRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	var read ReadReceipt
+
+	if err := json.NewDecoder(r.Body).Decode(&read); err != nil {
+		h.logger.ErrorContext(r.Context(), "Invalid input")
+		writeProblem(w, r, problemInvalidInput, "Invalid input")
+
+		return
+	}
+
+	lastSeq, err := h.messageRepo.GetLastSeq(chat.ID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get last message", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to get last message")
+
+		return
+	}
+
+	if read.Seq < 1 || read.Seq > lastSeq {
+		writeError(w, r, newFieldError("seq", fmt.Sprintf("seq must be between 1 and the last message seq %d", lastSeq)), "invalid seq")
+
+		return
+	}
+
+	receipt, previous, err := h.chatRepo.MarkRead(chat.ID, currentUser(r), read.Seq)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to mark messages as read", slog.String("error", err.Error()))
+
+		writeError(w, r, err, "failed to mark messages as read")
+
+		return
+	}
+
+	h.notify(r.Context(), receipt, previous, read.Seq)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(newReceiptResponse(receipt)); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to write response", slog.String("error", err.Error()))
+	}
+}
+
+// getReceipts gets the receipts of the participants of the chat.
+func (h *ReceiptHandler) getReceipts(chatID string) ([]repo.Receipt, error) {
+	receipts, err := h.chatRepo.GetReceipts(chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get receipts: %w", err)
+	}
+
+	return receipts, nil
+}
+
+// delivered marks the message pushed to the user as delivered, the messages of the user are ignored.
+func (h *ReceiptHandler) delivered(ctx context.Context, user string, message MessageResponse) {
+	if message.Sender != user {
+		h.markDelivered(ctx, user, message.ChatID, message.Seq)
+	}
+}
+
+// markDelivered marks the messages of the chat up to seq as delivered to the user.
+// The delivery goes on whatever happens to its receipt, a failure is only logged.
+func (h *ReceiptHandler) markDelivered(ctx context.Context, user, chatID string, seq int64) {
+	receipt, previous, err := h.chatRepo.MarkDelivered(chatID, user, seq)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "failed to mark messages as delivered", slog.String("error", err.Error()))
+
+		return
+	}
+
+	h.notify(ctx, receipt, previous, seq)
+}
+
+// notify notifies the senders of the messages after the previous sequence number up to seq,
+// the messages whose status changed, about the updated receipt.
+// The receipt is updated, a failure to notify is only logged.
+func (h *ReceiptHandler) notify(ctx context.Context, receipt repo.Receipt, previous, seq int64) {
+	if previous >= seq {
+		return
+	}
+
+	messages, err := h.messageRepo.GetChatMessages(receipt.ChatID, repo.MessageQuery{
+		AfterSeq:  previous,
+		BeforeSeq: seq + 1,
+		Viewer:    receipt.User,
+	})
+	if err != nil {
+		h.logger.ErrorContext(ctx, "failed to get chat messages", slog.String("error", err.Error()))
+
+		return
+	}
+
+	var senders []string
+
+	for _, message := range messages {
+		if !message.System && message.Sender != receipt.User && !slices.Contains(senders, message.Sender) {
+			senders = append(senders, message.Sender)
+		}
+	}
+
+	if len(senders) > 0 {
+		h.publisher.Publish(ctx, event.ReceiptUpdated{Receipt: receipt, Senders: senders})
+	}
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/realtime"
+)
+
+// nextReceipt waits for the next receipt event of the subscription.
+func nextReceipt(ctx context.Context, t *testing.T, sub *realtime.Subscription) ReceiptResponse {
+	t.Helper()
+
+	for {
+		select {
+		case <-ctx.Done():
+			t.Fatal("no receipt event")
+		case event := <-sub.Events():
+			if receipt, ok := event.Data.(ReceiptResponse); ok {
+				assert.Equal(t, realtime.EventReceiptUpdated, event.Type)
+
+				return receipt
+			}
+		}
+	}
+}
+
+func TestReceiptHandler_MarkRead(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 2)
+	sender, receiver := users[0], users[1]
+
+	sub, err := testHub.Subscribe(sender)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	var chatID string
+
+	for i := range 3 {
+		chatID = sendTestMessage(ctx, t, sender, receiver, "message "+strconv.Itoa(i)).ChatID
+	}
+
+	// the messages are sent, listing them delivers them to the receiver.
+	page := getTestPage[MessageResponse](t, "/chats/"+chatID+"/messages", sender)
+	assert.Equal(t, map[string]string{receiver: receiptSent}, page.Items[0].Receipts)
+
+	getTestPage[MessageResponse](t, "/chats/"+chatID+"/messages", receiver)
+	assert.Equal(t, ReceiptResponse{ChatID: chatID, User: receiver, DeliveredSeq: 3}, nextReceipt(ctx, t, sub))
+
+	rr := requestTest(ctx, t, http.MethodPost, "/chats/"+chatID+"/read", `{"seq": 2}`, receiver)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var receipt ReceiptResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&receipt))
+	assert.Equal(t, ReceiptResponse{ChatID: chatID, User: receiver, DeliveredSeq: 3, ReadSeq: 2}, receipt)
+	assert.Equal(t, receipt, nextReceipt(ctx, t, sub))
+
+	// the messages already read are ignored.
+	rr = requestTest(ctx, t, http.MethodPost, "/chats/"+chatID+"/read", `{"seq": 1}`, receiver)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&receipt))
+	assert.Equal(t, int64(2), receipt.ReadSeq)
+
+	page = getTestPage[MessageResponse](t, "/chats/"+chatID+"/messages", sender)
+
+	var statuses []string
+	for _, message := range page.Items {
+		statuses = append(statuses, message.Receipts[receiver])
+	}
+
+	assert.Equal(t, []string{receiptRead, receiptRead, receiptDelivered}, statuses)
+}
+
+func TestReceiptHandler_Delivered_Streamed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := httptest.NewServer(testRouter)
+	t.Cleanup(server.Close)
+
+	users := registerTestUsers(t, 2)
+	sender, receiver := users[0], users[1]
+
+	chatID := sendTestMessage(ctx, t, sender, receiver, "first").ChatID
+
+	sub, err := testHub.Subscribe(sender)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	// the messages streamed to the receiver are delivered.
+	stream := openEventStream(ctx, t, server, "/chats/"+chatID+"/events", receiver, "")
+	second := sendTestMessage(ctx, t, sender, receiver, "second")
+
+	requireMessageEvent(t, second, readSSE(t, stream))
+	assert.Equal(t, ReceiptResponse{ChatID: chatID, User: receiver, DeliveredSeq: 2}, nextReceipt(ctx, t, sub))
+
+	// the receipts of the messages of the receiver are streamed too.
+	third := sendTestMessage(ctx, t, receiver, sender, "third")
+	requireMessageEvent(t, third, readSSE(t, stream))
+
+	rr := requestTest(ctx, t, http.MethodPost, "/chats/"+chatID+"/read", `{"seq": 3}`, sender)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	event := readSSE(t, stream)
+	require.Equal(t, realtime.EventReceiptUpdated, event.Type)
+
+	var receipt ReceiptResponse
+	require.NoError(t, json.Unmarshal([]byte(event.Data), &receipt))
+	assert.Equal(t, ReceiptResponse{ChatID: chatID, User: sender, DeliveredSeq: 3, ReadSeq: 3}, receipt)
+}
+
+func TestReceiptHandler_MarkRead_Errors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 3)
+	sender, receiver, outsider := users[0], users[1], users[2]
+
+	chatID := sendTestMessage(ctx, t, sender, receiver, "Hello").ChatID
+
+	testCases := []struct {
+		desc     string
+		chatID   string
+		body     string
+		user     string
+		expected int
+	}{
+		{
+			desc:     "unauthenticated",
+			chatID:   chatID,
+			body:     `{"seq": 1}`,
+			expected: http.StatusUnauthorized,
+		},
+		{
+			desc:     "unknown chat",
+			chatID:   "unknown",
+			body:     `{"seq": 1}`,
+			user:     receiver,
+			expected: http.StatusNotFound,
+		},
+		{
+			desc:     "not a participant",
+			chatID:   chatID,
+			body:     `{"seq": 1}`,
+			user:     outsider,
+			expected: http.StatusForbidden,
+		},
+		{
+			desc:     "invalid input",
+			chatID:   chatID,
+			body:     `{"seq": "1"}`,
+			user:     receiver,
+			expected: http.StatusBadRequest,
+		},
+		{
+			desc:     "no seq",
+			chatID:   chatID,
+			body:     `{}`,
+			user:     receiver,
+			expected: http.StatusBadRequest,
+		},
+		{
+			desc:     "after the last message",
+			chatID:   chatID,
+			body:     `{"seq": 2}`,
+			user:     receiver,
+			expected: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			rr := requestTest(ctx, t, http.MethodPost, "/chats/"+test.chatID+"/read", test.body, test.user)
+			assert.Equal(t, test.expected, rr.Code)
+		})
+	}
+}
@@ -4,17 +4,98 @@ package api
 import "net/http"
 
 // NewRouter is the router for the API.
-func NewRouter(users *UserHandler, messages *MessageHandler, chats *ChatHandler) http.Handler {
+// Every route, except the registration, the login and the documentation, requires an authenticated user.
+// The routes are described by the OpenAPI specification served by the docs handler.
+// The requests of each client are limited by the limiter,
+// and the registration and the sending of messages can be retried safely with an idempotency key.
+func NewRouter(
+	users *UserHandler,
+	auth *AuthHandler,
+	messages *MessageHandler,
+	edits *MessageEditHandler,
+	reactions *ReactionHandler,
+	attachments *AttachmentHandler,
+	search *SearchHandler,
+	blocks *BlockHandler,
+	chats *ChatHandler,
+	receipts *ReceiptHandler,
+	ws *WebSocketHandler,
+	events *EventHandler,
+	docs *DocsHandler,
+	limiter *RateLimiter,
+	idempotency *Idempotency,
+) http.Handler {
 	mux := http.NewServeMux()
 
-	// user registration with phone number.
-	mux.HandleFunc("POST /register", users.RegisterUser)
-	// send a message to another user, it will be associated to a chat.
-	mux.HandleFunc("POST /messages", messages.SendMessage)
-	// list all chats for a user based on the phone number.
-	mux.HandleFunc("GET /chats", chats.ListChats)
+	// user registration with phone number, a one-time code is sent by SMS to verify it.
+	mux.HandleFunc("POST /register/start", idempotency.Handle(users.StartRegistration))
+	// registration of the verified phone number, it issues an access token.
+	mux.HandleFunc("POST /register/verify", idempotency.Handle(users.VerifyRegistration))
+	// a one-time code is sent by SMS to a registered user to log in.
+	mux.HandleFunc("POST /login/start", auth.StartLogin)
+	// login of a registered user with the one-time code, it issues an access token.
+	mux.HandleFunc("POST /login", auth.Login)
+	// send a message from the authenticated user to another user, it will be associated to a chat,
+	// or to a chat the user participates in.
+	mux.HandleFunc("POST /messages", requireUser(idempotency.Handle(messages.SendMessage)))
+	// create a named group chat with the authenticated user and other members.
+	mux.HandleFunc("POST /chats", requireUser(chats.CreateChat))
+	// list the chats of the authenticated user.
+	mux.HandleFunc("GET /chats", requireUser(chats.ListChats))
+	// rename a group chat, only its admins can rename it.
+	mux.HandleFunc("PATCH /chats/{id}", requireUser(chats.UpdateChat))
+	// add members to a group chat, only its admins can add them.
+	mux.HandleFunc("POST /chats/{id}/participants", requireUser(chats.AddParticipants))
+	// remove a participant from a group chat, the admins can remove anyone and the others only themselves.
+	mux.HandleFunc("DELETE /chats/{id}/participants/{phoneNumber}", requireUser(chats.RemoveParticipant))
+	// leave a group chat.
+	mux.HandleFunc("POST /chats/{id}/leave", requireUser(chats.LeaveChat))
+	// promote a participant to admin of a group chat.
+	mux.HandleFunc("PUT /chats/{id}/admins/{phoneNumber}", requireUser(chats.AddAdmin))
+	// demote an admin of a group chat to member.
+	mux.HandleFunc("DELETE /chats/{id}/admins/{phoneNumber}", requireUser(chats.RemoveAdmin))
 	// list all messages for a chat.
-	mux.HandleFunc("GET /chats/{id}/messages", chats.ListChatMessages)
+	mux.HandleFunc("GET /chats/{id}/messages", requireUser(chats.ListChatMessages))
+	// mark the messages of a chat as read, up to a sequence number.
+	mux.HandleFunc("POST /chats/{id}/read", requireUser(receipts.MarkRead))
+	// get a message of a chat.
+	mux.HandleFunc("GET /chats/{id}/messages/{messageId}", requireUser(chats.GetChatMessage))
+	// edit a message of the authenticated user, during the edit window.
+	mux.HandleFunc("PATCH /chats/{id}/messages/{messageId}", requireUser(edits.EditMessage))
+	// delete a message of the authenticated user, it stays in the chat as a tombstone.
+	mux.HandleFunc("DELETE /chats/{id}/messages/{messageId}", requireUser(edits.DeleteMessage))
+	// list the replies to a message of a chat, its thread.
+	mux.HandleFunc("GET /chats/{id}/messages/{messageId}/thread", requireUser(chats.ListThread))
+	// get the versions of an edited message of the authenticated user.
+	mux.HandleFunc("GET /chats/{id}/messages/{messageId}/history", requireUser(edits.GetMessageHistory))
+	// react to a message of a chat with an emoji, once per emoji.
+	mux.HandleFunc("PUT /chats/{id}/messages/{messageId}/reactions/{emoji}", requireUser(reactions.AddReaction))
+	// remove the reaction of the authenticated user with an emoji from a message of a chat.
+	mux.HandleFunc("DELETE /chats/{id}/messages/{messageId}/reactions/{emoji}", requireUser(reactions.RemoveReaction))
+	// upload a file of the authenticated user, attached to a message by sending it with its ID.
+	mux.HandleFunc("POST /attachments", requireUser(attachments.Upload))
+	// download a file attached to a message of a chat of the authenticated user, or uploaded by them.
+	mux.HandleFunc("GET /attachments/{id}", requireUser(attachments.Download))
+	// download a thumbnail of an image attachment, visible like the image.
+	mux.HandleFunc("GET /attachments/{id}/thumbnails/{size}", requireUser(attachments.DownloadThumbnail))
+	// search the messages of the chats of the authenticated user by their words, from the most relevant.
+	mux.HandleFunc("GET /search", requireUser(search.Search))
+	// block a user, their direct messages are hidden from the authenticated user.
+	mux.HandleFunc("POST /blocks/{phoneNumber}", requireUser(blocks.Block))
+	// unblock a user blocked by the authenticated user.
+	mux.HandleFunc("DELETE /blocks/{phoneNumber}", requireUser(blocks.Unblock))
+	// list the users blocked by the authenticated user.
+	mux.HandleFunc("GET /blocks", requireUser(blocks.ListBlocks))
+	// real-time connection to receive new messages and send messages.
+	mux.HandleFunc("GET /ws", requireUser(ws.Connect))
+	// stream of the events of all chats of a user, an alternative to the WebSocket.
+	mux.HandleFunc("GET /events", requireUser(events.Events))
+	// stream of the events of a chat.
+	mux.HandleFunc("GET /chats/{id}/events", requireUser(events.ChatEvents))
+	// the OpenAPI specification of the API.
+	mux.HandleFunc("GET /openapi.json", docs.Spec)
+	// the documentation page of the API, rendered from its specification.
+	mux.HandleFunc("GET /docs", docs.Page)
 
-	return mux
+	return auth.Authenticate(limiter.Limit(mux))
 }
@@ -0,0 +1,250 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+	"github.com/jbdoumenjou/mychat/internal/search"
+)
+
+// SearchHandler is the handler for the search of the messages of the chats of a user.
+type SearchHandler struct {
+	chatRepo    SearchChatRepo
+	messageRepo SearchMessageRepo
+	phones      PhoneNormalizer
+
+	logger *slog.Logger
+}
+
+// SearchChatRepo defines the chat repository.
+type SearchChatRepo interface {
+	GetChat(chatID string) (repo.Chat, error)
+	GetUserChats(user string, query repo.ChatQuery) ([]repo.UserChat, error)
+}
+
+// SearchMessageRepo defines the message repository.
+type SearchMessageRepo interface {
+	SearchMessages(query repo.SearchQuery) ([]repo.Message, error)
+}
+
+// NewSearchHandler creates a new SearchHandler.
+func NewSearchHandler(chatRepo SearchChatRepo, messageRepo SearchMessageRepo, phones PhoneNormalizer) *SearchHandler {
+	logger := slog.With(slog.String("handler", "search"))
+	logger.Info("created handler")
+
+	return &SearchHandler{
+		chatRepo:    chatRepo,
+		messageRepo: messageRepo,
+		phones:      phones,
+		logger:      logger,
+	}
+}
+
+const (
+	// maxSearchTerms is the maximum number of words searched at once.
+	maxSearchTerms = 10
+	// maxSnippetWords is the maximum number of words of the snippet of a message found.
+	maxSnippetWords = 20
+)
+
+var (
+	errSearchTextRequired = newFieldError("q", "q is required")
+	errSearchNoWord       = newFieldError("q", "q must contain a word")
+	errTooManySearchTerms = newFieldError("q", fmt.Sprintf("q has at most %d words", maxSearchTerms))
+	errInvalidFrom        = newFieldError("from", "from must be an RFC 3339 time")
+	errInvalidTo          = newFieldError("to", "to must be an RFC 3339 time")
+	errFromAfterTo        = newFieldError("from", "from must be before to")
+	errSearchBefore       = newFieldError("before", "search results are only paged with after")
+)
+
+// SearchResultResponse is a message found by a search.
+// This is the response format for the API.
+type SearchResultResponse struct {
+	Message MessageResponse `json:"message"`
+	// Snippet is the excerpt of the content around the words found, split to highlight them.
+	Snippet []SnippetFragment `json:"snippet"`
+}
+
+// SnippetFragment is a part of a snippet, Match reports whether it is a word searched for.
+type SnippetFragment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match"`
+}
+
+func newSearchResultResponse(message repo.Message, terms []string) SearchResultResponse {
+	fragments := search.Snippet(message.Content, terms, maxSnippetWords)
+
+	snippet := make([]SnippetFragment, len(fragments))
+	for i, fragment := range fragments {
+		snippet[i] = SnippetFragment{Text: fragment.Text, Match: fragment.Match}
+	}
+
+	return SearchResultResponse{Message: newMessageResponse(message), Snippet: snippet}
+}
+
+// searchCursor is the position of a message in the search results, by rank.
+type searchCursor struct {
+	Offset int `json:"offset"`
+}
+
+// parseSearchQuery parses the searched words, the time range and the requested page of results.
+// One more message than the page limit is queried to know if there is a next page.
+func parseSearchQuery(r *http.Request) (pageRequest, repo.SearchQuery, error) {
+	page, err := parsePageRequest(r)
+	if err != nil {
+		return pageRequest{}, repo.SearchQuery{}, err
+	}
+
+	if page.before != "" {
+		return pageRequest{}, repo.SearchQuery{}, errSearchBefore
+	}
+
+	values := r.URL.Query()
+	query := repo.SearchQuery{Limit: page.limit + 1}
+
+	if page.after != "" {
+		var cursor searchCursor
+		if err = decodeCursor(page.after, &cursor); err != nil || cursor.Offset < 1 {
+			return pageRequest{}, repo.SearchQuery{}, &fieldError{field: "after", err: errInvalidCursor}
+		}
+
+		query.Offset = cursor.Offset
+	}
+
+	text := values.Get("q")
+	if text == "" {
+		return pageRequest{}, repo.SearchQuery{}, errSearchTextRequired
+	}
+
+	query.Terms = search.Terms(text)
+
+	switch {
+	case len(query.Terms) == 0:
+		return pageRequest{}, repo.SearchQuery{}, errSearchNoWord
+	case len(query.Terms) > maxSearchTerms:
+		return pageRequest{}, repo.SearchQuery{}, errTooManySearchTerms
+	}
+
+	if from := values.Get("from"); from != "" {
+		if query.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return pageRequest{}, repo.SearchQuery{}, errInvalidFrom
+		}
+	}
+
+	if to := values.Get("to"); to != "" {
+		if query.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return pageRequest{}, repo.SearchQuery{}, errInvalidTo
+		}
+	}
+
+	if !query.From.IsZero() && !query.To.IsZero() && !query.From.Before(query.To) {
+		return pageRequest{}, repo.SearchQuery{}, errFromAfterTo
+	}
+
+	return page, query, nil
+}
+
+// Search lists a page of the messages of the chats of the authenticated user holding all the searched words,
+// from the most relevant, with a snippet highlighting the words found.
+// The search can be restricted to a chat, a sender and a time range.
+func (h *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler search messages", slog.String("path", r.URL.Path))
+
+	page, query, err := parseSearchQuery(r)
+	if err != nil {
+		writeError(w, r, err, "invalid search")
+
+		return
+	}
+
+	var ok bool
+
+	if sender := r.URL.Query().Get("sender"); sender != "" {
+		if query.Sender, ok = normalizePhoneNumber(w, r, h.phones, "sender", sender, h.logger); !ok {
+			return
+		}
+	}
+
+	if query.ChatIDs, ok = h.getSearchedChats(w, r); !ok {
+		return
+	}
+
+	query.Viewer = currentUser(r)
+
+	messages, err := h.messageRepo.SearchMessages(query)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to search messages", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to search messages")
+
+		return
+	}
+
+	// the results are sorted by rank, the next page starts after the last result of this one.
+	next := func(repo.Message) any { return searchCursor{Offset: query.Offset + page.limit} }
+	result := newPage(messages, page.limit, false, next, func(message repo.Message) SearchResultResponse {
+		return newSearchResultResponse(message, query.Terms)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(result); err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to write response", slog.String("error", err.Error()))
+
+		writeProblem(w, r, problemInternal, "failed to write response")
+	}
+}
+
+// getSearchedChats gets the IDs of the chats to search: the chat of the chatId query parameter
+// if the authenticated user participates in it, all the chats of the user otherwise.
+// It writes the error response on failure.
+func (h *SearchHandler) getSearchedChats(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	user := currentUser(r)
+
+	if chatID := r.URL.Query().Get("chatId"); chatID != "" {
+		chat, err := h.chatRepo.GetChat(chatID)
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "failed to get chat", slog.String("error", err.Error()))
+
+			if errors.Is(err, repo.ErrChatNotFound) {
+				writeError(w, r, errChatNotFound, "failed to get chat")
+
+				return nil, false
+			}
+
+			writeProblem(w, r, problemInternal, "failed to get chat")
+
+			return nil, false
+		}
+
+		if !slices.Contains(chat.Participants, user) {
+			h.logger.ErrorContext(r.Context(), "user is not a participant of the chat", slog.String("phoneNumber", user))
+			writeError(w, r, errNotParticipant, "failed to get chat")
+
+			return nil, false
+		}
+
+		return []string{chat.ID}, true
+	}
+
+	chats, err := h.chatRepo.GetUserChats(user, repo.ChatQuery{})
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get user chats", slog.String("error", err.Error()))
+		writeProblem(w, r, problemInternal, "failed to get user chats")
+
+		return nil, false
+	}
+
+	chatIDs := make([]string, len(chats))
+	for i, chat := range chats {
+		chatIDs[i] = chat.ID
+	}
+
+	return chatIDs, true
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// searchTarget returns the search URL with the query parameters, given as key value pairs.
+func searchTarget(params ...string) string {
+	values := url.Values{}
+	for i := 0; i+1 < len(params); i += 2 {
+		values.Set(params[i], params[i+1])
+	}
+
+	return "/search?" + values.Encode()
+}
+
+// sendToChatTest sends a message to a chat with the API.
+func sendToChatTest(ctx context.Context, t *testing.T, sender, chatID, content string) MessageResponse {
+	t.Helper()
+
+	payload, err := json.Marshal(Message{ChatID: chatID, Content: content})
+	require.NoError(t, err)
+
+	rr := requestTest(ctx, t, http.MethodPost, "/messages", string(payload), sender)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	var message MessageResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&message))
+
+	return message
+}
+
+func searchResultIDs(results []SearchResultResponse) []string {
+	ids := make([]string, len(results))
+	for i, result := range results {
+		ids[i] = result.Message.ID
+	}
+
+	return ids
+}
+
+func TestSearchHandler_Search(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 4)
+	alice, bob, carol, outsider := users[0], users[1], users[2], users[3]
+
+	// the searched word is unique to the test, the repository is shared.
+	word := "pizza" + strings.TrimPrefix(alice, "+")
+
+	lunch := sendTestMessage(ctx, t, alice, bob, "Lunch at the "+strings.ToUpper(word)+" place?")
+	repeated := sendTestMessage(ctx, t, bob, alice, word+" "+word+" "+word)
+	group := createTestGroup(ctx, t, alice, "Friends", bob, carol)
+	tomorrow := sendToChatTest(ctx, t, carol, group.ID, word+" tomorrow")
+	_ = sendTestMessage(ctx, t, outsider, carol, word)
+	_ = sendTestMessage(ctx, t, alice, bob, "something else")
+
+	// the message repeating the word ranks first, the messages of other chats are not found.
+	page := getTestPage[SearchResultResponse](t, searchTarget("q", word), alice)
+	require.Len(t, page.Items, 3)
+	assert.Empty(t, page.NextCursor)
+	assert.Equal(t, repeated.ID, page.Items[0].Message.ID)
+	assert.ElementsMatch(t, []string{lunch.ID, tomorrow.ID}, searchResultIDs(page.Items[1:]))
+
+	for _, result := range page.Items {
+		if result.Message.ID == lunch.ID {
+			assert.Equal(t, lunch, result.Message)
+			assert.Equal(t, []SnippetFragment{
+				{Text: "Lunch at the "},
+				{Text: strings.ToUpper(word), Match: true},
+				{Text: " place?"},
+			}, result.Snippet)
+		}
+	}
+
+	// every word must match.
+	page = getTestPage[SearchResultResponse](t, searchTarget("q", "Tomorrow, "+word+"!"), alice)
+	assert.Equal(t, []string{tomorrow.ID}, searchResultIDs(page.Items))
+
+	page = getTestPage[SearchResultResponse](t, searchTarget("q", word+" unknown"), alice)
+	assert.Empty(t, page.Items)
+
+	// the results are filtered by chat, sender and time range.
+	page = getTestPage[SearchResultResponse](t, searchTarget("q", word, "chatId", group.ID), alice)
+	assert.Equal(t, []string{tomorrow.ID}, searchResultIDs(page.Items))
+
+	page = getTestPage[SearchResultResponse](t, searchTarget("q", word, "sender", bob), alice)
+	assert.Equal(t, []string{repeated.ID}, searchResultIDs(page.Items))
+
+	page = getTestPage[SearchResultResponse](t, searchTarget("q", word, "to", lunch.CreatedAt.Format(time.RFC3339)), alice)
+	assert.Empty(t, page.Items)
+
+	from := lunch.CreatedAt.Add(-time.Second).Format(time.RFC3339)
+	to := tomorrow.CreatedAt.Add(time.Second).Format(time.RFC3339)
+	page = getTestPage[SearchResultResponse](t, searchTarget("q", word, "from", from, "to", to), alice)
+	assert.Len(t, page.Items, 3)
+
+	// the results are paged by rank.
+	page = getTestPage[SearchResultResponse](t, searchTarget("q", word, "limit", "2"), alice)
+	require.Len(t, page.Items, 2)
+	require.NotEmpty(t, page.NextCursor)
+	assert.Equal(t, repeated.ID, page.Items[0].Message.ID)
+
+	next := getTestPage[SearchResultResponse](t, searchTarget("q", word, "limit", "2", "after", page.NextCursor), alice)
+	require.Len(t, next.Items, 1)
+	assert.Empty(t, next.NextCursor)
+	assert.ElementsMatch(t, []string{lunch.ID, tomorrow.ID}, append(searchResultIDs(page.Items[1:]), next.Items[0].Message.ID))
+
+	// the deleted messages are not found.
+	rr := requestTest(ctx, t, http.MethodDelete, "/chats/"+repeated.ChatID+"/messages/"+repeated.ID, "", bob)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+
+	page = getTestPage[SearchResultResponse](t, searchTarget("q", word), bob)
+	assert.ElementsMatch(t, []string{lunch.ID, tomorrow.ID}, searchResultIDs(page.Items))
+}
+
+func TestSearchHandler_Search_Errors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 3)
+	alice, bob, outsider := users[0], users[1], users[2]
+
+	message := sendTestMessage(ctx, t, alice, bob, "hello")
+
+	rr := getTest(t, searchTarget("q", "hello"), "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	testCases := []struct {
+		desc    string
+		target  string
+		user    string
+		status  int
+		message string
+	}{
+		{
+			desc:    "missing text",
+			target:  searchTarget("chatId", message.ChatID),
+			status:  http.StatusBadRequest,
+			message: "q is required",
+		},
+		{
+			desc:    "no word",
+			target:  searchTarget("q", "?!"),
+			status:  http.StatusBadRequest,
+			message: "q must contain a word",
+		},
+		{
+			desc:    "too many words",
+			target:  searchTarget("q", "a b c d e f g h i j k"),
+			status:  http.StatusBadRequest,
+			message: "q has at most 10 words",
+		},
+		{
+			desc:    "invalid from",
+			target:  searchTarget("q", "hello", "from", "yesterday"),
+			status:  http.StatusBadRequest,
+			message: "from must be an RFC 3339 time",
+		},
+		{
+			desc:    "invalid to",
+			target:  searchTarget("q", "hello", "to", "2024-01-01"),
+			status:  http.StatusBadRequest,
+			message: "to must be an RFC 3339 time",
+		},
+		{
+			desc:    "from after to",
+			target:  searchTarget("q", "hello", "from", "2024-01-02T00:00:00Z", "to", "2024-01-01T00:00:00Z"),
+			status:  http.StatusBadRequest,
+			message: "from must be before to",
+		},
+		{
+			desc:    "before cursor",
+			target:  searchTarget("q", "hello", "before", encodeCursor(searchCursor{Offset: 1})),
+			status:  http.StatusBadRequest,
+			message: "search results are only paged with after",
+		},
+		{
+			desc:    "invalid cursor",
+			target:  searchTarget("q", "hello", "after", encodeCursor(searchCursor{})),
+			status:  http.StatusBadRequest,
+			message: "invalid cursor",
+		},
+		{
+			desc:    "invalid sender",
+			target:  searchTarget("q", "hello", "sender", "123"),
+			status:  http.StatusBadRequest,
+			message: "sender: invalid phone number: too short",
+		},
+		{
+			desc:    "unknown chat",
+			target:  searchTarget("q", "hello", "chatId", "unknown"),
+			status:  http.StatusNotFound,
+			message: "chat not found",
+		},
+		{
+			desc:    "not a participant",
+			target:  searchTarget("q", "hello", "chatId", message.ChatID),
+			user:    outsider,
+			status:  http.StatusForbidden,
+			message: "user is not a participant of the chat",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			user := test.user
+			if user == "" {
+				user = alice
+			}
+
+			rr := getTest(t, test.target, user)
+			assert.Equal(t, test.status, rr.Code)
+			assert.Equal(t, test.message, decodeProblem(t, rr).Detail)
+		})
+	}
+}
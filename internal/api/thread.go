@@ -0,0 +1,114 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// QuoteResponse represents the message replied to, quoted in its replies.
+// This is the response format for the API.
+type QuoteResponse struct {
+	ID        string    `json:"id"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// newQuoteResponse quotes the message as it is displayed, a deleted message is quoted as a tombstone.
+func newQuoteResponse(message repo.Message) *QuoteResponse {
+	response := newMessageResponse(message)
+
+	return &QuoteResponse{
+		ID:        response.ID,
+		Sender:    response.Sender,
+		Content:   response.Content,
+		CreatedAt: response.CreatedAt,
+	}
+}
+
+// quoteGetter gets messages of a chat by their IDs.
+type quoteGetter interface {
+	GetChatMessagesByID(chatID string, messageIDs []string) ([]repo.Message, error)
+}
+
+// getQuotes gets the quotes of the messages replied to by the messages of the chat, by message ID.
+func getQuotes(getter quoteGetter, chatID string, messages []repo.Message) (map[string]*QuoteResponse, error) {
+	var replyTo []string
+
+	for _, message := range messages {
+		if message.ReplyTo != "" && !slices.Contains(replyTo, message.ReplyTo) {
+			replyTo = append(replyTo, message.ReplyTo)
+		}
+	}
+
+	quotes := make(map[string]*QuoteResponse, len(replyTo))
+	if len(replyTo) == 0 {
+		return quotes, nil
+	}
+
+	parents, err := getter.GetChatMessagesByID(chatID, replyTo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get replied messages: %w", err)
+	}
+
+	for _, parent := range parents {
+		quotes[parent.ID] = newQuoteResponse(parent)
+	}
+
+	return quotes, nil
+}
+
+// newQuotedMessageResponse converts the message with the quote of the message it replies to, if any.
+func newQuotedMessageResponse(message repo.Message, quotes map[string]*QuoteResponse) MessageResponse {
+	response := newMessageResponse(message)
+	response.Quote = quotes[message.ReplyTo]
+
+	return response
+}
+
+// ListThread list a page of the replies to a message of a chat, sorted by sequence number.
+// The replies to the replies are in the threads of the replies.
+func (h *ChatHandler) ListThread(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler list the thread of a message", slog.String("path", r.URL.Path))
+
+	chat, ok := getParticipantChat(w, r, h.chatRepo, h.logger)
+	if !ok {
+		return
+	}
+
+	parent, err := h.messageRepo.GetChatMessage(chat.ID, r.PathValue("messageId"))
+	if err == nil && !parent.VisibleTo(currentUser(r)) {
+		err = repo.ErrMessageNotFound
+	}
+
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to get chat message", slog.String("error", err.Error()))
+
+		if errors.Is(err, repo.ErrMessageNotFound) {
+			writeProblem(w, r, problemMessageNotFound, "message not found")
+
+			return
+		}
+
+		writeProblem(w, r, problemInternal, "failed to get chat message")
+
+		return
+	}
+
+	page, query, err := parseMessageQuery(r)
+	if err != nil {
+		writeError(w, r, err, "invalid page")
+
+		return
+	}
+
+	query.ReplyTo = parent.ID
+
+	h.writeMessages(w, r, chat.ID, page, query)
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/realtime"
+)
+
+// replyTest sends a reply of the sender to a message of the chat with the API.
+func replyTest(ctx context.Context, t *testing.T, sender, chatID, replyTo, content string) MessageResponse {
+	t.Helper()
+
+	rr := requestTest(ctx, t, http.MethodPost, "/messages",
+		`{"chatId": "`+chatID+`", "replyTo": "`+replyTo+`", "content": "`+content+`"}`, sender)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	var reply MessageResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&reply))
+
+	return reply
+}
+
+func TestChatHandler_ListThread(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 2)
+	sender, receiver := users[0], users[1]
+
+	parent := sendTestMessage(ctx, t, sender, receiver, "Hello")
+	quote := &QuoteResponse{ID: parent.ID, Sender: sender, Content: "Hello", CreatedAt: parent.CreatedAt}
+
+	sub, err := testHub.Subscribe(sender)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	first := replyTest(ctx, t, receiver, parent.ChatID, parent.ID, "Hi")
+	assert.Equal(t, parent.ID, first.ReplyTo)
+	assert.Equal(t, quote, first.Quote)
+
+	// the participants are notified with the quoted message.
+	event := <-sub.Events()
+	assert.Equal(t, realtime.EventMessageCreated, event.Type)
+	assert.Equal(t, first, event.Data)
+
+	sendTestMessage(ctx, t, sender, receiver, "How are you?")
+	second := replyTest(ctx, t, sender, parent.ChatID, parent.ID, "Hey")
+	nested := replyTest(ctx, t, sender, parent.ChatID, first.ID, "Hey you")
+
+	messages := getTestPage[MessageResponse](t, "/chats/"+parent.ChatID+"/messages", receiver).Items
+	require.Len(t, messages, 5)
+	assert.Equal(t, int64(2), messages[0].ReplyCount)
+	assert.Equal(t, int64(1), messages[1].ReplyCount)
+	assert.Empty(t, messages[2].ReplyTo)
+	assert.Equal(t, quote, messages[3].Quote)
+	assert.Equal(t, first.ID, messages[4].Quote.ID)
+
+	thread := getTestPage[MessageResponse](t, "/chats/"+parent.ChatID+"/messages/"+parent.ID+"/thread", receiver)
+	assert.Equal(t, []string{first.ID, second.ID}, messageIDs(thread.Items))
+	assert.Equal(t, quote, thread.Items[1].Quote)
+
+	// the thread is paginated like the messages.
+	thread = getTestPage[MessageResponse](t, "/chats/"+parent.ChatID+"/messages/"+parent.ID+"/thread?limit=1", receiver)
+	assert.Equal(t, []string{second.ID}, messageIDs(thread.Items))
+	require.NotEmpty(t, thread.NextCursor)
+
+	thread = getTestPage[MessageResponse](t, "/chats/"+parent.ChatID+"/messages/"+parent.ID+"/thread?limit=1&before="+thread.NextCursor, receiver)
+	assert.Equal(t, []string{first.ID}, messageIDs(thread.Items))
+
+	thread = getTestPage[MessageResponse](t, "/chats/"+parent.ChatID+"/messages/"+first.ID+"/thread", receiver)
+	assert.Equal(t, []string{nested.ID}, messageIDs(thread.Items))
+
+	// the deleted message is quoted as a tombstone.
+	rr := requestTest(ctx, t, http.MethodDelete, "/chats/"+parent.ChatID+"/messages/"+parent.ID, "", sender)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+
+	thread = getTestPage[MessageResponse](t, "/chats/"+parent.ChatID+"/messages/"+parent.ID+"/thread", receiver)
+	require.Len(t, thread.Items, 2)
+	assert.Equal(t, deletedMessageContent, thread.Items[0].Quote.Content)
+}
+
+func TestChatHandler_ListThread_Errors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 3)
+	sender, receiver, outsider := users[0], users[1], users[2]
+
+	message := sendTestMessage(ctx, t, sender, receiver, "Hello")
+	other := sendTestMessage(ctx, t, sender, outsider, "Hello")
+
+	testCases := []struct {
+		desc     string
+		method   string
+		target   string
+		body     string
+		user     string
+		expected int
+		message  string
+	}{
+		{
+			desc:     "reply to an unknown message",
+			method:   http.MethodPost,
+			target:   "/messages",
+			body:     `{"chatId": "` + message.ChatID + `", "replyTo": "unknown", "content": "Hi"}`,
+			user:     receiver,
+			expected: http.StatusBadRequest,
+			message:  "replyTo message not found in the chat",
+		},
+		{
+			desc:     "reply to a message of another chat",
+			method:   http.MethodPost,
+			target:   "/messages",
+			body:     `{"receiver": "` + receiver + `", "replyTo": "` + other.ID + `", "content": "Hi"}`,
+			user:     sender,
+			expected: http.StatusBadRequest,
+			message:  "replyTo message not found in the chat",
+		},
+		{
+			desc:     "thread unauthenticated",
+			method:   http.MethodGet,
+			target:   "/chats/" + message.ChatID + "/messages/" + message.ID + "/thread",
+			expected: http.StatusUnauthorized,
+		},
+		{
+			desc:     "thread of an unknown message",
+			method:   http.MethodGet,
+			target:   "/chats/" + message.ChatID + "/messages/unknown/thread",
+			user:     sender,
+			expected: http.StatusNotFound,
+			message:  "message not found",
+		},
+		{
+			desc:     "thread of a message of another chat",
+			method:   http.MethodGet,
+			target:   "/chats/" + message.ChatID + "/messages/" + other.ID + "/thread",
+			user:     sender,
+			expected: http.StatusNotFound,
+			message:  "message not found",
+		},
+		{
+			desc:     "thread of a chat of other users",
+			method:   http.MethodGet,
+			target:   "/chats/" + other.ChatID + "/messages/" + other.ID + "/thread",
+			user:     receiver,
+			expected: http.StatusForbidden,
+			message:  "user is not a participant of the chat",
+		},
+		{
+			desc:     "thread invalid page",
+			method:   http.MethodGet,
+			target:   "/chats/" + message.ChatID + "/messages/" + message.ID + "/thread?limit=0",
+			user:     sender,
+			expected: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			rr := requestTest(ctx, t, test.method, test.target, test.body, test.user)
+			assert.Equal(t, test.expected, rr.Code)
+
+			if test.message != "" {
+				assert.Equal(t, test.message, decodeProblem(t, rr).Detail)
+			}
+		})
+	}
+
+	// the failed replies are not counted.
+	got := getTestPage[MessageResponse](t, "/chats/"+other.ChatID+"/messages", sender).Items
+	require.Len(t, got, 1)
+	assert.Zero(t, got[0].ReplyCount)
+}
+
+// messageIDs returns the IDs of the messages.
+func messageIDs(messages []MessageResponse) []string {
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+
+	return ids
+}
@@ -5,13 +5,20 @@ import (
 	"errors"
 	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/jbdoumenjou/mychat/internal/event"
 	"github.com/jbdoumenjou/mychat/internal/repo"
 )
 
 // UserHandler is the handler for user registration.
+// A phone number is registered once verified with a one-time code sent by SMS.
 type UserHandler struct {
-	userRepo UserRepo
+	userRepo  UserRepo
+	phones    PhoneNormalizer
+	verifier  PhoneVerifier
+	tokens    TokenService
+	publisher Publisher
 
 	logger *slog.Logger
 }
@@ -23,13 +30,23 @@ type UserRepo interface {
 }
 
 // NewUserHandler creates a new UserHandler.
-func NewUserHandler(userRepo UserRepo) *UserHandler {
+func NewUserHandler(
+	userRepo UserRepo,
+	phones PhoneNormalizer,
+	verifier PhoneVerifier,
+	tokens TokenService,
+	publisher Publisher,
+) *UserHandler {
 	logger := slog.With(slog.String("handler", "user"))
 	logger.Info("created handler")
 
 	return &UserHandler{
-		userRepo: userRepo,
-		logger:   logger,
+		userRepo:  userRepo,
+		phones:    phones,
+		verifier:  verifier,
+		tokens:    tokens,
+		publisher: publisher,
+		logger:    logger,
 	}
 }
 
@@ -38,62 +55,103 @@ type User struct {
 	PhoneNumber string `json:"phoneNumber"`
 }
 
-// RegisterUser create a new user.
-func (h *UserHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
-	h.logger.DebugContext(r.Context(), "handler register user", slog.String("path", r.URL.Path))
+// StartRegistration sends a one-time code to the phone number to register.
+func (h *UserHandler) StartRegistration(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler start registration", slog.String("path", r.URL.Path))
 
 	var user User
 
 	// Decode the JSON body
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		h.logger.ErrorContext(r.Context(), "Invalid input")
-		// TODO: improve error management.
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+		writeProblem(w, r, problemInvalidInput, "Invalid input")
 
 		return
 	}
 
-	if user.PhoneNumber == "" {
-		h.logger.ErrorContext(r.Context(), "phoneNumber is required")
-		http.Error(w, "phoneNumber is required", http.StatusBadRequest)
+	phoneNumber, ok := h.checkRegistrable(w, r, user.PhoneNumber)
+	if !ok {
+		return
+	}
+
+	sendVerificationCode(w, r, h.verifier, phoneNumber, h.logger)
+}
+
+// VerifyRegistration registers the phone number given the one-time code sent by StartRegistration.
+// The registered user is logged in.
+func (h *UserHandler) VerifyRegistration(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler verify registration", slog.String("path", r.URL.Path))
+
+	var verification Verification
+
+	// Decode the JSON body
+	if err := json.NewDecoder(r.Body).Decode(&verification); err != nil {
+		h.logger.ErrorContext(r.Context(), "Invalid input")
+		writeProblem(w, r, problemInvalidInput, "Invalid input")
 
 		return
 	}
 
-	// Check if the phone number is already registered
-	if h.userRepo.IsRegistered(user.PhoneNumber) {
-		h.logger.ErrorContext(r.Context(),
-			"Phone number already registered",
-			slog.String("phoneNumber", user.PhoneNumber),
-		)
-		http.Error(w, "Phone number already registered", http.StatusConflict)
+	phoneNumber, ok := h.checkRegistrable(w, r, verification.PhoneNumber)
+	if !ok {
+		return
+	}
+
+	verification.PhoneNumber = phoneNumber
 
+	if !verifyCode(w, r, h.verifier, verification, http.StatusBadRequest, h.logger) {
 		return
 	}
 
 	// Register the phone number
-	if err := h.userRepo.AddUser(user.PhoneNumber); err != nil {
+	if err := h.userRepo.AddUser(verification.PhoneNumber); err != nil {
 		h.logger.ErrorContext(r.Context(),
 			"Failed to register user",
 			slog.String("error", err.Error()),
 		)
 
 		if errors.Is(err, repo.ErrPhoneNumberAlreadyRegistered) {
-			http.Error(w, "Phone number already registered", http.StatusConflict)
+			writeProblem(w, r, problemPhoneRegistered, "Phone number already registered")
 
 			return
 		}
 
-		http.Error(w, "Failed to register user", http.StatusInternalServerError)
+		writeProblem(w, r, problemInternal, "Failed to register user")
 
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	h.publisher.Publish(r.Context(), event.UserRegistered{
+		PhoneNumber:  verification.PhoneNumber,
+		RegisteredAt: time.Now().UTC(),
+	})
 
 	h.logger.DebugContext(r.Context(),
 		"User registered successfully",
-		slog.Any("user", user),
+		slog.String("phoneNumber", verification.PhoneNumber),
 	)
+
+	writeToken(w, r, h.tokens, verification.PhoneNumber, http.StatusCreated, h.logger)
+}
+
+// checkRegistrable checks the phone number is valid and not registered yet, writing the error otherwise.
+// The phone number is returned normalized.
+func (h *UserHandler) checkRegistrable(w http.ResponseWriter, r *http.Request, phoneNumber string) (string, bool) {
+	phoneNumber, ok := normalizePhoneNumber(w, r, h.phones, "phoneNumber", phoneNumber, h.logger)
+	if !ok {
+		return "", false
+	}
+
+	// Check if the phone number is already registered
+	if h.userRepo.IsRegistered(phoneNumber) {
+		h.logger.ErrorContext(r.Context(),
+			"Phone number already registered",
+			slog.String("phoneNumber", phoneNumber),
+		)
+		writeProblem(w, r, problemPhoneRegistered, "Phone number already registered")
+
+		return "", false
+	}
+
+	return phoneNumber, true
 }
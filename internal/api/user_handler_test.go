@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -10,47 +11,91 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/event"
+	"github.com/jbdoumenjou/mychat/internal/otp"
 )
 
-func TestRegisterUser(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// postTest posts the JSON payload to the router.
+func postTest(ctx context.Context, t *testing.T, target, payload string) *httptest.ResponseRecorder {
+	t.Helper()
 
-	// Create a test request
-	phoneNumber := generateRandomPhoneNumber()
-	req, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodPost,
-		"/register",
-		strings.NewReader(`{"phoneNumber": "`+phoneNumber+`"}`),
-	)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(payload))
 	require.NoError(t, err)
 	req.Header.Set("Content-Type", "application/json")
 
-	// Record the HTTP response
 	rr := httptest.NewRecorder()
 	testRouter.ServeHTTP(rr, req)
 
-	respContentType := rr.Header().Get("Content-Type")
-	assert.Equal(t, "application/json", respContentType)
-	assert.Equal(t, http.StatusCreated, rr.Code)
+	return rr
+}
 
-	// Try to register the same user again
-	req2, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodPost,
-		"/register",
-		strings.NewReader(`{"phoneNumber": "`+phoneNumber+`"}`),
-	)
+// verificationPayload returns the JSON payload verifying the phone number with the code.
+func verificationPayload(phoneNumber, code string) string {
+	return `{"phoneNumber": "` + phoneNumber + `", "code": "` + code + `"}`
+}
+
+func TestRegisterUser(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var registered []event.UserRegistered
+
+	unsubscribe := event.Subscribe(testBus, func(_ context.Context, e event.UserRegistered) {
+		registered = append(registered, e)
+	})
+	defer unsubscribe()
+
+	phoneNumber := generateRandomPhoneNumber()
+
+	// a code is sent to the phone number.
+	rr := postTest(ctx, t, "/register/start", `{"phoneNumber": "`+phoneNumber+`"}`)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	require.Equal(t, http.StatusAccepted, rr.Code)
+
+	var challenge VerificationResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&challenge))
+	assert.True(t, challenge.ExpiresAt.After(challenge.ResendAt))
+
+	code := testSMS.code(phoneNumber)
+	require.Len(t, code, 6)
+
+	// the user is not registered before the verification.
+	assert.False(t, testUserRepo.IsRegistered(phoneNumber))
+	assert.Empty(t, registered)
+
+	// the code can't be requested again right away.
+	rr = postTest(ctx, t, "/register/start", `{"phoneNumber": "`+phoneNumber+`"}`)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+	assert.Equal(t, code, testSMS.code(phoneNumber))
+
+	// the code registers the user and logs them in.
+	rr = postTest(ctx, t, "/register/verify", verificationPayload(phoneNumber, code))
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	var token TokenResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&token))
+
+	user, err := testTokens.Verify(token.AccessToken)
 	require.NoError(t, err)
-	req2.Header.Set("Content-Type", "application/json")
+	assert.Equal(t, phoneNumber, user)
+
+	assert.True(t, testUserRepo.IsRegistered(phoneNumber))
+	require.Len(t, registered, 1)
+	assert.Equal(t, phoneNumber, registered[0].PhoneNumber)
 
-	rr = httptest.NewRecorder()
-	testRouter.ServeHTTP(rr, req2)
+	// Try to register the same user again
+	rr = postTest(ctx, t, "/register/start", `{"phoneNumber": "`+phoneNumber+`"}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Equal(t, "phone_number_already_registered", decodeProblem(t, rr).Code)
 
-	respContentType2 := rr.Header().Get("Content-Type")
-	assert.Equal(t, "text/plain; charset=utf-8", respContentType2)
+	rr = postTest(ctx, t, "/register/verify", verificationPayload(phoneNumber, code))
 	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	// no event for a failed registration
+	assert.Len(t, registered, 1)
 }
 
 func TestRegisterUser_Errors(t *testing.T) {
@@ -59,38 +104,123 @@ func TestRegisterUser_Errors(t *testing.T) {
 
 	tests := []struct {
 		name            string
+		target          string
 		payload         string
 		expectedErrCode int
+		expectedDetail  string
 	}{
 		{
 			name:            "Invalid phone number",
+			target:          "/register/start",
 			payload:         `{"phoneNumber": ""}`,
 			expectedErrCode: http.StatusBadRequest,
 		},
+		{
+			name:            "Too short phone number",
+			target:          "/register/start",
+			payload:         `{"phoneNumber": "+33 6 66"}`,
+			expectedErrCode: http.StatusBadRequest,
+			expectedDetail:  "phoneNumber: invalid phone number: too short",
+		},
+		{
+			name:            "Unknown country code",
+			target:          "/register/start",
+			payload:         `{"phoneNumber": "+999 123 456 789"}`,
+			expectedErrCode: http.StatusBadRequest,
+			expectedDetail:  "phoneNumber: invalid phone number: missing or unknown country code",
+		},
 		{
 			name:            "invalid input",
+			target:          "/register/start",
+			payload:         `{"phoneNumber": 123}`,
+			expectedErrCode: http.StatusBadRequest,
+		},
+		{
+			name:            "verify invalid input",
+			target:          "/register/verify",
 			payload:         `{"phoneNumber": 123}`,
 			expectedErrCode: http.StatusBadRequest,
 		},
+		{
+			name:            "no code sent",
+			target:          "/register/verify",
+			payload:         verificationPayload(generateRandomPhoneNumber(), "123456"),
+			expectedErrCode: http.StatusBadRequest,
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			req, err := http.NewRequestWithContext(
-				ctx,
-				http.MethodPost,
-				"/register",
-				strings.NewReader(test.payload),
-			)
-			require.NoError(t, err)
-			req.Header.Set("Content-Type", "application/json")
-
-			rr := httptest.NewRecorder()
-			testRouter.ServeHTTP(rr, req)
+			rr := postTest(ctx, t, test.target, test.payload)
 
 			respContentType := rr.Header().Get("Content-Type")
-			assert.Equal(t, "text/plain; charset=utf-8", respContentType)
+			assert.Equal(t, ProblemContentType, respContentType)
 			assert.Equal(t, test.expectedErrCode, rr.Code)
+
+			if test.expectedDetail != "" {
+				assert.Equal(t, test.expectedDetail, decodeProblem(t, rr).Detail)
+			}
 		})
 	}
 }
+
+func TestRegisterUser_NormalizesPhoneNumber(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	phoneNumber := generateRandomPhoneNumber()
+	national := "0" + strings.TrimPrefix(phoneNumber, "+33")
+
+	rr := postTest(ctx, t, "/register/start", `{"phoneNumber": "`+national+`"}`)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+
+	// the code is sent to the normalized phone number, which is verified whatever the way it is written.
+	code := testSMS.code(phoneNumber)
+	require.NotEmpty(t, code)
+
+	rr = postTest(ctx, t, "/register/verify", verificationPayload("00"+strings.TrimPrefix(phoneNumber, "+"), code))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	var token TokenResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&token))
+
+	user, err := testTokens.Verify(token.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, phoneNumber, user)
+	assert.True(t, testUserRepo.IsRegistered(phoneNumber))
+
+	// the phone number is already registered, in any format.
+	rr = postTest(ctx, t, "/register/start", `{"phoneNumber": "`+national[:2]+" "+national[2:]+`"}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+func TestRegisterUser_TooManyAttempts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	phoneNumber := generateRandomPhoneNumber()
+
+	rr := postTest(ctx, t, "/register/start", `{"phoneNumber": "`+phoneNumber+`"}`)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+
+	code := testSMS.code(phoneNumber)
+	wrong := "000000"
+
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	for range otp.DefaultConfig.MaxAttempts - 1 {
+		rr = postTest(ctx, t, "/register/verify", verificationPayload(phoneNumber, wrong))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, "invalid verification code", decodeProblem(t, rr).Detail)
+	}
+
+	rr = postTest(ctx, t, "/register/verify", verificationPayload(phoneNumber, wrong))
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+
+	// the code is locked.
+	rr = postTest(ctx, t, "/register/verify", verificationPayload(phoneNumber, code))
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.False(t, testUserRepo.IsRegistered(phoneNumber))
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/otp"
+)
+
+// PhoneVerifier sends one-time codes to phone numbers and verifies them.
+type PhoneVerifier interface {
+	SendCode(ctx context.Context, phoneNumber string) (otp.Challenge, error)
+	VerifyCode(phoneNumber, code string) error
+}
+
+// Verification represents a one-time code received by a phone number.
+type Verification struct {
+	PhoneNumber string `json:"phoneNumber"`
+	Code        string `json:"code"`
+}
+
+// VerificationResponse describes the one-time code sent to a phone number.
+type VerificationResponse struct {
+	ExpiresAt time.Time `json:"expiresAt"`
+	// ResendAt is the time from which a new code can be requested.
+	ResendAt time.Time `json:"resendAt"`
+}
+
+// sendVerificationCode sends a one-time code to the phone number and writes the challenge.
+func sendVerificationCode(w http.ResponseWriter, r *http.Request, verifier PhoneVerifier, phoneNumber string, logger *slog.Logger) {
+	challenge, err := verifier.SendCode(r.Context(), phoneNumber)
+	if err != nil {
+		logger.ErrorContext(r.Context(),
+			"failed to send verification code",
+			slog.String("phoneNumber", phoneNumber),
+			slog.String("error", err.Error()),
+		)
+
+		if errors.Is(err, otp.ErrResendTooSoon) {
+			retryAfter := math.Ceil(time.Until(challenge.ResendAt).Seconds())
+			w.Header().Set("Retry-After", strconv.Itoa(max(int(retryAfter), 1)))
+			writeProblem(w, r, problemCodeSentTooRecently, "verification code sent too recently")
+
+			return
+		}
+
+		writeProblem(w, r, problemInternal, "failed to send verification code")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+
+	err = json.NewEncoder(w).Encode(VerificationResponse{
+		ExpiresAt: challenge.ExpiresAt,
+		ResendAt:  challenge.ResendAt,
+	})
+	if err != nil {
+		logger.ErrorContext(r.Context(), "failed to write response", slog.String("error", err.Error()))
+	}
+}
+
+// verifyCode verifies the one-time code, writing the error with the given status if the code is rejected.
+// Once the code is locked after too many attempts, a new one must be requested.
+func verifyCode(
+	w http.ResponseWriter,
+	r *http.Request,
+	verifier PhoneVerifier,
+	verification Verification,
+	rejectedStatus int,
+	logger *slog.Logger,
+) bool {
+	err := verifier.VerifyCode(verification.PhoneNumber, verification.Code)
+	if err == nil {
+		return true
+	}
+
+	logger.ErrorContext(r.Context(),
+		"failed to verify code",
+		slog.String("phoneNumber", verification.PhoneNumber),
+		slog.String("error", err.Error()),
+	)
+
+	switch {
+	case errors.Is(err, otp.ErrTooManyAttempts):
+		writeProblem(w, r, problemTooManyAttempts, "too many verification attempts, request a new code")
+	case errors.Is(err, otp.ErrCodeExpired):
+		writeProblemStatus(w, r, problemCodeExpired, rejectedStatus, "verification code expired")
+	default:
+		writeProblemStatus(w, r, problemInvalidCode, rejectedStatus, "invalid verification code")
+	}
+
+	return false
+}
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"github.com/jbdoumenjou/mychat/internal/realtime"
+)
+
+const (
+	// pingInterval is the interval between 2 pings to check the connection is alive.
+	pingInterval = 30 * time.Second
+	// wsWriteTimeout is the maximum duration to write a message or to get a pong.
+	wsWriteTimeout = 10 * time.Second
+	// wsSendMessageRoute is the route whose rate limit applies to the messages sent over the WebSocket.
+	wsSendMessageRoute = "POST /messages"
+)
+
+// WebSocket message types.
+const (
+	// wsTypeSendMessage is sent by the client to send a message.
+	wsTypeSendMessage = "message.send"
+	// wsTypeMessageSent replies to a message sent by the client.
+	wsTypeMessageSent = "message.sent"
+	// wsTypeError replies to a request that failed.
+	wsTypeError = "error"
+)
+
+// WebSocketHandler is the handler for the real-time connections.
+type WebSocketHandler struct {
+	subscriber Subscriber
+	messages   *MessageHandler
+	receipts   *ReceiptHandler
+	limiter    *RateLimiter
+
+	logger *slog.Logger
+}
+
+// Subscriber subscribes to the events published to a user.
+type Subscriber interface {
+	Subscribe(user string) (*realtime.Subscription, error)
+}
+
+// NewWebSocketHandler creates a new WebSocketHandler.
+// The messages sent over the WebSocket are handled by the MessageHandler,
+// and the messages pushed are delivered to the user, their receipts are tracked by the ReceiptHandler.
+// The messages sent over the WebSocket are limited by the limiter, as the messages sent with the HTTP API.
+func NewWebSocketHandler(
+	subscriber Subscriber,
+	messages *MessageHandler,
+	receipts *ReceiptHandler,
+	limiter *RateLimiter,
+) *WebSocketHandler {
+	logger := slog.With(slog.String("handler", "websocket"))
+	logger.Info("created handler")
+
+	return &WebSocketHandler{
+		subscriber: subscriber,
+		messages:   messages,
+		receipts:   receipts,
+		limiter:    limiter,
+		logger:     logger,
+	}
+}
+
+// WebSocketRequest is a request sent by the client over the WebSocket.
+type WebSocketRequest struct {
+	Type string `json:"type"`
+	// ID is chosen by the client, it is sent back in the reply to match the request.
+	ID   string          `json:"id,omitempty"`
+	Data json.RawMessage `json:"data"`
+}
+
+// WebSocketReply is the reply to a request sent by the client over the WebSocket.
+type WebSocketReply struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+	Data any    `json:"data"`
+}
+
+// WebSocketError describes why a request failed, its code is the one of the problem of the HTTP API.
+type WebSocketError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// WebSocketMessage represents a message sent over the WebSocket, the sender is the connected user.
+// The message is sent either to a receiver or to a chat.
+type WebSocketMessage struct {
+	Receiver    string   `json:"receiver,omitempty"`
+	ChatID      string   `json:"chatId,omitempty"`
+	Content     string   `json:"content"`
+	ReplyTo     string   `json:"replyTo,omitempty"`
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+// Connect upgrades the connection to a WebSocket.
+// The authenticated user receives the events of the chats they participate in, like new messages,
+// and can send messages over the same connection.
+func (h *WebSocketHandler) Connect(w http.ResponseWriter, r *http.Request) {
+	h.logger.DebugContext(r.Context(), "handler connect websocket", slog.String("path", r.URL.Path))
+
+	user := currentUser(r)
+
+	sub, err := h.subscriber.Subscribe(user)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to subscribe", slog.String("error", err.Error()))
+
+		if errors.Is(err, realtime.ErrHubClosed) {
+			writeProblem(w, r, problemUnavailable, "server shutting down")
+
+			return
+		}
+
+		writeProblem(w, r, problemInternal, "failed to subscribe")
+
+		return
+	}
+	defer sub.Close()
+
+	// The connection outlives the server timeouts, the deadlines are managed per message.
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		// Accept already wrote the response.
+		h.logger.ErrorContext(r.Context(), "failed to accept websocket", slog.String("error", err.Error()))
+
+		return
+	}
+	defer conn.CloseNow()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	go func() {
+		defer cancel()
+
+		h.read(ctx, conn, user)
+	}()
+
+	h.write(ctx, conn, sub, user)
+}
+
+// read handles the requests sent by the client until the connection is closed.
+func (h *WebSocketHandler) read(ctx context.Context, conn *websocket.Conn, user string) {
+	for {
+		var req WebSocketRequest
+		if err := wsjson.Read(ctx, conn, &req); err != nil {
+			if websocket.CloseStatus(err) == -1 && ctx.Err() == nil {
+				h.logger.ErrorContext(ctx, "failed to read websocket", slog.String("error", err.Error()))
+			}
+
+			return
+		}
+
+		reply := h.handle(ctx, user, req)
+
+		writeCtx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+		err := wsjson.Write(writeCtx, conn, reply)
+
+		cancel()
+
+		if err != nil {
+			h.logger.ErrorContext(ctx, "failed to write websocket reply", slog.String("error", err.Error()))
+
+			return
+		}
+	}
+}
+
+// handle handles a request sent by the client.
+// The messages share the rate limit of the user with the messages sent with the HTTP API.
+func (h *WebSocketHandler) handle(ctx context.Context, user string, req WebSocketRequest) WebSocketReply {
+	if req.Type != wsTypeSendMessage {
+		return WebSocketReply{
+			Type: wsTypeError, ID: req.ID, Data: WebSocketError{Error: "unknown request type", Code: problemInvalidInput.code},
+		}
+	}
+
+	if !h.limiter.allow(ctx, wsSendMessageRoute, user) {
+		return WebSocketReply{Type: wsTypeError, ID: req.ID, Data: WebSocketError{Error: "too many requests", Code: problemRateLimited.code}}
+	}
+
+	var message WebSocketMessage
+	if err := json.Unmarshal(req.Data, &message); err != nil {
+		return WebSocketReply{Type: wsTypeError, ID: req.ID, Data: WebSocketError{Error: "Invalid input", Code: problemInvalidInput.code}}
+	}
+
+	created, err := h.messages.send(ctx, user, Message{
+		Receiver:    message.Receiver,
+		ChatID:      message.ChatID,
+		Content:     message.Content,
+		ReplyTo:     message.ReplyTo,
+		Attachments: message.Attachments,
+	})
+	if err != nil {
+		if problem := problemOf(err); problem != problemInternal {
+			return WebSocketReply{Type: wsTypeError, ID: req.ID, Data: WebSocketError{Error: err.Error(), Code: problem.code}}
+		}
+
+		return WebSocketReply{Type: wsTypeError, ID: req.ID, Data: WebSocketError{Error: "Failed to send message", Code: problemInternal.code}}
+	}
+
+	return WebSocketReply{Type: wsTypeMessageSent, ID: req.ID, Data: created}
+}
+
+// write pushes the events to the client of the user and keeps the connection alive,
+// until the connection or the subscription is closed.
+func (h *WebSocketHandler) write(ctx context.Context, conn *websocket.Conn, sub *realtime.Subscription, user string) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				// the hub is shutting down or the client is too slow.
+				_ = conn.Close(websocket.StatusGoingAway, "subscription closed")
+
+				return
+			}
+
+			writeCtx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+			err := wsjson.Write(writeCtx, conn, event)
+
+			cancel()
+
+			if err != nil {
+				h.logger.ErrorContext(ctx, "failed to write websocket event", slog.String("error", err.Error()))
+
+				return
+			}
+
+			if message, ok := event.Data.(MessageResponse); ok && event.Type == realtime.EventMessageCreated {
+				h.receipts.delivered(ctx, user, message)
+			}
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+			err := conn.Ping(pingCtx)
+
+			cancel()
+
+			if err != nil {
+				h.logger.DebugContext(ctx, "websocket ping failed", slog.String("error", err.Error()))
+
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/auth"
+	"github.com/jbdoumenjou/mychat/internal/phone"
+	"github.com/jbdoumenjou/mychat/internal/ratelimit"
+	"github.com/jbdoumenjou/mychat/internal/realtime"
+)
+
+// dialWebSocket opens a WebSocket connection to the server for the user.
+func dialWebSocket(ctx context.Context, t *testing.T, server *httptest.Server, user string) *websocket.Conn {
+	t.Helper()
+
+	conn, resp, err := websocket.Dial(ctx, server.URL+"/ws?access_token="+url.QueryEscape(testAccessToken(t, user)), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	t.Cleanup(func() {
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+	})
+
+	return conn
+}
+
+// readEvent reads the next event received on the connection, with its data decoded in data.
+func readEvent(ctx context.Context, t *testing.T, conn *websocket.Conn, data any) string {
+	t.Helper()
+
+	var event struct {
+		Type string          `json:"type"`
+		ID   string          `json:"id"`
+		Data json.RawMessage `json:"data"`
+	}
+
+	require.NoError(t, wsjson.Read(ctx, conn, &event))
+	require.NoError(t, json.Unmarshal(event.Data, data))
+
+	return event.Type
+}
+
+func TestWebSocketHandler_Connect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	sender := generateRandomPhoneNumber()
+	require.NoError(t, testUserRepo.AddUser(sender))
+
+	receiver := generateRandomPhoneNumber()
+	require.NoError(t, testUserRepo.AddUser(receiver))
+
+	senderConn := dialWebSocket(ctx, t, server, sender)
+	receiverConn := dialWebSocket(ctx, t, server, receiver)
+
+	// a message sent with the HTTP API is pushed to the participants.
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		"/messages",
+		strings.NewReader(`{"receiver": "`+receiver+`", "content": "Hello World!"}`),
+	)
+	require.NoError(t, err)
+	authenticate(t, req, sender)
+
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	var created MessageResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&created))
+
+	for _, conn := range []*websocket.Conn{senderConn, receiverConn} {
+		// the chat is created by the first message.
+		var chat ChatResponse
+		assert.Equal(t, realtime.EventChatCreated, readEvent(ctx, t, conn, &chat))
+		assert.Equal(t, created.ChatID, chat.ID)
+		assert.Equal(t, []string{sender, receiver}, chat.Participants)
+
+		var message MessageResponse
+		assert.Equal(t, realtime.EventMessageCreated, readEvent(ctx, t, conn, &message))
+		assert.Equal(t, created, message)
+	}
+
+	// the message pushed to the receiver is delivered.
+	var receipt ReceiptResponse
+	assert.Equal(t, realtime.EventReceiptUpdated, readEvent(ctx, t, senderConn, &receipt))
+	assert.Equal(t, ReceiptResponse{ChatID: created.ChatID, User: receiver, DeliveredSeq: 1}, receipt)
+
+	// a message sent over the WebSocket is acknowledged and pushed to the participants.
+	err = wsjson.Write(ctx, receiverConn, WebSocketRequest{
+		Type: wsTypeSendMessage,
+		ID:   "1",
+		Data: json.RawMessage(`{"receiver": "` + sender + `", "content": "Hi!"}`),
+	})
+	require.NoError(t, err)
+
+	var pushed MessageResponse
+	assert.Equal(t, realtime.EventMessageCreated, readEvent(ctx, t, senderConn, &pushed))
+	assert.Equal(t, receiver, pushed.Sender)
+	assert.Equal(t, "Hi!", pushed.Content)
+	assert.Equal(t, created.ChatID, pushed.ChatID)
+	assert.Equal(t, int64(2), pushed.Seq)
+
+	// the reply, the event of the sender and the delivery to the receiver can come in any order.
+	for range 3 {
+		var data json.RawMessage
+
+		switch readEvent(ctx, t, receiverConn, &data) {
+		case wsTypeMessageSent, realtime.EventMessageCreated:
+			var message MessageResponse
+			require.NoError(t, json.Unmarshal(data, &message))
+			assert.Equal(t, pushed, message)
+		case realtime.EventReceiptUpdated:
+			require.NoError(t, json.Unmarshal(data, &receipt))
+			assert.Equal(t, ReceiptResponse{ChatID: created.ChatID, User: sender, DeliveredSeq: 2}, receipt)
+		default:
+			t.Fatal("unexpected event")
+		}
+	}
+
+	// an invalid message is rejected.
+	err = wsjson.Write(ctx, receiverConn, WebSocketRequest{
+		Type: wsTypeSendMessage,
+		ID:   "2",
+		Data: json.RawMessage(`{"receiver": "` + sender + `", "content": ""}`),
+	})
+	require.NoError(t, err)
+
+	var wsErr WebSocketError
+	assert.Equal(t, wsTypeError, readEvent(ctx, t, receiverConn, &wsErr))
+	assert.Equal(t, WebSocketError{Error: errContentRequired.Error(), Code: "validation_failed"}, wsErr)
+}
+
+func TestWebSocketHandler_Connect_Errors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	_, resp, err := websocket.Dial(ctx, server.URL+"/ws", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	_, resp, err = websocket.Dial(ctx, server.URL+"/ws?access_token=invalid", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestWebSocketHandler_Connect_RateLimited(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := registerTestUsers(t, 2)
+	sender, receiver := users[0], users[1]
+
+	phones, err := phone.NewNormalizer("FR")
+	require.NoError(t, err)
+
+	limiter := NewRateLimiter(ratelimit.NewMemoryStore(), map[string]ratelimit.Limit{
+		"POST /messages": {Requests: 3, Period: time.Hour},
+	}, false)
+	wsHandler := NewWebSocketHandler(
+		testHub,
+		NewMessageHandler(testUserRepo, testMessageRepo, testChatRepo, phones, testBus),
+		NewReceiptHandler(testChatRepo, testMessageRepo, testBus),
+		limiter,
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wsHandler.Connect(w, r.WithContext(auth.WithUser(r.Context(), sender)))
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.Dial(ctx, server.URL, nil)
+	require.NoError(t, err)
+
+	defer func() { _ = conn.Close(websocket.StatusNormalClosure, "") }()
+
+	// the messages flooding the socket are limited as the messages sent with the HTTP API.
+	const sent = 10
+	for i := range sent {
+		err = wsjson.Write(ctx, conn, WebSocketRequest{
+			Type: wsTypeSendMessage,
+			ID:   strconv.Itoa(i),
+			Data: json.RawMessage(`{"receiver": "` + receiver + `", "content": "flood"}`),
+		})
+		require.NoError(t, err)
+	}
+
+	var limited []WebSocketError
+
+	for replies := 0; replies < sent; {
+		var data json.RawMessage
+
+		switch readEvent(ctx, t, conn, &data) {
+		case wsTypeMessageSent:
+			replies++
+		case wsTypeError:
+			replies++
+
+			var wsErr WebSocketError
+			require.NoError(t, json.Unmarshal(data, &wsErr))
+			limited = append(limited, wsErr)
+		}
+	}
+
+	require.Len(t, limited, sent-3)
+
+	for _, wsErr := range limited {
+		assert.Equal(t, WebSocketError{Error: "too many requests", Code: "rate_limited"}, wsErr)
+	}
+
+	// the HTTP API shares the limit of the user.
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /messages", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusCreated) })
+
+	rr := limitedTest(t, limiter.Limit(mux), http.MethodPost, "/messages", sender, "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+}
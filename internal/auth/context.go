@@ -0,0 +1,17 @@
+package auth
+
+import "context"
+
+type contextKey struct{}
+
+// WithUser returns a copy of the context holding the authenticated user.
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// UserFromContext returns the authenticated user of the context, if any.
+func UserFromContext(ctx context.Context) (string, bool) {
+	user, ok := ctx.Value(contextKey{}).(string)
+
+	return user, ok && user != ""
+}
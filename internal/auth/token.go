@@ -0,0 +1,114 @@
+// Package auth authenticates the users with signed access tokens.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidToken is returned when the token is malformed or its signature does not match.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpiredToken is returned when the token is expired.
+	ErrExpiredToken = errors.New("token expired")
+)
+
+// header is the JOSE header of the tokens, they are all signed with HMAC SHA-256.
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// claims are the claims of a token.
+type claims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Token is an access token issued to a user.
+type Token struct {
+	Value     string
+	ExpiresAt time.Time
+}
+
+// Tokens issues and verifies the access tokens of the users.
+// The tokens are JSON Web Tokens signed with HMAC SHA-256 (HS256),
+// so every instance sharing the secret can verify them.
+type Tokens struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewTokens creates a new Tokens signing the tokens with the secret,
+// the tokens expire after the ttl.
+func NewTokens(secret []byte, ttl time.Duration) *Tokens {
+	return &Tokens{
+		secret: secret,
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// Issue issues a token for the user.
+func (t *Tokens) Issue(user string) (Token, error) {
+	now := t.now().UTC().Truncate(time.Second)
+	expiresAt := now.Add(t.ttl)
+
+	payload, err := json.Marshal(claims{
+		Subject:   user,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to encode claims: %w", err)
+	}
+
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	return Token{
+		Value:     unsigned + "." + t.sign(unsigned),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// Verify verifies the token and returns the user it has been issued to.
+func (t *Tokens) Verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != header {
+		return "", ErrInvalidToken
+	}
+
+	unsigned := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(t.sign(unsigned))) {
+		return "", ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	var c claims
+	if err = json.Unmarshal(payload, &c); err != nil || c.Subject == "" {
+		return "", ErrInvalidToken
+	}
+
+	if !t.now().Before(time.Unix(c.ExpiresAt, 0)) {
+		return "", ErrExpiredToken
+	}
+
+	return c.Subject, nil
+}
+
+// sign returns the signature of the unsigned token.
+func (t *Tokens) sign(unsigned string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(unsigned))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
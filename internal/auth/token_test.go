@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokens(t *testing.T) {
+	tokens := NewTokens([]byte("secret"), time.Hour)
+
+	token, err := tokens.Issue("+33666666666")
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(token.Value, "."), 3)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), token.ExpiresAt, 2*time.Second)
+
+	user, err := tokens.Verify(token.Value)
+	require.NoError(t, err)
+	assert.Equal(t, "+33666666666", user)
+
+	// a token signed with another secret is rejected.
+	_, err = NewTokens([]byte("other"), time.Hour).Verify(token.Value)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// a tampered token is rejected.
+	other, err := tokens.Issue("+33777777777")
+	require.NoError(t, err)
+
+	parts := strings.Split(token.Value, ".")
+	otherParts := strings.Split(other.Value, ".")
+	_, err = tokens.Verify(parts[0] + "." + otherParts[1] + "." + parts[2])
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	for _, invalid := range []string{"", "abc", "a.b.c", parts[0] + "." + parts[1]} {
+		_, err = tokens.Verify(invalid)
+		require.ErrorIs(t, err, ErrInvalidToken, invalid)
+	}
+}
+
+func TestTokens_Expired(t *testing.T) {
+	tokens := NewTokens([]byte("secret"), time.Hour)
+
+	token, err := tokens.Issue("+33666666666")
+	require.NoError(t, err)
+
+	tokens.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+	_, err = tokens.Verify(token.Value)
+	require.ErrorIs(t, err, ErrExpiredToken)
+}
+
+func TestUserFromContext(t *testing.T) {
+	_, ok := UserFromContext(context.Background())
+	assert.False(t, ok)
+
+	user, ok := UserFromContext(WithUser(context.Background(), "+33666666666"))
+	assert.True(t, ok)
+	assert.Equal(t, "+33666666666", user)
+}
@@ -0,0 +1,23 @@
+// Package blob stores the content of the files uploaded by the users, the blobs, under a key.
+// The blobs are kept on the local filesystem or in an S3-compatible object storage.
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned when no blob is stored under the key.
+var ErrNotFound = errors.New("blob not found")
+
+// validKey reports an error if the key can't be used as a blob name,
+// a key is a single path element so it can't escape the store.
+func validKey(key string) error {
+	if key == "" || key == "." || strings.ContainsAny(key, `/\`) || !filepath.IsLocal(key) {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+
+	return nil
+}
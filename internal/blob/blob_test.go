@@ -0,0 +1,97 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// store is implemented by the blob stores.
+type store interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// testStore tests the behavior shared by the blob stores.
+func testStore(t *testing.T, s store) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := s.Get(ctx, "missing")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, s.Put(ctx, "key", strings.NewReader("hello"), 5, "text/plain"))
+	assertBlob(ctx, t, s, "key", "hello")
+
+	// the blob is replaced.
+	require.NoError(t, s.Put(ctx, "key", strings.NewReader("hello world"), -1, "text/plain"))
+	assertBlob(ctx, t, s, "key", "hello world")
+
+	require.NoError(t, s.Delete(ctx, "key"))
+
+	_, err = s.Get(ctx, "key")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// deleting a missing blob is not an error.
+	require.NoError(t, s.Delete(ctx, "key"))
+
+	for _, key := range []string{"", ".", "..", "../key", "dir/key", `dir\key`} {
+		require.Error(t, s.Put(ctx, key, strings.NewReader("hello"), 5, "text/plain"), key)
+
+		_, err = s.Get(ctx, key)
+		require.Error(t, err, key)
+
+		require.Error(t, s.Delete(ctx, key), key)
+	}
+}
+
+// assertBlob asserts the content of the blob stored under the key.
+func assertBlob(ctx context.Context, t *testing.T, s store, key, expected string) {
+	t.Helper()
+
+	r, err := s.Get(ctx, key)
+	require.NoError(t, err)
+
+	defer func() { _ = r.Close() }()
+
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(content))
+}
+
+func TestLocalStore(t *testing.T) {
+	s, err := NewLocalStore(t.TempDir() + "/blobs")
+	require.NoError(t, err)
+
+	testStore(t, s)
+}
+
+// TestS3Store runs against the S3-compatible storage at MYCHAT_TEST_S3_ENDPOINT, like the MinIO of the docker-compose.
+func TestS3Store(t *testing.T) {
+	endpoint := os.Getenv("MYCHAT_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MYCHAT_TEST_S3_ENDPOINT is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s, err := NewS3Store(ctx, S3Config{
+		Endpoint:  endpoint,
+		AccessKey: os.Getenv("MYCHAT_TEST_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("MYCHAT_TEST_S3_SECRET_KEY"),
+		Bucket:    "mychat-test",
+	})
+	require.NoError(t, err)
+
+	testStore(t, s)
+}
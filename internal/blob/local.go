@@ -0,0 +1,90 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// LocalStore stores the blobs as files of a local directory, one file per key.
+type LocalStore struct {
+	dir string
+}
+
+// NewLocalStore creates a new LocalStore in the directory, created if missing.
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	slog.Info("created blob store", slog.String("blob", "local"), slog.String("dir", dir))
+
+	return &LocalStore{dir: dir}, nil
+}
+
+// Put stores the content of the reader under the key, replacing the previous blob if any.
+// The blob is written to a temporary file first, a partial upload is never visible.
+func (s *LocalStore) Put(_ context.Context, key string, r io.Reader, _ int64, _ string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create blob file: %w", err)
+	}
+
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	if _, err = io.Copy(f, r); err != nil {
+		_ = f.Close()
+
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+
+	if err = os.Rename(f.Name(), filepath.Join(s.dir, key)); err != nil {
+		return fmt.Errorf("failed to store blob: %w", err)
+	}
+
+	return nil
+}
+
+// Get opens the blob stored under the key, the caller closes it.
+func (s *LocalStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(s.dir, key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+
+	return f, nil
+}
+
+// Delete deletes the blob stored under the key, deleting a missing blob is not an error.
+func (s *LocalStore) Delete(_ context.Context, key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath.Join(s.dir, key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config is the configuration of an S3-compatible object storage, like AWS S3 or MinIO.
+type S3Config struct {
+	// Endpoint is the host and port of the storage, without scheme.
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	// Bucket is created if missing.
+	Bucket string
+	UseSSL bool
+	Region string
+}
+
+// S3Store stores the blobs as objects of a bucket of an S3-compatible object storage.
+type S3Store struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3Store creates a new S3Store, the bucket is created if missing.
+func NewS3Store(ctx context.Context, config S3Config) (*S3Store, error) {
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.UseSSL,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+
+	exists, err := client.BucketExists(ctx, config.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket %q: %w", config.Bucket, err)
+	}
+
+	if !exists {
+		if err = client.MakeBucket(ctx, config.Bucket, minio.MakeBucketOptions{Region: config.Region}); err != nil {
+			return nil, fmt.Errorf("failed to create bucket %q: %w", config.Bucket, err)
+		}
+	}
+
+	slog.Info("created blob store",
+		slog.String("blob", "s3"), slog.String("endpoint", config.Endpoint), slog.String("bucket", config.Bucket))
+
+	return &S3Store{client: client, bucket: config.Bucket}, nil
+}
+
+// Put stores the content of the reader under the key, replacing the previous blob if any.
+// The size is the length of the content, -1 if unknown.
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return fmt.Errorf("failed to put blob: %w", err)
+	}
+
+	return nil
+}
+
+// Get opens the blob stored under the key, the caller closes it.
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob: %w", err)
+	}
+
+	// the object is fetched lazily, its metadata tells if it exists.
+	if _, err = object.Stat(); err != nil {
+		_ = object.Close()
+
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+
+		return nil, fmt.Errorf("failed to get blob: %w", err)
+	}
+
+	return object, nil
+}
+
+// Delete deletes the blob stored under the key, deleting a missing blob is not an error.
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	if err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+
+	return nil
+}
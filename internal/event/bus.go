@@ -0,0 +1,120 @@
+// Package event provides an in-process bus to publish and subscribe to domain events.
+package event
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Topic identifies a kind of event.
+type Topic string
+
+// Event is a domain event published on the bus.
+type Event interface {
+	Topic() Topic
+}
+
+// Handler handles the events of a subscription.
+type Handler func(ctx context.Context, event Event)
+
+// subscription is a handler subscribed to the bus, all topics if topic is empty.
+type subscription struct {
+	id      int
+	topic   Topic
+	handler Handler
+}
+
+// Bus dispatches the published events to the handlers subscribed to their topic.
+// The handlers are called synchronously by Publish, in the order of subscription,
+// they must return quickly and hand over long tasks to their own goroutine.
+type Bus struct {
+	mu            sync.RWMutex
+	subscriptions []subscription
+	nextID        int
+
+	logger *slog.Logger
+}
+
+// NewBus creates a new Bus.
+func NewBus() *Bus {
+	logger := slog.With(slog.String("component", "bus"))
+	logger.Info("created bus")
+
+	return &Bus{
+		logger: logger,
+	}
+}
+
+// SubscribeTopic subscribes the handler to the events of the topic.
+// The returned function unsubscribes the handler.
+func (b *Bus) SubscribeTopic(topic Topic, handler Handler) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	id := b.nextID
+
+	b.subscriptions = append(b.subscriptions, subscription{id: id, topic: topic, handler: handler})
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		for i, sub := range b.subscriptions {
+			if sub.id == id {
+				b.subscriptions = append(b.subscriptions[:i:i], b.subscriptions[i+1:]...)
+
+				return
+			}
+		}
+	}
+}
+
+// SubscribeAll subscribes the handler to the events of all topics.
+// The returned function unsubscribes the handler.
+func (b *Bus) SubscribeAll(handler Handler) func() {
+	return b.SubscribeTopic("", handler)
+}
+
+// Subscribe subscribes a handler of a given event type to the bus.
+// The returned function unsubscribes the handler.
+func Subscribe[E Event](b *Bus, handler func(ctx context.Context, event E)) func() {
+	var zero E
+
+	return b.SubscribeTopic(zero.Topic(), func(ctx context.Context, event Event) {
+		if e, ok := event.(E); ok {
+			handler(ctx, e)
+		}
+	})
+}
+
+// Publish sends the event to the handlers subscribed to its topic.
+// A panicking handler does not prevent the others from receiving the event.
+func (b *Bus) Publish(ctx context.Context, event Event) {
+	b.mu.RLock()
+	subscriptions := b.subscriptions
+	b.mu.RUnlock()
+
+	for _, sub := range subscriptions {
+		if sub.topic != "" && sub.topic != event.Topic() {
+			continue
+		}
+
+		b.dispatch(ctx, sub, event)
+	}
+}
+
+func (b *Bus) dispatch(ctx context.Context, sub subscription, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.logger.ErrorContext(ctx, "event handler panicked",
+				slog.String("topic", string(event.Topic())),
+				slog.String("error", fmt.Sprint(r)),
+			)
+		}
+	}()
+
+	sub.handler(ctx, event)
+}
@@ -0,0 +1,60 @@
+package event
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+func TestBus_Publish(t *testing.T) {
+	bus := NewBus()
+	ctx := context.Background()
+
+	var (
+		registered []UserRegistered
+		all        []Topic
+	)
+
+	unsubscribe := Subscribe(bus, func(_ context.Context, e UserRegistered) {
+		registered = append(registered, e)
+	})
+
+	bus.SubscribeAll(func(_ context.Context, e Event) {
+		all = append(all, e.Topic())
+	})
+
+	bus.Publish(ctx, UserRegistered{PhoneNumber: "123"})
+	bus.Publish(ctx, ChatCreated{Chat: repo.Chat{ID: "chat"}})
+
+	require.Equal(t, []UserRegistered{{PhoneNumber: "123"}}, registered)
+	assert.Equal(t, []Topic{TopicUserRegistered, TopicChatCreated}, all)
+
+	// an unsubscribed handler no longer receives the events.
+	unsubscribe()
+	bus.Publish(ctx, UserRegistered{PhoneNumber: "456"})
+
+	assert.Len(t, registered, 1)
+	assert.Len(t, all, 3)
+}
+
+func TestBus_Publish_Panic(t *testing.T) {
+	bus := NewBus()
+
+	var received int
+
+	Subscribe(bus, func(context.Context, MessageSent) {
+		panic("boom")
+	})
+	Subscribe(bus, func(context.Context, MessageSent) {
+		received++
+	})
+
+	require.NotPanics(t, func() {
+		bus.Publish(context.Background(), MessageSent{})
+	})
+	assert.Equal(t, 1, received)
+}
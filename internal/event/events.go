@@ -0,0 +1,90 @@
+package event
+
+import (
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// Topics of the domain events.
+const (
+	TopicUserRegistered Topic = "user.registered"
+	TopicChatCreated    Topic = "chat.created"
+	TopicChatUpdated    Topic = "chat.updated"
+	TopicMessageSent    Topic = "message.sent"
+	TopicMessageEdited  Topic = "message.edited"
+	TopicMessageDeleted Topic = "message.deleted"
+	TopicReceiptUpdated Topic = "receipt.updated"
+)
+
+// UserRegistered is published when a user registers.
+type UserRegistered struct {
+	PhoneNumber  string
+	RegisteredAt time.Time
+}
+
+// Topic implements Event.
+func (UserRegistered) Topic() Topic { return TopicUserRegistered }
+
+// ChatCreated is published when a chat is created.
+type ChatCreated struct {
+	Chat repo.Chat
+	// Participants are the participants notified about the chat,
+	// the receiver of a direct chat who blocked its creator is not.
+	Participants []string
+}
+
+// Topic implements Event.
+func (ChatCreated) Topic() Topic { return TopicChatCreated }
+
+// ChatUpdated is published when the name or the participants of a chat change.
+type ChatUpdated struct {
+	Chat repo.Chat
+	// Participants are the participants of the chat before and after the update.
+	Participants []string
+}
+
+// Topic implements Event.
+func (ChatUpdated) Topic() Topic { return TopicChatUpdated }
+
+// MessageSent is published when a message is added to a chat.
+type MessageSent struct {
+	Message repo.Message
+	// ReplyTo is the message the message replies to, nil if it is not a reply.
+	ReplyTo *repo.Message
+	// Participants are the participants of the chat at the time the message is sent.
+	Participants []string
+}
+
+// Topic implements Event.
+func (MessageSent) Topic() Topic { return TopicMessageSent }
+
+// MessageEdited is published when the sender of a message changes its content.
+type MessageEdited struct {
+	Message repo.Message
+	// Participants are the participants of the chat at the time the message is edited.
+	Participants []string
+}
+
+// Topic implements Event.
+func (MessageEdited) Topic() Topic { return TopicMessageEdited }
+
+// MessageDeleted is published when the sender of a message deletes it.
+type MessageDeleted struct {
+	Message repo.Message
+	// Participants are the participants of the chat at the time the message is deleted.
+	Participants []string
+}
+
+// Topic implements Event.
+func (MessageDeleted) Topic() Topic { return TopicMessageDeleted }
+
+// ReceiptUpdated is published when messages are delivered to or read by a participant of a chat.
+type ReceiptUpdated struct {
+	Receipt repo.Receipt
+	// Senders are the senders of the messages whose status changed.
+	Senders []string
+}
+
+// Topic implements Event.
+func (ReceiptUpdated) Topic() Topic { return TopicReceiptUpdated }
@@ -0,0 +1,189 @@
+// Package otp verifies the phone numbers with one-time codes sent by SMS.
+package otp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrResendTooSoon is returned when a code is requested again before the resend delay.
+	ErrResendTooSoon = errors.New("verification code sent too recently")
+	// ErrNoPendingCode is returned when no code has been sent to the phone number.
+	ErrNoPendingCode = errors.New("no pending verification code")
+	// ErrCodeExpired is returned when the code is expired.
+	ErrCodeExpired = errors.New("verification code expired")
+	// ErrInvalidCode is returned when the code does not match the sent one.
+	ErrInvalidCode = errors.New("invalid verification code")
+	// ErrTooManyAttempts is returned when the code has been invalidated after too many failed attempts.
+	ErrTooManyAttempts = errors.New("too many verification attempts")
+)
+
+const (
+	// codeDigits is the number of digits of a code.
+	codeDigits = 6
+	// maxCode is the exclusive upper bound of the codes.
+	maxCode = 1_000_000
+)
+
+// SMSSender sends a text message to a phone number.
+type SMSSender interface {
+	SendSMS(ctx context.Context, phoneNumber, text string) error
+}
+
+// Config configures the codes.
+type Config struct {
+	// TTL is the lifetime of a code.
+	TTL time.Duration
+	// ResendDelay is the minimum delay between 2 codes sent to the same phone number.
+	ResendDelay time.Duration
+	// MaxAttempts is the number of failed attempts invalidating a code.
+	MaxAttempts int
+}
+
+// DefaultConfig is the default configuration of the codes.
+var DefaultConfig = Config{
+	TTL:         5 * time.Minute,
+	ResendDelay: 30 * time.Second,
+	MaxAttempts: 5,
+}
+
+// Challenge describes a code sent to a phone number.
+type Challenge struct {
+	ExpiresAt time.Time
+	// ResendAt is the time from which a new code can be sent.
+	ResendAt time.Time
+}
+
+// pendingCode is a code waiting to be verified.
+type pendingCode struct {
+	code      string
+	challenge Challenge
+	attempts  int
+	// locked is set after too many failed attempts, until a new code is sent.
+	locked bool
+}
+
+// Verifier sends one-time codes to phone numbers and verifies them.
+// The codes are kept in memory.
+type Verifier struct {
+	mu     sync.Mutex
+	codes  map[string]*pendingCode // map[phoneNumber]code
+	sender SMSSender
+	config Config
+	now    func() time.Time
+
+	logger *slog.Logger
+}
+
+// NewVerifier creates a new Verifier sending the codes with the sender.
+func NewVerifier(sender SMSSender, config Config) *Verifier {
+	logger := slog.With(slog.String("component", "otp"))
+
+	return &Verifier{
+		codes:  make(map[string]*pendingCode),
+		sender: sender,
+		config: config,
+		now:    time.Now,
+		logger: logger,
+	}
+}
+
+// SendCode sends a new code to the phone number, replacing the previous one.
+// ErrResendTooSoon is returned with the challenge of the previous code if it has been sent too recently.
+func (v *Verifier) SendCode(ctx context.Context, phoneNumber string) (Challenge, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.now()
+	v.prune(now)
+
+	if pending, ok := v.codes[phoneNumber]; ok && now.Before(pending.challenge.ResendAt) {
+		return pending.challenge, ErrResendTooSoon
+	}
+
+	code, err := generateCode()
+	if err != nil {
+		return Challenge{}, err
+	}
+
+	if err = v.sender.SendSMS(ctx, phoneNumber, "Your mychat verification code is "+code); err != nil {
+		return Challenge{}, fmt.Errorf("failed to send verification code: %w", err)
+	}
+
+	pending := &pendingCode{
+		code: code,
+		challenge: Challenge{
+			ExpiresAt: now.Add(v.config.TTL),
+			ResendAt:  now.Add(v.config.ResendDelay),
+		},
+	}
+	v.codes[phoneNumber] = pending
+
+	v.logger.DebugContext(ctx, "sent verification code", slog.String("phoneNumber", phoneNumber))
+
+	return pending.challenge, nil
+}
+
+// VerifyCode verifies the code sent to the phone number.
+// A verified code can't be used again.
+func (v *Verifier) VerifyCode(phoneNumber, code string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	pending, ok := v.codes[phoneNumber]
+	if !ok {
+		return ErrNoPendingCode
+	}
+
+	if pending.locked {
+		return ErrTooManyAttempts
+	}
+
+	if !v.now().Before(pending.challenge.ExpiresAt) {
+		delete(v.codes, phoneNumber)
+
+		return ErrCodeExpired
+	}
+
+	if subtle.ConstantTimeCompare([]byte(pending.code), []byte(code)) != 1 {
+		pending.attempts++
+		if pending.attempts >= v.config.MaxAttempts {
+			pending.locked = true
+
+			return ErrTooManyAttempts
+		}
+
+		return ErrInvalidCode
+	}
+
+	delete(v.codes, phoneNumber)
+
+	return nil
+}
+
+// prune forgets the codes which are expired and can be sent again.
+func (v *Verifier) prune(now time.Time) {
+	for phoneNumber, pending := range v.codes {
+		if !now.Before(pending.challenge.ExpiresAt) && !now.Before(pending.challenge.ResendAt) {
+			delete(v.codes, phoneNumber)
+		}
+	}
+}
+
+// generateCode generates a random code of codeDigits digits.
+func generateCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(maxCode))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate code: %w", err)
+	}
+
+	return fmt.Sprintf("%0*d", codeDigits, n.Int64()), nil
+}
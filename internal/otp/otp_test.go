@@ -0,0 +1,106 @@
+package otp
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSender records the last code sent to each phone number.
+type testSender struct {
+	codes map[string]string
+}
+
+func (s *testSender) SendSMS(_ context.Context, phoneNumber, text string) error {
+	s.codes[phoneNumber] = text[strings.LastIndex(text, " ")+1:]
+
+	return nil
+}
+
+func newTestVerifier() (*Verifier, *testSender, *time.Time) {
+	sender := &testSender{codes: make(map[string]string)}
+	verifier := NewVerifier(sender, DefaultConfig)
+
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	return verifier, sender, &now
+}
+
+func TestVerifier(t *testing.T) {
+	verifier, sender, now := newTestVerifier()
+
+	challenge, err := verifier.SendCode(context.Background(), "+33666666666")
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(DefaultConfig.TTL), challenge.ExpiresAt)
+	assert.Equal(t, now.Add(DefaultConfig.ResendDelay), challenge.ResendAt)
+
+	code := sender.codes["+33666666666"]
+	require.Len(t, code, codeDigits)
+
+	require.ErrorIs(t, verifier.VerifyCode("+33777777777", code), ErrNoPendingCode)
+	require.ErrorIs(t, verifier.VerifyCode("+33666666666", "abcdef"), ErrInvalidCode)
+	require.NoError(t, verifier.VerifyCode("+33666666666", code))
+
+	// a code is used once.
+	require.ErrorIs(t, verifier.VerifyCode("+33666666666", code), ErrNoPendingCode)
+}
+
+func TestVerifier_ResendTooSoon(t *testing.T) {
+	verifier, sender, now := newTestVerifier()
+
+	first, err := verifier.SendCode(context.Background(), "+33666666666")
+	require.NoError(t, err)
+
+	code := sender.codes["+33666666666"]
+
+	challenge, err := verifier.SendCode(context.Background(), "+33666666666")
+	require.ErrorIs(t, err, ErrResendTooSoon)
+	assert.Equal(t, first, challenge)
+	assert.Equal(t, code, sender.codes["+33666666666"])
+
+	*now = now.Add(DefaultConfig.ResendDelay)
+
+	_, err = verifier.SendCode(context.Background(), "+33666666666")
+	require.NoError(t, err)
+}
+
+func TestVerifier_Expired(t *testing.T) {
+	verifier, sender, now := newTestVerifier()
+
+	_, err := verifier.SendCode(context.Background(), "+33666666666")
+	require.NoError(t, err)
+
+	*now = now.Add(DefaultConfig.TTL)
+
+	require.ErrorIs(t, verifier.VerifyCode("+33666666666", sender.codes["+33666666666"]), ErrCodeExpired)
+}
+
+func TestVerifier_TooManyAttempts(t *testing.T) {
+	verifier, sender, now := newTestVerifier()
+
+	_, err := verifier.SendCode(context.Background(), "+33666666666")
+	require.NoError(t, err)
+
+	for range DefaultConfig.MaxAttempts - 1 {
+		require.ErrorIs(t, verifier.VerifyCode("+33666666666", "wrong"), ErrInvalidCode)
+	}
+
+	require.ErrorIs(t, verifier.VerifyCode("+33666666666", "wrong"), ErrTooManyAttempts)
+
+	// the right code is rejected too, until a new code is sent.
+	require.ErrorIs(t, verifier.VerifyCode("+33666666666", sender.codes["+33666666666"]), ErrTooManyAttempts)
+
+	_, err = verifier.SendCode(context.Background(), "+33666666666")
+	require.ErrorIs(t, err, ErrResendTooSoon)
+
+	*now = now.Add(DefaultConfig.ResendDelay)
+
+	_, err = verifier.SendCode(context.Background(), "+33666666666")
+	require.NoError(t, err)
+	require.NoError(t, verifier.VerifyCode("+33666666666", sender.codes["+33666666666"]))
+}
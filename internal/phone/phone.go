@@ -0,0 +1,91 @@
+// Package phone validates the phone numbers and normalizes them to the E.164 format,
+// so a phone number identifies a single user whatever the way it is written.
+package phone
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+var (
+	// ErrInvalid is wrapped by all the errors returned for an invalid phone number.
+	ErrInvalid = errors.New("invalid phone number")
+	// ErrEmpty is returned when the phone number is empty.
+	ErrEmpty = fmt.Errorf("%w: empty", ErrInvalid)
+	// ErrNotANumber is returned when the phone number contains no digits or unexpected characters.
+	ErrNotANumber = fmt.Errorf("%w: not a number", ErrInvalid)
+	// ErrCountryCode is returned when the country code is missing or unknown.
+	ErrCountryCode = fmt.Errorf("%w: missing or unknown country code", ErrInvalid)
+	// ErrTooShort is returned when the phone number is too short for its country.
+	ErrTooShort = fmt.Errorf("%w: too short", ErrInvalid)
+	// ErrTooLong is returned when the phone number is too long for its country.
+	ErrTooLong = fmt.Errorf("%w: too long", ErrInvalid)
+	// ErrUnassigned is returned when the phone number has a valid length
+	// but does not match any number range of its country.
+	ErrUnassigned = fmt.Errorf("%w: not an assigned number range", ErrInvalid)
+)
+
+// Normalizer normalizes the phone numbers to the E.164 format.
+type Normalizer struct {
+	// region is the region of the phone numbers written without international prefix.
+	region string
+}
+
+// NewNormalizer creates a new Normalizer.
+// The phone numbers written without international prefix are parsed as numbers of the default region,
+// an ISO 3166-1 alpha-2 code like "FR". Without default region, the international prefix is required.
+func NewNormalizer(defaultRegion string) (*Normalizer, error) {
+	if defaultRegion == "" {
+		return &Normalizer{region: phonenumbers.UNKNOWN_REGION}, nil
+	}
+
+	region := strings.ToUpper(defaultRegion)
+	if !phonenumbers.GetSupportedRegions()[region] {
+		return nil, fmt.Errorf("unsupported phone region %q", defaultRegion)
+	}
+
+	return &Normalizer{region: region}, nil
+}
+
+// Normalize validates the phone number and returns it in the E.164 format, like "+33666666666".
+// The errors wrap ErrInvalid.
+func (n *Normalizer) Normalize(phoneNumber string) (string, error) {
+	if strings.TrimSpace(phoneNumber) == "" {
+		return "", ErrEmpty
+	}
+
+	number, err := phonenumbers.Parse(phoneNumber, n.region)
+	if err != nil {
+		switch {
+		case errors.Is(err, phonenumbers.ErrInvalidCountryCode):
+			return "", ErrCountryCode
+		case errors.Is(err, phonenumbers.ErrTooShortNSN), errors.Is(err, phonenumbers.ErrTooShortAfterIDD):
+			return "", ErrTooShort
+		case errors.Is(err, phonenumbers.ErrNumTooLong):
+			return "", ErrTooLong
+		default:
+			return "", ErrNotANumber
+		}
+	}
+
+	switch phonenumbers.IsPossibleNumberWithReason(number) {
+	case phonenumbers.IS_POSSIBLE, phonenumbers.IS_POSSIBLE_LOCAL_ONLY:
+	case phonenumbers.INVALID_COUNTRY_CODE:
+		return "", ErrCountryCode
+	case phonenumbers.TOO_SHORT:
+		return "", ErrTooShort
+	case phonenumbers.TOO_LONG:
+		return "", ErrTooLong
+	default:
+		return "", ErrUnassigned
+	}
+
+	if !phonenumbers.IsValidNumber(number) {
+		return "", ErrUnassigned
+	}
+
+	return phonenumbers.Format(number, phonenumbers.E164), nil
+}
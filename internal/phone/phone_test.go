@@ -0,0 +1,64 @@
+package phone
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizer_Normalize(t *testing.T) {
+	normalizer, err := NewNormalizer("fr")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		phoneNumber string
+		expected    string
+		expectedErr error
+	}{
+		{name: "E.164", phoneNumber: "+33666666666", expected: "+33666666666"},
+		{name: "spaces", phoneNumber: "+33 6 66 66 66 66", expected: "+33666666666"},
+		{name: "international prefix", phoneNumber: "0033666666666", expected: "+33666666666"},
+		{name: "national format", phoneNumber: "06.66.66.66.66", expected: "+33666666666"},
+		{name: "other region", phoneNumber: "+1 (201) 555-0123", expected: "+12015550123"},
+		{name: "empty", phoneNumber: " ", expectedErr: ErrEmpty},
+		{name: "not a number", phoneNumber: "hello", expectedErr: ErrNotANumber},
+		{name: "unknown country code", phoneNumber: "+999123456789", expectedErr: ErrCountryCode},
+		{name: "too short", phoneNumber: "+33666", expectedErr: ErrTooShort},
+		{name: "too long", phoneNumber: "+3366666666666", expectedErr: ErrTooLong},
+		{name: "unassigned", phoneNumber: "+33066666666", expectedErr: ErrUnassigned},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			normalized, err := normalizer.Normalize(test.phoneNumber)
+			if test.expectedErr != nil {
+				require.ErrorIs(t, err, test.expectedErr)
+				require.ErrorIs(t, err, ErrInvalid)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, normalized)
+		})
+	}
+}
+
+func TestNormalizer_NoDefaultRegion(t *testing.T) {
+	normalizer, err := NewNormalizer("")
+	require.NoError(t, err)
+
+	normalized, err := normalizer.Normalize("+33 6 66 66 66 66")
+	require.NoError(t, err)
+	assert.Equal(t, "+33666666666", normalized)
+
+	_, err = normalizer.Normalize("06 66 66 66 66")
+	require.ErrorIs(t, err, ErrCountryCode)
+}
+
+func TestNewNormalizer_UnsupportedRegion(t *testing.T) {
+	_, err := NewNormalizer("XX")
+	require.Error(t, err)
+}
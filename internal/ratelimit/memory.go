@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pruneInterval is the minimum delay between 2 removals of the full buckets of a MemoryStore.
+const pruneInterval = time.Minute
+
+// MemoryStore keeps the token buckets in memory, they are not shared between several instances.
+// The full buckets are forgotten, they are the same as new ones.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	// prunedAt is the time of the last removal of the full buckets.
+	prunedAt time.Time
+	now      func() time.Time
+}
+
+// memoryBucket is a bucket with the time it is full again.
+type memoryBucket struct {
+	bucket
+
+	fullAt time.Time
+}
+
+// NewMemoryStore creates a new MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*memoryBucket),
+		now:     time.Now,
+	}
+}
+
+// Take takes a token from the bucket of the key, filled according to the limit.
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	if limit.Requests <= 0 || limit.Period <= 0 {
+		return Result{}, errInvalidLimit
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.prune(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{}
+		s.buckets[key] = b
+	}
+
+	result := b.take(limit, now)
+	b.fullAt = b.full(limit)
+
+	return result, nil
+}
+
+// prune removes the buckets full at the time, at most once per pruneInterval.
+func (s *MemoryStore) prune(now time.Time) {
+	if now.Sub(s.prunedAt) < pruneInterval {
+		return
+	}
+
+	s.prunedAt = now
+
+	for key, b := range s.buckets {
+		if !now.Before(b.fullAt) {
+			delete(s.buckets, key)
+		}
+	}
+}
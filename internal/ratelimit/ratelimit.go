@@ -0,0 +1,107 @@
+// Package ratelimit limits the rate of the requests of the clients with token buckets.
+// A bucket holds up to a burst of tokens, refilled at a steady rate, and a request takes a token.
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is the number of requests allowed during a period.
+// The requests can come in a burst, the bucket is then refilled by one token every Period / Requests.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseLimit parses a limit written as requests/period, like 30/1m.
+func ParseLimit(s string) (Limit, error) {
+	requests, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid limit %q, expected requests/period", s)
+	}
+
+	var (
+		limit Limit
+		err   error
+	)
+
+	if limit.Requests, err = strconv.Atoi(requests); err != nil || limit.Requests <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q, the requests must be a positive integer", s)
+	}
+
+	if limit.Period, err = time.ParseDuration(period); err != nil || limit.Period <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q, the period must be a positive duration", s)
+	}
+
+	return limit, nil
+}
+
+// rate returns the number of tokens refilled per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Result is the outcome of a request taking a token.
+type Result struct {
+	// Allowed reports whether the request can be served, a token has been taken.
+	Allowed bool
+	// Remaining is the number of requests that can be served right now.
+	Remaining int
+	// RetryAfter is the delay before the next token, zero if the request is allowed.
+	RetryAfter time.Duration
+	// Reset is the delay before the bucket is full again.
+	Reset time.Duration
+}
+
+// errInvalidLimit is returned when taking a token with a limit allowing no request.
+var errInvalidLimit = errors.New("a limit allows at least one request during a positive period")
+
+// bucket is the state of a token bucket.
+type bucket struct {
+	tokens float64
+	// updatedAt is the time the tokens have been counted.
+	updatedAt time.Time
+}
+
+// take takes a token from the bucket at the time, if any, after refilling it since its last update.
+// A new bucket is zero, it starts full.
+func (b *bucket) take(limit Limit, now time.Time) Result {
+	capacity := float64(limit.Requests)
+
+	if b.updatedAt.IsZero() {
+		b.tokens = capacity
+	} else if elapsed := now.Sub(b.updatedAt); elapsed > 0 {
+		b.tokens = min(capacity, b.tokens+elapsed.Seconds()*limit.rate())
+	}
+
+	b.updatedAt = now
+
+	var result Result
+
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / limit.rate())
+	}
+
+	result.Remaining = int(math.Floor(b.tokens))
+	result.Reset = seconds((capacity - b.tokens) / limit.rate())
+
+	return result
+}
+
+// full returns the time the bucket is full again.
+func (b *bucket) full(limit Limit) time.Time {
+	return b.updatedAt.Add(seconds((float64(limit.Requests) - b.tokens) / limit.rate()))
+}
+
+// seconds converts a number of seconds to a duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimit(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		limit    string
+		expected Limit
+		wantErr  bool
+	}{
+		{desc: "per minute", limit: "30/1m", expected: Limit{Requests: 30, Period: time.Minute}},
+		{desc: "per hour", limit: "5/1h30m", expected: Limit{Requests: 5, Period: 90 * time.Minute}},
+		{desc: "no period", limit: "30", wantErr: true},
+		{desc: "invalid requests", limit: "many/1m", wantErr: true},
+		{desc: "no request", limit: "0/1m", wantErr: true},
+		{desc: "invalid period", limit: "30/minute", wantErr: true},
+		{desc: "negative period", limit: "30/-1m", wantErr: true},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			limit, err := ParseLimit(test.limit)
+			if test.wantErr {
+				require.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, limit)
+		})
+	}
+}
+
+func newTestStore() (*MemoryStore, *time.Time) {
+	store := NewMemoryStore()
+
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	return store, &now
+}
+
+func TestMemoryStore_Take(t *testing.T) {
+	ctx := context.Background()
+	store, now := newTestStore()
+	limit := Limit{Requests: 3, Period: 3 * time.Second}
+
+	// the bucket starts full, the requests come in a burst.
+	for remaining := 2; remaining >= 0; remaining-- {
+		result, err := store.Take(ctx, "client", limit)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Allowed: true, Remaining: remaining, Reset: time.Duration(3-remaining) * time.Second}, result)
+	}
+
+	result, err := store.Take(ctx, "client", limit)
+	require.NoError(t, err)
+	assert.Equal(t, Result{RetryAfter: time.Second, Reset: 3 * time.Second}, result)
+
+	// the other keys have their own bucket.
+	result, err = store.Take(ctx, "other", limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	// a token is refilled every second.
+	*now = now.Add(500 * time.Millisecond)
+
+	result, err = store.Take(ctx, "client", limit)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+
+	*now = now.Add(500 * time.Millisecond)
+
+	result, err = store.Take(ctx, "client", limit)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Allowed: true, Reset: 3 * time.Second}, result)
+
+	// the bucket holds at most its burst.
+	*now = now.Add(time.Hour)
+
+	result, err = store.Take(ctx, "client", limit)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Allowed: true, Remaining: 2, Reset: time.Second}, result)
+
+	_, err = store.Take(ctx, "client", Limit{})
+	require.ErrorIs(t, err, errInvalidLimit)
+}
+
+func TestMemoryStore_Prune(t *testing.T) {
+	ctx := context.Background()
+	store, now := newTestStore()
+	limit := Limit{Requests: 2, Period: 2 * pruneInterval}
+
+	_, err := store.Take(ctx, "refilled", Limit{Requests: 1, Period: time.Second})
+	require.NoError(t, err)
+
+	_, err = store.Take(ctx, "empty", limit)
+	require.NoError(t, err)
+
+	_, err = store.Take(ctx, "empty", limit)
+	require.NoError(t, err)
+
+	// the full buckets are forgotten, the other ones are kept.
+	*now = now.Add(pruneInterval)
+
+	_, err = store.Take(ctx, "new", limit)
+	require.NoError(t, err)
+	assert.Len(t, store.buckets, 2)
+	assert.Contains(t, store.buckets, "empty")
+
+	result, err := store.Take(ctx, "empty", limit)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Remaining)
+}
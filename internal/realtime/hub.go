@@ -0,0 +1,199 @@
+// Package realtime pushes events to the connected users.
+package realtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// subscriptionBuffer is the number of events a subscription can hold before being considered too slow.
+const subscriptionBuffer = 64
+
+// Event types.
+const (
+	// EventMessageCreated is the type of the event sent when a message is added to a chat.
+	EventMessageCreated = "message.created"
+	// EventChatCreated is the type of the event sent when a chat is created.
+	EventChatCreated = "chat.created"
+	// EventChatUpdated is the type of the event sent when the name or the participants of a chat change.
+	EventChatUpdated = "chat.updated"
+	// EventMessageUpdated is the type of the event sent when a message is edited.
+	EventMessageUpdated = "message.updated"
+	// EventMessageDeleted is the type of the event sent when a message is deleted.
+	EventMessageDeleted = "message.deleted"
+	// EventReceiptUpdated is the type of the event sent when messages are delivered to or read by a participant.
+	EventReceiptUpdated = "receipt.updated"
+)
+
+// ErrHubClosed is returned when subscribing to a closed hub.
+var ErrHubClosed = errors.New("hub closed")
+
+// Event is a notification pushed to the connected users.
+type Event struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+	// Position orders the events of all the users, like the global sequence number of a created message,
+	// it is not sent to the clients.
+	Position int64 `json:"-"`
+}
+
+// Hub dispatches the published events to the subscriptions of the users.
+type Hub struct {
+	mu            sync.Mutex
+	subscriptions map[string]map[*Subscription]struct{} // map[user]subscriptions
+	closed        bool
+	// active tracks the subscriptions not released yet, to wait for them on shutdown.
+	active sync.WaitGroup
+
+	logger *slog.Logger
+}
+
+// NewHub creates a new Hub.
+func NewHub() *Hub {
+	logger := slog.With(slog.String("component", "hub"))
+	logger.Info("created hub")
+
+	return &Hub{
+		subscriptions: make(map[string]map[*Subscription]struct{}),
+		logger:        logger,
+	}
+}
+
+// Subscription receives the events published to a user.
+type Subscription struct {
+	user   string
+	events chan Event
+	hub    *Hub
+	once   sync.Once
+}
+
+// Events returns the channel of the events published to the user.
+// The channel is closed when the hub is closed,
+// or when the subscription does not consume its events fast enough.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close releases the subscription.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.hub.unsubscribe(s)
+		s.hub.active.Done()
+	})
+}
+
+// Subscribe subscribes to the events published to the user.
+// The subscription must be closed when it is no longer used.
+func (h *Hub) Subscribe(user string) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, ErrHubClosed
+	}
+
+	sub := &Subscription{
+		user:   user,
+		events: make(chan Event, subscriptionBuffer),
+		hub:    h,
+	}
+
+	if _, exists := h.subscriptions[user]; !exists {
+		h.subscriptions[user] = make(map[*Subscription]struct{})
+	}
+
+	h.subscriptions[user][sub] = struct{}{}
+	h.active.Add(1)
+
+	h.logger.Debug("subscribed", slog.String("user", user))
+
+	return sub, nil
+}
+
+// unsubscribe removes the subscription from the hub and closes its events channel.
+func (h *Hub) unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(sub)
+}
+
+// remove removes the subscription, the caller must hold the lock.
+func (h *Hub) remove(sub *Subscription) {
+	subs, exists := h.subscriptions[sub.user]
+	if !exists {
+		return
+	}
+
+	if _, exists = subs[sub]; !exists {
+		return
+	}
+
+	delete(subs, sub)
+	close(sub.events)
+
+	if len(subs) == 0 {
+		delete(h.subscriptions, sub.user)
+	}
+
+	h.logger.Debug("unsubscribed", slog.String("user", sub.user))
+}
+
+// Publish sends the event to all subscriptions of the users.
+// It never blocks, a subscription too slow to receive the event is closed.
+func (h *Hub) Publish(users []string, event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	seen := make(map[string]bool, len(users))
+
+	for _, user := range users {
+		if seen[user] {
+			continue
+		}
+
+		seen[user] = true
+
+		for sub := range h.subscriptions[user] {
+			select {
+			case sub.events <- event:
+			default:
+				h.logger.Warn("subscription too slow, closing it", slog.String("user", user))
+				h.remove(sub)
+			}
+		}
+	}
+}
+
+// Shutdown closes all the subscriptions and waits for them to be released.
+// New subscriptions are refused once the shutdown started.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.closed = true
+
+	for _, subs := range h.subscriptions {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+	h.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		h.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		h.logger.Info("hub shutdown")
+
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to wait for subscriptions: %w", ctx.Err())
+	}
+}
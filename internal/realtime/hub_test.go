@@ -0,0 +1,77 @@
+package realtime
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_Publish(t *testing.T) {
+	hub := NewHub()
+
+	alice, err := hub.Subscribe("alice")
+	require.NoError(t, err)
+
+	defer alice.Close()
+
+	bob, err := hub.Subscribe("bob")
+	require.NoError(t, err)
+
+	defer bob.Close()
+
+	event := Event{Type: EventMessageCreated, Data: "hello"}
+
+	// a user listed twice receives the event once.
+	hub.Publish([]string{"alice", "alice", "carol"}, event)
+
+	require.Len(t, alice.Events(), 1)
+	assert.Equal(t, event, <-alice.Events())
+	assert.Empty(t, bob.Events())
+}
+
+func TestHub_Publish_SlowSubscription(t *testing.T) {
+	hub := NewHub()
+
+	sub, err := hub.Subscribe("alice")
+	require.NoError(t, err)
+
+	defer sub.Close()
+
+	for range subscriptionBuffer + 1 {
+		hub.Publish([]string{"alice"}, Event{Type: EventMessageCreated})
+	}
+
+	// the buffered events are still delivered before the channel is closed.
+	for range subscriptionBuffer {
+		_, ok := <-sub.Events()
+		require.True(t, ok)
+	}
+
+	_, ok := <-sub.Events()
+	require.False(t, ok)
+}
+
+func TestHub_Shutdown(t *testing.T) {
+	hub := NewHub()
+
+	sub, err := hub.Subscribe("alice")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// the subscription is not released yet.
+	require.ErrorIs(t, hub.Shutdown(ctx), context.DeadlineExceeded)
+
+	_, ok := <-sub.Events()
+	require.False(t, ok)
+
+	sub.Close()
+	require.NoError(t, hub.Shutdown(context.Background()))
+
+	_, err = hub.Subscribe("alice")
+	require.ErrorIs(t, err, ErrHubClosed)
+}
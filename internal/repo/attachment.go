@@ -0,0 +1,136 @@
+package repo
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Attachment is a file uploaded by a user to be attached to a message.
+// Its content is kept in a blob store, under its ID.
+type Attachment struct {
+	ID          string
+	Uploader    string
+	Filename    string
+	ContentType string
+	Size        int64
+	CreatedAt   time.Time
+	// ChatID and MessageID are empty until the attachment is attached to a message.
+	ChatID    string
+	MessageID string
+	// Image describes the attachment if it is an image with thumbnails, nil otherwise.
+	Image *Image
+}
+
+// Image describes an image attachment, its thumbnails are kept in the blob store along with it.
+type Image struct {
+	Width  int
+	Height int
+	// Thumbnails are the names of the sizes of its thumbnails.
+	Thumbnails []string
+}
+
+// Attached reports whether the attachment is attached to a message.
+func (a Attachment) Attached() bool {
+	return a.MessageID != ""
+}
+
+// AddAttachment adds the metadata of a file uploaded by the user, not attached to a message yet.
+// The image is nil if the file is not an image with thumbnails.
+func (repo *MessageRepository) AddAttachment(uploader, filename, contentType string, size int64, image *Image) (Attachment, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	attachment := Attachment{
+		ID:          uuid.NewString(),
+		Uploader:    uploader,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		CreatedAt:   time.Now().UTC().Truncate(time.Millisecond),
+		Image:       image,
+	}
+
+	repo.attachments[attachment.ID] = attachment
+
+	return attachment, nil
+}
+
+// GetAttachment returns the attachment with the given ID.
+func (repo *MessageRepository) GetAttachment(attachmentID string) (Attachment, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	attachment, ok := repo.attachments[attachmentID]
+	if !ok {
+		return Attachment{}, ErrAttachmentNotFound
+	}
+
+	return attachment, nil
+}
+
+// DeleteAttachment deletes an attachment not attached to a message yet.
+func (repo *MessageRepository) DeleteAttachment(attachmentID string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	attachment, ok := repo.attachments[attachmentID]
+	if !ok {
+		return ErrAttachmentNotFound
+	}
+
+	if attachment.Attached() {
+		return ErrAttachmentInUse
+	}
+
+	delete(repo.attachments, attachmentID)
+
+	return nil
+}
+
+// GetUnattachedAttachments returns the attachments not attached to a message, uploaded before the given time,
+// from the oldest to the newest.
+func (repo *MessageRepository) GetUnattachedAttachments(before time.Time) ([]Attachment, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	var attachments []Attachment
+
+	for _, attachment := range repo.attachments {
+		if !attachment.Attached() && attachment.CreatedAt.Before(before) {
+			attachments = append(attachments, attachment)
+		}
+	}
+
+	slices.SortFunc(attachments, func(a, b Attachment) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+
+	return attachments, nil
+}
+
+// getAttachable returns the attachments of the user that can be attached to a new message.
+// An attachment is attached once, even if it is given several times.
+func (repo *MessageRepository) getAttachable(user string, attachmentIDs []string) ([]Attachment, error) {
+	attachments := make([]Attachment, 0, len(attachmentIDs))
+	seen := make(map[string]bool, len(attachmentIDs))
+
+	for _, id := range attachmentIDs {
+		attachment, ok := repo.attachments[id]
+		if !ok || attachment.Uploader != user {
+			return nil, ErrAttachmentNotFound
+		}
+
+		if attachment.Attached() || seen[id] {
+			return nil, ErrAttachmentInUse
+		}
+
+		seen[id] = true
+
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, nil
+}
@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRepository_Attachments(t *testing.T) {
+	messageRepo := NewMessageRepository()
+
+	image, err := messageRepo.AddAttachment("1", "cat.png", "image/png", 42, nil)
+	require.NoError(t, err)
+	assert.False(t, image.Attached())
+
+	doc, err := messageRepo.AddAttachment("1", "notes.txt", "text/plain; charset=utf-8", 12, nil)
+	require.NoError(t, err)
+
+	other, err := messageRepo.AddAttachment("2", "dog.png", "image/png", 24, nil)
+	require.NoError(t, err)
+
+	got, err := messageRepo.GetAttachment(image.ID)
+	require.NoError(t, err)
+	assert.Equal(t, image, got)
+
+	photo, err := messageRepo.AddAttachment("1", "photo.jpg", "image/jpeg", 2048,
+		&Image{Width: 800, Height: 600, Thumbnails: []string{"small", "medium"}})
+	require.NoError(t, err)
+
+	got, err = messageRepo.GetAttachment(photo.ID)
+	require.NoError(t, err)
+	assert.Equal(t, photo, got)
+
+	// the attachments must be unattached attachments of the sender.
+	_, err = messageRepo.AddMessageWithOptions("chat", "1", "Look", MessageOptions{Attachments: []string{image.ID, other.ID}})
+	require.ErrorIs(t, err, ErrAttachmentNotFound)
+
+	_, err = messageRepo.AddMessageWithOptions("chat", "1", "Look", MessageOptions{Attachments: []string{"unknown"}})
+	require.ErrorIs(t, err, ErrAttachmentNotFound)
+
+	_, err = messageRepo.AddMessageWithOptions("chat", "1", "Look", MessageOptions{Attachments: []string{image.ID, image.ID}})
+	require.ErrorIs(t, err, ErrAttachmentInUse)
+
+	message, err := messageRepo.AddMessageWithOptions("chat", "1", "Look", MessageOptions{Attachments: []string{doc.ID, image.ID}})
+	require.NoError(t, err)
+	require.Len(t, message.Attachments, 2)
+	assert.Equal(t, doc.ID, message.Attachments[0].ID)
+	assert.Equal(t, image.ID, message.Attachments[1].ID)
+
+	got, err = messageRepo.GetAttachment(image.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "chat", got.ChatID)
+	assert.Equal(t, message.ID, got.MessageID)
+
+	messages, err := messageRepo.GetChatMessages("chat", MessageQuery{})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, message.Attachments, messages[0].Attachments)
+
+	_, err = messageRepo.AddMessageWithOptions("chat", "1", "Again", MessageOptions{Attachments: []string{image.ID}})
+	require.ErrorIs(t, err, ErrAttachmentInUse)
+	require.ErrorIs(t, messageRepo.DeleteAttachment(image.ID), ErrAttachmentInUse)
+
+	// the attachments left unattached, uploaded before the time.
+	unattached, err := messageRepo.GetUnattachedAttachments(time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []Attachment{other, photo}, unattached)
+
+	unattached, err = messageRepo.GetUnattachedAttachments(other.CreatedAt)
+	require.NoError(t, err)
+	assert.Empty(t, unattached)
+
+	require.NoError(t, messageRepo.DeleteAttachment(other.ID))
+	_, err = messageRepo.GetAttachment(other.ID)
+	require.ErrorIs(t, err, ErrAttachmentNotFound)
+	require.ErrorIs(t, messageRepo.DeleteAttachment(other.ID), ErrAttachmentNotFound)
+
+	// the attachments of a deleted message are erased.
+	deleted, err := messageRepo.DeleteMessage("chat", message.ID)
+	require.NoError(t, err)
+	assert.Empty(t, deleted.Attachments)
+
+	_, err = messageRepo.GetAttachment(image.ID)
+	require.ErrorIs(t, err, ErrAttachmentNotFound)
+}
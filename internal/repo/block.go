@@ -0,0 +1,98 @@
+package repo
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Block is a user blocked by another one.
+// The blocked user's direct messages to the blocker are only visible to their sender,
+// and the blocked user can't add the blocker to a group chat.
+type Block struct {
+	Blocker   string
+	Blocked   string
+	CreatedAt time.Time
+}
+
+// AddBlock blocks a user for the blocker, nothing changes if the user is already blocked.
+func (r *ChatRepository) AddBlock(blocker, blocked string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.blocks[blocker][blocked]; ok {
+		return nil
+	}
+
+	if r.blocks[blocker] == nil {
+		r.blocks[blocker] = make(map[string]Block)
+	}
+
+	r.blocks[blocker][blocked] = Block{Blocker: blocker, Blocked: blocked, CreatedAt: time.Now().UTC().Truncate(time.Millisecond)}
+
+	return nil
+}
+
+// RemoveBlock unblocks a user for the blocker.
+// It returns ErrBlockNotFound if the user is not blocked.
+func (r *ChatRepository) RemoveBlock(blocker, blocked string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.blocks[blocker][blocked]; !ok {
+		return ErrBlockNotFound
+	}
+
+	delete(r.blocks[blocker], blocked)
+
+	return nil
+}
+
+// GetBlocks gets the users blocked by the blocker, in the order they have been blocked.
+func (r *ChatRepository) GetBlocks(blocker string) ([]Block, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	blocks := make([]Block, 0, len(r.blocks[blocker]))
+	for _, block := range r.blocks[blocker] {
+		blocks = append(blocks, block)
+	}
+
+	slices.SortFunc(blocks, func(a, b Block) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.Blocked, b.Blocked))
+	})
+
+	return blocks, nil
+}
+
+// GetBlockers gets the users, among the given ones, who blocked the user.
+func (r *ChatRepository) GetBlockers(user string, users []string) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	blockers := []string{}
+
+	for _, other := range users {
+		if _, ok := r.blocks[other][user]; ok && !slices.Contains(blockers, other) {
+			blockers = append(blockers, other)
+		}
+	}
+
+	return blockers, nil
+}
+
+// hasBlocked reports whether the user blocked the other participant of the direct chat.
+func (r *ChatRepository) hasBlocked(user string, chat *Chat) bool {
+	if chat.Group {
+		return false
+	}
+
+	for _, participant := range chat.Participants {
+		if _, ok := r.blocks[user][participant]; ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -3,17 +3,29 @@ package repo
 
 import (
 	"log/slog"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-// Chat represents a chat between 2 users.
+// Chat represents a chat, either a direct chat between 2 users or a named group chat.
 type Chat struct {
-	ID           string
+	ID string
+	// Name is the name of a group chat, empty for a direct chat.
+	Name         string
+	Group        bool
 	Participants []string // user IDs
-	CreatedAt    time.Time
+	// Admins are the participants managing a group chat, in the participants order.
+	Admins    []string
+	CreatedAt time.Time
+}
+
+// IsAdmin reports whether the user is an admin of the chat.
+func (c Chat) IsAdmin(user string) bool {
+	return slices.Contains(c.Admins, user)
 }
 
 // ChatRepository manages chat storage and operations
@@ -23,26 +35,36 @@ type ChatRepository struct {
 	mu          sync.RWMutex
 	chats       map[string]map[string]*Chat // map[user][user2]Chat
 	chatsByUser map[string][]*Chat
+	chatsByID   map[string]*Chat
+	receipts    map[string]map[string]Receipt // map[chatID][user]Receipt
+	blocks      map[string]map[string]Block   // map[blocker][blocked]Block
+	// messages are the messages of the chats, to list the chats with their activity.
+	messages *MessageRepository
 
 	logger *slog.Logger
 }
 
-// NewChatRepository initializes a new ChatRepository.
-func NewChatRepository() *ChatRepository {
+// NewChatRepository initializes a new ChatRepository, the messages of its chats are stored in the messages repository.
+func NewChatRepository(messages *MessageRepository) *ChatRepository {
 	logger := slog.With(slog.String("repo", "chat"))
 	logger.Info("created repository")
 
 	return &ChatRepository{
 		chats:       make(map[string]map[string]*Chat),
 		chatsByUser: make(map[string][]*Chat),
+		chatsByID:   make(map[string]*Chat),
+		receipts:    make(map[string]map[string]Receipt),
+		blocks:      make(map[string]map[string]Block),
+		messages:    messages,
 		logger:      logger,
 	}
 }
 
 // GetOrCreateChat gets a chat from the repository.
+// It reports whether the chat has been created.
 // TODO: refactor to avoid doing 2 things in one function.
 // This is a very naive approach to chat management.
-func (r *ChatRepository) GetOrCreateChat(sender, receiver string) (string, error) {
+func (r *ChatRepository) GetOrCreateChat(sender, receiver string) (Chat, bool, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -55,41 +77,290 @@ func (r *ChatRepository) GetOrCreateChat(sender, receiver string) (string, error
 	}
 
 	chat, exists := r.chats[sender][receiver]
-	if !exists || chat == nil {
-		chat = &Chat{
-			ID:           uuid.NewString(),
-			Participants: []string{sender, receiver},
-			CreatedAt:    time.Now().UTC().Truncate(time.Millisecond),
+	if exists && chat != nil {
+		return *chat, false, nil
+	}
+
+	chat = &Chat{
+		ID:           uuid.NewString(),
+		Participants: []string{sender, receiver},
+		CreatedAt:    time.Now().UTC().Truncate(time.Millisecond),
+	}
+
+	// add chat to sender and receiver to retrieve all chat for a user
+	r.chats[sender][receiver] = chat
+	r.chats[receiver][sender] = chat
+
+	// Add to `chatsByUser`
+	r.chatsByUser[sender] = append(r.chatsByUser[sender], chat)
+	r.chatsByUser[receiver] = append(r.chatsByUser[receiver], chat)
+
+	r.chatsByID[chat.ID] = chat
+
+	return *chat, true, nil
+}
+
+// CreateGroupChat creates a named group chat with the members, in their joining order.
+// The first member, its creator, is the admin of the chat, the members can't be empty.
+func (r *ChatRepository) CreateGroupChat(name string, members []string) (Chat, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat := &Chat{
+		ID:           uuid.NewString(),
+		Name:         name,
+		Group:        true,
+		Participants: slices.Clone(members),
+		Admins:       []string{members[0]},
+		CreatedAt:    time.Now().UTC().Truncate(time.Millisecond),
+	}
+
+	for _, member := range chat.Participants {
+		r.chatsByUser[member] = append(r.chatsByUser[member], chat)
+	}
+
+	r.chatsByID[chat.ID] = chat
+
+	return *chat, nil
+}
+
+// GetChat gets a chat by its ID.
+func (r *ChatRepository) GetChat(chatID string) (Chat, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	chat, exists := r.chatsByID[chatID]
+	if !exists {
+		return Chat{}, ErrChatNotFound
+	}
+
+	return *chat, nil
+}
+
+// getGroupChat gets a group chat by its ID, for an update.
+func (r *ChatRepository) getGroupChat(chatID string) (*Chat, error) {
+	chat, exists := r.chatsByID[chatID]
+	if !exists {
+		return nil, ErrChatNotFound
+	}
+
+	if !chat.Group {
+		return nil, ErrNotGroupChat
+	}
+
+	return chat, nil
+}
+
+// AddParticipants adds the users to the group chat, as members.
+// The users already participating are ignored, the added ones are returned with the updated chat.
+func (r *ChatRepository) AddParticipants(chatID string, users []string) (Chat, []string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat, err := r.getGroupChat(chatID)
+	if err != nil {
+		return Chat{}, nil, err
+	}
+
+	var added []string
+
+	// the slices are replaced instead of updated, they are shared with the returned chats.
+	participants := slices.Clone(chat.Participants)
+
+	for _, user := range users {
+		if slices.Contains(participants, user) {
+			continue
+		}
+
+		participants = append(participants, user)
+		added = append(added, user)
+		r.chatsByUser[user] = append(r.chatsByUser[user], chat)
+	}
+
+	chat.Participants = participants
+
+	return *chat, added, nil
+}
+
+// RemoveParticipant removes the user from the group chat.
+// When the last admin is removed, the oldest participant becomes an admin and is returned with the updated chat.
+func (r *ChatRepository) RemoveParticipant(chatID, user string) (Chat, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat, err := r.getGroupChat(chatID)
+	if err != nil {
+		return Chat{}, "", err
+	}
+
+	if !slices.Contains(chat.Participants, user) {
+		return Chat{}, "", ErrNotParticipant
+	}
+
+	isUser := func(participant string) bool { return participant == user }
+
+	chat.Participants = slices.DeleteFunc(slices.Clone(chat.Participants), isUser)
+	chat.Admins = slices.DeleteFunc(slices.Clone(chat.Admins), isUser)
+	r.chatsByUser[user] = slices.DeleteFunc(r.chatsByUser[user], func(c *Chat) bool { return c.ID == chatID })
+	delete(r.receipts[chatID], user)
+
+	var promoted string
+
+	if len(chat.Admins) == 0 && len(chat.Participants) > 0 {
+		promoted = chat.Participants[0]
+		chat.Admins = []string{promoted}
+	}
+
+	return *chat, promoted, nil
+}
+
+// SetAdmin promotes the participant to admin of the group chat, or demotes them to member.
+// The last admin can't be demoted.
+func (r *ChatRepository) SetAdmin(chatID, user string, admin bool) (Chat, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat, err := r.getGroupChat(chatID)
+	if err != nil {
+		return Chat{}, err
+	}
+
+	if !slices.Contains(chat.Participants, user) {
+		return Chat{}, ErrNotParticipant
+	}
+
+	if !admin && chat.IsAdmin(user) && len(chat.Admins) == 1 {
+		return Chat{}, ErrLastAdmin
+	}
+
+	// keep the admins in the participants order.
+	admins := make([]string, 0, len(chat.Admins)+1)
+
+	for _, participant := range chat.Participants {
+		if (participant == user && admin) || (participant != user && chat.IsAdmin(participant)) {
+			admins = append(admins, participant)
 		}
+	}
+
+	chat.Admins = admins
+
+	return *chat, nil
+}
+
+// RenameChat renames the group chat.
+func (r *ChatRepository) RenameChat(chatID, name string) (Chat, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat, err := r.getGroupChat(chatID)
+	if err != nil {
+		return Chat{}, err
+	}
 
-		// add chat to sender and receiver to retrieve all chat for a user
-		r.chats[sender][receiver] = chat
-		r.chats[receiver][sender] = chat
+	chat.Name = name
 
-		// Add to `chatsByUser`
-		r.chatsByUser[sender] = append(r.chatsByUser[sender], chat)
-		r.chatsByUser[receiver] = append(r.chatsByUser[receiver], chat)
+	return *chat, nil
+}
+
+// UserChat is a chat listed for one of its participants.
+type UserChat struct {
+	Chat
+	// LastMessage is the last message of the chat, nil if the chat has no message.
+	LastMessage *Message
+	// Unread is the number of messages of the other participants not read by the user yet.
+	Unread int64
+}
+
+// LastActivityAt returns the time of the last message of the chat, or its creation time if it has no message.
+func (c UserChat) LastActivityAt() time.Time {
+	if c.LastMessage != nil {
+		return c.LastMessage.CreatedAt
+	}
+
+	return c.CreatedAt
+}
+
+// ChatPosition is the position of a chat in the chats of a user.
+type ChatPosition struct {
+	LastActivityAt time.Time
+	ID             string
+}
+
+// Position returns the position of the chat in the chats of its users.
+func (c UserChat) Position() ChatPosition {
+	return ChatPosition{LastActivityAt: c.LastActivityAt(), ID: c.ID}
+}
+
+// Compare returns -1 if p is before o, 1 if p is after o, 0 if they are equal.
+func (p ChatPosition) Compare(o ChatPosition) int {
+	if c := p.LastActivityAt.Compare(o.LastActivityAt); c != 0 {
+		return c
 	}
 
-	return chat.ID, nil
+	return strings.Compare(p.ID, o.ID)
+}
+
+// ChatQuery selects the chats of a user.
+// The chats are always sorted from the most recently active to the least recently active.
+type ChatQuery struct {
+	// Before keeps the chats less recently active than this position, if set.
+	Before *ChatPosition
+	// After keeps the chats more recently active than this position, if set.
+	After *ChatPosition
+	// Limit is the maximum number of chats, 0 for no limit.
+	// When only After is set, the chats right after this position are kept,
+	// otherwise the most recently active chats are kept.
+	Limit int
 }
 
-// GetUserChats gets all chats for a user.
-// TODO:
-// * add pagination. (more appropriate for a database)
-// * return a DTO instead of the entity.
-func (r *ChatRepository) GetUserChats(user string) ([]Chat, error) {
+// Forward reports whether the limited chats are the first ones after the After position,
+// instead of the most recently active ones.
+func (q ChatQuery) Forward() bool {
+	return q.After != nil && q.Before == nil
+}
+
+// GetUserChats gets the chats of a user matching the query, with their last message
+// and the number of messages the user has not read.
+// The direct chats with a user blocked by the user are hidden.
+func (r *ChatRepository) GetUserChats(user string, query ChatQuery) ([]UserChat, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	if _, exists := r.chatsByUser[user]; !exists {
-		return []Chat{}, nil
+	result := []UserChat{}
+
+	for _, chat := range r.chatsByUser[user] {
+		if r.hasBlocked(user, chat) {
+			continue
+		}
+
+		// dereference the chats
+		userChat := UserChat{Chat: *chat}
+		if last, ok := r.messages.lastMessage(chat.ID, user); ok {
+			userChat.LastMessage = &last
+		}
+
+		if query.Before != nil && userChat.Position().Compare(*query.Before) >= 0 {
+			continue
+		}
+
+		if query.After != nil && userChat.Position().Compare(*query.After) <= 0 {
+			continue
+		}
+
+		userChat.Unread = r.messages.countUnread(chat.ID, user, r.receipts[chat.ID][user].ReadSeq)
+		result = append(result, userChat)
 	}
 
-	// dereference the chats
-	result := make([]Chat, len(r.chatsByUser[user]))
-	for i, chat := range r.chatsByUser[user] {
-		result[i] = *chat
+	slices.SortFunc(result, func(a, b UserChat) int {
+		return b.Position().Compare(a.Position())
+	})
+
+	if query.Limit > 0 && len(result) > query.Limit {
+		if query.Forward() {
+			result = result[len(result)-query.Limit:]
+		} else {
+			result = result[:query.Limit]
+		}
 	}
 
 	r.logger.Debug("get user chats",
@@ -0,0 +1,264 @@
+package repo
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChatRepository_GetOrCreateChat(t *testing.T) {
+	chatRepo := NewChatRepository(NewMessageRepository())
+
+	chat, created, err := chatRepo.GetOrCreateChat("123", "456")
+	require.NoError(t, err)
+	require.True(t, created)
+	assert.Equal(t, []string{"123", "456"}, chat.Participants)
+
+	// the chat is shared whatever the order of the users.
+	sameChat, created, err := chatRepo.GetOrCreateChat("456", "123")
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, chat, sameChat)
+
+	got, err := chatRepo.GetChat(chat.ID)
+	require.NoError(t, err)
+	assert.Equal(t, chat, got)
+
+	_, err = chatRepo.GetChat("unknown")
+	require.ErrorIs(t, err, ErrChatNotFound)
+}
+
+func TestChatRepository_CreateGroupChat(t *testing.T) {
+	chatRepo := NewChatRepository(NewMessageRepository())
+
+	direct, _, err := chatRepo.GetOrCreateChat("123", "456")
+	require.NoError(t, err)
+	assert.False(t, direct.Group)
+	assert.Empty(t, direct.Name)
+
+	group, err := chatRepo.CreateGroupChat("friends", []string{"123", "456", "789"})
+	require.NoError(t, err)
+	assert.True(t, group.Group)
+	assert.Equal(t, "friends", group.Name)
+	assert.Equal(t, []string{"123", "456", "789"}, group.Participants)
+
+	got, err := chatRepo.GetChat(group.ID)
+	require.NoError(t, err)
+	assert.Equal(t, group, got)
+
+	// the group does not replace the direct chat of its members.
+	sameChat, created, err := chatRepo.GetOrCreateChat("456", "123")
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, direct.ID, sameChat.ID)
+
+	// every member sees the group.
+	for _, member := range group.Participants {
+		chats, err := chatRepo.GetUserChats(member, ChatQuery{})
+		require.NoError(t, err)
+		assert.Contains(t, chats, UserChat{Chat: group})
+	}
+}
+
+func TestChatRepository_GetUserChats(t *testing.T) {
+	chatRepo := NewChatRepository(NewMessageRepository())
+
+	var chats []UserChat
+
+	for i := range 4 {
+		chat, _, err := chatRepo.GetOrCreateChat("123", strconv.Itoa(i))
+		require.NoError(t, err)
+
+		chats = append(chats, UserChat{Chat: chat})
+	}
+
+	// the chats are sorted from the most recent to the oldest.
+	slices.SortFunc(chats, func(a, b UserChat) int {
+		return b.Position().Compare(a.Position())
+	})
+
+	testCases := []struct {
+		desc     string
+		query    ChatQuery
+		expected []UserChat
+	}{
+		{
+			desc:     "all",
+			query:    ChatQuery{},
+			expected: chats,
+		},
+		{
+			desc:     "most recent",
+			query:    ChatQuery{Limit: 2},
+			expected: chats[:2],
+		},
+		{
+			desc:     "before",
+			query:    ChatQuery{Before: ptr(chats[0].Position()), Limit: 2},
+			expected: chats[1:3],
+		},
+		{
+			desc:     "after",
+			query:    ChatQuery{After: ptr(chats[3].Position()), Limit: 2},
+			expected: chats[1:3],
+		},
+		{
+			desc:     "after the most recent",
+			query:    ChatQuery{After: ptr(chats[0].Position())},
+			expected: []UserChat{},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := chatRepo.GetUserChats("123", test.query)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, got)
+		})
+	}
+
+	got, err := chatRepo.GetUserChats("unknown", ChatQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestChatRepository_GetUserChats_Activity(t *testing.T) {
+	messageRepo := NewMessageRepository()
+	chatRepo := NewChatRepository(messageRepo)
+
+	older, _, err := chatRepo.GetOrCreateChat("123", "456")
+	require.NoError(t, err)
+
+	time.Sleep(time.Millisecond)
+
+	newer, _, err := chatRepo.GetOrCreateChat("123", "789")
+	require.NoError(t, err)
+
+	chats, err := chatRepo.GetUserChats("123", ChatQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []UserChat{{Chat: newer}, {Chat: older}}, chats)
+
+	// a new message makes the older chat the most recently active one.
+	time.Sleep(time.Millisecond)
+
+	_, err = messageRepo.AddMessage(older.ID, "123", "Hello")
+	require.NoError(t, err)
+
+	_, err = messageRepo.AddMessage(older.ID, "456", "Hi")
+	require.NoError(t, err)
+
+	last, err := messageRepo.AddMessage(older.ID, "456", "How are you?")
+	require.NoError(t, err)
+
+	chats, err = chatRepo.GetUserChats("123", ChatQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []UserChat{{Chat: older, LastMessage: &last, Unread: 2}, {Chat: newer}}, chats)
+	assert.Equal(t, last.CreatedAt, chats[0].LastActivityAt())
+	assert.Equal(t, newer.CreatedAt, chats[1].LastActivityAt())
+
+	// the messages read and the messages of the user are not unread.
+	_, _, err = chatRepo.MarkRead(older.ID, "123", 2)
+	require.NoError(t, err)
+
+	chats, err = chatRepo.GetUserChats("123", ChatQuery{Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), chats[0].Unread)
+
+	chats, err = chatRepo.GetUserChats("456", ChatQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), chats[0].Unread)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestChatRepository_Membership(t *testing.T) {
+	chatRepo := NewChatRepository(NewMessageRepository())
+
+	chat, err := chatRepo.CreateGroupChat("friends", []string{"1", "2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1"}, chat.Admins)
+	assert.True(t, chat.IsAdmin("1"))
+	assert.False(t, chat.IsAdmin("2"))
+
+	// the participants are ignored.
+	updated, added, err := chatRepo.AddParticipants(chat.ID, []string{"2", "3", "4"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"3", "4"}, added)
+	assert.Equal(t, []string{"1", "2", "3", "4"}, updated.Participants)
+
+	// the returned chats are not updated afterward.
+	assert.Equal(t, []string{"1", "2"}, chat.Participants)
+
+	chats, err := chatRepo.GetUserChats("4", ChatQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []UserChat{{Chat: updated}}, chats)
+
+	updated, err = chatRepo.SetAdmin(chat.ID, "3", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "3"}, updated.Admins)
+
+	updated, err = chatRepo.SetAdmin(chat.ID, "1", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"3"}, updated.Admins)
+
+	_, err = chatRepo.SetAdmin(chat.ID, "3", false)
+	require.ErrorIs(t, err, ErrLastAdmin)
+
+	_, err = chatRepo.SetAdmin(chat.ID, "5", true)
+	require.ErrorIs(t, err, ErrNotParticipant)
+
+	// removing the last admin promotes the oldest participant.
+	updated, promoted, err := chatRepo.RemoveParticipant(chat.ID, "3")
+	require.NoError(t, err)
+	assert.Equal(t, "1", promoted)
+	assert.Equal(t, []string{"1", "2", "4"}, updated.Participants)
+	assert.Equal(t, []string{"1"}, updated.Admins)
+
+	chats, err = chatRepo.GetUserChats("3", ChatQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, chats)
+
+	updated, promoted, err = chatRepo.RemoveParticipant(chat.ID, "2")
+	require.NoError(t, err)
+	assert.Empty(t, promoted)
+	assert.Equal(t, []string{"1", "4"}, updated.Participants)
+
+	_, _, err = chatRepo.RemoveParticipant(chat.ID, "2")
+	require.ErrorIs(t, err, ErrNotParticipant)
+
+	updated, err = chatRepo.RenameChat(chat.ID, "best friends")
+	require.NoError(t, err)
+	assert.Equal(t, "best friends", updated.Name)
+
+	got, err := chatRepo.GetChat(chat.ID)
+	require.NoError(t, err)
+	assert.Equal(t, updated, got)
+}
+
+func TestChatRepository_Membership_Errors(t *testing.T) {
+	chatRepo := NewChatRepository(NewMessageRepository())
+
+	direct, _, err := chatRepo.GetOrCreateChat("1", "2")
+	require.NoError(t, err)
+
+	_, _, err = chatRepo.AddParticipants(direct.ID, []string{"3"})
+	require.ErrorIs(t, err, ErrNotGroupChat)
+
+	_, _, err = chatRepo.RemoveParticipant(direct.ID, "2")
+	require.ErrorIs(t, err, ErrNotGroupChat)
+
+	_, err = chatRepo.SetAdmin(direct.ID, "2", true)
+	require.ErrorIs(t, err, ErrNotGroupChat)
+
+	_, err = chatRepo.RenameChat(direct.ID, "name")
+	require.ErrorIs(t, err, ErrNotGroupChat)
+
+	_, err = chatRepo.RenameChat("unknown", "name")
+	require.ErrorIs(t, err, ErrChatNotFound)
+}
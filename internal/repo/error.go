@@ -2,5 +2,31 @@ package repo
 
 import "errors"
 
-// ErrPhoneNumberAlreadyRegistered is returned when the phone number is already registered.
-var ErrPhoneNumberAlreadyRegistered = errors.New("phone number already registered")
+var (
+	// ErrPhoneNumberAlreadyRegistered is returned when the phone number is already registered.
+	ErrPhoneNumberAlreadyRegistered = errors.New("phone number already registered")
+	// ErrChatNotFound is returned when the chat does not exist.
+	ErrChatNotFound = errors.New("chat not found")
+	// ErrNotGroupChat is returned when managing the participants of a direct chat.
+	ErrNotGroupChat = errors.New("chat is not a group chat")
+	// ErrNotParticipant is returned when the user is not a participant of the chat.
+	ErrNotParticipant = errors.New("user is not a participant of the chat")
+	// ErrLastAdmin is returned when demoting the last admin of a group chat.
+	ErrLastAdmin = errors.New("a group chat needs an admin")
+	// ErrMessageNotFound is returned when the message does not exist in the chat.
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrMessageDeleted is returned when changing a deleted message.
+	ErrMessageDeleted = errors.New("message is deleted")
+	// ErrReplyToNotFound is returned when the replied message does not exist in the chat.
+	ErrReplyToNotFound = errors.New("replied message not found in the chat")
+	// ErrAttachmentNotFound is returned when the attachment does not exist, or is not one of the user.
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	// ErrAttachmentInUse is returned when attaching an attachment already attached to a message.
+	ErrAttachmentInUse = errors.New("attachment already attached to a message")
+	// ErrBlockNotFound is returned when unblocking a user who is not blocked.
+	ErrBlockNotFound = errors.New("user not blocked")
+	// ErrIdempotencyKeyExists is returned when adding an idempotency key already used by a request which has not expired.
+	ErrIdempotencyKeyExists = errors.New("idempotency key already exists")
+	// ErrIdempotencyKeyNotFound is returned when completing the request of an idempotency key without record.
+	ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+)
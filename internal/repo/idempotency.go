@@ -0,0 +1,116 @@
+package repo
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// idempotencyPruneInterval is the minimum interval between two removals of the expired idempotency keys.
+const idempotencyPruneInterval = time.Minute
+
+// IdempotencyRecord is a request sent with an idempotency key, and its response once completed.
+// A request retried with the same key gets the same response, without being executed again.
+type IdempotencyRecord struct {
+	Key string
+	// Fingerprint identifies the request, a retry must have the same one.
+	Fingerprint string
+	// Response is the response of the request, its status is 0 while the request is in progress.
+	Response  IdempotencyResponse
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// IdempotencyResponse is the response of a request sent with an idempotency key.
+type IdempotencyResponse struct {
+	Status      int
+	ContentType string
+	Location    string
+	Body        []byte
+	// Withheld is set when the body holds secrets, like an access token, it is not stored and can't be replayed.
+	Withheld bool
+}
+
+// IdempotencyRepository manages the idempotency keys of the requests.
+// In-memory store for simplicity.
+type IdempotencyRepository struct {
+	mu       sync.Mutex
+	records  map[string]IdempotencyRecord
+	prunedAt time.Time
+
+	logger *slog.Logger
+}
+
+// NewIdempotencyRepository initializes a new IdempotencyRepository.
+func NewIdempotencyRepository() *IdempotencyRepository {
+	logger := slog.With(slog.String("repo", "idempotency"))
+	logger.Info("created repository")
+
+	return &IdempotencyRepository{
+		records: make(map[string]IdempotencyRecord),
+		logger:  logger,
+	}
+}
+
+// AddIdempotencyKey saves the record of a new request, without response.
+// If the key has a record which has not expired, it returns it with ErrIdempotencyKeyExists.
+func (r *IdempotencyRepository) AddIdempotencyKey(record IdempotencyRecord) (IdempotencyRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	r.prune(now)
+
+	if existing, ok := r.records[record.Key]; ok && existing.ExpiresAt.After(now) {
+		return existing, ErrIdempotencyKeyExists
+	}
+
+	record.CreatedAt = now.Truncate(time.Millisecond)
+	record.Response = IdempotencyResponse{}
+	r.records[record.Key] = record
+
+	return record, nil
+}
+
+// CompleteIdempotencyKey saves the response of the request of the key.
+// It returns ErrIdempotencyKeyNotFound if the key has no record.
+func (r *IdempotencyRepository) CompleteIdempotencyKey(key string, response IdempotencyResponse) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.records[key]
+	if !ok {
+		return ErrIdempotencyKeyNotFound
+	}
+
+	record.Response = response
+	r.records[key] = record
+
+	return nil
+}
+
+// RemoveIdempotencyKey removes the record of the key, so its request can be sent again.
+// Nothing changes if the key has no record.
+func (r *IdempotencyRepository) RemoveIdempotencyKey(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.records, key)
+
+	return nil
+}
+
+// prune removes the expired records, at most once per idempotencyPruneInterval.
+func (r *IdempotencyRepository) prune(now time.Time) {
+	if now.Sub(r.prunedAt) < idempotencyPruneInterval {
+		return
+	}
+
+	r.prunedAt = now
+
+	for key, record := range r.records {
+		if !record.ExpiresAt.After(now) {
+			delete(r.records, key)
+		}
+	}
+}
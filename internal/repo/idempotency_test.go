@@ -0,0 +1,48 @@
+package repo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyRepository(t *testing.T) {
+	idempotencyRepo := NewIdempotencyRepository()
+
+	expiresAt := time.Now().UTC().Add(time.Hour).Truncate(time.Millisecond)
+
+	added, err := idempotencyRepo.AddIdempotencyKey(IdempotencyRecord{Key: "1", Fingerprint: "a", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	assert.False(t, added.CreatedAt.IsZero())
+
+	// the request is in progress.
+	record, err := idempotencyRepo.AddIdempotencyKey(IdempotencyRecord{Key: "1", Fingerprint: "b", ExpiresAt: expiresAt})
+	require.ErrorIs(t, err, ErrIdempotencyKeyExists)
+	assert.Equal(t, added, record)
+
+	response := IdempotencyResponse{Status: 201, ContentType: "application/json", Location: "/here", Body: []byte(`{}`)}
+	require.NoError(t, idempotencyRepo.CompleteIdempotencyKey("1", response))
+	require.ErrorIs(t, idempotencyRepo.CompleteIdempotencyKey("2", response), ErrIdempotencyKeyNotFound)
+
+	record, err = idempotencyRepo.AddIdempotencyKey(IdempotencyRecord{Key: "1", Fingerprint: "a", ExpiresAt: expiresAt})
+	require.ErrorIs(t, err, ErrIdempotencyKeyExists)
+	assert.Equal(t, IdempotencyRecord{
+		Key: "1", Fingerprint: "a", Response: response, CreatedAt: added.CreatedAt, ExpiresAt: expiresAt,
+	}, record)
+
+	// a removed key can be used again.
+	require.NoError(t, idempotencyRepo.RemoveIdempotencyKey("1"))
+	require.NoError(t, idempotencyRepo.RemoveIdempotencyKey("1"))
+
+	_, err = idempotencyRepo.AddIdempotencyKey(IdempotencyRecord{Key: "1", Fingerprint: "b", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+
+	// an expired key can be used again.
+	_, err = idempotencyRepo.AddIdempotencyKey(IdempotencyRecord{Key: "2", Fingerprint: "a", ExpiresAt: time.Now().UTC()})
+	require.NoError(t, err)
+
+	_, err = idempotencyRepo.AddIdempotencyKey(IdempotencyRecord{Key: "2", Fingerprint: "b", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+}
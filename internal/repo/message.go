@@ -3,15 +3,88 @@ package repo
 
 import (
 	"log/slog"
+	"slices"
 	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/jbdoumenjou/mychat/internal/search"
 )
 
+// Message represents a message sent by a user in a chat.
+type Message struct {
+	ID        string
+	ChatID    string
+	Sender    string // user ID
+	Content   string
+	CreatedAt time.Time
+	// Seq is the position of the message in its chat, starting at 1.
+	Seq int64
+	// GlobalSeq is the position of the message in all the messages, starting at 1.
+	// It orders the messages of different chats, even if they are created in the same millisecond.
+	GlobalSeq int64
+	// System reports whether the message records an event of the chat, like a new participant,
+	// the sender being the user at the origin of the event.
+	System bool
+	// EditedAt is the time of the last edit of the message, zero if it has never been edited.
+	EditedAt time.Time
+	// DeletedAt is the time the message has been deleted, zero if it is not deleted.
+	// A deleted message stays in its chat as a tombstone, without content.
+	DeletedAt time.Time
+	// ReplyTo is the ID of the message of the chat this message replies to, empty if it is not a reply.
+	ReplyTo string
+	// ReplyCount is the number of replies to the message, its thread.
+	ReplyCount int64
+	// Attachments are the files attached to the message, in the order they have been given.
+	Attachments []Attachment
+	// Blocked reports whether the receiver of the direct message had blocked its sender,
+	// the message is only visible to its sender.
+	Blocked bool
+}
+
+// VisibleTo reports whether the message is visible to the user.
+func (m Message) VisibleTo(user string) bool {
+	return !m.Blocked || m.Sender == user
+}
+
+// Deleted reports whether the message has been deleted.
+func (m Message) Deleted() bool {
+	return !m.DeletedAt.IsZero()
+}
+
+// Version returns the current version of the message.
+func (m Message) Version() MessageVersion {
+	createdAt := m.CreatedAt
+	if !m.EditedAt.IsZero() {
+		createdAt = m.EditedAt
+	}
+
+	return MessageVersion{Content: m.Content, CreatedAt: createdAt}
+}
+
+// MessageVersion is a content of a message, the original one or an edited one.
+type MessageVersion struct {
+	Content string
+	// CreatedAt is the time the content has been written.
+	CreatedAt time.Time
+}
+
 // MessageRepository manages user storage and operations
 // In-memory store for simplicity
 // TODO: use a database instead.
 type MessageRepository struct {
 	mu       sync.RWMutex
-	messages map[string][]string // map[chatID][message1, message2, message3]
+	messages map[string][]Message        // map[chatID][message1, message2, message3]
+	versions map[string][]MessageVersion // map[messageID][previous versions]
+	// reactions are kept in the order they have been added.
+	reactions   map[string][]Reaction // map[messageID][reactions]
+	attachments map[string]Attachment // map[attachmentID]attachment
+	// index holds the content of the messages to search, except the system and the deleted ones.
+	index     *search.Index
+	positions map[string]messagePosition // map[messageID]position
+	// lastGlobalSeq is the global sequence number of the last message.
+	lastGlobalSeq int64
 
 	logger *slog.Logger
 }
@@ -22,31 +95,307 @@ func NewMessageRepository() *MessageRepository {
 	logger.Info("created repository")
 
 	return &MessageRepository{
-		messages: make(map[string][]string),
-		logger:   logger,
+		messages:    make(map[string][]Message),
+		versions:    make(map[string][]MessageVersion),
+		reactions:   make(map[string][]Reaction),
+		attachments: make(map[string]Attachment),
+		index:       search.NewIndex(),
+		positions:   make(map[string]messagePosition),
+		logger:      logger,
 	}
 }
 
 // AddMessage adds a new message to the repository.
-func (repo *MessageRepository) AddMessage(chatID, content string) error {
+func (repo *MessageRepository) AddMessage(chatID, sender, content string) (Message, error) {
+	return repo.add(chatID, sender, content, false, MessageOptions{})
+}
+
+// AddSystemMessage adds a new system message to the repository.
+func (repo *MessageRepository) AddSystemMessage(chatID, sender, content string) (Message, error) {
+	return repo.add(chatID, sender, content, true, MessageOptions{})
+}
+
+// MessageOptions are the optional parts of a message sent by a user.
+type MessageOptions struct {
+	// ReplyTo is the ID of the message of the chat the message replies to, if not empty.
+	// The replied message counts one more reply.
+	ReplyTo string
+	// Attachments are the IDs of the attachments to attach to the message,
+	// they must have been uploaded by the sender and not attached to another message.
+	Attachments []string
+	// Blocked hides the message from the other participants, the receiver blocked the sender.
+	Blocked bool
+}
+
+// AddMessageWithOptions adds a new message of a user to the repository.
+// It returns ErrReplyToNotFound if the replied message is not in the chat,
+// and ErrAttachmentNotFound or ErrAttachmentInUse if an attachment can't be attached.
+func (repo *MessageRepository) AddMessageWithOptions(chatID, sender, content string, options MessageOptions) (Message, error) {
+	return repo.add(chatID, sender, content, false, options)
+}
+
+func (repo *MessageRepository) add(chatID, sender, content string, system bool, options MessageOptions) (Message, error) {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
-	repo.messages[chatID] = append(repo.messages[chatID], content)
+	var parent *Message
 
-	return nil
+	if options.ReplyTo != "" {
+		var err error
+
+		if parent, err = repo.getMessage(chatID, options.ReplyTo); err != nil {
+			return Message{}, ErrReplyToNotFound
+		}
+	}
+
+	attachments, err := repo.getAttachable(sender, options.Attachments)
+	if err != nil {
+		return Message{}, err
+	}
+
+	message := Message{
+		ID:        uuid.NewString(),
+		ChatID:    chatID,
+		Sender:    sender,
+		Content:   content,
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+		Seq:       int64(len(repo.messages[chatID])) + 1,
+		GlobalSeq: repo.lastGlobalSeq + 1,
+		System:    system,
+		ReplyTo:   options.ReplyTo,
+		Blocked:   options.Blocked,
+	}
+
+	repo.lastGlobalSeq = message.GlobalSeq
+
+	if parent != nil {
+		parent.ReplyCount++
+	}
+
+	for _, attachment := range attachments {
+		attachment.ChatID = chatID
+		attachment.MessageID = message.ID
+		repo.attachments[attachment.ID] = attachment
+		message.Attachments = append(message.Attachments, attachment)
+	}
+
+	repo.positions[message.ID] = messagePosition{chatID: chatID, index: len(repo.messages[chatID])}
+	repo.messages[chatID] = append(repo.messages[chatID], message)
+
+	if !system {
+		repo.index.Add(message.ID, content)
+	}
+
+	return message, nil
+}
+
+// MessageQuery selects the messages of a chat.
+// The messages are always sorted by sequence number.
+type MessageQuery struct {
+	// AfterSeq keeps the messages with a greater sequence number, if not zero.
+	AfterSeq int64
+	// BeforeSeq keeps the messages with a lower sequence number, if not zero.
+	BeforeSeq int64
+	// AfterGlobalSeq keeps the messages with a greater global sequence number, if not zero.
+	AfterGlobalSeq int64
+	// Limit is the maximum number of messages, 0 for no limit.
+	// When only AfterSeq or AfterGlobalSeq is set, the messages right after them are kept,
+	// otherwise the latest messages are kept.
+	Limit int
+	// ReplyTo keeps the replies to the message of this ID, its thread, if not empty.
+	ReplyTo string
+	// Viewer keeps the messages visible to this user, if not empty.
+	Viewer string
+}
+
+// Forward reports whether the limited messages are the first ones after AfterSeq or AfterGlobalSeq,
+// instead of the latest ones.
+func (q MessageQuery) Forward() bool {
+	return (q.AfterSeq > 0 || q.AfterGlobalSeq > 0) && q.BeforeSeq == 0
+}
+
+// GetChatMessages gets the messages of a chat matching the query.
+func (repo *MessageRepository) GetChatMessages(chatID string, query MessageQuery) ([]Message, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	messages := repo.messages[chatID]
+
+	// the sequence number is the position of the message in the chat, starting at 1.
+	start := min(max(query.AfterSeq, 0), int64(len(messages)))
+	end := int64(len(messages))
+
+	if query.BeforeSeq > 0 {
+		end = max(min(query.BeforeSeq-1, end), start)
+	}
+
+	// copy the messages to avoid sharing the underlying array with the caller
+	result := make([]Message, 0, end-start)
+
+	for _, message := range messages[start:end] {
+		if (query.ReplyTo == "" || message.ReplyTo == query.ReplyTo) &&
+			(query.Viewer == "" || message.VisibleTo(query.Viewer)) &&
+			message.GlobalSeq > query.AfterGlobalSeq {
+			result = append(result, message)
+		}
+	}
+
+	if query.Limit > 0 && len(result) > query.Limit {
+		if query.Forward() {
+			result = result[:query.Limit]
+		} else {
+			result = result[len(result)-query.Limit:]
+		}
+	}
+
+	return result, nil
 }
 
-// GetChatMessages gets all messages from a chat.
-func (repo *MessageRepository) GetChatMessages(chatID string) ([]string, error) {
+// GetChatMessagesByID gets the messages of a chat among the given IDs, sorted by sequence number.
+func (repo *MessageRepository) GetChatMessagesByID(chatID string, messageIDs []string) ([]Message, error) {
 	repo.mu.RLock()
 	defer repo.mu.RUnlock()
 
-	messages, ok := repo.messages[chatID]
-	if !ok {
-		// consider an empty slice of messages if the chat does not exist
-		return []string{}, nil
+	messages := []Message{}
+
+	for _, message := range repo.messages[chatID] {
+		if slices.Contains(messageIDs, message.ID) {
+			messages = append(messages, message)
+		}
 	}
 
 	return messages, nil
 }
+
+// lastMessage gets the last message of a chat visible to the user, it reports whether there is one.
+func (repo *MessageRepository) lastMessage(chatID, user string) (Message, bool) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	messages := repo.messages[chatID]
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].VisibleTo(user) {
+			return messages[i], true
+		}
+	}
+
+	return Message{}, false
+}
+
+// countUnread counts the messages of a chat after readSeq not sent by the user, and visible to them.
+func (repo *MessageRepository) countUnread(chatID, user string, readSeq int64) int64 {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	messages := repo.messages[chatID]
+
+	var count int64
+
+	for _, message := range messages[min(max(readSeq, 0), int64(len(messages))):] {
+		if message.Sender != user && !message.Blocked {
+			count++
+		}
+	}
+
+	return count
+}
+
+// GetChatMessage gets a message of a chat by its ID.
+func (repo *MessageRepository) GetChatMessage(chatID, messageID string) (Message, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, message := range repo.messages[chatID] {
+		if message.ID == messageID {
+			return message, nil
+		}
+	}
+
+	return Message{}, ErrMessageNotFound
+}
+
+// GetLastSeq gets the sequence number of the last message of a chat, 0 if the chat has no message.
+func (repo *MessageRepository) GetLastSeq(chatID string) (int64, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	return int64(len(repo.messages[chatID])), nil
+}
+
+// getMessage gets a pointer to a message of a chat, to change it.
+func (repo *MessageRepository) getMessage(chatID, messageID string) (*Message, error) {
+	messages := repo.messages[chatID]
+
+	for i := range messages {
+		if messages[i].ID == messageID {
+			return &messages[i], nil
+		}
+	}
+
+	return nil, ErrMessageNotFound
+}
+
+// EditMessage replaces the content of a message, its previous version is kept in its history.
+func (repo *MessageRepository) EditMessage(chatID, messageID, content string) (Message, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	message, err := repo.getMessage(chatID, messageID)
+	if err != nil {
+		return Message{}, err
+	}
+
+	if message.Deleted() {
+		return Message{}, ErrMessageDeleted
+	}
+
+	repo.versions[messageID] = append(repo.versions[messageID], message.Version())
+
+	message.Content = content
+	message.EditedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+	repo.index.Add(messageID, content)
+
+	return *message, nil
+}
+
+// DeleteMessage deletes a message, its content, its history, its reactions and its attachments are erased.
+func (repo *MessageRepository) DeleteMessage(chatID, messageID string) (Message, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	message, err := repo.getMessage(chatID, messageID)
+	if err != nil {
+		return Message{}, err
+	}
+
+	if message.Deleted() {
+		return Message{}, ErrMessageDeleted
+	}
+
+	delete(repo.versions, messageID)
+	delete(repo.reactions, messageID)
+	repo.index.Remove(messageID)
+
+	for _, attachment := range message.Attachments {
+		delete(repo.attachments, attachment.ID)
+	}
+
+	message.Content = ""
+	message.Attachments = nil
+	message.DeletedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+	return *message, nil
+}
+
+// GetMessageHistory gets the previous versions of a message, from the original one to the last replaced one.
+func (repo *MessageRepository) GetMessageHistory(chatID, messageID string) ([]MessageVersion, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	if _, err := repo.getMessage(chatID, messageID); err != nil {
+		return nil, err
+	}
+
+	return append([]MessageVersion{}, repo.versions[messageID]...), nil
+}
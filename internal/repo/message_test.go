@@ -0,0 +1,262 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRepository_AddMessage(t *testing.T) {
+	messageRepo := NewMessageRepository()
+
+	first, err := messageRepo.AddMessage("chat1", "123", "Hello")
+	require.NoError(t, err)
+	assert.NotEmpty(t, first.ID)
+	assert.Equal(t, "chat1", first.ChatID)
+	assert.Equal(t, "123", first.Sender)
+	assert.Equal(t, "Hello", first.Content)
+	assert.Equal(t, int64(1), first.Seq)
+	assert.False(t, first.CreatedAt.IsZero())
+
+	second, err := messageRepo.AddMessage("chat1", "456", "World")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), second.Seq)
+
+	// the sequence is per chat
+	other, err := messageRepo.AddMessage("chat2", "123", "Hi")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), other.Seq)
+
+	// the global sequence orders the messages of all the chats
+	assert.Equal(t, first.GlobalSeq+1, second.GlobalSeq)
+	assert.Equal(t, second.GlobalSeq+1, other.GlobalSeq)
+
+	messages, err := messageRepo.GetChatMessages("chat1", MessageQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []Message{first, second}, messages)
+
+	message, err := messageRepo.GetChatMessage("chat1", second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, second, message)
+
+	_, err = messageRepo.GetChatMessage("chat2", second.ID)
+	require.ErrorIs(t, err, ErrMessageNotFound)
+
+	messages, err = messageRepo.GetChatMessages("unknown", MessageQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+
+	messages, err = messageRepo.GetChatMessages("chat1", MessageQuery{AfterSeq: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []Message{second}, messages)
+
+	messages, err = messageRepo.GetChatMessages("chat1", MessageQuery{AfterGlobalSeq: first.GlobalSeq, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []Message{second}, messages)
+
+	messages, err = messageRepo.GetChatMessages("chat1", MessageQuery{AfterGlobalSeq: other.GlobalSeq})
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+
+	messages, err = messageRepo.GetChatMessages("chat1", MessageQuery{AfterSeq: 5})
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+
+	seq, err := messageRepo.GetLastSeq("chat1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), seq)
+}
+
+func TestMessageRepository_AddSystemMessage(t *testing.T) {
+	messageRepo := NewMessageRepository()
+
+	message, err := messageRepo.AddMessage("chat", "123", "Hello")
+	require.NoError(t, err)
+	assert.False(t, message.System)
+
+	system, err := messageRepo.AddSystemMessage("chat", "123", "123 added 789")
+	require.NoError(t, err)
+	assert.True(t, system.System)
+	assert.Equal(t, int64(2), system.Seq)
+
+	messages, err := messageRepo.GetChatMessages("chat", MessageQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []Message{message, system}, messages)
+}
+
+func TestMessageRepository_EditMessage(t *testing.T) {
+	messageRepo := NewMessageRepository()
+
+	message, err := messageRepo.AddMessage("chat", "123", "Hello")
+	require.NoError(t, err)
+
+	edited, err := messageRepo.EditMessage("chat", message.ID, "Hello, World!")
+	require.NoError(t, err)
+	assert.Equal(t, "Hello, World!", edited.Content)
+	assert.False(t, edited.EditedAt.IsZero())
+
+	last, err := messageRepo.EditMessage("chat", message.ID, "Hello, World!!")
+	require.NoError(t, err)
+
+	got, err := messageRepo.GetChatMessage("chat", message.ID)
+	require.NoError(t, err)
+	assert.Equal(t, last, got)
+
+	history, err := messageRepo.GetMessageHistory("chat", message.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []MessageVersion{
+		{Content: "Hello", CreatedAt: message.CreatedAt},
+		{Content: "Hello, World!", CreatedAt: edited.EditedAt},
+	}, history)
+
+	// the deleted message is a tombstone without content nor history.
+	deleted, err := messageRepo.DeleteMessage("chat", message.ID)
+	require.NoError(t, err)
+	assert.True(t, deleted.Deleted())
+	assert.Empty(t, deleted.Content)
+	assert.Equal(t, message.Seq, deleted.Seq)
+
+	history, err = messageRepo.GetMessageHistory("chat", message.ID)
+	require.NoError(t, err)
+	assert.Empty(t, history)
+
+	messages, err := messageRepo.GetChatMessages("chat", MessageQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []Message{deleted}, messages)
+
+	_, err = messageRepo.EditMessage("chat", message.ID, "Hi")
+	require.ErrorIs(t, err, ErrMessageDeleted)
+
+	_, err = messageRepo.DeleteMessage("chat", message.ID)
+	require.ErrorIs(t, err, ErrMessageDeleted)
+
+	_, err = messageRepo.EditMessage("other", message.ID, "Hi")
+	require.ErrorIs(t, err, ErrMessageNotFound)
+
+	_, err = messageRepo.DeleteMessage("chat", "unknown")
+	require.ErrorIs(t, err, ErrMessageNotFound)
+
+	_, err = messageRepo.GetMessageHistory("chat", "unknown")
+	require.ErrorIs(t, err, ErrMessageNotFound)
+}
+
+func TestMessageRepository_AddMessageWithOptions_Reply(t *testing.T) {
+	messageRepo := NewMessageRepository()
+
+	parent, err := messageRepo.AddMessage("chat", "123", "Hello")
+	require.NoError(t, err)
+
+	first, err := messageRepo.AddMessageWithOptions("chat", "456", "Hi", MessageOptions{ReplyTo: parent.ID})
+	require.NoError(t, err)
+	assert.Equal(t, parent.ID, first.ReplyTo)
+	assert.Equal(t, int64(2), first.Seq)
+
+	_, err = messageRepo.AddMessage("chat", "123", "How are you?")
+	require.NoError(t, err)
+
+	second, err := messageRepo.AddMessageWithOptions("chat", "123", "Hey", MessageOptions{ReplyTo: parent.ID})
+	require.NoError(t, err)
+
+	// a reply to a reply is in the thread of the reply.
+	nested, err := messageRepo.AddMessageWithOptions("chat", "123", "Hey you", MessageOptions{ReplyTo: first.ID})
+	require.NoError(t, err)
+
+	got, err := messageRepo.GetChatMessage("chat", parent.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), got.ReplyCount)
+
+	first, err = messageRepo.GetChatMessage("chat", first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), first.ReplyCount)
+
+	thread, err := messageRepo.GetChatMessages("chat", MessageQuery{ReplyTo: parent.ID})
+	require.NoError(t, err)
+	assert.Equal(t, []Message{first, second}, thread)
+
+	thread, err = messageRepo.GetChatMessages("chat", MessageQuery{ReplyTo: parent.ID, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []Message{second}, thread)
+
+	thread, err = messageRepo.GetChatMessages("chat", MessageQuery{ReplyTo: parent.ID, AfterSeq: 1, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []Message{first}, thread)
+
+	thread, err = messageRepo.GetChatMessages("chat", MessageQuery{ReplyTo: first.ID})
+	require.NoError(t, err)
+	assert.Equal(t, []Message{nested}, thread)
+
+	messages, err := messageRepo.GetChatMessagesByID("chat", []string{second.ID, parent.ID, "unknown"})
+	require.NoError(t, err)
+	assert.Equal(t, []Message{got, second}, messages)
+
+	// the replied message must be in the chat.
+	_, err = messageRepo.AddMessageWithOptions("other", "123", "Hi", MessageOptions{ReplyTo: parent.ID})
+	require.ErrorIs(t, err, ErrReplyToNotFound)
+
+	_, err = messageRepo.AddMessageWithOptions("chat", "123", "Hi", MessageOptions{ReplyTo: "unknown"})
+	require.ErrorIs(t, err, ErrReplyToNotFound)
+}
+
+func TestMessageRepository_GetChatMessages(t *testing.T) {
+	messageRepo := NewMessageRepository()
+
+	var messages []Message
+
+	for range 5 {
+		message, err := messageRepo.AddMessage("chat1", "123", "Hello")
+		require.NoError(t, err)
+
+		messages = append(messages, message)
+	}
+
+	testCases := []struct {
+		desc     string
+		query    MessageQuery
+		expected []Message
+	}{
+		{
+			desc:     "all",
+			query:    MessageQuery{},
+			expected: messages,
+		},
+		{
+			desc:     "latest",
+			query:    MessageQuery{Limit: 2},
+			expected: messages[3:],
+		},
+		{
+			desc:     "before",
+			query:    MessageQuery{BeforeSeq: 4, Limit: 2},
+			expected: messages[1:3],
+		},
+		{
+			desc:     "after",
+			query:    MessageQuery{AfterSeq: 1, Limit: 2},
+			expected: messages[1:3],
+		},
+		{
+			desc:     "between",
+			query:    MessageQuery{AfterSeq: 1, BeforeSeq: 5, Limit: 2},
+			expected: messages[2:4],
+		},
+		{
+			desc:     "before the first message",
+			query:    MessageQuery{BeforeSeq: 1},
+			expected: []Message{},
+		},
+		{
+			desc:     "after the last message",
+			query:    MessageQuery{AfterSeq: 5, Limit: 2},
+			expected: []Message{},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := messageRepo.GetChatMessages("chat1", test.query)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, got)
+		})
+	}
+}
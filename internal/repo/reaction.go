@@ -0,0 +1,86 @@
+package repo
+
+import (
+	"slices"
+	"time"
+)
+
+// Reaction is an emoji added by a participant to a message of a chat.
+// A participant reacts at most once to a message with a given emoji.
+type Reaction struct {
+	MessageID string
+	User      string
+	Emoji     string
+	CreatedAt time.Time
+}
+
+// AddReaction adds the reaction of the user with the emoji to a message,
+// nothing changes if the user already reacted with it.
+func (repo *MessageRepository) AddReaction(chatID, messageID, user, emoji string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	message, err := repo.getMessage(chatID, messageID)
+	if err != nil {
+		return err
+	}
+
+	if message.Deleted() {
+		return ErrMessageDeleted
+	}
+
+	if slices.ContainsFunc(repo.reactions[messageID], isReaction(user, emoji)) {
+		return nil
+	}
+
+	repo.reactions[messageID] = append(repo.reactions[messageID], Reaction{
+		MessageID: messageID,
+		User:      user,
+		Emoji:     emoji,
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	})
+
+	return nil
+}
+
+// RemoveReaction removes the reaction of the user with the emoji from a message,
+// nothing changes if the user did not react with it.
+func (repo *MessageRepository) RemoveReaction(chatID, messageID, user, emoji string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	message, err := repo.getMessage(chatID, messageID)
+	if err != nil {
+		return err
+	}
+
+	if message.Deleted() {
+		return ErrMessageDeleted
+	}
+
+	repo.reactions[messageID] = slices.DeleteFunc(repo.reactions[messageID], isReaction(user, emoji))
+
+	return nil
+}
+
+// GetReactions gets the reactions to the messages of a chat, in the order they have been added.
+func (repo *MessageRepository) GetReactions(chatID string, messageIDs []string) ([]Reaction, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	reactions := []Reaction{}
+
+	for _, message := range repo.messages[chatID] {
+		if slices.Contains(messageIDs, message.ID) {
+			reactions = append(reactions, repo.reactions[message.ID]...)
+		}
+	}
+
+	return reactions, nil
+}
+
+func isReaction(user, emoji string) func(Reaction) bool {
+	return func(reaction Reaction) bool {
+		return reaction.User == user && reaction.Emoji == emoji
+	}
+}
@@ -0,0 +1,61 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRepository_Reactions(t *testing.T) {
+	messageRepo := NewMessageRepository()
+
+	first, err := messageRepo.AddMessage("chat", "1", "Hello")
+	require.NoError(t, err)
+
+	second, err := messageRepo.AddMessage("chat", "2", "World")
+	require.NoError(t, err)
+
+	require.NoError(t, messageRepo.AddReaction("chat", first.ID, "2", "👍"))
+	require.NoError(t, messageRepo.AddReaction("chat", first.ID, "1", "👍"))
+	require.NoError(t, messageRepo.AddReaction("chat", second.ID, "1", "🎉"))
+	// a user reacts once with an emoji.
+	require.NoError(t, messageRepo.AddReaction("chat", first.ID, "2", "👍"))
+
+	reactions, err := messageRepo.GetReactions("chat", []string{first.ID, second.ID})
+	require.NoError(t, err)
+	require.Len(t, reactions, 3)
+
+	for _, reaction := range reactions {
+		assert.False(t, reaction.CreatedAt.IsZero())
+	}
+
+	assert.Equal(t, Reaction{MessageID: first.ID, User: "2", Emoji: "👍", CreatedAt: reactions[0].CreatedAt}, reactions[0])
+	assert.Equal(t, Reaction{MessageID: first.ID, User: "1", Emoji: "👍", CreatedAt: reactions[1].CreatedAt}, reactions[1])
+	assert.Equal(t, Reaction{MessageID: second.ID, User: "1", Emoji: "🎉", CreatedAt: reactions[2].CreatedAt}, reactions[2])
+
+	require.NoError(t, messageRepo.RemoveReaction("chat", first.ID, "2", "👍"))
+	require.NoError(t, messageRepo.RemoveReaction("chat", first.ID, "2", "👍"))
+
+	reactions, err = messageRepo.GetReactions("chat", []string{first.ID})
+	require.NoError(t, err)
+	require.Len(t, reactions, 1)
+	assert.Equal(t, "1", reactions[0].User)
+
+	reactions, err = messageRepo.GetReactions("other", []string{first.ID})
+	require.NoError(t, err)
+	assert.Empty(t, reactions)
+
+	// the reactions of a deleted message are erased.
+	_, err = messageRepo.DeleteMessage("chat", first.ID)
+	require.NoError(t, err)
+
+	reactions, err = messageRepo.GetReactions("chat", []string{first.ID})
+	require.NoError(t, err)
+	assert.Empty(t, reactions)
+
+	require.ErrorIs(t, messageRepo.AddReaction("chat", first.ID, "1", "👍"), ErrMessageDeleted)
+	require.ErrorIs(t, messageRepo.RemoveReaction("chat", first.ID, "1", "👍"), ErrMessageDeleted)
+	require.ErrorIs(t, messageRepo.AddReaction("other", second.ID, "1", "👍"), ErrMessageNotFound)
+	require.ErrorIs(t, messageRepo.RemoveReaction("chat", "unknown", "1", "👍"), ErrMessageNotFound)
+}
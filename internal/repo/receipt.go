@@ -0,0 +1,86 @@
+package repo
+
+import "slices"
+
+// Receipt is the position of a participant in the messages of a chat:
+// the messages up to DeliveredSeq are delivered to the participant, and the ones up to ReadSeq are read.
+// The messages are read once delivered, ReadSeq is never greater than DeliveredSeq.
+type Receipt struct {
+	ChatID       string
+	User         string
+	DeliveredSeq int64
+	ReadSeq      int64
+}
+
+// MarkDelivered marks the messages of the chat up to seq as delivered to the participant.
+// It returns the receipt of the participant and the previous DeliveredSeq,
+// the receipt is unchanged if the messages were already delivered.
+func (r *ChatRepository) MarkDelivered(chatID, user string, seq int64) (Receipt, int64, error) {
+	return r.mark(chatID, user, seq, false)
+}
+
+// MarkRead marks the messages of the chat up to seq as read, and delivered, by the participant.
+// It returns the receipt of the participant and the previous ReadSeq,
+// the receipt is unchanged if the messages were already read.
+func (r *ChatRepository) MarkRead(chatID, user string, seq int64) (Receipt, int64, error) {
+	return r.mark(chatID, user, seq, true)
+}
+
+func (r *ChatRepository) mark(chatID, user string, seq int64, read bool) (Receipt, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat, exists := r.chatsByID[chatID]
+	if !exists {
+		return Receipt{}, 0, ErrChatNotFound
+	}
+
+	if !slices.Contains(chat.Participants, user) {
+		return Receipt{}, 0, ErrNotParticipant
+	}
+
+	receipt, exists := r.receipts[chatID][user]
+	if !exists {
+		receipt = Receipt{ChatID: chatID, User: user}
+	}
+
+	previous := receipt.DeliveredSeq
+	if read {
+		previous = receipt.ReadSeq
+		receipt.ReadSeq = max(receipt.ReadSeq, seq)
+	}
+
+	receipt.DeliveredSeq = max(receipt.DeliveredSeq, seq)
+
+	if _, exists = r.receipts[chatID]; !exists {
+		r.receipts[chatID] = make(map[string]Receipt)
+	}
+
+	r.receipts[chatID][user] = receipt
+
+	return receipt, previous, nil
+}
+
+// GetReceipts gets the receipts of the participants of the chat, in the participants order.
+func (r *ChatRepository) GetReceipts(chatID string) ([]Receipt, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	chat, exists := r.chatsByID[chatID]
+	if !exists {
+		return nil, ErrChatNotFound
+	}
+
+	receipts := make([]Receipt, 0, len(chat.Participants))
+
+	for _, participant := range chat.Participants {
+		receipt, exists := r.receipts[chatID][participant]
+		if !exists {
+			receipt = Receipt{ChatID: chatID, User: participant}
+		}
+
+		receipts = append(receipts, receipt)
+	}
+
+	return receipts, nil
+}
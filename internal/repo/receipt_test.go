@@ -0,0 +1,72 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChatRepository_Receipts(t *testing.T) {
+	chatRepo := NewChatRepository(NewMessageRepository())
+
+	chat, err := chatRepo.CreateGroupChat("friends", []string{"1", "2", "3"})
+	require.NoError(t, err)
+
+	receipt, previous, err := chatRepo.MarkDelivered(chat.ID, "2", 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), previous)
+	assert.Equal(t, Receipt{ChatID: chat.ID, User: "2", DeliveredSeq: 3}, receipt)
+
+	// the messages already delivered are ignored.
+	receipt, previous, err = chatRepo.MarkDelivered(chat.ID, "2", 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), previous)
+	assert.Equal(t, Receipt{ChatID: chat.ID, User: "2", DeliveredSeq: 3}, receipt)
+
+	receipt, previous, err = chatRepo.MarkRead(chat.ID, "2", 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), previous)
+	assert.Equal(t, Receipt{ChatID: chat.ID, User: "2", DeliveredSeq: 3, ReadSeq: 2}, receipt)
+
+	// the read messages are delivered.
+	receipt, previous, err = chatRepo.MarkRead(chat.ID, "3", 4)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), previous)
+	assert.Equal(t, Receipt{ChatID: chat.ID, User: "3", DeliveredSeq: 4, ReadSeq: 4}, receipt)
+
+	receipts, err := chatRepo.GetReceipts(chat.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []Receipt{
+		{ChatID: chat.ID, User: "1"},
+		{ChatID: chat.ID, User: "2", DeliveredSeq: 3, ReadSeq: 2},
+		{ChatID: chat.ID, User: "3", DeliveredSeq: 4, ReadSeq: 4},
+	}, receipts)
+
+	// the receipts of a removed participant are dropped.
+	_, _, err = chatRepo.RemoveParticipant(chat.ID, "3")
+	require.NoError(t, err)
+
+	_, _, err = chatRepo.AddParticipants(chat.ID, []string{"3"})
+	require.NoError(t, err)
+
+	receipts, err = chatRepo.GetReceipts(chat.ID)
+	require.NoError(t, err)
+	assert.Equal(t, Receipt{ChatID: chat.ID, User: "3"}, receipts[2])
+}
+
+func TestChatRepository_Receipts_Errors(t *testing.T) {
+	chatRepo := NewChatRepository(NewMessageRepository())
+
+	chat, _, err := chatRepo.GetOrCreateChat("1", "2")
+	require.NoError(t, err)
+
+	_, _, err = chatRepo.MarkRead(chat.ID, "3", 1)
+	require.ErrorIs(t, err, ErrNotParticipant)
+
+	_, _, err = chatRepo.MarkDelivered("unknown", "1", 1)
+	require.ErrorIs(t, err, ErrChatNotFound)
+
+	_, err = chatRepo.GetReceipts("unknown")
+	require.ErrorIs(t, err, ErrChatNotFound)
+}
@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SearchQuery selects the messages holding words, from the most relevant to the least relevant.
+// The deleted messages and the system messages are never found.
+type SearchQuery struct {
+	// Terms are the words the messages must all hold, as split by search.Terms.
+	Terms []string
+	// ChatIDs are the chats whose messages are searched.
+	ChatIDs []string
+	// Sender keeps the messages sent by this user, if not empty.
+	Sender string
+	// Viewer keeps the messages visible to this user, if not empty.
+	Viewer string
+	// From keeps the messages sent at or after this time, if not zero.
+	From time.Time
+	// To keeps the messages sent before this time, if not zero.
+	To time.Time
+	// Offset is the number of the most relevant messages skipped.
+	Offset int
+	// Limit is the maximum number of messages, 0 for no limit.
+	Limit int
+}
+
+// keeps reports whether the message matches the sender, the viewer and the time range of the query.
+func (q SearchQuery) keeps(message Message) bool {
+	return (q.Sender == "" || message.Sender == q.Sender) &&
+		(q.Viewer == "" || message.VisibleTo(q.Viewer)) &&
+		(q.From.IsZero() || !message.CreatedAt.Before(q.From)) &&
+		(q.To.IsZero() || message.CreatedAt.Before(q.To))
+}
+
+// messagePosition locates a message in the messages of its chat.
+type messagePosition struct {
+	chatID string
+	index  int
+}
+
+// SearchMessages gets the messages matching the query, from the most relevant.
+// The messages of the same relevance are sorted from the most recent.
+func (repo *MessageRepository) SearchMessages(query SearchQuery) ([]Message, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	chats := make(map[string]bool, len(query.ChatIDs))
+	for _, chatID := range query.ChatIDs {
+		chats[chatID] = true
+	}
+
+	type match struct {
+		message Message
+		score   float64
+	}
+
+	var matches []match
+
+	hits := repo.index.Search(query.Terms, func(id string) bool {
+		position := repo.positions[id]
+
+		return chats[position.chatID] && query.keeps(repo.messages[position.chatID][position.index])
+	})
+
+	for _, hit := range hits {
+		position := repo.positions[hit.ID]
+		matches = append(matches, match{message: repo.messages[position.chatID][position.index], score: hit.Score})
+	}
+
+	slices.SortFunc(matches, func(a, b match) int {
+		return cmp.Or(
+			cmp.Compare(b.score, a.score),
+			b.message.CreatedAt.Compare(a.message.CreatedAt),
+			strings.Compare(a.message.ID, b.message.ID),
+		)
+	})
+
+	messages := []Message{}
+
+	for _, match := range matches[min(query.Offset, len(matches)):] {
+		if query.Limit > 0 && len(messages) == query.Limit {
+			break
+		}
+
+		messages = append(messages, match.message)
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,92 @@
+package repo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRepository_SearchMessages(t *testing.T) {
+	messageRepo := NewMessageRepository()
+
+	tonight, err := messageRepo.AddMessage("chat", "1", "Pizza tonight?")
+	require.NoError(t, err)
+
+	pizza, err := messageRepo.AddMessage("chat", "2", "pizza, pizza, PIZZA!")
+	require.NoError(t, err)
+
+	party, err := messageRepo.AddMessage("chat", "1", "Sushi tomorrow")
+	require.NoError(t, err)
+
+	other, err := messageRepo.AddMessage("other", "3", "pizza")
+	require.NoError(t, err)
+
+	_, err = messageRepo.AddSystemMessage("chat", "1", "pizza added 3")
+	require.NoError(t, err)
+
+	deleted, err := messageRepo.AddMessage("chat", "1", "pizza")
+	require.NoError(t, err)
+
+	_, err = messageRepo.DeleteMessage("chat", deleted.ID)
+	require.NoError(t, err)
+
+	party, err = messageRepo.EditMessage("chat", party.ID, "Pizza party tomorrow")
+	require.NoError(t, err)
+
+	// the message repeating the word ranks first.
+	messages, err := messageRepo.SearchMessages(SearchQuery{Terms: []string{"pizza"}, ChatIDs: []string{"chat"}})
+	require.NoError(t, err)
+	require.Len(t, messages, 3)
+	assert.Equal(t, pizza, messages[0])
+	assert.ElementsMatch(t, []Message{tonight, party}, messages[1:])
+
+	messages, err = messageRepo.SearchMessages(SearchQuery{Terms: []string{"pizza"}, ChatIDs: []string{"chat", "other"}})
+	require.NoError(t, err)
+	assert.Len(t, messages, 4)
+	assert.Contains(t, messages, other)
+
+	// the replaced content is not found anymore.
+	messages, err = messageRepo.SearchMessages(SearchQuery{Terms: []string{"sushi"}, ChatIDs: []string{"chat"}})
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+
+	messages, err = messageRepo.SearchMessages(SearchQuery{Terms: []string{"pizza", "tomorrow"}, ChatIDs: []string{"chat"}})
+	require.NoError(t, err)
+	assert.Equal(t, []Message{party}, messages)
+
+	messages, err = messageRepo.SearchMessages(SearchQuery{Terms: []string{"pizza"}, ChatIDs: []string{"chat"}, Sender: "1"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []Message{tonight, party}, messages)
+
+	messages, err = messageRepo.SearchMessages(SearchQuery{
+		Terms:   []string{"pizza"},
+		ChatIDs: []string{"chat"},
+		From:    pizza.CreatedAt,
+		To:      pizza.CreatedAt.Add(time.Millisecond),
+	})
+	require.NoError(t, err)
+	assert.Contains(t, messages, pizza)
+
+	messages, err = messageRepo.SearchMessages(SearchQuery{Terms: []string{"pizza"}, ChatIDs: []string{"chat"}, To: tonight.CreatedAt})
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+
+	// the messages are paged by their rank.
+	messages, err = messageRepo.SearchMessages(SearchQuery{Terms: []string{"pizza"}, ChatIDs: []string{"chat"}, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []Message{pizza}, messages)
+
+	messages, err = messageRepo.SearchMessages(SearchQuery{Terms: []string{"pizza"}, ChatIDs: []string{"chat"}, Offset: 1, Limit: 5})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []Message{tonight, party}, messages)
+
+	messages, err = messageRepo.SearchMessages(SearchQuery{Terms: []string{"pizza"}, ChatIDs: []string{"chat"}, Offset: 5})
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+
+	messages, err = messageRepo.SearchMessages(SearchQuery{Terms: []string{"pizza"}})
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
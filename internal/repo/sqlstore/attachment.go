@@ -0,0 +1,235 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+const (
+	attachmentColumns = `id, uploader, filename, content_type, size, created_at, chat_id, message_id, width, height, thumbnails`
+	selectAttachments = `SELECT ` + attachmentColumns + ` FROM attachments`
+)
+
+// AddAttachment adds the metadata of a file uploaded by the user, not attached to a message yet.
+// The image is nil if the file is not an image with thumbnails.
+func (r *MessageRepository) AddAttachment(uploader, filename, contentType string, size int64, image *repo.Image) (repo.Attachment, error) {
+	attachment := repo.Attachment{
+		ID:          uuid.NewString(),
+		Uploader:    uploader,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		CreatedAt:   time.Now().UTC().Truncate(time.Millisecond),
+		Image:       image,
+	}
+
+	var (
+		width, height sql.Null[int]
+		thumbnails    sql.Null[string]
+	)
+
+	if image != nil {
+		width = sql.Null[int]{V: image.Width, Valid: true}
+		height = sql.Null[int]{V: image.Height, Valid: true}
+		thumbnails = sql.Null[string]{V: strings.Join(image.Thumbnails, ","), Valid: true}
+	}
+
+	_, err := r.db.db.Exec(`
+		INSERT INTO attachments (id, uploader, filename, content_type, size, created_at, width, height, thumbnails)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		attachment.ID, attachment.Uploader, attachment.Filename, attachment.ContentType, attachment.Size, attachment.CreatedAt,
+		width, height, thumbnails,
+	)
+	if err != nil {
+		return repo.Attachment{}, fmt.Errorf("failed to insert attachment: %w", err)
+	}
+
+	return attachment, nil
+}
+
+// scanAttachment scans a row of the attachmentColumns.
+func scanAttachment(row interface{ Scan(dest ...any) error }) (repo.Attachment, error) {
+	var (
+		attachment    repo.Attachment
+		chatID        sql.Null[string]
+		messageID     sql.Null[string]
+		width, height sql.Null[int]
+		thumbnails    sql.Null[string]
+	)
+
+	err := row.Scan(
+		&attachment.ID,
+		&attachment.Uploader,
+		&attachment.Filename,
+		&attachment.ContentType,
+		&attachment.Size,
+		&attachment.CreatedAt,
+		&chatID,
+		&messageID,
+		&width,
+		&height,
+		&thumbnails,
+	)
+	if err != nil {
+		return repo.Attachment{}, fmt.Errorf("failed to scan attachment: %w", err)
+	}
+
+	attachment.CreatedAt = attachment.CreatedAt.UTC()
+	attachment.ChatID = chatID.V
+	attachment.MessageID = messageID.V
+
+	if thumbnails.Valid {
+		attachment.Image = &repo.Image{Width: width.V, Height: height.V, Thumbnails: strings.Split(thumbnails.V, ",")}
+	}
+
+	return attachment, nil
+}
+
+// GetAttachment returns the attachment with the given ID.
+func (r *MessageRepository) GetAttachment(attachmentID string) (repo.Attachment, error) {
+	attachment, err := scanAttachment(r.db.db.QueryRow(selectAttachments+` WHERE id = $1`, attachmentID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return repo.Attachment{}, repo.ErrAttachmentNotFound
+	}
+
+	return attachment, err
+}
+
+// DeleteAttachment deletes an attachment not attached to a message yet.
+func (r *MessageRepository) DeleteAttachment(attachmentID string) error {
+	result, err := r.db.db.Exec(`DELETE FROM attachments WHERE id = $1 AND message_id IS NULL`, attachmentID)
+	if err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+
+	if count == 0 {
+		if _, err = r.GetAttachment(attachmentID); err != nil {
+			return err
+		}
+
+		return repo.ErrAttachmentInUse
+	}
+
+	return nil
+}
+
+// GetUnattachedAttachments returns the attachments not attached to a message, uploaded before the given time,
+// from the oldest to the newest.
+func (r *MessageRepository) GetUnattachedAttachments(before time.Time) ([]repo.Attachment, error) {
+	rows, err := r.db.db.Query(
+		selectAttachments+` WHERE message_id IS NULL AND created_at < $1 ORDER BY created_at, id`,
+		before.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unattached attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var attachments []repo.Attachment
+
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		attachments = append(attachments, attachment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get unattached attachments: %w", err)
+	}
+
+	return attachments, nil
+}
+
+// attach attaches the attachments of the sender to the message, in the given order.
+// It returns repo.ErrAttachmentNotFound if an attachment is not one of the sender,
+// and repo.ErrAttachmentInUse if it is already attached.
+func attach(tx *sql.Tx, message *repo.Message, attachmentIDs []string) error {
+	for i, id := range attachmentIDs {
+		// the conditional update attaches the attachment once, even with concurrent messages.
+		attachment, err := scanAttachment(tx.QueryRow(`
+			UPDATE attachments SET chat_id = $1, message_id = $2, position = $3
+			WHERE id = $4 AND uploader = $5 AND message_id IS NULL
+			RETURNING `+attachmentColumns,
+			message.ChatID, message.ID, i, id, message.Sender,
+		))
+		if errors.Is(err, sql.ErrNoRows) {
+			var uploader string
+
+			err = tx.QueryRow(`SELECT uploader FROM attachments WHERE id = $1`, id).Scan(&uploader)
+			if errors.Is(err, sql.ErrNoRows) || (err == nil && uploader != message.Sender) {
+				return repo.ErrAttachmentNotFound
+			}
+
+			if err != nil {
+				return fmt.Errorf("failed to get attachment: %w", err)
+			}
+
+			return repo.ErrAttachmentInUse
+		}
+
+		if err != nil {
+			return err
+		}
+
+		message.Attachments = append(message.Attachments, attachment)
+	}
+
+	return nil
+}
+
+// loadAttachments loads the attachments of the messages.
+func loadAttachments(q querier, messages []repo.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(messages))
+	args := make([]any, len(messages))
+	index := make(map[string]int, len(messages))
+
+	for i, message := range messages {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = message.ID
+		index[message.ID] = i
+	}
+
+	rows, err := q.Query(
+		selectAttachments+` WHERE message_id IN (`+strings.Join(placeholders, ", ")+`) ORDER BY position`,
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to get attachments: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return err
+		}
+
+		i := index[attachment.MessageID]
+		messages[i].Attachments = append(messages[i].Attachments, attachment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to get attachments: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package sqlstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+func TestMessageRepository_Attachments(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+		messageRepo := NewMessageRepository(db)
+
+		chat, _, err := chatRepo.GetOrCreateChat("1", "2")
+		require.NoError(t, err)
+
+		chatID := chat.ID
+
+		image, err := messageRepo.AddAttachment("1", "cat.png", "image/png", 42, nil)
+		require.NoError(t, err)
+		assert.False(t, image.Attached())
+
+		doc, err := messageRepo.AddAttachment("1", "notes.txt", "text/plain; charset=utf-8", 12, nil)
+		require.NoError(t, err)
+
+		other, err := messageRepo.AddAttachment("2", "dog.png", "image/png", 24, nil)
+		require.NoError(t, err)
+
+		got, err := messageRepo.GetAttachment(image.ID)
+		require.NoError(t, err)
+		assert.Equal(t, image, got)
+
+		photo, err := messageRepo.AddAttachment("1", "photo.jpg", "image/jpeg", 2048,
+			&repo.Image{Width: 800, Height: 600, Thumbnails: []string{"small", "medium"}})
+		require.NoError(t, err)
+
+		got, err = messageRepo.GetAttachment(photo.ID)
+		require.NoError(t, err)
+		assert.Equal(t, photo, got)
+
+		// the attachments must be unattached attachments of the sender.
+		_, err = messageRepo.AddMessageWithOptions(chatID, "1", "Look", repo.MessageOptions{Attachments: []string{image.ID, other.ID}})
+		require.ErrorIs(t, err, repo.ErrAttachmentNotFound)
+
+		_, err = messageRepo.AddMessageWithOptions(chatID, "1", "Look", repo.MessageOptions{Attachments: []string{"unknown"}})
+		require.ErrorIs(t, err, repo.ErrAttachmentNotFound)
+
+		_, err = messageRepo.AddMessageWithOptions(chatID, "1", "Look", repo.MessageOptions{Attachments: []string{image.ID, image.ID}})
+		require.ErrorIs(t, err, repo.ErrAttachmentInUse)
+
+		message, err := messageRepo.AddMessageWithOptions(chatID, "1", "Look", repo.MessageOptions{Attachments: []string{doc.ID, image.ID}})
+		require.NoError(t, err)
+		require.Len(t, message.Attachments, 2)
+		assert.Equal(t, doc.ID, message.Attachments[0].ID)
+		assert.Equal(t, image.ID, message.Attachments[1].ID)
+
+		got, err = messageRepo.GetAttachment(image.ID)
+		require.NoError(t, err)
+		assert.Equal(t, chatID, got.ChatID)
+		assert.Equal(t, message.ID, got.MessageID)
+
+		messages, err := messageRepo.GetChatMessages(chatID, repo.MessageQuery{})
+		require.NoError(t, err)
+		require.Len(t, messages, 1)
+		assert.Equal(t, message.Attachments, messages[0].Attachments)
+
+		_, err = messageRepo.AddMessageWithOptions(chatID, "1", "Again", repo.MessageOptions{Attachments: []string{image.ID}})
+		require.ErrorIs(t, err, repo.ErrAttachmentInUse)
+		require.ErrorIs(t, messageRepo.DeleteAttachment(image.ID), repo.ErrAttachmentInUse)
+
+		// the attachments left unattached, uploaded before the time.
+		unattached, err := messageRepo.GetUnattachedAttachments(time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []repo.Attachment{other, photo}, unattached)
+
+		unattached, err = messageRepo.GetUnattachedAttachments(other.CreatedAt)
+		require.NoError(t, err)
+		assert.Empty(t, unattached)
+
+		require.NoError(t, messageRepo.DeleteAttachment(other.ID))
+		_, err = messageRepo.GetAttachment(other.ID)
+		require.ErrorIs(t, err, repo.ErrAttachmentNotFound)
+		require.ErrorIs(t, messageRepo.DeleteAttachment(other.ID), repo.ErrAttachmentNotFound)
+
+		// the attachments of a deleted message are erased.
+		deleted, err := messageRepo.DeleteMessage(chatID, message.ID)
+		require.NoError(t, err)
+		assert.Empty(t, deleted.Attachments)
+
+		_, err = messageRepo.GetAttachment(image.ID)
+		require.ErrorIs(t, err, repo.ErrAttachmentNotFound)
+	})
+}
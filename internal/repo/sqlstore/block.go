@@ -0,0 +1,113 @@
+package sqlstore
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// AddBlock blocks a user for the blocker, nothing changes if the user is already blocked.
+func (r *ChatRepository) AddBlock(blocker, blocked string) error {
+	_, err := r.db.db.Exec(
+		`INSERT INTO blocks (blocker, blocked, created_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`,
+		blocker, blocked, time.Now().UTC().Truncate(time.Millisecond),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert block: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveBlock unblocks a user for the blocker.
+// It returns repo.ErrBlockNotFound if the user is not blocked.
+func (r *ChatRepository) RemoveBlock(blocker, blocked string) error {
+	result, err := r.db.db.Exec(`DELETE FROM blocks WHERE blocker = $1 AND blocked = $2`, blocker, blocked)
+	if err != nil {
+		return fmt.Errorf("failed to delete block: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete block: %w", err)
+	}
+
+	if count == 0 {
+		return repo.ErrBlockNotFound
+	}
+
+	return nil
+}
+
+// GetBlocks gets the users blocked by the blocker, in the order they have been blocked.
+func (r *ChatRepository) GetBlocks(blocker string) ([]repo.Block, error) {
+	rows, err := r.db.db.Query(
+		`SELECT blocked, created_at FROM blocks WHERE blocker = $1 ORDER BY created_at, blocked`,
+		blocker,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blocks: %w", err)
+	}
+	defer rows.Close()
+
+	blocks := []repo.Block{}
+
+	for rows.Next() {
+		block := repo.Block{Blocker: blocker}
+		if err = rows.Scan(&block.Blocked, &block.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan block: %w", err)
+		}
+
+		block.CreatedAt = block.CreatedAt.UTC()
+		blocks = append(blocks, block)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get blocks: %w", err)
+	}
+
+	return blocks, nil
+}
+
+// GetBlockers gets the users, among the given ones, who blocked the user.
+func (r *ChatRepository) GetBlockers(user string, users []string) ([]string, error) {
+	blockers := []string{}
+
+	if len(users) == 0 {
+		return blockers, nil
+	}
+
+	args := []any{user}
+
+	placeholders := make([]string, len(users))
+	for i, other := range users {
+		args = append(args, other)
+		placeholders[i] = fmt.Sprintf("$%d", len(args))
+	}
+
+	rows, err := r.db.db.Query(
+		`SELECT blocker FROM blocks WHERE blocked = $1 AND blocker IN (`+strings.Join(placeholders, ", ")+`)`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blockers: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var blocker string
+		if err = rows.Scan(&blocker); err != nil {
+			return nil, fmt.Errorf("failed to scan blocker: %w", err)
+		}
+
+		blockers = append(blockers, blocker)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get blockers: %w", err)
+	}
+
+	return blockers, nil
+}
@@ -0,0 +1,105 @@
+package sqlstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+func TestChatRepository_Blocks(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+
+		require.NoError(t, chatRepo.AddBlock("1", "2"))
+		time.Sleep(time.Millisecond)
+		require.NoError(t, chatRepo.AddBlock("1", "3"))
+		// blocking a user twice keeps the first block.
+		require.NoError(t, chatRepo.AddBlock("1", "2"))
+		require.NoError(t, chatRepo.AddBlock("3", "2"))
+
+		blocks, err := chatRepo.GetBlocks("1")
+		require.NoError(t, err)
+		require.Len(t, blocks, 2)
+		assert.Equal(t, repo.Block{Blocker: "1", Blocked: "2", CreatedAt: blocks[0].CreatedAt}, blocks[0])
+		assert.Equal(t, repo.Block{Blocker: "1", Blocked: "3", CreatedAt: blocks[1].CreatedAt}, blocks[1])
+		assert.True(t, blocks[0].CreatedAt.Before(blocks[1].CreatedAt))
+
+		blockers, err := chatRepo.GetBlockers("2", []string{"1", "3", "4"})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"1", "3"}, blockers)
+
+		require.NoError(t, chatRepo.RemoveBlock("1", "2"))
+		require.ErrorIs(t, chatRepo.RemoveBlock("1", "2"), repo.ErrBlockNotFound)
+
+		blockers, err = chatRepo.GetBlockers("2", []string{"1", "3"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"3"}, blockers)
+
+		blocks, err = chatRepo.GetBlocks("4")
+		require.NoError(t, err)
+		assert.Empty(t, blocks)
+	})
+}
+
+func TestChatRepository_GetUserChats_Blocked(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+		messageRepo := NewMessageRepository(db)
+
+		direct, _, err := chatRepo.GetOrCreateChat("1", "2")
+		require.NoError(t, err)
+
+		group, err := chatRepo.CreateGroupChat("Friends", []string{"1", "2"})
+		require.NoError(t, err)
+
+		// the direct chat is the most recently active one.
+		time.Sleep(time.Millisecond)
+
+		hello, err := messageRepo.AddMessage(direct.ID, "2", "Hello")
+		require.NoError(t, err)
+
+		require.NoError(t, chatRepo.AddBlock("1", "2"))
+
+		blocked, err := messageRepo.AddMessageWithOptions(direct.ID, "2", "Hello?", repo.MessageOptions{Blocked: true})
+		require.NoError(t, err)
+		assert.True(t, blocked.VisibleTo("2"))
+		assert.False(t, blocked.VisibleTo("1"))
+
+		// the direct chat with the blocked user is hidden, not the group chat.
+		chats, err := chatRepo.GetUserChats("1", repo.ChatQuery{})
+		require.NoError(t, err)
+		require.Len(t, chats, 1)
+		assert.Equal(t, group.ID, chats[0].ID)
+
+		// the blocked message is only visible to its sender.
+		chats, err = chatRepo.GetUserChats("2", repo.ChatQuery{})
+		require.NoError(t, err)
+		require.Len(t, chats, 2)
+		assert.Equal(t, &blocked, chats[0].LastMessage)
+
+		require.NoError(t, chatRepo.RemoveBlock("1", "2"))
+
+		chats, err = chatRepo.GetUserChats("1", repo.ChatQuery{})
+		require.NoError(t, err)
+		require.Len(t, chats, 2)
+		assert.Equal(t, direct.ID, chats[0].ID)
+		assert.Equal(t, &hello, chats[0].LastMessage)
+		assert.Equal(t, int64(1), chats[0].Unread)
+
+		messages, err := messageRepo.GetChatMessages(direct.ID, repo.MessageQuery{Viewer: "1"})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.Message{hello}, messages)
+
+		messages, err = messageRepo.GetChatMessages(direct.ID, repo.MessageQuery{Viewer: "2"})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.Message{hello, blocked}, messages)
+
+		messages, err = messageRepo.SearchMessages(repo.SearchQuery{Terms: []string{"hello"}, ChatIDs: []string{direct.ID}, Viewer: "1"})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.Message{hello}, messages)
+	})
+}
@@ -0,0 +1,638 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// ChatRepository manages chat storage and operations.
+type ChatRepository struct {
+	db *DB
+
+	logger *slog.Logger
+}
+
+// NewChatRepository initializes a new ChatRepository.
+func NewChatRepository(db *DB) *ChatRepository {
+	logger := slog.With(slog.String("repo", "chat"), slog.String("db", db.driver))
+	logger.Info("created repository")
+
+	return &ChatRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// directKey identifies the chat between 2 users, whatever the order of the users.
+func directKey(sender, receiver string) string {
+	users := []string{sender, receiver}
+	slices.Sort(users)
+
+	return strings.Join(users, " ")
+}
+
+// GetOrCreateChat gets the chat between the sender and the receiver, creating it if needed.
+// It reports whether the chat has been created.
+// The chat creation relies on the unique direct key of the chat,
+// so concurrent calls, even from several instances, always end with the same chat.
+func (r *ChatRepository) GetOrCreateChat(sender, receiver string) (repo.Chat, bool, error) {
+	var (
+		chat    repo.Chat
+		created bool
+	)
+
+	err := r.db.withTx(func(tx *sql.Tx) error {
+		key := directKey(sender, receiver)
+		newChat := repo.Chat{
+			ID:           uuid.NewString(),
+			Participants: []string{sender, receiver},
+			CreatedAt:    time.Now().UTC().Truncate(time.Millisecond),
+		}
+
+		// waits for a concurrent transaction creating the same chat, if any, to finish.
+		result, err := tx.Exec(
+			`INSERT INTO chats (id, direct_key, created_at) VALUES ($1, $2, $3) ON CONFLICT (direct_key) DO NOTHING`,
+			newChat.ID, key, newChat.CreatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert chat: %w", err)
+		}
+
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to insert chat: %w", err)
+		}
+
+		if inserted == 0 {
+			var chatID string
+			if err = tx.QueryRow(`SELECT id FROM chats WHERE direct_key = $1`, key).Scan(&chatID); err != nil {
+				return fmt.Errorf("failed to get chat: %w", err)
+			}
+
+			chat, err = getChat(tx, chatID)
+
+			return err
+		}
+
+		for i, user := range newChat.Participants {
+			_, err = tx.Exec(
+				`INSERT INTO chat_participants (chat_id, user_id, position) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`,
+				newChat.ID, user, i,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to insert chat participant: %w", err)
+			}
+		}
+
+		chat, created = newChat, true
+
+		return nil
+	})
+	if err != nil {
+		return repo.Chat{}, false, err
+	}
+
+	return chat, created, nil
+}
+
+// Roles of the participants of a group chat.
+const (
+	roleAdmin  = "admin"
+	roleMember = "member"
+)
+
+// CreateGroupChat creates a named group chat with the members, in their joining order.
+// The first member, its creator, is the admin of the chat, the members can't be empty.
+func (r *ChatRepository) CreateGroupChat(name string, members []string) (repo.Chat, error) {
+	chat := repo.Chat{
+		ID:           uuid.NewString(),
+		Name:         name,
+		Group:        true,
+		Participants: slices.Clone(members),
+		Admins:       []string{members[0]},
+		CreatedAt:    time.Now().UTC().Truncate(time.Millisecond),
+	}
+
+	err := r.db.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO chats (id, name, created_at) VALUES ($1, $2, $3)`, chat.ID, chat.Name, chat.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert chat: %w", err)
+		}
+
+		for i, member := range chat.Participants {
+			role := roleMember
+			if chat.IsAdmin(member) {
+				role = roleAdmin
+			}
+
+			_, err = tx.Exec(
+				`INSERT INTO chat_participants (chat_id, user_id, position, role) VALUES ($1, $2, $3, $4)`,
+				chat.ID, member, i, role,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to insert chat participant: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return repo.Chat{}, err
+	}
+
+	return chat, nil
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// getChat gets a chat by its ID.
+func getChat(q querier, chatID string) (repo.Chat, error) {
+	chat := repo.Chat{ID: chatID}
+
+	err := q.QueryRow(`SELECT name, direct_key IS NULL, created_at FROM chats WHERE id = $1`, chatID).
+		Scan(&chat.Name, &chat.Group, &chat.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repo.Chat{}, repo.ErrChatNotFound
+	}
+
+	if err != nil {
+		return repo.Chat{}, fmt.Errorf("failed to get chat: %w", err)
+	}
+
+	chat.CreatedAt = chat.CreatedAt.UTC()
+
+	rows, err := q.Query(`SELECT user_id, role FROM chat_participants WHERE chat_id = $1 ORDER BY position`, chatID)
+	if err != nil {
+		return repo.Chat{}, fmt.Errorf("failed to get chat participants: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var participant, role string
+		if err = rows.Scan(&participant, &role); err != nil {
+			return repo.Chat{}, fmt.Errorf("failed to scan chat participant: %w", err)
+		}
+
+		addParticipant(&chat, participant, role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return repo.Chat{}, fmt.Errorf("failed to get chat participants: %w", err)
+	}
+
+	return chat, nil
+}
+
+// addParticipant adds the participant with the role to the chat, the participants being loaded in their order.
+func addParticipant(chat *repo.Chat, participant, role string) {
+	chat.Participants = append(chat.Participants, participant)
+
+	if role == roleAdmin {
+		chat.Admins = append(chat.Admins, participant)
+	}
+}
+
+// GetChat gets a chat by its ID.
+func (r *ChatRepository) GetChat(chatID string) (repo.Chat, error) {
+	return getChat(r.db.db, chatID)
+}
+
+// lockGroupChat locks the group chat until the end of the transaction,
+// so its participants are updated one transaction at a time.
+func lockGroupChat(tx *sql.Tx, chatID string) error {
+	var group bool
+
+	// the no-op update locks the chat row.
+	err := tx.QueryRow(`UPDATE chats SET name = name WHERE id = $1 RETURNING direct_key IS NULL`, chatID).Scan(&group)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repo.ErrChatNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to lock chat: %w", err)
+	}
+
+	if !group {
+		return repo.ErrNotGroupChat
+	}
+
+	return nil
+}
+
+// AddParticipants adds the users to the group chat, as members.
+// The users already participating are ignored, the added ones are returned with the updated chat.
+func (r *ChatRepository) AddParticipants(chatID string, users []string) (repo.Chat, []string, error) {
+	var (
+		chat  repo.Chat
+		added []string
+	)
+
+	err := r.db.withTx(func(tx *sql.Tx) error {
+		if err := lockGroupChat(tx, chatID); err != nil {
+			return err
+		}
+
+		var position int
+
+		err := tx.QueryRow(`SELECT COALESCE(MAX(position), -1) + 1 FROM chat_participants WHERE chat_id = $1`, chatID).Scan(&position)
+		if err != nil {
+			return fmt.Errorf("failed to get chat participants: %w", err)
+		}
+
+		for _, user := range users {
+			result, err := tx.Exec(
+				`INSERT INTO chat_participants (chat_id, user_id, position, role) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`,
+				chatID, user, position, roleMember,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to insert chat participant: %w", err)
+			}
+
+			inserted, err := result.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("failed to insert chat participant: %w", err)
+			}
+
+			if inserted > 0 {
+				added = append(added, user)
+				position++
+			}
+		}
+
+		chat, err = getChat(tx, chatID)
+
+		return err
+	})
+	if err != nil {
+		return repo.Chat{}, nil, err
+	}
+
+	return chat, added, nil
+}
+
+// RemoveParticipant removes the user from the group chat.
+// When the last admin is removed, the oldest participant becomes an admin and is returned with the updated chat.
+func (r *ChatRepository) RemoveParticipant(chatID, user string) (repo.Chat, string, error) {
+	var (
+		chat     repo.Chat
+		promoted string
+	)
+
+	err := r.db.withTx(func(tx *sql.Tx) error {
+		if err := lockGroupChat(tx, chatID); err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(`DELETE FROM chat_participants WHERE chat_id = $1 AND user_id = $2`, chatID, user)
+		if err != nil {
+			return fmt.Errorf("failed to delete chat participant: %w", err)
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to delete chat participant: %w", err)
+		}
+
+		if deleted == 0 {
+			return repo.ErrNotParticipant
+		}
+
+		if promoted, err = promoteOldestIfNoAdmin(tx, chatID); err != nil {
+			return err
+		}
+
+		chat, err = getChat(tx, chatID)
+
+		return err
+	})
+	if err != nil {
+		return repo.Chat{}, "", err
+	}
+
+	return chat, promoted, nil
+}
+
+// promoteOldestIfNoAdmin promotes the oldest participant of the chat to admin if the chat has no admin,
+// and returns them.
+func promoteOldestIfNoAdmin(tx *sql.Tx, chatID string) (string, error) {
+	var admins int
+
+	err := tx.QueryRow(`SELECT COUNT(*) FROM chat_participants WHERE chat_id = $1 AND role = $2`, chatID, roleAdmin).Scan(&admins)
+	if err != nil {
+		return "", fmt.Errorf("failed to count chat admins: %w", err)
+	}
+
+	if admins > 0 {
+		return "", nil
+	}
+
+	var oldest string
+
+	err = tx.QueryRow(`SELECT user_id FROM chat_participants WHERE chat_id = $1 ORDER BY position LIMIT 1`, chatID).Scan(&oldest)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the chat has no participant anymore.
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("failed to get chat participants: %w", err)
+	}
+
+	if err = setRole(tx, chatID, oldest, roleAdmin); err != nil {
+		return "", err
+	}
+
+	return oldest, nil
+}
+
+// setRole sets the role of the participant of the chat.
+func setRole(tx *sql.Tx, chatID, user, role string) error {
+	_, err := tx.Exec(`UPDATE chat_participants SET role = $1 WHERE chat_id = $2 AND user_id = $3`, role, chatID, user)
+	if err != nil {
+		return fmt.Errorf("failed to update chat participant: %w", err)
+	}
+
+	return nil
+}
+
+// SetAdmin promotes the participant to admin of the group chat, or demotes them to member.
+// The last admin can't be demoted.
+func (r *ChatRepository) SetAdmin(chatID, user string, admin bool) (repo.Chat, error) {
+	var chat repo.Chat
+
+	err := r.db.withTx(func(tx *sql.Tx) error {
+		if err := lockGroupChat(tx, chatID); err != nil {
+			return err
+		}
+
+		var role string
+
+		err := tx.QueryRow(`SELECT role FROM chat_participants WHERE chat_id = $1 AND user_id = $2`, chatID, user).Scan(&role)
+		if errors.Is(err, sql.ErrNoRows) {
+			return repo.ErrNotParticipant
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to get chat participant: %w", err)
+		}
+
+		newRole := roleMember
+		if admin {
+			newRole = roleAdmin
+		}
+
+		if role == roleAdmin && newRole == roleMember {
+			var admins int
+
+			err = tx.QueryRow(`SELECT COUNT(*) FROM chat_participants WHERE chat_id = $1 AND role = $2`, chatID, roleAdmin).Scan(&admins)
+			if err != nil {
+				return fmt.Errorf("failed to count chat admins: %w", err)
+			}
+
+			if admins == 1 {
+				return repo.ErrLastAdmin
+			}
+		}
+
+		if err = setRole(tx, chatID, user, newRole); err != nil {
+			return err
+		}
+
+		chat, err = getChat(tx, chatID)
+
+		return err
+	})
+	if err != nil {
+		return repo.Chat{}, err
+	}
+
+	return chat, nil
+}
+
+// RenameChat renames the group chat.
+func (r *ChatRepository) RenameChat(chatID, name string) (repo.Chat, error) {
+	var chat repo.Chat
+
+	err := r.db.withTx(func(tx *sql.Tx) error {
+		if err := lockGroupChat(tx, chatID); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`UPDATE chats SET name = $1 WHERE id = $2`, name, chatID); err != nil {
+			return fmt.Errorf("failed to rename chat: %w", err)
+		}
+
+		var err error
+		chat, err = getChat(tx, chatID)
+
+		return err
+	})
+	if err != nil {
+		return repo.Chat{}, err
+	}
+
+	return chat, nil
+}
+
+// lastActivityAt is the time of the last message of a chat, or its creation time if it has no message.
+const lastActivityAt = `COALESCE(m.created_at, c.created_at)`
+
+// GetUserChats gets the chats of a user matching the query, with their last message
+// and the number of messages the user has not read.
+// The direct chats with a user blocked by the user are hidden.
+func (r *ChatRepository) GetUserChats(user string, query repo.ChatQuery) ([]repo.UserChat, error) {
+	stmt := `
+		SELECT c.id, c.name, c.direct_key IS NULL, c.created_at,
+			m.id, m.seq, m.global_seq, m.sender, m.content, m.created_at, m.system, m.edited_at, m.deleted_at, m.reply_to, m.reply_count, m.blocked,
+			(SELECT COUNT(*) FROM messages u WHERE u.chat_id = c.id AND u.seq > p.read_seq AND u.sender <> p.user_id AND NOT u.blocked)
+		FROM chats c
+		JOIN chat_participants p ON p.chat_id = c.id
+		LEFT JOIN messages m ON m.id = (
+			SELECT v.id FROM messages v WHERE v.chat_id = c.id AND (NOT v.blocked OR v.sender = p.user_id) ORDER BY v.seq DESC LIMIT 1
+		)
+		WHERE p.user_id = $1 AND NOT EXISTS (
+			SELECT 1 FROM blocks b JOIN chat_participants o ON o.user_id = b.blocked
+			WHERE c.direct_key IS NOT NULL AND o.chat_id = c.id AND b.blocker = p.user_id
+		)`
+	args := []any{user}
+
+	if query.Before != nil {
+		stmt += fmt.Sprintf(` AND (%[1]s < $%[2]d OR (%[1]s = $%[2]d AND c.id < $%[3]d))`, lastActivityAt, len(args)+1, len(args)+2)
+		args = append(args, query.Before.LastActivityAt, query.Before.ID)
+	}
+
+	if query.After != nil {
+		stmt += fmt.Sprintf(` AND (%[1]s > $%[2]d OR (%[1]s = $%[2]d AND c.id > $%[3]d))`, lastActivityAt, len(args)+1, len(args)+2)
+		args = append(args, query.After.LastActivityAt, query.After.ID)
+	}
+
+	// the chats right after the After position are selected in the reverse order, then sorted back.
+	reverse := query.Limit > 0 && query.Forward()
+	if reverse {
+		stmt += ` ORDER BY ` + lastActivityAt + `, c.id`
+	} else {
+		stmt += ` ORDER BY ` + lastActivityAt + ` DESC, c.id DESC`
+	}
+
+	if query.Limit > 0 {
+		stmt += fmt.Sprintf(` LIMIT $%d`, len(args)+1)
+		args = append(args, query.Limit)
+	}
+
+	rows, err := r.db.db.Query(stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user chats: %w", err)
+	}
+	defer rows.Close()
+
+	userChats := []repo.UserChat{}
+
+	for rows.Next() {
+		userChat, err := scanUserChat(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		userChats = append(userChats, userChat)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get user chats: %w", err)
+	}
+
+	if reverse {
+		slices.Reverse(userChats)
+	}
+
+	if err = r.loadParticipants(userChats); err != nil {
+		return nil, err
+	}
+
+	r.logger.Debug("get user chats",
+		slog.String("user", user),
+		slog.Any("chats", userChats),
+	)
+
+	return userChats, nil
+}
+
+// scanUserChat scans a row selected by GetUserChats, the last message columns are null if the chat has no message.
+func scanUserChat(rows *sql.Rows) (repo.UserChat, error) {
+	var (
+		userChat repo.UserChat
+		message  struct {
+			id         sql.Null[string]
+			seq        sql.Null[int64]
+			globalSeq  sql.Null[int64]
+			sender     sql.Null[string]
+			content    sql.Null[string]
+			createdAt  sql.Null[time.Time]
+			system     sql.Null[bool]
+			editedAt   sql.Null[time.Time]
+			deletedAt  sql.Null[time.Time]
+			replyTo    sql.Null[string]
+			replyCount sql.Null[int64]
+			blocked    sql.Null[bool]
+		}
+	)
+
+	err := rows.Scan(
+		&userChat.ID, &userChat.Name, &userChat.Group, &userChat.CreatedAt,
+		&message.id, &message.seq, &message.globalSeq, &message.sender, &message.content, &message.createdAt, &message.system,
+		&message.editedAt, &message.deletedAt, &message.replyTo, &message.replyCount, &message.blocked,
+		&userChat.Unread,
+	)
+	if err != nil {
+		return repo.UserChat{}, fmt.Errorf("failed to scan chat: %w", err)
+	}
+
+	userChat.CreatedAt = userChat.CreatedAt.UTC()
+
+	if message.id.Valid {
+		userChat.LastMessage = &repo.Message{
+			ID:         message.id.V,
+			ChatID:     userChat.ID,
+			Sender:     message.sender.V,
+			Content:    message.content.V,
+			CreatedAt:  message.createdAt.V.UTC(),
+			Seq:        message.seq.V,
+			GlobalSeq:  message.globalSeq.V,
+			System:     message.system.V,
+			ReplyTo:    message.replyTo.V,
+			ReplyCount: message.replyCount.V,
+			Blocked:    message.blocked.V,
+		}
+
+		if message.editedAt.Valid {
+			userChat.LastMessage.EditedAt = message.editedAt.V.UTC()
+		}
+
+		if message.deletedAt.Valid {
+			userChat.LastMessage.DeletedAt = message.deletedAt.V.UTC()
+		}
+	}
+
+	return userChat, nil
+}
+
+// loadParticipants fills the participants of the chats.
+func (r *ChatRepository) loadParticipants(chats []repo.UserChat) error {
+	if len(chats) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(chats))
+	args := make([]any, len(chats))
+	indexes := make(map[string]int, len(chats))
+
+	for i, chat := range chats {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = chat.ID
+		indexes[chat.ID] = i
+	}
+
+	rows, err := r.db.db.Query(`
+		SELECT chat_id, user_id, role
+		FROM chat_participants
+		WHERE chat_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY chat_id, position`,
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to get chat participants: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chatID, participant, role string
+		if err = rows.Scan(&chatID, &participant, &role); err != nil {
+			return fmt.Errorf("failed to scan chat participant: %w", err)
+		}
+
+		i, ok := indexes[chatID]
+		if !ok {
+			continue
+		}
+
+		addParticipant(&chats[i].Chat, participant, role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to get chat participants: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,374 @@
+package sqlstore
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+func TestChatRepository_GetOrCreateChat(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+
+		chat, created, err := chatRepo.GetOrCreateChat("123", "456")
+		require.NoError(t, err)
+		require.True(t, created)
+		require.NotEmpty(t, chat.ID)
+		assert.Equal(t, []string{"123", "456"}, chat.Participants)
+		assert.False(t, chat.CreatedAt.IsZero())
+
+		// the chat is shared whatever the order of the users.
+		sameChat, created, err := chatRepo.GetOrCreateChat("456", "123")
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, chat, sameChat)
+
+		otherChat, created, err := chatRepo.GetOrCreateChat("123", "789")
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.NotEqual(t, chat.ID, otherChat.ID)
+	})
+}
+
+func TestChatRepository_GetOrCreateChat_Concurrent(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+
+		var wg sync.WaitGroup
+
+		chats := make([]repo.Chat, 10)
+		created := make([]bool, len(chats))
+		errs := make([]error, len(chats))
+
+		for i := range chats {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				// alternate the order of the users to check the chat is shared.
+				if i%2 == 0 {
+					chats[i], created[i], errs[i] = chatRepo.GetOrCreateChat("123", "456")
+				} else {
+					chats[i], created[i], errs[i] = chatRepo.GetOrCreateChat("456", "123")
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		creations := 0
+
+		for i := range chats {
+			require.NoError(t, errs[i])
+			assert.Equal(t, chats[0].ID, chats[i].ID)
+
+			if created[i] {
+				creations++
+			}
+		}
+
+		assert.Equal(t, 1, creations)
+
+		userChats, err := chatRepo.GetUserChats("123", repo.ChatQuery{})
+		require.NoError(t, err)
+		assert.Len(t, userChats, 1)
+	})
+}
+
+func TestChatRepository_GetChat(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+
+		chat, _, err := chatRepo.GetOrCreateChat("123", "456")
+		require.NoError(t, err)
+
+		got, err := chatRepo.GetChat(chat.ID)
+		require.NoError(t, err)
+		assert.Equal(t, chat, got)
+
+		_, err = chatRepo.GetChat("unknown")
+		require.ErrorIs(t, err, repo.ErrChatNotFound)
+	})
+}
+
+func TestChatRepository_CreateGroupChat(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+
+		direct, _, err := chatRepo.GetOrCreateChat("123", "456")
+		require.NoError(t, err)
+
+		group, err := chatRepo.CreateGroupChat("friends", []string{"456", "123", "789"})
+		require.NoError(t, err)
+		assert.True(t, group.Group)
+		assert.Equal(t, "friends", group.Name)
+
+		got, err := chatRepo.GetChat(group.ID)
+		require.NoError(t, err)
+		assert.Equal(t, group, got)
+
+		got, err = chatRepo.GetChat(direct.ID)
+		require.NoError(t, err)
+		assert.False(t, got.Group)
+		assert.Empty(t, got.Name)
+
+		// several groups can have the same members.
+		otherGroup, err := chatRepo.CreateGroupChat("friends", []string{"456", "123", "789"})
+		require.NoError(t, err)
+		assert.NotEqual(t, group.ID, otherGroup.ID)
+
+		chats, err := chatRepo.GetUserChats("789", repo.ChatQuery{})
+		require.NoError(t, err)
+		assert.Equal(t, sortChats(group, otherGroup), chats)
+
+		chats, err = chatRepo.GetUserChats("123", repo.ChatQuery{})
+		require.NoError(t, err)
+		assert.Equal(t, sortChats(direct, group, otherGroup), chats)
+	})
+}
+
+func TestChatRepository_Membership(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+
+		chat, err := chatRepo.CreateGroupChat("friends", []string{"1", "2"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1"}, chat.Admins)
+
+		// the participants already in the chat are ignored.
+		updated, added, err := chatRepo.AddParticipants(chat.ID, []string{"2", "3", "4"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"3", "4"}, added)
+		assert.Equal(t, []string{"1", "2", "3", "4"}, updated.Participants)
+
+		chats, err := chatRepo.GetUserChats("4", repo.ChatQuery{})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.UserChat{{Chat: updated}}, chats)
+
+		updated, err = chatRepo.SetAdmin(chat.ID, "3", true)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1", "3"}, updated.Admins)
+
+		updated, err = chatRepo.SetAdmin(chat.ID, "1", false)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"3"}, updated.Admins)
+
+		_, err = chatRepo.SetAdmin(chat.ID, "3", false)
+		require.ErrorIs(t, err, repo.ErrLastAdmin)
+
+		_, err = chatRepo.SetAdmin(chat.ID, "5", true)
+		require.ErrorIs(t, err, repo.ErrNotParticipant)
+
+		// removing the last admin promotes the oldest participant.
+		updated, promoted, err := chatRepo.RemoveParticipant(chat.ID, "3")
+		require.NoError(t, err)
+		assert.Equal(t, "1", promoted)
+		assert.Equal(t, []string{"1", "2", "4"}, updated.Participants)
+		assert.Equal(t, []string{"1"}, updated.Admins)
+
+		chats, err = chatRepo.GetUserChats("3", repo.ChatQuery{})
+		require.NoError(t, err)
+		assert.Empty(t, chats)
+
+		_, _, err = chatRepo.RemoveParticipant(chat.ID, "3")
+		require.ErrorIs(t, err, repo.ErrNotParticipant)
+
+		// a participant joining again is the most recent one.
+		updated, added, err = chatRepo.AddParticipants(chat.ID, []string{"3"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"3"}, added)
+		assert.Equal(t, []string{"1", "2", "4", "3"}, updated.Participants)
+
+		updated, err = chatRepo.RenameChat(chat.ID, "best friends")
+		require.NoError(t, err)
+		assert.Equal(t, "best friends", updated.Name)
+
+		got, err := chatRepo.GetChat(chat.ID)
+		require.NoError(t, err)
+		assert.Equal(t, updated, got)
+	})
+}
+
+func TestChatRepository_Membership_Errors(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+
+		direct, _, err := chatRepo.GetOrCreateChat("1", "2")
+		require.NoError(t, err)
+		assert.Empty(t, direct.Admins)
+
+		_, _, err = chatRepo.AddParticipants(direct.ID, []string{"3"})
+		require.ErrorIs(t, err, repo.ErrNotGroupChat)
+
+		_, _, err = chatRepo.RemoveParticipant(direct.ID, "2")
+		require.ErrorIs(t, err, repo.ErrNotGroupChat)
+
+		_, err = chatRepo.SetAdmin(direct.ID, "2", true)
+		require.ErrorIs(t, err, repo.ErrNotGroupChat)
+
+		_, err = chatRepo.RenameChat("unknown", "name")
+		require.ErrorIs(t, err, repo.ErrChatNotFound)
+	})
+}
+
+func TestChatRepository_GetUserChats(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+
+		chat, _, err := chatRepo.GetOrCreateChat("123", "456")
+		require.NoError(t, err)
+
+		otherChat, _, err := chatRepo.GetOrCreateChat("789", "123")
+		require.NoError(t, err)
+
+		// the chats are sorted from the most recent to the oldest.
+		chats, err := chatRepo.GetUserChats("123", repo.ChatQuery{})
+		require.NoError(t, err)
+		assert.Equal(t, sortChats(chat, otherChat), chats)
+
+		chats, err = chatRepo.GetUserChats("456", repo.ChatQuery{})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.UserChat{{Chat: chat}}, chats)
+
+		chats, err = chatRepo.GetUserChats("unknown", repo.ChatQuery{})
+		require.NoError(t, err)
+		assert.Empty(t, chats)
+	})
+}
+
+func TestChatRepository_GetUserChats_Activity(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+		messageRepo := NewMessageRepository(db)
+
+		older, _, err := chatRepo.GetOrCreateChat("123", "456")
+		require.NoError(t, err)
+
+		time.Sleep(time.Millisecond)
+
+		newer, _, err := chatRepo.GetOrCreateChat("123", "789")
+		require.NoError(t, err)
+
+		// a new message makes the older chat the most recently active one.
+		time.Sleep(time.Millisecond)
+
+		_, err = messageRepo.AddMessage(older.ID, "123", "Hello")
+		require.NoError(t, err)
+
+		_, err = messageRepo.AddMessage(older.ID, "456", "Hi")
+		require.NoError(t, err)
+
+		last, err := messageRepo.AddMessage(older.ID, "456", "How are you?")
+		require.NoError(t, err)
+
+		chats, err := chatRepo.GetUserChats("123", repo.ChatQuery{})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.UserChat{{Chat: older, LastMessage: &last, Unread: 2}, {Chat: newer}}, chats)
+
+		chats, err = chatRepo.GetUserChats("123", repo.ChatQuery{After: ptr(chats[1].Position())})
+		require.NoError(t, err)
+		require.Len(t, chats, 1)
+		assert.Equal(t, older.ID, chats[0].ID)
+
+		chats, err = chatRepo.GetUserChats("123", repo.ChatQuery{Before: ptr(chats[0].Position())})
+		require.NoError(t, err)
+		require.Len(t, chats, 1)
+		assert.Equal(t, newer.ID, chats[0].ID)
+
+		// the messages read and the messages of the user are not unread.
+		_, _, err = chatRepo.MarkRead(older.ID, "123", 2)
+		require.NoError(t, err)
+
+		chats, err = chatRepo.GetUserChats("123", repo.ChatQuery{Limit: 1})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), chats[0].Unread)
+
+		chats, err = chatRepo.GetUserChats("456", repo.ChatQuery{})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), chats[0].Unread)
+	})
+}
+
+func TestChatRepository_GetUserChats_Pagination(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+
+		var created []repo.Chat
+
+		// the chats are created in the same millisecond, some of them share the same creation date.
+		for i := range 5 {
+			chat, _, err := chatRepo.GetOrCreateChat("123", strconv.Itoa(i))
+			require.NoError(t, err)
+
+			created = append(created, chat)
+		}
+
+		chats := sortChats(created...)
+
+		testCases := []struct {
+			desc     string
+			query    repo.ChatQuery
+			expected []repo.UserChat
+		}{
+			{
+				desc:     "most recent",
+				query:    repo.ChatQuery{Limit: 2},
+				expected: chats[:2],
+			},
+			{
+				desc:     "before",
+				query:    repo.ChatQuery{Before: ptr(chats[1].Position()), Limit: 2},
+				expected: chats[2:4],
+			},
+			{
+				desc:     "after",
+				query:    repo.ChatQuery{After: ptr(chats[4].Position()), Limit: 2},
+				expected: chats[2:4],
+			},
+			{
+				desc:     "between",
+				query:    repo.ChatQuery{Before: ptr(chats[0].Position()), After: ptr(chats[4].Position()), Limit: 2},
+				expected: chats[1:3],
+			},
+			{
+				desc:     "after the most recent",
+				query:    repo.ChatQuery{After: ptr(chats[0].Position())},
+				expected: []repo.UserChat{},
+			},
+		}
+
+		for _, test := range testCases {
+			t.Run(test.desc, func(t *testing.T) {
+				got, err := chatRepo.GetUserChats("123", test.query)
+				require.NoError(t, err)
+				assert.Equal(t, test.expected, got)
+			})
+		}
+	})
+}
+
+// sortChats sorts the chats without message from the most recent to the oldest.
+func sortChats(chats ...repo.Chat) []repo.UserChat {
+	userChats := make([]repo.UserChat, 0, len(chats))
+	for _, chat := range chats {
+		userChats = append(userChats, repo.UserChat{Chat: chat})
+	}
+
+	slices.SortFunc(userChats, func(a, b repo.UserChat) int {
+		return b.Position().Compare(a.Position())
+	})
+
+	return userChats
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
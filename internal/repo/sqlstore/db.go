@@ -0,0 +1,112 @@
+// Package sqlstore implements the repositories on top of a SQL database.
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	// register the PostgreSQL driver.
+	_ "github.com/jackc/pgx/v5/stdlib"
+	// register the pure Go SQLite driver.
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// DriverSQLite is the driver name of the embedded SQLite database.
+	DriverSQLite = "sqlite"
+	// DriverPostgres is the driver name of the PostgreSQL database.
+	DriverPostgres = "postgres"
+)
+
+// sqlDrivers maps the supported drivers to their database/sql driver names.
+var sqlDrivers = map[string]string{
+	DriverSQLite:   "sqlite",
+	DriverPostgres: "pgx",
+}
+
+// ErrUnsupportedDriver is returned when the driver is not supported by the store.
+var ErrUnsupportedDriver = errors.New("unsupported driver")
+
+// DB is a connection to the database used by the repositories.
+type DB struct {
+	db     *sql.DB
+	driver string
+
+	logger *slog.Logger
+}
+
+// Open opens a connection to the database.
+// The schema is not migrated, see MigrateUp.
+func Open(driver, dsn string) (*DB, error) {
+	sqlDriver, ok := sqlDrivers[driver]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedDriver, driver)
+	}
+
+	sqlDB, err := sql.Open(sqlDriver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if driver == DriverSQLite {
+		// SQLite only supports a single writer,
+		// sharing one connection avoids "database is locked" errors
+		// and keeps in-memory databases alive between queries.
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	if err = sqlDB.Ping(); err != nil {
+		_ = sqlDB.Close()
+
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	if driver == DriverSQLite {
+		if _, err = sqlDB.Exec(`PRAGMA foreign_keys = ON`); err != nil {
+			_ = sqlDB.Close()
+
+			return nil, fmt.Errorf("failed to enable foreign keys: %w", err)
+		}
+	}
+
+	db := &DB{
+		db:     sqlDB,
+		driver: driver,
+		logger: slog.With(slog.String("db", driver)),
+	}
+
+	db.logger.Info("opened database")
+
+	return db, nil
+}
+
+// Close closes the connection to the database.
+func (db *DB) Close() error {
+	if err := db.db.Close(); err != nil {
+		return fmt.Errorf("failed to close database: %w", err)
+	}
+
+	return nil
+}
+
+// withTx runs fn in a transaction, committed if fn succeeds and rolled back otherwise.
+func (db *DB) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
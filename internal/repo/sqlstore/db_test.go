@@ -0,0 +1,125 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// postgresDSNEnv is the environment variable giving the PostgreSQL database used by the tests.
+// The PostgreSQL tests are skipped when it is not set.
+const postgresDSNEnv = "MYCHAT_TEST_POSTGRES_DSN"
+
+// testDSN returns a DSN to a new empty database for the driver.
+func testDSN(t *testing.T, driver string) string {
+	t.Helper()
+
+	if driver == DriverSQLite {
+		return filepath.Join(t.TempDir(), "mychat.db")
+	}
+
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresDSNEnv)
+	}
+
+	// isolate each test in its own schema.
+	admin, err := sql.Open(sqlDrivers[DriverPostgres], dsn)
+	require.NoError(t, err)
+
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	_, err = admin.Exec(`CREATE SCHEMA ` + schema)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		require.NoError(t, err)
+		require.NoError(t, admin.Close())
+	})
+
+	u, err := url.Parse(dsn)
+	require.NoError(t, err)
+
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// forEachDB runs the test against a new migrated database of each driver.
+func forEachDB(t *testing.T, test func(t *testing.T, db *DB)) {
+	t.Helper()
+
+	for _, driver := range []string{DriverSQLite, DriverPostgres} {
+		t.Run(driver, func(t *testing.T) {
+			db, err := Open(driver, testDSN(t, driver))
+			require.NoError(t, err)
+
+			t.Cleanup(func() {
+				require.NoError(t, db.Close())
+			})
+
+			require.NoError(t, db.MigrateUp())
+
+			test(t, db)
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	_, err := Open("unknown", "")
+	require.ErrorIs(t, err, ErrUnsupportedDriver)
+}
+
+func TestDB_Migrate(t *testing.T) {
+	for _, driver := range []string{DriverSQLite, DriverPostgres} {
+		t.Run(driver, func(t *testing.T) {
+			dsn := testDSN(t, driver)
+
+			migrations, err := loadMigrations(driver)
+			require.NoError(t, err)
+			require.NotEmpty(t, migrations)
+
+			latest := migrations[len(migrations)-1].version
+
+			db, err := Open(driver, dsn)
+			require.NoError(t, err)
+
+			version, err := db.SchemaVersion()
+			require.NoError(t, err)
+			require.Zero(t, version)
+
+			require.NoError(t, db.MigrateUp())
+			require.NoError(t, NewUserRepository(db).AddUser("123"))
+			require.NoError(t, db.Close())
+
+			// reopening the database keeps the data and does not apply the migrations twice.
+			db, err = Open(driver, dsn)
+			require.NoError(t, err)
+			require.NoError(t, db.MigrateUp())
+			require.True(t, NewUserRepository(db).IsRegistered("123"))
+
+			version, err = db.SchemaVersion()
+			require.NoError(t, err)
+			require.Equal(t, latest, version)
+
+			// revert all the migrations, then apply them again.
+			require.NoError(t, db.MigrateDown(len(migrations)+1))
+
+			version, err = db.SchemaVersion()
+			require.NoError(t, err)
+			require.Zero(t, version)
+
+			require.NoError(t, db.MigrateUp())
+			require.False(t, NewUserRepository(db).IsRegistered("123"))
+			require.NoError(t, db.Close())
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package sqlstore
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// IdempotencyRepository manages the idempotency keys of the requests,
+// shared by the instances using the same database.
+type IdempotencyRepository struct {
+	db *DB
+
+	logger *slog.Logger
+}
+
+// NewIdempotencyRepository initializes a new IdempotencyRepository.
+func NewIdempotencyRepository(db *DB) *IdempotencyRepository {
+	logger := slog.With(slog.String("repo", "idempotency"), slog.String("db", db.driver))
+	logger.Info("created repository")
+
+	return &IdempotencyRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// AddIdempotencyKey saves the record of a new request, without response.
+// If the key has a record which has not expired, it returns it with repo.ErrIdempotencyKeyExists.
+func (r *IdempotencyRepository) AddIdempotencyKey(record repo.IdempotencyRecord) (repo.IdempotencyRecord, error) {
+	now := time.Now().UTC()
+
+	if _, err := r.db.db.Exec(`DELETE FROM idempotency_keys WHERE expires_at <= $1`, now); err != nil {
+		return repo.IdempotencyRecord{}, fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+
+	record.CreatedAt = now.Truncate(time.Millisecond)
+	record.Response = repo.IdempotencyResponse{}
+
+	result, err := r.db.db.Exec(
+		`INSERT INTO idempotency_keys (key, fingerprint, created_at, expires_at) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`,
+		record.Key, record.Fingerprint, record.CreatedAt, record.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return repo.IdempotencyRecord{}, fmt.Errorf("failed to insert idempotency key: %w", err)
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return repo.IdempotencyRecord{}, fmt.Errorf("failed to insert idempotency key: %w", err)
+	}
+
+	if inserted == 1 {
+		return record, nil
+	}
+
+	existing := repo.IdempotencyRecord{Key: record.Key}
+
+	err = r.db.db.QueryRow(
+		`SELECT fingerprint, status, content_type, location, body, withheld, created_at, expires_at FROM idempotency_keys WHERE key = $1`,
+		record.Key,
+	).Scan(
+		&existing.Fingerprint, &existing.Response.Status, &existing.Response.ContentType, &existing.Response.Location,
+		&existing.Response.Body, &existing.Response.Withheld, &existing.CreatedAt, &existing.ExpiresAt,
+	)
+	if err != nil {
+		return repo.IdempotencyRecord{}, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+
+	existing.CreatedAt = existing.CreatedAt.UTC()
+	existing.ExpiresAt = existing.ExpiresAt.UTC()
+
+	return existing, repo.ErrIdempotencyKeyExists
+}
+
+// CompleteIdempotencyKey saves the response of the request of the key.
+// It returns repo.ErrIdempotencyKeyNotFound if the key has no record.
+func (r *IdempotencyRepository) CompleteIdempotencyKey(key string, response repo.IdempotencyResponse) error {
+	result, err := r.db.db.Exec(
+		`UPDATE idempotency_keys SET status = $1, content_type = $2, location = $3, body = $4, withheld = $5 WHERE key = $6`,
+		response.Status, response.ContentType, response.Location, response.Body, response.Withheld, key,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update idempotency key: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update idempotency key: %w", err)
+	}
+
+	if count == 0 {
+		return repo.ErrIdempotencyKeyNotFound
+	}
+
+	return nil
+}
+
+// RemoveIdempotencyKey removes the record of the key, so its request can be sent again.
+// Nothing changes if the key has no record.
+func (r *IdempotencyRepository) RemoveIdempotencyKey(key string) error {
+	if _, err := r.db.db.Exec(`DELETE FROM idempotency_keys WHERE key = $1`, key); err != nil {
+		return fmt.Errorf("failed to delete idempotency key: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,453 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// MessageRepository manages message storage and operations.
+type MessageRepository struct {
+	db *DB
+
+	logger *slog.Logger
+}
+
+// NewMessageRepository initializes a new MessageRepository.
+func NewMessageRepository(db *DB) *MessageRepository {
+	logger := slog.With(slog.String("repo", "message"), slog.String("db", db.driver))
+	logger.Info("created repository")
+
+	return &MessageRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// AddMessage adds a new message to the repository.
+func (r *MessageRepository) AddMessage(chatID, sender, content string) (repo.Message, error) {
+	return r.add(chatID, sender, content, false, repo.MessageOptions{})
+}
+
+// AddSystemMessage adds a new system message to the repository.
+func (r *MessageRepository) AddSystemMessage(chatID, sender, content string) (repo.Message, error) {
+	return r.add(chatID, sender, content, true, repo.MessageOptions{})
+}
+
+// AddMessageWithOptions adds a new message of a user to the repository.
+// It returns repo.ErrReplyToNotFound if the replied message is not in the chat,
+// and repo.ErrAttachmentNotFound or repo.ErrAttachmentInUse if an attachment can't be attached.
+func (r *MessageRepository) AddMessageWithOptions(chatID, sender, content string, options repo.MessageOptions) (repo.Message, error) {
+	return r.add(chatID, sender, content, false, options)
+}
+
+func (r *MessageRepository) add(chatID, sender, content string, system bool, options repo.MessageOptions) (repo.Message, error) {
+	message := repo.Message{
+		ID:        uuid.NewString(),
+		ChatID:    chatID,
+		Sender:    sender,
+		Content:   content,
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+		System:    system,
+		ReplyTo:   options.ReplyTo,
+		Blocked:   options.Blocked,
+	}
+
+	err := r.db.withTx(func(tx *sql.Tx) error {
+		// the chat row holds the sequence of its messages,
+		// incrementing it locks the chat until the message is stored.
+		err := tx.QueryRow(
+			`UPDATE chats SET last_seq = last_seq + 1 WHERE id = $1 RETURNING last_seq`,
+			chatID,
+		).Scan(&message.Seq)
+		if errors.Is(err, sql.ErrNoRows) {
+			return repo.ErrChatNotFound
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to get message sequence: %w", err)
+		}
+
+		// the global sequence orders the messages of all the chats.
+		err = tx.QueryRow(`UPDATE message_order SET last_seq = last_seq + 1 RETURNING last_seq`).Scan(&message.GlobalSeq)
+		if err != nil {
+			return fmt.Errorf("failed to get message global sequence: %w", err)
+		}
+
+		if options.ReplyTo != "" {
+			if err = addReplyCount(tx, chatID, options.ReplyTo); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(
+			`INSERT INTO messages (id, chat_id, seq, global_seq, sender, content, created_at, system, reply_to, blocked)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			message.ID, message.ChatID, message.Seq, message.GlobalSeq, message.Sender, message.Content, message.CreatedAt, message.System,
+			sql.Null[string]{V: options.ReplyTo, Valid: options.ReplyTo != ""}, message.Blocked,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert message: %w", err)
+		}
+
+		return attach(tx, &message, options.Attachments)
+	})
+	if err != nil {
+		return repo.Message{}, err
+	}
+
+	return message, nil
+}
+
+// addReplyCount counts one more reply to the message of the chat.
+func addReplyCount(tx *sql.Tx, chatID, messageID string) error {
+	result, err := tx.Exec(`UPDATE messages SET reply_count = reply_count + 1 WHERE chat_id = $1 AND id = $2`, chatID, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to count reply: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to count reply: %w", err)
+	}
+
+	if count == 0 {
+		return repo.ErrReplyToNotFound
+	}
+
+	return nil
+}
+
+const (
+	messageColumns = `id, chat_id, seq, global_seq, sender, content, created_at, system, edited_at, deleted_at, reply_to, reply_count, blocked`
+	selectMessages = `SELECT ` + messageColumns + ` FROM messages`
+)
+
+// scanMessage scans a row of the messageColumns.
+func scanMessage(row interface{ Scan(dest ...any) error }) (repo.Message, error) {
+	var (
+		message   repo.Message
+		editedAt  sql.Null[time.Time]
+		deletedAt sql.Null[time.Time]
+		replyTo   sql.Null[string]
+	)
+
+	err := row.Scan(
+		&message.ID,
+		&message.ChatID,
+		&message.Seq,
+		&message.GlobalSeq,
+		&message.Sender,
+		&message.Content,
+		&message.CreatedAt,
+		&message.System,
+		&editedAt,
+		&deletedAt,
+		&replyTo,
+		&message.ReplyCount,
+		&message.Blocked,
+	)
+	if err != nil {
+		return repo.Message{}, fmt.Errorf("failed to scan message: %w", err)
+	}
+
+	message.CreatedAt = message.CreatedAt.UTC()
+
+	if editedAt.Valid {
+		message.EditedAt = editedAt.V.UTC()
+	}
+
+	if deletedAt.Valid {
+		message.DeletedAt = deletedAt.V.UTC()
+	}
+
+	message.ReplyTo = replyTo.V
+
+	return message, nil
+}
+
+// GetChatMessages gets the messages of a chat matching the query.
+func (r *MessageRepository) GetChatMessages(chatID string, query repo.MessageQuery) ([]repo.Message, error) {
+	beforeSeq := query.BeforeSeq
+	if beforeSeq <= 0 {
+		beforeSeq = math.MaxInt64
+	}
+
+	// the latest messages are selected in the reverse order, then sorted back.
+	reverse := query.Limit > 0 && !query.Forward()
+
+	stmt := selectMessages + ` WHERE chat_id = $1 AND seq > $2 AND seq < $3`
+	args := []any{chatID, query.AfterSeq, beforeSeq}
+
+	if query.ReplyTo != "" {
+		args = append(args, query.ReplyTo)
+		stmt += fmt.Sprintf(` AND reply_to = $%d`, len(args))
+	}
+
+	if query.Viewer != "" {
+		args = append(args, query.Viewer)
+		stmt += fmt.Sprintf(` AND (NOT blocked OR sender = $%d)`, len(args))
+	}
+
+	if query.AfterGlobalSeq > 0 {
+		args = append(args, query.AfterGlobalSeq)
+		stmt += fmt.Sprintf(` AND global_seq > $%d`, len(args))
+	}
+
+	stmt += ` ORDER BY seq`
+	if reverse {
+		stmt += ` DESC`
+	}
+
+	if query.Limit > 0 {
+		args = append(args, query.Limit)
+		stmt += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+
+	messages, err := r.queryMessages(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if reverse {
+		slices.Reverse(messages)
+	}
+
+	return messages, nil
+}
+
+// GetChatMessagesByID gets the messages of a chat among the given IDs, sorted by sequence number.
+func (r *MessageRepository) GetChatMessagesByID(chatID string, messageIDs []string) ([]repo.Message, error) {
+	if len(messageIDs) == 0 {
+		return []repo.Message{}, nil
+	}
+
+	placeholders := make([]string, len(messageIDs))
+	args := make([]any, 0, len(messageIDs)+1)
+	args = append(args, chatID)
+
+	for i, messageID := range messageIDs {
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+		args = append(args, messageID)
+	}
+
+	return r.queryMessages(selectMessages+` WHERE chat_id = $1 AND id IN (`+strings.Join(placeholders, ", ")+`) ORDER BY seq`, args...)
+}
+
+// queryMessages queries the messageColumns of the selected messages.
+func (r *MessageRepository) queryMessages(stmt string, args ...any) ([]repo.Message, error) {
+	rows, err := r.db.db.Query(stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []repo.Message{}
+
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, message)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get chat messages: %w", err)
+	}
+
+	if err = loadAttachments(r.db.db, messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// GetLastSeq gets the sequence number of the last message of a chat, 0 if the chat has no message.
+func (r *MessageRepository) GetLastSeq(chatID string) (int64, error) {
+	var seq int64
+
+	err := r.db.db.QueryRow(`SELECT last_seq FROM chats WHERE id = $1`, chatID).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last message sequence: %w", err)
+	}
+
+	return seq, nil
+}
+
+// GetChatMessage gets a message of a chat by its ID.
+func (r *MessageRepository) GetChatMessage(chatID, messageID string) (repo.Message, error) {
+	message, err := scanMessage(r.db.db.QueryRow(selectMessages+` WHERE chat_id = $1 AND id = $2`, chatID, messageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return repo.Message{}, repo.ErrMessageNotFound
+	}
+
+	if err != nil {
+		return repo.Message{}, err
+	}
+
+	messages := []repo.Message{message}
+	if err = loadAttachments(r.db.db, messages); err != nil {
+		return repo.Message{}, err
+	}
+
+	return messages[0], nil
+}
+
+// lockMessage gets a message of a chat, locking it until the end of the transaction.
+func lockMessage(tx *sql.Tx, driver, chatID, messageID string) (repo.Message, error) {
+	stmt := selectMessages + ` WHERE chat_id = $1 AND id = $2`
+
+	// the SQLite connection is shared, its transactions already run one at a time.
+	if driver == DriverPostgres {
+		stmt += ` FOR UPDATE`
+	}
+
+	message, err := scanMessage(tx.QueryRow(stmt, chatID, messageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return repo.Message{}, repo.ErrMessageNotFound
+	}
+
+	if err != nil {
+		return repo.Message{}, err
+	}
+
+	if message.Deleted() {
+		return repo.Message{}, repo.ErrMessageDeleted
+	}
+
+	messages := []repo.Message{message}
+	if err = loadAttachments(tx, messages); err != nil {
+		return repo.Message{}, err
+	}
+
+	return messages[0], nil
+}
+
+// EditMessage replaces the content of a message, its previous version is kept in its history.
+func (r *MessageRepository) EditMessage(chatID, messageID, content string) (repo.Message, error) {
+	var message repo.Message
+
+	err := r.db.withTx(func(tx *sql.Tx) error {
+		var err error
+
+		if message, err = lockMessage(tx, r.db.driver, chatID, messageID); err != nil {
+			return err
+		}
+
+		previous := message.Version()
+
+		_, err = tx.Exec(`
+			INSERT INTO message_versions (message_id, version, content, created_at)
+			SELECT $1, COUNT(*) + 1, $2, $3 FROM message_versions WHERE message_id = $1`,
+			message.ID, previous.Content, previous.CreatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert message version: %w", err)
+		}
+
+		message.Content = content
+		message.EditedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+		_, err = tx.Exec(`UPDATE messages SET content = $1, edited_at = $2 WHERE id = $3`, message.Content, message.EditedAt, message.ID)
+		if err != nil {
+			return fmt.Errorf("failed to update message: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return repo.Message{}, err
+	}
+
+	return message, nil
+}
+
+// DeleteMessage deletes a message, its content, its history, its reactions and its attachments are erased.
+func (r *MessageRepository) DeleteMessage(chatID, messageID string) (repo.Message, error) {
+	var message repo.Message
+
+	err := r.db.withTx(func(tx *sql.Tx) error {
+		var err error
+
+		if message, err = lockMessage(tx, r.db.driver, chatID, messageID); err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(`DELETE FROM message_versions WHERE message_id = $1`, message.ID); err != nil {
+			return fmt.Errorf("failed to delete message versions: %w", err)
+		}
+
+		if _, err = tx.Exec(`DELETE FROM message_reactions WHERE message_id = $1`, message.ID); err != nil {
+			return fmt.Errorf("failed to delete message reactions: %w", err)
+		}
+
+		if _, err = tx.Exec(`DELETE FROM attachments WHERE message_id = $1`, message.ID); err != nil {
+			return fmt.Errorf("failed to delete message attachments: %w", err)
+		}
+
+		message.Content = ""
+		message.Attachments = nil
+		message.DeletedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+		_, err = tx.Exec(`UPDATE messages SET content = '', deleted_at = $1 WHERE id = $2`, message.DeletedAt, message.ID)
+		if err != nil {
+			return fmt.Errorf("failed to delete message: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return repo.Message{}, err
+	}
+
+	return message, nil
+}
+
+// GetMessageHistory gets the previous versions of a message, from the original one to the last replaced one.
+func (r *MessageRepository) GetMessageHistory(chatID, messageID string) ([]repo.MessageVersion, error) {
+	if _, err := r.GetChatMessage(chatID, messageID); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.db.Query(
+		`SELECT content, created_at FROM message_versions WHERE message_id = $1 ORDER BY version`,
+		messageID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message history: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []repo.MessageVersion{}
+
+	for rows.Next() {
+		var version repo.MessageVersion
+		if err = rows.Scan(&version.Content, &version.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message version: %w", err)
+		}
+
+		version.CreatedAt = version.CreatedAt.UTC()
+		versions = append(versions, version)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get message history: %w", err)
+	}
+
+	return versions, nil
+}
@@ -0,0 +1,300 @@
+package sqlstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+func TestMessageRepository_AddMessage(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+		messageRepo := NewMessageRepository(db)
+
+		chat, _, err := chatRepo.GetOrCreateChat("123", "456")
+		require.NoError(t, err)
+
+		otherChat, _, err := chatRepo.GetOrCreateChat("123", "789")
+		require.NoError(t, err)
+
+		chatID, otherChatID := chat.ID, otherChat.ID
+
+		first, err := messageRepo.AddMessage(chatID, "123", "Hello")
+		require.NoError(t, err)
+		assert.NotEmpty(t, first.ID)
+		assert.Equal(t, chatID, first.ChatID)
+		assert.Equal(t, "123", first.Sender)
+		assert.Equal(t, "Hello", first.Content)
+		assert.Equal(t, int64(1), first.Seq)
+		assert.False(t, first.CreatedAt.IsZero())
+
+		second, err := messageRepo.AddMessage(chatID, "456", "World")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), second.Seq)
+
+		// the sequence is per chat
+		other, err := messageRepo.AddMessage(otherChatID, "123", "Hi")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), other.Seq)
+
+		// the global sequence orders the messages of all the chats
+		assert.Equal(t, first.GlobalSeq+1, second.GlobalSeq)
+		assert.Equal(t, second.GlobalSeq+1, other.GlobalSeq)
+
+		_, err = messageRepo.AddMessage("unknown", "123", "Hi")
+		require.ErrorIs(t, err, repo.ErrChatNotFound)
+
+		messages, err := messageRepo.GetChatMessages(chatID, repo.MessageQuery{})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.Message{first, second}, messages)
+
+		message, err := messageRepo.GetChatMessage(chatID, second.ID)
+		require.NoError(t, err)
+		assert.Equal(t, second, message)
+
+		_, err = messageRepo.GetChatMessage(otherChatID, second.ID)
+		require.ErrorIs(t, err, repo.ErrMessageNotFound)
+
+		messages, err = messageRepo.GetChatMessages("unknown", repo.MessageQuery{})
+		require.NoError(t, err)
+		assert.Empty(t, messages)
+
+		messages, err = messageRepo.GetChatMessages(chatID, repo.MessageQuery{AfterSeq: 1})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.Message{second}, messages)
+
+		messages, err = messageRepo.GetChatMessages(chatID, repo.MessageQuery{AfterGlobalSeq: first.GlobalSeq, Limit: 1})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.Message{second}, messages)
+
+		messages, err = messageRepo.GetChatMessages(chatID, repo.MessageQuery{AfterGlobalSeq: other.GlobalSeq})
+		require.NoError(t, err)
+		assert.Empty(t, messages)
+
+		seq, err := messageRepo.GetLastSeq(chatID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), seq)
+
+		seq, err = messageRepo.GetLastSeq("unknown")
+		require.NoError(t, err)
+		assert.Zero(t, seq)
+	})
+}
+
+func TestMessageRepository_EditMessage(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+		messageRepo := NewMessageRepository(db)
+
+		chat, _, err := chatRepo.GetOrCreateChat("123", "456")
+		require.NoError(t, err)
+
+		message, err := messageRepo.AddMessage(chat.ID, "123", "Hello")
+		require.NoError(t, err)
+
+		edited, err := messageRepo.EditMessage(chat.ID, message.ID, "Hello, World!")
+		require.NoError(t, err)
+		assert.Equal(t, "Hello, World!", edited.Content)
+		assert.False(t, edited.EditedAt.IsZero())
+
+		last, err := messageRepo.EditMessage(chat.ID, message.ID, "Hello, World!!")
+		require.NoError(t, err)
+
+		got, err := messageRepo.GetChatMessage(chat.ID, message.ID)
+		require.NoError(t, err)
+		assert.Equal(t, last, got)
+
+		history, err := messageRepo.GetMessageHistory(chat.ID, message.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []repo.MessageVersion{
+			{Content: "Hello", CreatedAt: message.CreatedAt},
+			{Content: "Hello, World!", CreatedAt: edited.EditedAt},
+		}, history)
+
+		// the deleted message is a tombstone without content nor history.
+		deleted, err := messageRepo.DeleteMessage(chat.ID, message.ID)
+		require.NoError(t, err)
+		assert.True(t, deleted.Deleted())
+		assert.Empty(t, deleted.Content)
+
+		history, err = messageRepo.GetMessageHistory(chat.ID, message.ID)
+		require.NoError(t, err)
+		assert.Empty(t, history)
+
+		messages, err := messageRepo.GetChatMessages(chat.ID, repo.MessageQuery{})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.Message{deleted}, messages)
+
+		chats, err := chatRepo.GetUserChats("456", repo.ChatQuery{})
+		require.NoError(t, err)
+		assert.Equal(t, &deleted, chats[0].LastMessage)
+
+		_, err = messageRepo.EditMessage(chat.ID, message.ID, "Hi")
+		require.ErrorIs(t, err, repo.ErrMessageDeleted)
+
+		_, err = messageRepo.DeleteMessage(chat.ID, message.ID)
+		require.ErrorIs(t, err, repo.ErrMessageDeleted)
+
+		_, err = messageRepo.EditMessage("unknown", message.ID, "Hi")
+		require.ErrorIs(t, err, repo.ErrMessageNotFound)
+
+		_, err = messageRepo.DeleteMessage(chat.ID, "unknown")
+		require.ErrorIs(t, err, repo.ErrMessageNotFound)
+
+		_, err = messageRepo.GetMessageHistory(chat.ID, "unknown")
+		require.ErrorIs(t, err, repo.ErrMessageNotFound)
+	})
+}
+
+func TestMessageRepository_AddMessageWithOptions_Reply(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+		messageRepo := NewMessageRepository(db)
+
+		chat, _, err := chatRepo.GetOrCreateChat("123", "456")
+		require.NoError(t, err)
+
+		otherChat, _, err := chatRepo.GetOrCreateChat("123", "789")
+		require.NoError(t, err)
+
+		chatID, otherChatID := chat.ID, otherChat.ID
+
+		parent, err := messageRepo.AddMessage(chatID, "123", "Hello")
+		require.NoError(t, err)
+
+		first, err := messageRepo.AddMessageWithOptions(chatID, "456", "Hi", repo.MessageOptions{ReplyTo: parent.ID})
+		require.NoError(t, err)
+		assert.Equal(t, parent.ID, first.ReplyTo)
+		assert.Equal(t, int64(2), first.Seq)
+
+		_, err = messageRepo.AddMessage(chatID, "123", "How are you?")
+		require.NoError(t, err)
+
+		second, err := messageRepo.AddMessageWithOptions(chatID, "123", "Hey", repo.MessageOptions{ReplyTo: parent.ID})
+		require.NoError(t, err)
+
+		// a reply to a reply is in the thread of the reply.
+		nested, err := messageRepo.AddMessageWithOptions(chatID, "123", "Hey you", repo.MessageOptions{ReplyTo: first.ID})
+		require.NoError(t, err)
+
+		got, err := messageRepo.GetChatMessage(chatID, parent.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), got.ReplyCount)
+
+		first, err = messageRepo.GetChatMessage(chatID, first.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), first.ReplyCount)
+
+		thread, err := messageRepo.GetChatMessages(chatID, repo.MessageQuery{ReplyTo: parent.ID})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.Message{first, second}, thread)
+
+		thread, err = messageRepo.GetChatMessages(chatID, repo.MessageQuery{ReplyTo: parent.ID, Limit: 1})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.Message{second}, thread)
+
+		thread, err = messageRepo.GetChatMessages(chatID, repo.MessageQuery{ReplyTo: parent.ID, AfterSeq: 1, Limit: 1})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.Message{first}, thread)
+
+		thread, err = messageRepo.GetChatMessages(chatID, repo.MessageQuery{ReplyTo: first.ID})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.Message{nested}, thread)
+
+		messages, err := messageRepo.GetChatMessagesByID(chatID, []string{second.ID, parent.ID, "unknown"})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.Message{got, second}, messages)
+
+		// the replied message must be in the chat.
+		_, err = messageRepo.AddMessageWithOptions(otherChatID, "123", "Hi", repo.MessageOptions{ReplyTo: parent.ID})
+		require.ErrorIs(t, err, repo.ErrReplyToNotFound)
+
+		_, err = messageRepo.AddMessageWithOptions(chatID, "123", "Hi", repo.MessageOptions{ReplyTo: "unknown"})
+		require.ErrorIs(t, err, repo.ErrReplyToNotFound)
+	})
+}
+
+func TestMessageRepository_AddSystemMessage(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+		messageRepo := NewMessageRepository(db)
+
+		chat, err := chatRepo.CreateGroupChat("friends", []string{"123", "456"})
+		require.NoError(t, err)
+
+		message, err := messageRepo.AddMessage(chat.ID, "123", "Hello")
+		require.NoError(t, err)
+		assert.False(t, message.System)
+
+		system, err := messageRepo.AddSystemMessage(chat.ID, "123", "123 added 789")
+		require.NoError(t, err)
+		assert.True(t, system.System)
+		assert.Equal(t, int64(2), system.Seq)
+
+		messages, err := messageRepo.GetChatMessages(chat.ID, repo.MessageQuery{})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.Message{message, system}, messages)
+	})
+}
+
+func TestMessageRepository_GetChatMessages(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+		messageRepo := NewMessageRepository(db)
+
+		chat, _, err := chatRepo.GetOrCreateChat("123", "456")
+		require.NoError(t, err)
+
+		var messages []repo.Message
+
+		for range 5 {
+			message, err := messageRepo.AddMessage(chat.ID, "123", "Hello")
+			require.NoError(t, err)
+
+			messages = append(messages, message)
+		}
+
+		testCases := []struct {
+			desc     string
+			query    repo.MessageQuery
+			expected []repo.Message
+		}{
+			{
+				desc:     "latest",
+				query:    repo.MessageQuery{Limit: 2},
+				expected: messages[3:],
+			},
+			{
+				desc:     "before",
+				query:    repo.MessageQuery{BeforeSeq: 4, Limit: 2},
+				expected: messages[1:3],
+			},
+			{
+				desc:     "after",
+				query:    repo.MessageQuery{AfterSeq: 1, Limit: 2},
+				expected: messages[1:3],
+			},
+			{
+				desc:     "between",
+				query:    repo.MessageQuery{AfterSeq: 1, BeforeSeq: 5, Limit: 2},
+				expected: messages[2:4],
+			},
+			{
+				desc:     "before the first message",
+				query:    repo.MessageQuery{BeforeSeq: 1},
+				expected: []repo.Message{},
+			},
+		}
+
+		for _, test := range testCases {
+			t.Run(test.desc, func(t *testing.T) {
+				got, err := messageRepo.GetChatMessages(chat.ID, test.query)
+				require.NoError(t, err)
+				assert.Equal(t, test.expected, got)
+			})
+		}
+	})
+}
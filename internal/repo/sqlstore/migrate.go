@@ -0,0 +1,238 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationLockID identifies the PostgreSQL advisory lock
+// preventing several instances from migrating the schema at the same time.
+const migrationLockID = 1_337_042
+
+// ErrIrreversibleMigration is returned when reverting a migration without down script.
+var ErrIrreversibleMigration = errors.New("irreversible migration")
+
+// migration is a versioned change of the database schema.
+// Migrations are stored in the migrations/<driver> directory,
+// as <version>_<description>.up.sql and <version>_<description>.down.sql files.
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// loadMigrations reads the migrations of the driver, sorted by version.
+func loadMigrations(driver string) ([]migration, error) {
+	dir := path.Join("migrations", driver)
+
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*migration)
+
+	for _, entry := range entries {
+		name, direction, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			continue
+		}
+
+		rawVersion, _, _ := strings.Cut(name, "_")
+
+		version, err := strconv.Atoi(rawVersion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %q: %w", entry.Name(), err)
+		}
+
+		content, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %q: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: name}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.up = string(content)
+		} else {
+			m.down = string(content)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	return migrations, nil
+}
+
+// withMigrationLock runs fn in a transaction holding the migration lock.
+// The schema_migrations table, holding the applied versions, is created if needed.
+func (db *DB) withMigrationLock(fn func(tx *sql.Tx) error) error {
+	return db.withTx(func(tx *sql.Tx) error {
+		if db.driver == DriverPostgres {
+			// released at the end of the transaction.
+			if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+				return fmt.Errorf("failed to lock migrations: %w", err)
+			}
+		}
+
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL
+		)`)
+		if err != nil {
+			return fmt.Errorf("failed to create migrations table: %w", err)
+		}
+
+		return fn(tx)
+	})
+}
+
+// schemaVersion returns the version of the last applied migration, 0 if none.
+func schemaVersion(tx *sql.Tx) (int, error) {
+	var version int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %w", err)
+	}
+
+	return version, nil
+}
+
+// SchemaVersion returns the version of the last applied migration, 0 if none.
+func (db *DB) SchemaVersion() (int, error) {
+	var version int
+
+	err := db.withMigrationLock(func(tx *sql.Tx) error {
+		var err error
+		version, err = schemaVersion(tx)
+
+		return err
+	})
+
+	return version, err
+}
+
+// MigrateUp applies the migrations not yet applied to the database.
+// It is safe to call it from several instances sharing the same database.
+func (db *DB) MigrateUp() error {
+	migrations, err := loadMigrations(db.driver)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		applied := false
+
+		err = db.withMigrationLock(func(tx *sql.Tx) error {
+			version, err := schemaVersion(tx)
+			if err != nil {
+				return err
+			}
+
+			// already applied, possibly by another instance.
+			if m.version <= version {
+				return nil
+			}
+
+			if _, err = tx.Exec(m.up); err != nil {
+				return fmt.Errorf("failed to apply migration %q: %w", m.name, err)
+			}
+
+			_, err = tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`,
+				m.version, time.Now().UTC(),
+			)
+			if err != nil {
+				return fmt.Errorf("failed to record migration %q: %w", m.name, err)
+			}
+
+			applied = true
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if applied {
+			db.logger.Info("applied migration", slog.String("migration", m.name))
+		}
+	}
+
+	return nil
+}
+
+// MigrateDown reverts the last steps applied migrations.
+func (db *DB) MigrateDown(steps int) error {
+	migrations, err := loadMigrations(db.driver)
+	if err != nil {
+		return err
+	}
+
+	byVersion := make(map[int]migration, len(migrations))
+	for _, m := range migrations {
+		byVersion[m.version] = m
+	}
+
+	for range steps {
+		var reverted string
+
+		err = db.withMigrationLock(func(tx *sql.Tx) error {
+			version, err := schemaVersion(tx)
+			if err != nil || version == 0 {
+				return err
+			}
+
+			m, ok := byVersion[version]
+			if !ok || m.down == "" {
+				return fmt.Errorf("%w: version %d", ErrIrreversibleMigration, version)
+			}
+
+			if _, err = tx.Exec(m.down); err != nil {
+				return fmt.Errorf("failed to revert migration %q: %w", m.name, err)
+			}
+
+			if _, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, m.version); err != nil {
+				return fmt.Errorf("failed to record migration %q: %w", m.name, err)
+			}
+
+			reverted = m.name
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if reverted == "" {
+			// nothing left to revert.
+			return nil
+		}
+
+		db.logger.Info("reverted migration", slog.String("migration", reverted))
+	}
+
+	return nil
+}
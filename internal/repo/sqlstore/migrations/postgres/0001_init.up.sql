@@ -0,0 +1,33 @@
+CREATE TABLE users (
+    phone_number TEXT PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE chats (
+    id TEXT PRIMARY KEY,
+    -- identifies a chat between 2 users, whatever the order of the participants.
+    direct_key TEXT UNIQUE,
+    -- sequence number of the last message of the chat.
+    last_seq BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE chat_participants (
+    chat_id TEXT NOT NULL REFERENCES chats (id),
+    user_id TEXT NOT NULL,
+    -- keeps the participants in the order they joined the chat.
+    position INTEGER NOT NULL,
+    PRIMARY KEY (chat_id, user_id)
+);
+
+CREATE INDEX chat_participants_user_id_idx ON chat_participants (user_id);
+
+CREATE TABLE messages (
+    id TEXT PRIMARY KEY,
+    chat_id TEXT NOT NULL REFERENCES chats (id),
+    seq BIGINT NOT NULL,
+    sender TEXT NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    UNIQUE (chat_id, seq)
+);
@@ -0,0 +1,7 @@
+DELETE FROM messages WHERE chat_id IN (SELECT id FROM chats WHERE direct_key IS NULL);
+
+DELETE FROM chat_participants WHERE chat_id IN (SELECT id FROM chats WHERE direct_key IS NULL);
+
+DELETE FROM chats WHERE direct_key IS NULL;
+
+ALTER TABLE chats DROP COLUMN name;
@@ -0,0 +1,5 @@
+DELETE FROM messages WHERE system;
+
+ALTER TABLE messages DROP COLUMN system;
+
+ALTER TABLE chat_participants DROP COLUMN role;
@@ -0,0 +1,9 @@
+-- role of the participant in a group chat, admin or member.
+ALTER TABLE chat_participants ADD COLUMN role TEXT NOT NULL DEFAULT 'member';
+
+-- the creators of the existing group chats are their admins.
+UPDATE chat_participants SET role = 'admin'
+WHERE position = 0 AND chat_id IN (SELECT id FROM chats WHERE direct_key IS NULL);
+
+-- a system message records an event of the chat, like a new participant.
+ALTER TABLE messages ADD COLUMN system BOOLEAN NOT NULL DEFAULT FALSE;
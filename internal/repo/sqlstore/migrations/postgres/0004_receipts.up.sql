@@ -0,0 +1,3 @@
+-- the messages up to delivered_seq are delivered to the participant, and the ones up to read_seq are read.
+ALTER TABLE chat_participants ADD COLUMN delivered_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE chat_participants ADD COLUMN read_seq BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,13 @@
+-- the time of the last edit of a message, and the time it has been deleted, null if it has not been.
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMPTZ;
+
+-- the previous versions of the edited messages.
+CREATE TABLE message_versions (
+    message_id TEXT NOT NULL REFERENCES messages (id),
+    -- keeps the versions in the order they have been written, starting at 1.
+    version INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (message_id, version)
+);
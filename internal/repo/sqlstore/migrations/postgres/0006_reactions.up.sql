@@ -0,0 +1,10 @@
+-- the emoji reactions to the messages, a user reacts at most once to a message with an emoji.
+CREATE TABLE message_reactions (
+    message_id TEXT NOT NULL REFERENCES messages (id),
+    user_id TEXT NOT NULL,
+    emoji TEXT NOT NULL,
+    -- keeps the reactions to a message in the order they have been added.
+    position INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (message_id, user_id, emoji)
+);
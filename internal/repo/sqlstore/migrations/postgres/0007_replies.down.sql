@@ -0,0 +1,5 @@
+DROP INDEX messages_reply_to_idx;
+
+ALTER TABLE messages DROP COLUMN reply_count;
+
+ALTER TABLE messages DROP COLUMN reply_to;
@@ -0,0 +1,7 @@
+-- the message a message replies to, null if it is not a reply,
+-- and the number of replies to a message, counted as they are added.
+ALTER TABLE messages ADD COLUMN reply_to TEXT REFERENCES messages (id);
+ALTER TABLE messages ADD COLUMN reply_count INTEGER NOT NULL DEFAULT 0;
+
+-- the replies to a message, its thread, in the order of the chat.
+CREATE INDEX messages_reply_to_idx ON messages (reply_to, seq);
@@ -0,0 +1,5 @@
+ALTER TABLE attachments DROP COLUMN thumbnails;
+
+ALTER TABLE attachments DROP COLUMN height;
+
+ALTER TABLE attachments DROP COLUMN width;
@@ -0,0 +1,6 @@
+-- the words of the content of the messages, lower-cased without stemming like the in-memory index does.
+-- the punctuation is replaced by spaces for the parser to split the emails or the URLs in words too.
+ALTER TABLE messages ADD COLUMN search_vector TSVECTOR
+    GENERATED ALWAYS AS (to_tsvector('simple', regexp_replace(content, '[^[:alnum:]]+', ' ', 'g'))) STORED;
+
+CREATE INDEX messages_search_vector_idx ON messages USING GIN (search_vector);
@@ -0,0 +1,10 @@
+-- the users blocked by a user, the blocker.
+CREATE TABLE blocks (
+    blocker TEXT NOT NULL,
+    blocked TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (blocker, blocked)
+);
+
+-- a blocked message is a direct message sent to a user who blocked its sender, only visible to its sender.
+ALTER TABLE messages ADD COLUMN blocked BOOLEAN NOT NULL DEFAULT FALSE;
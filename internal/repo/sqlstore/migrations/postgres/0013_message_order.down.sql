@@ -0,0 +1,3 @@
+DROP TABLE message_order;
+
+ALTER TABLE messages DROP COLUMN global_seq;
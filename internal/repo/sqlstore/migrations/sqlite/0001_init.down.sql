@@ -0,0 +1,7 @@
+DROP TABLE messages;
+
+DROP TABLE chat_participants;
+
+DROP TABLE chats;
+
+DROP TABLE users;
@@ -0,0 +1,3 @@
+-- name of a group chat, empty for a direct chat.
+-- the group chats have no direct key.
+ALTER TABLE chats ADD COLUMN name TEXT NOT NULL DEFAULT '';
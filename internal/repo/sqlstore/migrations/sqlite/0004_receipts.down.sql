@@ -0,0 +1,3 @@
+ALTER TABLE chat_participants DROP COLUMN read_seq;
+
+ALTER TABLE chat_participants DROP COLUMN delivered_seq;
@@ -0,0 +1,3 @@
+DROP INDEX attachments_message_id_idx;
+
+DROP TABLE attachments;
@@ -0,0 +1,17 @@
+-- the files uploaded by the users, their content is kept in the blob store under their id.
+-- an attachment is uploaded first, then attached once to a message of its uploader.
+CREATE TABLE attachments (
+    id TEXT PRIMARY KEY,
+    uploader TEXT NOT NULL,
+    filename TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    -- null until the attachment is attached to a message.
+    chat_id TEXT,
+    message_id TEXT REFERENCES messages (id),
+    -- keeps the attachments of a message in the order they have been given.
+    position INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX attachments_message_id_idx ON attachments (message_id, position);
@@ -0,0 +1,5 @@
+-- the dimensions of an image attachment and the names of the sizes of its thumbnails, separated by commas,
+-- null if the attachment is not an image with thumbnails.
+ALTER TABLE attachments ADD COLUMN width INTEGER;
+ALTER TABLE attachments ADD COLUMN height INTEGER;
+ALTER TABLE attachments ADD COLUMN thumbnails TEXT;
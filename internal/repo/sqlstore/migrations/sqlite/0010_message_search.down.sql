@@ -0,0 +1,7 @@
+DROP TRIGGER messages_fts_update;
+
+DROP TRIGGER messages_fts_delete;
+
+DROP TRIGGER messages_fts_insert;
+
+DROP TABLE messages_fts;
@@ -0,0 +1,14 @@
+-- the position of a message in all the messages, ordering the messages of the different chats,
+-- the row of message_order holds the sequence, incremented for each message.
+ALTER TABLE messages ADD COLUMN global_seq INTEGER NOT NULL DEFAULT 0;
+
+-- the existing messages are ordered by creation time.
+UPDATE messages SET global_seq = ordered.global_seq
+FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY created_at, chat_id, seq) AS global_seq FROM messages) AS ordered
+WHERE messages.id = ordered.id;
+
+CREATE TABLE message_order (
+    last_seq INTEGER NOT NULL
+);
+
+INSERT INTO message_order (last_seq) SELECT COALESCE(MAX(global_seq), 0) FROM messages;
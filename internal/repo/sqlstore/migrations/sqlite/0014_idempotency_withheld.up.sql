@@ -0,0 +1,2 @@
+-- the body of a response holding secrets, like an access token, is withheld: it is not stored and not replayed.
+ALTER TABLE idempotency_keys ADD COLUMN withheld BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,34 @@
+-- the full-text index is keyed on the global sequence number of the messages rather than their rowid,
+-- a VACUUM may renumber the rowids of a table without INTEGER PRIMARY KEY, not its columns.
+DROP TRIGGER messages_fts_update;
+
+DROP TRIGGER messages_fts_delete;
+
+DROP TRIGGER messages_fts_insert;
+
+DROP TABLE messages_fts;
+
+CREATE UNIQUE INDEX messages_global_seq_idx ON messages (global_seq);
+
+CREATE VIRTUAL TABLE messages_fts USING fts5(
+    content,
+    content = 'messages',
+    content_rowid = 'global_seq',
+    tokenize = 'unicode61 remove_diacritics 0'
+);
+
+CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+    INSERT INTO messages_fts (rowid, content) VALUES (new.global_seq, new.content);
+END;
+
+CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages BEGIN
+    INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.global_seq, old.content);
+END;
+
+CREATE TRIGGER messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+    INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.global_seq, old.content);
+    INSERT INTO messages_fts (rowid, content) VALUES (new.global_seq, new.content);
+END;
+
+-- index the existing messages.
+INSERT INTO messages_fts (messages_fts) VALUES ('rebuild');
@@ -0,0 +1,100 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// AddReaction adds the reaction of the user with the emoji to a message,
+// nothing changes if the user already reacted with it.
+func (r *MessageRepository) AddReaction(chatID, messageID, user, emoji string) error {
+	return r.db.withTx(func(tx *sql.Tx) error {
+		if _, err := lockMessage(tx, r.db.driver, chatID, messageID); err != nil {
+			return err
+		}
+
+		// the message is locked, the next position is not taken by a concurrent reaction.
+		_, err := tx.Exec(`
+			INSERT INTO message_reactions (message_id, user_id, emoji, position, created_at)
+			SELECT $1, $2, $3, COALESCE(MAX(position), -1) + 1, $4 FROM message_reactions WHERE message_id = $1
+			ON CONFLICT DO NOTHING`,
+			messageID, user, emoji, time.Now().UTC().Truncate(time.Millisecond),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert reaction: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// RemoveReaction removes the reaction of the user with the emoji from a message,
+// nothing changes if the user did not react with it.
+func (r *MessageRepository) RemoveReaction(chatID, messageID, user, emoji string) error {
+	return r.db.withTx(func(tx *sql.Tx) error {
+		if _, err := lockMessage(tx, r.db.driver, chatID, messageID); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(
+			`DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3`,
+			messageID, user, emoji,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to delete reaction: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// GetReactions gets the reactions to the messages of a chat, in the order they have been added.
+func (r *MessageRepository) GetReactions(chatID string, messageIDs []string) ([]repo.Reaction, error) {
+	reactions := []repo.Reaction{}
+
+	if len(messageIDs) == 0 {
+		return reactions, nil
+	}
+
+	placeholders := make([]string, len(messageIDs))
+	args := make([]any, 0, len(messageIDs)+1)
+	args = append(args, chatID)
+
+	for i, messageID := range messageIDs {
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+		args = append(args, messageID)
+	}
+
+	rows, err := r.db.db.Query(`
+		SELECT r.message_id, r.user_id, r.emoji, r.created_at
+		FROM message_reactions r
+		JOIN messages m ON m.id = r.message_id
+		WHERE m.chat_id = $1 AND r.message_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY m.seq, r.position`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var reaction repo.Reaction
+		if err = rows.Scan(&reaction.MessageID, &reaction.User, &reaction.Emoji, &reaction.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan reaction: %w", err)
+		}
+
+		reaction.CreatedAt = reaction.CreatedAt.UTC()
+		reactions = append(reactions, reaction)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get reactions: %w", err)
+	}
+
+	return reactions, nil
+}
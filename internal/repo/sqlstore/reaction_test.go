@@ -0,0 +1,74 @@
+package sqlstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+func TestMessageRepository_Reactions(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+		messageRepo := NewMessageRepository(db)
+
+		chat, _, err := chatRepo.GetOrCreateChat("1", "2")
+		require.NoError(t, err)
+
+		otherChat, _, err := chatRepo.GetOrCreateChat("1", "3")
+		require.NoError(t, err)
+
+		chatID, otherChatID := chat.ID, otherChat.ID
+
+		first, err := messageRepo.AddMessage(chatID, "1", "Hello")
+		require.NoError(t, err)
+
+		second, err := messageRepo.AddMessage(chatID, "2", "World")
+		require.NoError(t, err)
+
+		require.NoError(t, messageRepo.AddReaction(chatID, first.ID, "2", "👍"))
+		require.NoError(t, messageRepo.AddReaction(chatID, first.ID, "1", "👍"))
+		require.NoError(t, messageRepo.AddReaction(chatID, second.ID, "1", "🎉"))
+		// a user reacts once with an emoji.
+		require.NoError(t, messageRepo.AddReaction(chatID, first.ID, "2", "👍"))
+
+		reactions, err := messageRepo.GetReactions(chatID, []string{first.ID, second.ID})
+		require.NoError(t, err)
+		require.Len(t, reactions, 3)
+
+		for _, reaction := range reactions {
+			assert.False(t, reaction.CreatedAt.IsZero())
+		}
+
+		assert.Equal(t, repo.Reaction{MessageID: first.ID, User: "2", Emoji: "👍", CreatedAt: reactions[0].CreatedAt}, reactions[0])
+		assert.Equal(t, repo.Reaction{MessageID: first.ID, User: "1", Emoji: "👍", CreatedAt: reactions[1].CreatedAt}, reactions[1])
+		assert.Equal(t, repo.Reaction{MessageID: second.ID, User: "1", Emoji: "🎉", CreatedAt: reactions[2].CreatedAt}, reactions[2])
+
+		require.NoError(t, messageRepo.RemoveReaction(chatID, first.ID, "2", "👍"))
+		require.NoError(t, messageRepo.RemoveReaction(chatID, first.ID, "2", "👍"))
+
+		reactions, err = messageRepo.GetReactions(chatID, []string{first.ID})
+		require.NoError(t, err)
+		require.Len(t, reactions, 1)
+		assert.Equal(t, "1", reactions[0].User)
+
+		reactions, err = messageRepo.GetReactions(otherChatID, []string{first.ID})
+		require.NoError(t, err)
+		assert.Empty(t, reactions)
+
+		// the reactions of a deleted message are erased.
+		_, err = messageRepo.DeleteMessage(chatID, first.ID)
+		require.NoError(t, err)
+
+		reactions, err = messageRepo.GetReactions(chatID, []string{first.ID})
+		require.NoError(t, err)
+		assert.Empty(t, reactions)
+
+		require.ErrorIs(t, messageRepo.AddReaction(chatID, first.ID, "1", "👍"), repo.ErrMessageDeleted)
+		require.ErrorIs(t, messageRepo.RemoveReaction(chatID, first.ID, "1", "👍"), repo.ErrMessageDeleted)
+		require.ErrorIs(t, messageRepo.AddReaction(otherChatID, second.ID, "1", "👍"), repo.ErrMessageNotFound)
+		require.ErrorIs(t, messageRepo.RemoveReaction(chatID, "unknown", "1", "👍"), repo.ErrMessageNotFound)
+	})
+}
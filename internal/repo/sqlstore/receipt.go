@@ -0,0 +1,122 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// MarkDelivered marks the messages of the chat up to seq as delivered to the participant.
+// It returns the receipt of the participant and the previous DeliveredSeq,
+// the receipt is unchanged if the messages were already delivered.
+func (r *ChatRepository) MarkDelivered(chatID, user string, seq int64) (repo.Receipt, int64, error) {
+	return r.mark(chatID, user, seq, false)
+}
+
+// MarkRead marks the messages of the chat up to seq as read, and delivered, by the participant.
+// It returns the receipt of the participant and the previous ReadSeq,
+// the receipt is unchanged if the messages were already read.
+func (r *ChatRepository) MarkRead(chatID, user string, seq int64) (repo.Receipt, int64, error) {
+	return r.mark(chatID, user, seq, true)
+}
+
+func (r *ChatRepository) mark(chatID, user string, seq int64, read bool) (repo.Receipt, int64, error) {
+	receipt := repo.Receipt{ChatID: chatID, User: user}
+
+	var previous int64
+
+	err := r.db.withTx(func(tx *sql.Tx) error {
+		// the no-op update locks the receipt of the participant.
+		err := tx.QueryRow(
+			`UPDATE chat_participants SET delivered_seq = delivered_seq WHERE chat_id = $1 AND user_id = $2
+			RETURNING delivered_seq, read_seq`,
+			chatID, user,
+		).Scan(&receipt.DeliveredSeq, &receipt.ReadSeq)
+		if errors.Is(err, sql.ErrNoRows) {
+			return participantError(tx, chatID)
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to get receipt: %w", err)
+		}
+
+		previous = receipt.DeliveredSeq
+		if read {
+			previous = receipt.ReadSeq
+			receipt.ReadSeq = max(receipt.ReadSeq, seq)
+		}
+
+		if previous >= seq {
+			return nil
+		}
+
+		receipt.DeliveredSeq = max(receipt.DeliveredSeq, seq)
+
+		_, err = tx.Exec(
+			`UPDATE chat_participants SET delivered_seq = $1, read_seq = $2 WHERE chat_id = $3 AND user_id = $4`,
+			receipt.DeliveredSeq, receipt.ReadSeq, chatID, user,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update receipt: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return repo.Receipt{}, 0, err
+	}
+
+	return receipt, previous, nil
+}
+
+// participantError returns the error of a user not found in the participants of the chat,
+// depending on whether the chat exists.
+func participantError(q querier, chatID string) error {
+	var exists bool
+
+	if err := q.QueryRow(`SELECT EXISTS (SELECT 1 FROM chats WHERE id = $1)`, chatID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to get chat: %w", err)
+	}
+
+	if !exists {
+		return repo.ErrChatNotFound
+	}
+
+	return repo.ErrNotParticipant
+}
+
+// GetReceipts gets the receipts of the participants of the chat, in the participants order.
+func (r *ChatRepository) GetReceipts(chatID string) ([]repo.Receipt, error) {
+	rows, err := r.db.db.Query(
+		`SELECT user_id, delivered_seq, read_seq FROM chat_participants WHERE chat_id = $1 ORDER BY position`,
+		chatID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get receipts: %w", err)
+	}
+	defer rows.Close()
+
+	receipts := []repo.Receipt{}
+
+	for rows.Next() {
+		receipt := repo.Receipt{ChatID: chatID}
+		if err = rows.Scan(&receipt.User, &receipt.DeliveredSeq, &receipt.ReadSeq); err != nil {
+			return nil, fmt.Errorf("failed to scan receipt: %w", err)
+		}
+
+		receipts = append(receipts, receipt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get receipts: %w", err)
+	}
+
+	// a chat has participants, no receipt means no chat.
+	if len(receipts) == 0 {
+		return nil, repo.ErrChatNotFound
+	}
+
+	return receipts, nil
+}
@@ -0,0 +1,88 @@
+package sqlstore
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// searchStatement selects the messages matching the search $1 with its ranking,
+// the filters are appended to its WHERE clause.
+// PostgreSQL searches the text search vector of the messages, SQLite their full-text index.
+func (r *MessageRepository) searchStatement(terms []string) (stmt, rank string, match any) {
+	if r.db.driver == DriverPostgres {
+		// the terms are split like the content of the messages in the search_vector column.
+		const query = `plainto_tsquery('simple', regexp_replace($1, '[^[:alnum:]]+', ' ', 'g'))`
+
+		return selectMessages + ` WHERE search_vector @@ ` + query,
+			`ts_rank(search_vector, ` + query + `) DESC`,
+			strings.Join(terms, " ")
+	}
+
+	// the terms are words, quoting them keeps them from being read as operators like OR or NOT.
+	phrases := make([]string, len(terms))
+	for i, term := range terms {
+		phrases[i] = `"` + term + `"`
+	}
+
+	// bm25 ranks the best matches first, with the lowest values.
+	return selectMessages + ` JOIN (
+			SELECT rowid AS match_seq, bm25(messages_fts) AS match_rank FROM messages_fts WHERE messages_fts MATCH $1
+		) matches ON matches.match_seq = messages.global_seq WHERE TRUE`,
+		`matches.match_rank`,
+		strings.Join(phrases, " ")
+}
+
+// SearchMessages gets the messages matching the query, from the most relevant.
+// The messages of the same relevance are sorted from the most recent.
+func (r *MessageRepository) SearchMessages(query repo.SearchQuery) ([]repo.Message, error) {
+	if len(query.Terms) == 0 || len(query.ChatIDs) == 0 {
+		return []repo.Message{}, nil
+	}
+
+	stmt, rank, match := r.searchStatement(query.Terms)
+	args := []any{match}
+
+	placeholders := make([]string, len(query.ChatIDs))
+	for i, chatID := range query.ChatIDs {
+		args = append(args, chatID)
+		placeholders[i] = fmt.Sprintf("$%d", len(args))
+	}
+
+	stmt += ` AND chat_id IN (` + strings.Join(placeholders, ", ") + `) AND deleted_at IS NULL AND NOT system`
+
+	if query.Sender != "" {
+		args = append(args, query.Sender)
+		stmt += fmt.Sprintf(` AND sender = $%d`, len(args))
+	}
+
+	if query.Viewer != "" {
+		args = append(args, query.Viewer)
+		stmt += fmt.Sprintf(` AND (NOT blocked OR sender = $%d)`, len(args))
+	}
+
+	if !query.From.IsZero() {
+		args = append(args, query.From.UTC())
+		stmt += fmt.Sprintf(` AND created_at >= $%d`, len(args))
+	}
+
+	if !query.To.IsZero() {
+		args = append(args, query.To.UTC())
+		stmt += fmt.Sprintf(` AND created_at < $%d`, len(args))
+	}
+
+	stmt += ` ORDER BY ` + rank + `, created_at DESC, id`
+
+	// SQLite requires a limit to skip messages.
+	limit := int64(query.Limit)
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+
+	args = append(args, limit, max(query.Offset, 0))
+	stmt += fmt.Sprintf(` LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+
+	return r.queryMessages(stmt, args...)
+}
@@ -0,0 +1,103 @@
+package sqlstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+func TestMessageRepository_SearchMessages(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		chatRepo := NewChatRepository(db)
+		messageRepo := NewMessageRepository(db)
+
+		chat, _, err := chatRepo.GetOrCreateChat("1", "2")
+		require.NoError(t, err)
+
+		otherChat, _, err := chatRepo.GetOrCreateChat("1", "3")
+		require.NoError(t, err)
+
+		tonight, err := messageRepo.AddMessage(chat.ID, "1", "Pizza tonight?")
+		require.NoError(t, err)
+
+		pizza, err := messageRepo.AddMessage(chat.ID, "2", "pizza, pizza, PIZZA!")
+		require.NoError(t, err)
+
+		party, err := messageRepo.AddMessage(chat.ID, "1", "Sushi tomorrow")
+		require.NoError(t, err)
+
+		other, err := messageRepo.AddMessage(otherChat.ID, "3", "pizza")
+		require.NoError(t, err)
+
+		_, err = messageRepo.AddSystemMessage(chat.ID, "1", "pizza added 3")
+		require.NoError(t, err)
+
+		deleted, err := messageRepo.AddMessage(chat.ID, "1", "pizza")
+		require.NoError(t, err)
+
+		_, err = messageRepo.DeleteMessage(chat.ID, deleted.ID)
+		require.NoError(t, err)
+
+		party, err = messageRepo.EditMessage(chat.ID, party.ID, "Pizza party tomorrow")
+		require.NoError(t, err)
+
+		// the message repeating the word ranks first.
+		messages, err := messageRepo.SearchMessages(repo.SearchQuery{Terms: []string{"pizza"}, ChatIDs: []string{chat.ID}})
+		require.NoError(t, err)
+		require.Len(t, messages, 3)
+		assert.Equal(t, pizza, messages[0])
+		assert.ElementsMatch(t, []repo.Message{tonight, party}, messages[1:])
+
+		messages, err = messageRepo.SearchMessages(repo.SearchQuery{Terms: []string{"pizza"}, ChatIDs: []string{chat.ID, otherChat.ID}})
+		require.NoError(t, err)
+		assert.Len(t, messages, 4)
+		assert.Contains(t, messages, other)
+
+		// the replaced content is not found anymore.
+		messages, err = messageRepo.SearchMessages(repo.SearchQuery{Terms: []string{"sushi"}, ChatIDs: []string{chat.ID}})
+		require.NoError(t, err)
+		assert.Empty(t, messages)
+
+		messages, err = messageRepo.SearchMessages(repo.SearchQuery{Terms: []string{"pizza", "tomorrow"}, ChatIDs: []string{chat.ID}})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.Message{party}, messages)
+
+		messages, err = messageRepo.SearchMessages(repo.SearchQuery{Terms: []string{"pizza"}, ChatIDs: []string{chat.ID}, Sender: "1"})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []repo.Message{tonight, party}, messages)
+
+		messages, err = messageRepo.SearchMessages(repo.SearchQuery{
+			Terms:   []string{"pizza"},
+			ChatIDs: []string{chat.ID},
+			From:    pizza.CreatedAt,
+			To:      pizza.CreatedAt.Add(time.Millisecond),
+		})
+		require.NoError(t, err)
+		assert.Contains(t, messages, pizza)
+
+		messages, err = messageRepo.SearchMessages(repo.SearchQuery{Terms: []string{"pizza"}, ChatIDs: []string{chat.ID}, To: tonight.CreatedAt})
+		require.NoError(t, err)
+		assert.Empty(t, messages)
+
+		// the messages are paged by their rank.
+		messages, err = messageRepo.SearchMessages(repo.SearchQuery{Terms: []string{"pizza"}, ChatIDs: []string{chat.ID}, Limit: 1})
+		require.NoError(t, err)
+		assert.Equal(t, []repo.Message{pizza}, messages)
+
+		messages, err = messageRepo.SearchMessages(repo.SearchQuery{Terms: []string{"pizza"}, ChatIDs: []string{chat.ID}, Offset: 1, Limit: 5})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []repo.Message{tonight, party}, messages)
+
+		messages, err = messageRepo.SearchMessages(repo.SearchQuery{Terms: []string{"pizza"}, ChatIDs: []string{chat.ID}, Offset: 5})
+		require.NoError(t, err)
+		assert.Empty(t, messages)
+
+		messages, err = messageRepo.SearchMessages(repo.SearchQuery{Terms: []string{"pizza"}})
+		require.NoError(t, err)
+		assert.Empty(t, messages)
+	})
+}
@@ -0,0 +1,66 @@
+package sqlstore
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+// UserRepository manages user storage and operations.
+type UserRepository struct {
+	db *DB
+
+	logger *slog.Logger
+}
+
+// NewUserRepository initializes a new UserRepository.
+func NewUserRepository(db *DB) *UserRepository {
+	logger := slog.With(slog.String("repo", "user"), slog.String("db", db.driver))
+	logger.Info("created repository")
+
+	return &UserRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// AddUser adds a new user to the repository.
+func (r *UserRepository) AddUser(phoneNumber string) error {
+	result, err := r.db.db.Exec(
+		`INSERT INTO users (phone_number, created_at) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		phoneNumber, time.Now().UTC().Truncate(time.Millisecond),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert user: %w", err)
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to insert user: %w", err)
+	}
+
+	if inserted == 0 {
+		return repo.ErrPhoneNumberAlreadyRegistered
+	}
+
+	return nil
+}
+
+// IsRegistered checks if a phone number is already registered.
+func (r *UserRepository) IsRegistered(phoneNumber string) bool {
+	var exists bool
+
+	err := r.db.db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM users WHERE phone_number = $1)`,
+		phoneNumber,
+	).Scan(&exists)
+	if err != nil {
+		r.logger.Error("failed to check user registration", slog.String("error", err.Error()))
+
+		return false
+	}
+
+	return exists
+}
@@ -0,0 +1,27 @@
+package sqlstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jbdoumenjou/mychat/internal/repo"
+)
+
+func TestUserRepository_AddUser(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *DB) {
+		userRepo := NewUserRepository(db)
+
+		require.False(t, userRepo.IsRegistered("123"))
+
+		err := userRepo.AddUser("123")
+		require.NoError(t, err)
+		require.True(t, userRepo.IsRegistered("123"))
+
+		err = userRepo.AddUser("123")
+		require.ErrorIs(t, err, repo.ErrPhoneNumberAlreadyRegistered)
+
+		err = userRepo.AddUser("1234")
+		require.NoError(t, err)
+	})
+}
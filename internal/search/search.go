@@ -0,0 +1,187 @@
+// Package search finds the messages by their words.
+// The texts are split in lower-cased words, the terms, the same way whatever the store:
+// an in-memory inverted index, or the full-text search of a database.
+package search
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// token is a word of a text, at its byte offsets.
+type token struct {
+	term       string
+	start, end int
+}
+
+// isWordRune reports whether the rune is part of a word: a letter, a number or a mark, like an accent.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r)
+}
+
+// tokenize splits the text in words, the other characters separate them.
+func tokenize(text string) []token {
+	var (
+		tokens []token
+		start  = -1
+	)
+
+	for i, r := range text {
+		switch {
+		case isWordRune(r) && start < 0:
+			start = i
+		case !isWordRune(r) && start >= 0:
+			tokens = append(tokens, token{term: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+
+	if start >= 0 {
+		tokens = append(tokens, token{term: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+
+	return tokens
+}
+
+// Terms returns the distinct terms of the text, in their order of appearance.
+func Terms(text string) []string {
+	var terms []string
+
+	seen := make(map[string]bool)
+
+	for _, token := range tokenize(text) {
+		if !seen[token.term] {
+			seen[token.term] = true
+
+			terms = append(terms, token.term)
+		}
+	}
+
+	return terms
+}
+
+// BM25 parameters: the saturation of the term frequency and the weight of the document length.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// document is an indexed text, by its terms.
+type document struct {
+	frequencies map[string]int // map[term]occurrences
+	length      int
+}
+
+// Index is an inverted index of texts identified by their IDs:
+// each term points to the texts holding it.
+// It is not safe for concurrent use.
+type Index struct {
+	postings  map[string]map[string]int // map[term]map[ID]occurrences
+	documents map[string]document       // map[ID]document
+	length    int                       // total number of terms of the documents
+}
+
+// NewIndex creates an empty index.
+func NewIndex() *Index {
+	return &Index{
+		postings:  make(map[string]map[string]int),
+		documents: make(map[string]document),
+	}
+}
+
+// Add indexes the text of the ID, replacing its previous text.
+func (i *Index) Add(id, text string) {
+	i.Remove(id)
+
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return
+	}
+
+	doc := document{frequencies: make(map[string]int), length: len(tokens)}
+	for _, token := range tokens {
+		doc.frequencies[token.term]++
+	}
+
+	for term, count := range doc.frequencies {
+		if i.postings[term] == nil {
+			i.postings[term] = make(map[string]int)
+		}
+
+		i.postings[term][id] = count
+	}
+
+	i.documents[id] = doc
+	i.length += doc.length
+}
+
+// Remove removes the text of the ID from the index.
+func (i *Index) Remove(id string) {
+	doc, ok := i.documents[id]
+	if !ok {
+		return
+	}
+
+	for term := range doc.frequencies {
+		delete(i.postings[term], id)
+
+		if len(i.postings[term]) == 0 {
+			delete(i.postings, term)
+		}
+	}
+
+	delete(i.documents, id)
+	i.length -= doc.length
+}
+
+// Hit is a text matching a search, its score is higher when it is more relevant.
+type Hit struct {
+	ID    string
+	Score float64
+}
+
+// Search returns the IDs of the texts holding all the terms and kept by the filter, in no particular order.
+// Their score is computed with BM25: the rare terms, repeated in short texts, weight more.
+func (i *Index) Search(terms []string, keep func(id string) bool) []Hit {
+	if len(terms) == 0 {
+		return nil
+	}
+
+	// the candidates are the texts of the rarest term.
+	rarest := terms[0]
+	for _, term := range terms[1:] {
+		if len(i.postings[term]) < len(i.postings[rarest]) {
+			rarest = term
+		}
+	}
+
+	count := float64(len(i.documents))
+	averageLength := float64(i.length) / max(count, 1)
+
+	var hits []Hit
+
+candidates:
+	for id := range i.postings[rarest] {
+		doc := i.documents[id]
+		score := 0.0
+
+		for _, term := range terms {
+			frequency := float64(doc.frequencies[term])
+			if frequency == 0 {
+				continue candidates
+			}
+
+			matches := float64(len(i.postings[term]))
+			idf := math.Log(1 + (count-matches+0.5)/(matches+0.5))
+			score += idf * frequency * (bm25K1 + 1) /
+				(frequency + bm25K1*(1-bm25B+bm25B*float64(doc.length)/averageLength))
+		}
+
+		if keep(id) {
+			hits = append(hits, Hit{ID: id, Score: score})
+		}
+	}
+
+	return hits
+}
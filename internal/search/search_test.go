@@ -0,0 +1,121 @@
+package search
+
+import (
+	"cmp"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerms(t *testing.T) {
+	// the punctuation separates the words, like the apostrophes.
+	assert.Equal(t, []string{"hello", "wörld", "it", "s", "2024"}, Terms("Hello, WÖRLD! hello it's 2024..."))
+	assert.Empty(t, Terms(" ?! 🎉 "))
+}
+
+// ids returns the IDs of the hits, from the highest score.
+func ids(hits []Hit) []string {
+	slices.SortFunc(hits, func(a, b Hit) int { return cmp.Compare(b.Score, a.Score) })
+
+	result := make([]string, len(hits))
+	for i, hit := range hits {
+		result[i] = hit.ID
+	}
+
+	return result
+}
+
+func TestIndex_Search(t *testing.T) {
+	index := NewIndex()
+	index.Add("lunch", "Lunch at noon? The pizza place near the office")
+	index.Add("pizza", "pizza pizza pizza!")
+	index.Add("dinner", "Dinner tonight, no pizza this time")
+	index.Add("other", "See you tomorrow")
+
+	all := func(string) bool { return true }
+
+	// the text repeating the term ranks first, then the shorter one.
+	assert.Equal(t, []string{"pizza", "dinner", "lunch"}, ids(index.Search([]string{"pizza"}, all)))
+
+	// every term must match.
+	assert.Equal(t, []string{"dinner"}, ids(index.Search([]string{"pizza", "tonight"}, all)))
+	assert.Empty(t, index.Search([]string{"pizza", "tomorrow"}, all))
+	assert.Empty(t, index.Search([]string{"unknown"}, all))
+	assert.Empty(t, index.Search(nil, all))
+
+	// the filter drops the hits.
+	assert.Equal(t, []string{"pizza", "lunch"}, ids(index.Search([]string{"pizza"}, func(id string) bool { return id != "dinner" })))
+
+	// a replaced text is searched by its new terms.
+	index.Add("pizza", "sushi")
+	assert.Equal(t, []string{"dinner", "lunch"}, ids(index.Search([]string{"pizza"}, all)))
+	assert.Equal(t, []string{"pizza"}, ids(index.Search([]string{"sushi"}, all)))
+
+	index.Remove("pizza")
+	index.Remove("unknown")
+	assert.Empty(t, index.Search([]string{"sushi"}, all))
+	require.Len(t, index.documents, 3)
+	assert.NotContains(t, index.postings, "sushi")
+}
+
+func TestSnippet(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		text     string
+		terms    []string
+		expected []Fragment
+	}{
+		{
+			desc:  "whole text",
+			text:  "Pizza tonight? Pizza!",
+			terms: []string{"pizza"},
+			expected: []Fragment{
+				{Text: "Pizza", Match: true},
+				{Text: " tonight? "},
+				{Text: "Pizza", Match: true},
+				{Text: "!"},
+			},
+		},
+		{
+			desc:  "cut text",
+			text:  "one two three four five six seven eight nine ten",
+			terms: []string{"six", "seven"},
+			expected: []Fragment{
+				{Text: "…three four five "},
+				{Text: "six", Match: true},
+				{Text: " "},
+				{Text: "seven", Match: true},
+				{Text: "…"},
+			},
+		},
+		{
+			desc:  "cut text end",
+			text:  "one two three four five six seven eight nine ten",
+			terms: []string{"ten"},
+			expected: []Fragment{
+				{Text: "…six seven eight nine "},
+				{Text: "ten", Match: true},
+			},
+		},
+		{
+			desc:     "no match",
+			text:     "one two three four five six",
+			terms:    []string{"ten"},
+			expected: []Fragment{{Text: "one two three four five…"}},
+		},
+		{
+			desc:     "no word",
+			text:     "🎉",
+			terms:    []string{"ten"},
+			expected: []Fragment{{Text: "🎉"}},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			assert.Equal(t, test.expected, Snippet(test.text, test.terms, 5))
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package search
+
+// Fragment is a part of a snippet, Match reports whether it is a word searched for.
+type Fragment struct {
+	Text  string
+	Match bool
+}
+
+// ellipsis marks the text cut from a snippet.
+const ellipsis = "…"
+
+// snippetContext is the number of words kept before the first match of a snippet.
+const snippetContext = 3
+
+// Snippet returns the excerpt of the text around its first word matching a term, at most maxWords long,
+// split in fragments to highlight the words matching the terms.
+// The text is cut at the word boundaries, an ellipsis marks the cut parts.
+func Snippet(text string, terms []string, maxWords int) []Fragment {
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return []Fragment{{Text: text}}
+	}
+
+	searched := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		searched[term] = true
+	}
+
+	first := 0
+
+	for i, token := range tokens {
+		if searched[token.term] {
+			first = i
+
+			break
+		}
+	}
+
+	start := max(first-snippetContext, 0)
+	end := min(start+maxWords, len(tokens))
+	start = max(end-maxWords, 0)
+
+	// the text before the first word and after the last word is kept when it is not cut.
+	from, to := 0, len(text)
+	if start > 0 {
+		from = tokens[start].start
+	}
+
+	if end < len(tokens) {
+		to = tokens[end-1].end
+	}
+
+	var (
+		fragments []Fragment
+		plain     string
+	)
+
+	if start > 0 {
+		plain = ellipsis
+	}
+
+	for _, token := range tokens[start:end] {
+		if !searched[token.term] {
+			continue
+		}
+
+		plain += text[from:token.start]
+		if plain != "" {
+			fragments = append(fragments, Fragment{Text: plain})
+		}
+
+		fragments = append(fragments, Fragment{Text: text[token.start:token.end], Match: true})
+		plain, from = "", token.end
+	}
+
+	plain += text[from:to]
+	if end < len(tokens) {
+		plain += ellipsis
+	}
+
+	if plain != "" {
+		fragments = append(fragments, Fragment{Text: plain})
+	}
+
+	return fragments
+}
@@ -0,0 +1,71 @@
+// Package sms sends text messages to phone numbers.
+// No SMS gateway is integrated yet, the messages are logged or written to a local file
+// so the development and the tests work offline.
+package sms
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogSender logs the text messages instead of sending them.
+type LogSender struct {
+	logger *slog.Logger
+}
+
+// NewLogSender creates a new LogSender.
+func NewLogSender() *LogSender {
+	logger := slog.With(slog.String("sms", "log"))
+	logger.Info("created sms sender")
+
+	return &LogSender{logger: logger}
+}
+
+// SendSMS logs the text message.
+func (s *LogSender) SendSMS(ctx context.Context, phoneNumber, text string) error {
+	s.logger.InfoContext(ctx, "sms", slog.String("phoneNumber", phoneNumber), slog.String("text", text))
+
+	return nil
+}
+
+// FileSender appends the text messages to a local file instead of sending them,
+// one line per message.
+type FileSender struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileSender creates a new FileSender writing to the file at path.
+func NewFileSender(path string) *FileSender {
+	slog.Info("created sms sender", slog.String("sms", "file"), slog.String("path", path))
+
+	return &FileSender{path: path}
+}
+
+// SendSMS appends the text message to the file.
+func (s *FileSender) SendSMS(_ context.Context, phoneNumber, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open sms file: %w", err)
+	}
+
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\n", time.Now().UTC().Format(time.RFC3339), phoneNumber, text)
+	if err != nil {
+		_ = f.Close()
+
+		return fmt.Errorf("failed to write sms: %w", err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("failed to write sms: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package sms
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSender_SendSMS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sms.log")
+	sender := NewFileSender(path)
+
+	require.NoError(t, sender.SendSMS(context.Background(), "+33666666666", "first"))
+	require.NoError(t, sender.SendSMS(context.Background(), "+33777777777", "second"))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasSuffix(lines[0], "\t+33666666666\tfirst"))
+	assert.True(t, strings.HasSuffix(lines[1], "\t+33777777777\tsecond"))
+}
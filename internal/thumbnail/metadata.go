@@ -0,0 +1,201 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// JPEG markers.
+const (
+	markerRST0  = 0xD0 // first restart marker, in the compressed data
+	markerRST7  = 0xD7 // last restart marker
+	markerSOI   = 0xD8 // start of image
+	markerEOI   = 0xD9 // end of image
+	markerSOS   = 0xDA // start of scan, the compressed data follow
+	markerAPP0  = 0xE0 // JFIF header
+	markerAPP1  = 0xE1 // EXIF or XMP metadata
+	markerAPP2  = 0xE2 // color profile, or multi-picture index
+	markerAPP14 = 0xEE // Adobe color transform
+	markerAPP15 = 0xEF // last application segment
+	markerCOM   = 0xFE // comment
+)
+
+// displaySegments are the prefixes of the application segments needed to display an image, by marker.
+var displaySegments = map[byte][]byte{
+	markerAPP0:  []byte("JFIF\x00"),
+	markerAPP2:  []byte("ICC_PROFILE\x00"),
+	markerAPP14: []byte("Adobe"),
+}
+
+// stripJPEG removes the metadata of a JPEG image: its application segments and comments,
+// except the ones needed to display it before the first scan, like the color profile,
+// and the data after the end of the image, like the other images of a multi-picture file.
+// It returns the EXIF orientation of the image, 0 if unknown.
+func stripJPEG(data []byte) ([]byte, int, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != markerSOI {
+		return nil, 0, fmt.Errorf("%w: missing JPEG start of image", ErrInvalid)
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	orientation := 0
+	scanned := false
+
+	for i := 2; ; {
+		// markers can be preceded by fill bytes.
+		for i+1 < len(data) && data[i] == 0xFF && data[i+1] == 0xFF {
+			i++
+		}
+
+		if i+2 > len(data) || data[i] != 0xFF {
+			return nil, 0, fmt.Errorf("%w: truncated JPEG segment", ErrInvalid)
+		}
+
+		marker := data[i+1]
+		if marker == markerEOI {
+			return append(out, 0xFF, markerEOI), orientation, nil
+		}
+
+		if i+4 > len(data) {
+			return nil, 0, fmt.Errorf("%w: truncated JPEG segment", ErrInvalid)
+		}
+
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:i+4]))
+		if end > len(data) {
+			return nil, 0, fmt.Errorf("%w: truncated JPEG segment", ErrInvalid)
+		}
+
+		if marker == markerAPP1 {
+			if o := exifOrientation(data[i+4 : end]); o != 0 {
+				orientation = o
+			}
+		}
+
+		if keepSegment(marker, data[i+4:end], scanned) {
+			out = append(out, data[i:end]...)
+		}
+
+		i = end
+
+		// the compressed data of a scan are kept as is.
+		if marker == markerSOS {
+			scanned = true
+			i = scanEnd(data, i)
+			out = append(out, data[end:i]...)
+		}
+	}
+}
+
+// keepSegment reports whether a segment is kept: the application segments and comments are metadata,
+// except the ones needed to display the image before the first scan.
+func keepSegment(marker byte, payload []byte, scanned bool) bool {
+	switch {
+	case marker == markerCOM:
+		return false
+	case marker < markerAPP0 || marker > markerAPP15:
+		return true
+	case scanned:
+		return false
+	}
+
+	prefix, ok := displaySegments[marker]
+
+	return ok && bytes.HasPrefix(payload, prefix)
+}
+
+// scanEnd returns the position of the marker ending the compressed data starting at i,
+// the escaped 0xFF bytes and the restart markers are part of the data.
+func scanEnd(data []byte, i int) int {
+	for ; i+1 < len(data); i++ {
+		if data[i] == 0xFF && data[i+1] != 0 && (data[i+1] < markerRST0 || data[i+1] > markerRST7) {
+			return i
+		}
+	}
+
+	return len(data)
+}
+
+// exifHeader starts the APP1 segment of the EXIF metadata.
+var exifHeader = []byte("Exif\x00\x00")
+
+// tagOrientation is the EXIF tag of the orientation of the image.
+const tagOrientation = 0x0112
+
+// exifOrientation returns the orientation of the EXIF metadata of the APP1 segment, 0 if unknown.
+// The metadata are a TIFF structure, the orientation is an entry of its first directory.
+func exifOrientation(segment []byte) int {
+	tiff, ok := bytes.CutPrefix(segment, exifHeader)
+	if !ok || len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0
+	}
+
+	count := int(order.Uint16(tiff[offset : offset+2]))
+
+	for entry := offset + 2; entry+12 <= len(tiff) && count > 0; entry, count = entry+12, count-1 {
+		if order.Uint16(tiff[entry:entry+2]) == tagOrientation {
+			return int(order.Uint16(tiff[entry+8 : entry+10]))
+		}
+	}
+
+	return 0
+}
+
+// pngSignature starts a PNG image.
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadataChunks are the chunks of a PNG image holding metadata: EXIF, text, XMP and modification time.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// stripPNG removes the metadata chunks of a PNG image, the chunks are kept as is otherwise.
+func stripPNG(data []byte) ([]byte, error) {
+	rest, ok := bytes.CutPrefix(data, pngSignature)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing PNG signature", ErrInvalid)
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+
+	for len(rest) > 0 {
+		// a chunk is its length, its type, its data and its CRC.
+		if len(rest) < 12 {
+			return nil, fmt.Errorf("%w: truncated PNG chunk", ErrInvalid)
+		}
+
+		end := 12 + int(binary.BigEndian.Uint32(rest[:4]))
+		if end < 12 || end > len(rest) {
+			return nil, fmt.Errorf("%w: truncated PNG chunk", ErrInvalid)
+		}
+
+		if !pngMetadataChunks[string(rest[4:8])] {
+			out = append(out, rest[:end]...)
+		}
+
+		rest = rest[end:]
+	}
+
+	return out, nil
+}
@@ -0,0 +1,343 @@
+// Package thumbnail prepares the uploaded images to be shared:
+// their metadata, like the EXIF GPS position, are stripped, and thumbnails are generated at a few sizes.
+// Only the standard image packages are used, JPEG, PNG and GIF images are supported.
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// Size is a size of thumbnail, the thumbnail fits in a square of Max pixels.
+type Size struct {
+	Name string
+	Max  int
+}
+
+// Sizes are the sizes of the generated thumbnails, from the smallest to the largest.
+var Sizes = []Size{
+	{Name: "small", Max: 160},
+	{Name: "medium", Max: 640},
+}
+
+// MaxPixels is the maximum number of pixels of a processed image, counting all the frames of a GIF image,
+// a small file can declare a huge image to exhaust the memory when decoded.
+const MaxPixels = 40_000_000
+
+// jpegQuality is the quality of the JPEG images encoded by the package.
+const jpegQuality = 85
+
+var (
+	// ErrUnsupported is returned when the content type is not a supported image.
+	ErrUnsupported = errors.New("unsupported image type")
+	// ErrInvalid is returned when the image can't be decoded.
+	ErrInvalid = errors.New("invalid image")
+	// ErrTooLarge is returned when the image has more than MaxPixels pixels, in all its frames.
+	ErrTooLarge = errors.New("image is too large")
+)
+
+// Supported reports whether the images of the content type are processed.
+func Supported(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	default:
+		return false
+	}
+}
+
+// ContentType returns the content type of the thumbnails of an image of the content type,
+// the JPEG images have JPEG thumbnails, the others have PNG thumbnails to keep their transparency.
+func ContentType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+
+	return "image/png"
+}
+
+// Image is an image without its metadata, and its thumbnails.
+type Image struct {
+	// Content is the encoded image without its metadata.
+	Content []byte
+	// Width and Height are the dimensions of the image, as displayed.
+	Width  int
+	Height int
+	// Thumbnails are the encoded thumbnails, in the order of the Sizes.
+	Thumbnails []Thumbnail
+}
+
+// Thumbnail is an encoded thumbnail of an image.
+type Thumbnail struct {
+	Size    Size
+	Content []byte
+}
+
+// Process strips the metadata of the image of the content type, and generates its thumbnails.
+// The JPEG and PNG images are kept as is without their metadata chunks, unless the EXIF orientation
+// of a JPEG image must be applied to its pixels before it is dropped; the GIF images are re-encoded.
+func Process(data []byte, contentType string) (Image, error) {
+	if !Supported(contentType) {
+		return Image{}, ErrUnsupported
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	pixels := int64(config.Width) * int64(config.Height)
+
+	// all the frames of a GIF image are decoded to be re-encoded.
+	if contentType == "image/gif" {
+		if pixels, err = gifPixels(data); err != nil {
+			return Image{}, err
+		}
+	}
+
+	if pixels > MaxPixels {
+		return Image{}, ErrTooLarge
+	}
+
+	content, orientation, err := strip(data, contentType)
+	if err != nil {
+		return Image{}, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	img = orient(img, orientation)
+
+	if orientation > 1 {
+		if content, err = encode(img, contentType); err != nil {
+			return Image{}, err
+		}
+	}
+
+	result := Image{
+		Content: content,
+		Width:   img.Bounds().Dx(),
+		Height:  img.Bounds().Dy(),
+	}
+
+	for _, size := range Sizes {
+		thumbnail, err := encode(scale(img, size.Max), ContentType(contentType))
+		if err != nil {
+			return Image{}, err
+		}
+
+		result.Thumbnails = append(result.Thumbnails, Thumbnail{Size: size, Content: thumbnail})
+	}
+
+	return result, nil
+}
+
+// strip removes the metadata of the image, it returns the EXIF orientation of a JPEG image, 0 if unknown.
+func strip(data []byte, contentType string) ([]byte, int, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		content, err := stripPNG(data)
+
+		return content, 0, err
+	default:
+		content, err := reencodeGIF(data)
+
+		return content, 0, err
+	}
+}
+
+// encode encodes the image in the format of the content type.
+func encode(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	var err error
+
+	switch contentType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	case "image/gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = png.Encode(&buf, img)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// reencodeGIF decodes and encodes all the frames of a GIF image,
+// only the frames and their timing are kept, the comments and the application extensions are dropped.
+func reencodeGIF(data []byte) ([]byte, error) {
+	img, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	var buf bytes.Buffer
+	if err = gif.EncodeAll(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// gifPixels returns the number of pixels of all the frames of a GIF image, read from their descriptors.
+// The blocks of the image are skipped without being decoded.
+func gifPixels(data []byte) (int64, error) {
+	// the header and the logical screen descriptor, followed by the global color table.
+	if len(data) < 13 {
+		return 0, fmt.Errorf("%w: truncated GIF header", ErrInvalid)
+	}
+
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+
+	var pixels int64
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension: its label and its sub-blocks
+			i = skipGIFSubBlocks(data, i+2)
+		case 0x2C: // image descriptor: its bounds, its local color table, its LZW code size and its sub-blocks
+			if i+10 > len(data) {
+				return 0, fmt.Errorf("%w: truncated GIF image descriptor", ErrInvalid)
+			}
+
+			pixels += int64(binary.LittleEndian.Uint16(data[i+5:i+7])) * int64(binary.LittleEndian.Uint16(data[i+7:i+9]))
+
+			flags := data[i+9]
+			i += 10
+
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+
+			i = skipGIFSubBlocks(data, i+1)
+		case 0x3B: // trailer
+			return pixels, nil
+		default:
+			return 0, fmt.Errorf("%w: unknown GIF block 0x%02x", ErrInvalid, data[i])
+		}
+	}
+
+	// a truncated image is rejected when decoded.
+	return pixels, nil
+}
+
+// skipGIFSubBlocks returns the position following the sub-blocks starting at i, ended by an empty sub-block.
+func skipGIFSubBlocks(data []byte, i int) int {
+	for i < len(data) && data[i] != 0 {
+		i += 1 + int(data[i])
+	}
+
+	return i + 1
+}
+
+// scale returns the image scaled down to fit in a square of max pixels, keeping its aspect ratio.
+// Each pixel of the scaled image is the average of the pixels it covers, a smaller image is kept as is.
+func scale(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	if srcWidth <= maxSize && srcHeight <= maxSize {
+		return img
+	}
+
+	width, height := maxSize, maxSize
+	if srcWidth > srcHeight {
+		height = max(1, srcHeight*maxSize/srcWidth)
+	} else {
+		width = max(1, srcWidth*maxSize/srcHeight)
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, srcWidth, srcHeight))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		y0, y1 := y*srcHeight/height, max((y+1)*srcHeight/height, y*srcHeight/height+1)
+
+		for x := range width {
+			x0, x1 := x*srcWidth/width, max((x+1)*srcWidth/width, x*srcWidth/width+1)
+
+			var r, g, b, a, n uint64
+
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r, g, b, a = r+uint64(p[0]), g+uint64(p[1]), b+uint64(p[2]), a+uint64(p[3])
+					n++
+				}
+			}
+
+			p := dst.Pix[y*dst.Stride+x*4 : y*dst.Stride+x*4+4]
+			p[0], p[1], p[2], p[3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+
+	return dst
+}
+
+// orient applies the EXIF orientation to the image, so it is displayed upright without its metadata.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	width, height := srcWidth, srcHeight
+	if orientation >= 5 {
+		width, height = srcHeight, srcWidth
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		for x := range width {
+			var sx, sy int
+
+			switch orientation {
+			case 2: // mirrored horizontally
+				sx, sy = srcWidth-1-x, y
+			case 3: // rotated 180°
+				sx, sy = srcWidth-1-x, srcHeight-1-y
+			case 4: // mirrored vertically
+				sx, sy = x, srcHeight-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // rotated 90° clockwise
+				sx, sy = y, srcHeight-1-x
+			case 7: // transversed
+				sx, sy = srcWidth-1-y, srcHeight-1-x
+			default: // rotated 90° counterclockwise
+				sx, sy = srcWidth-1-y, x
+			}
+
+			dst.Set(x, y, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,332 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gpsPosition stands for the GPS position hidden in the metadata of the test images.
+const gpsPosition = "GPS 48.8584N 2.2945E"
+
+// testImage creates an image of the size, its left half is red and its right half is blue.
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		for x := range width {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= width/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+
+			img.Set(x, y, c)
+		}
+	}
+
+	return img
+}
+
+// exifSegment creates an APP1 segment with EXIF metadata holding the orientation and the GPS position.
+func exifSegment(orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, tagOrientation)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3) // SHORT
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	tiff = append(tiff, gpsPosition...)
+
+	payload := append(append([]byte{}, exifHeader...), tiff...)
+	segment := []byte{0xFF, markerAPP1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+
+	return append(segment, payload...)
+}
+
+// testJPEG encodes the image as a JPEG with EXIF metadata and a comment.
+func testJPEG(t *testing.T, img image.Image, orientation uint16) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+
+	comment := []byte{0xFF, markerCOM, 0, byte(len(gpsPosition) + 2)}
+	comment = append(comment, gpsPosition...)
+
+	data := append([]byte{}, buf.Bytes()[:2]...)
+	data = append(data, exifSegment(orientation)...)
+	data = append(data, comment...)
+
+	return append(data, buf.Bytes()[2:]...)
+}
+
+// jpegSegment creates a JPEG segment of the marker with the payload.
+func jpegSegment(marker byte, payload ...byte) []byte {
+	segment := []byte{0xFF, marker}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+
+	return append(segment, payload...)
+}
+
+// testMultiPictureJPEG creates a multi-picture JPEG: the image with its index, followed by a second image with EXIF metadata.
+func testMultiPictureJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+
+	data := append([]byte{}, buf.Bytes()[:2]...)
+	data = append(data, jpegSegment(markerAPP2, []byte("MPF\x00"+gpsPosition)...)...)
+	data = append(data, buf.Bytes()[2:]...)
+
+	return append(data, testJPEG(t, img, 1)...)
+}
+
+// testProgressiveJPEG creates a progressive gray JPEG of 8x8 pixels, with EXIF metadata and a comment between its two scans.
+// Its tables hold a single code, its DC scan and its AC scan hold a single block without coefficient.
+func testProgressiveJPEG() []byte {
+	quantization := append([]byte{0x00}, bytes.Repeat([]byte{1}, 64)...)
+	singleCode := append([]byte{1}, make([]byte, 15)...)
+
+	data := []byte{0xFF, markerSOI}
+	data = append(data, jpegSegment(0xDB, quantization...)...)
+	data = append(data, jpegSegment(0xC2, 8, 0, 8, 0, 8, 1, 1, 0x11, 0)...)
+	data = append(data, jpegSegment(0xC4, append(append([]byte{0x00}, singleCode...), 0)...)...)
+	data = append(data, jpegSegment(0xC4, append(append([]byte{0x10}, singleCode...), 0)...)...)
+	data = append(data, jpegSegment(markerSOS, 1, 1, 0x00, 0, 0, 0)...)
+	data = append(data, 0x7F)
+	data = append(data, exifSegment(1)...)
+	data = append(data, jpegSegment(markerCOM, []byte(gpsPosition)...)...)
+	data = append(data, jpegSegment(markerSOS, 1, 1, 0x00, 1, 63, 0)...)
+	data = append(data, 0x7F)
+
+	return append(data, 0xFF, markerEOI)
+}
+
+// testPNG encodes the image as a PNG with a text chunk.
+func testPNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(gpsPosition)))
+	chunk = append(chunk, "tEXt"+gpsPosition...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	// the text chunk follows the header chunk, 8 bytes of signature and 25 bytes of IHDR.
+	data := append([]byte{}, buf.Bytes()[:33]...)
+	data = append(data, chunk...)
+
+	return append(data, buf.Bytes()[33:]...)
+}
+
+func TestProcess_JPEG(t *testing.T) {
+	data := testJPEG(t, testImage(800, 400), 1)
+	require.Contains(t, string(data), gpsPosition)
+
+	img, err := Process(data, "image/jpeg")
+	require.NoError(t, err)
+
+	assert.NotContains(t, string(img.Content), gpsPosition)
+	assert.NotContains(t, string(img.Content), "Exif")
+	assert.Equal(t, 800, img.Width)
+	assert.Equal(t, 400, img.Height)
+
+	// the upright image is not re-encoded.
+	decoded, err := jpeg.Decode(bytes.NewReader(img.Content))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 800, 400), decoded.Bounds())
+
+	require.Len(t, img.Thumbnails, 2)
+	assertThumbnail(t, img.Thumbnails[0], "small", image.Rect(0, 0, 160, 80))
+	assertThumbnail(t, img.Thumbnails[1], "medium", image.Rect(0, 0, 640, 320))
+}
+
+func TestProcess_JPEG_Orientation(t *testing.T) {
+	// the camera was rotated, the image is displayed rotated 90° clockwise.
+	img, err := Process(testJPEG(t, testImage(800, 400), 6), "image/jpeg")
+	require.NoError(t, err)
+
+	assert.NotContains(t, string(img.Content), gpsPosition)
+	assert.Equal(t, 400, img.Width)
+	assert.Equal(t, 800, img.Height)
+
+	decoded, err := jpeg.Decode(bytes.NewReader(img.Content))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 400, 800), decoded.Bounds())
+
+	assertThumbnail(t, img.Thumbnails[0], "small", image.Rect(0, 0, 80, 160))
+}
+
+func TestProcess_JPEG_MultiPicture(t *testing.T) {
+	data := testMultiPictureJPEG(t, testImage(100, 50))
+	require.Contains(t, string(data), gpsPosition)
+
+	img, err := Process(data, "image/jpeg")
+	require.NoError(t, err)
+
+	// the index and the second image are removed.
+	assert.NotContains(t, string(img.Content), gpsPosition)
+	assert.NotContains(t, string(img.Content), "MPF")
+	assert.NotContains(t, string(img.Content), "Exif")
+	assert.Equal(t, []byte{0xFF, markerEOI}, img.Content[len(img.Content)-2:])
+	assert.Equal(t, 1, bytes.Count(img.Content, []byte{0xFF, markerSOI}))
+
+	decoded, err := jpeg.Decode(bytes.NewReader(img.Content))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 100, 50), decoded.Bounds())
+}
+
+func TestProcess_JPEG_Progressive(t *testing.T) {
+	data := testProgressiveJPEG()
+
+	_, err := jpeg.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	img, err := Process(data, "image/jpeg")
+	require.NoError(t, err)
+
+	// the metadata between the scans are removed, both scans are kept.
+	assert.NotContains(t, string(img.Content), gpsPosition)
+	assert.NotContains(t, string(img.Content), "Exif")
+	assert.Equal(t, 2, bytes.Count(img.Content, []byte{0xFF, markerSOS}))
+
+	decoded, err := jpeg.Decode(bytes.NewReader(img.Content))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 8, 8), decoded.Bounds())
+}
+
+func TestProcess_PNG(t *testing.T) {
+	data := testPNG(t, testImage(100, 50))
+	require.Contains(t, string(data), gpsPosition)
+
+	img, err := Process(data, "image/png")
+	require.NoError(t, err)
+
+	assert.NotContains(t, string(img.Content), gpsPosition)
+	assert.Equal(t, 100, img.Width)
+	assert.Equal(t, 50, img.Height)
+
+	decoded, err := png.Decode(bytes.NewReader(img.Content))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 100, 50), decoded.Bounds())
+
+	// a small image is not scaled up.
+	assertThumbnail(t, img.Thumbnails[0], "small", image.Rect(0, 0, 100, 50))
+}
+
+func TestProcess_GIF(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, gif.Encode(&buf, testImage(320, 200), nil))
+
+	img, err := Process(buf.Bytes(), "image/gif")
+	require.NoError(t, err)
+
+	_, err = gif.DecodeAll(bytes.NewReader(img.Content))
+	require.NoError(t, err)
+
+	// the pixels of all the frames are counted.
+	frame := image.NewPaletted(image.Rect(0, 0, 320, 200), color.Palette{color.White, color.Black})
+
+	buf.Reset()
+	require.NoError(t, gif.EncodeAll(&buf, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}}))
+
+	pixels, err := gifPixels(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2*320*200), pixels)
+
+	// the thumbnails of a GIF image are PNG images.
+	assert.Equal(t, "image/png", ContentType("image/gif"))
+	assertThumbnail(t, img.Thumbnails[0], "small", image.Rect(0, 0, 160, 100))
+}
+
+func TestProcess_Errors(t *testing.T) {
+	_, err := Process([]byte("hello"), "text/plain")
+	require.ErrorIs(t, err, ErrUnsupported)
+
+	_, err = Process([]byte("\xFF\xD8 not a JPEG"), "image/jpeg")
+	require.ErrorIs(t, err, ErrInvalid)
+
+	// the header of a PNG of 10000x10000 pixels, without pixels.
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))))
+
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:20], 10000)
+	binary.BigEndian.PutUint32(data[20:24], 10000)
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+
+	_, err = Process(data, "image/png")
+	require.ErrorIs(t, err, ErrTooLarge)
+
+	// a GIF of 5000x5000 pixels with two frames, without pixels.
+	screen := []byte("GIF89a\x88\x13\x88\x13\x00\x00\x00")
+	frame := []byte("\x2C\x00\x00\x00\x00\x88\x13\x88\x13\x00\x02\x00")
+
+	data = append(append(append(screen, frame...), frame...), 0x3B)
+
+	_, err = Process(data, "image/gif")
+	require.ErrorIs(t, err, ErrTooLarge)
+
+	_, err = Process(append(screen, frame...), "image/gif")
+	require.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestOrient(t *testing.T) {
+	// the left pixel is red, the right pixel is blue.
+	src := testImage(2, 1)
+	red, blue := src.At(0, 0), src.At(1, 0)
+
+	testCases := []struct {
+		orientation int
+		expected    []color.Color // the pixels from the top left, row by row.
+		bounds      image.Rectangle
+	}{
+		{orientation: 1, expected: []color.Color{red, blue}, bounds: image.Rect(0, 0, 2, 1)},
+		{orientation: 2, expected: []color.Color{blue, red}, bounds: image.Rect(0, 0, 2, 1)},
+		{orientation: 3, expected: []color.Color{blue, red}, bounds: image.Rect(0, 0, 2, 1)},
+		{orientation: 4, expected: []color.Color{red, blue}, bounds: image.Rect(0, 0, 2, 1)},
+		{orientation: 5, expected: []color.Color{red, blue}, bounds: image.Rect(0, 0, 1, 2)},
+		{orientation: 6, expected: []color.Color{red, blue}, bounds: image.Rect(0, 0, 1, 2)},
+		{orientation: 7, expected: []color.Color{blue, red}, bounds: image.Rect(0, 0, 1, 2)},
+		{orientation: 8, expected: []color.Color{blue, red}, bounds: image.Rect(0, 0, 1, 2)},
+	}
+
+	for _, test := range testCases {
+		dst := orient(src, test.orientation)
+		require.Equal(t, test.bounds, dst.Bounds(), test.orientation)
+
+		var got []color.Color
+
+		for y := range test.bounds.Dy() {
+			for x := range test.bounds.Dx() {
+				got = append(got, color.RGBAModel.Convert(dst.At(x, y)))
+			}
+		}
+
+		assert.Equal(t, test.expected, got, test.orientation)
+	}
+}
+
+// assertThumbnail asserts the size and the bounds of the thumbnail.
+func assertThumbnail(t *testing.T, thumbnail Thumbnail, name string, bounds image.Rectangle) {
+	t.Helper()
+
+	assert.Equal(t, name, thumbnail.Size.Name)
+
+	img, _, err := image.Decode(bytes.NewReader(thumbnail.Content))
+	require.NoError(t, err)
+	assert.Equal(t, bounds, img.Bounds())
+}